@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// accessLog saves a log entry per request, nil when disabled
+var accessLog *accessLogger
+
+const (
+	accessCallerContextKey contextKey = "access_caller"
+	// accessLogBatchSize is how many entries are saved at most at a time
+	accessLogBatchSize = 500
+	// accessLogInterval is how long an entry waits at most to be saved
+	accessLogInterval = time.Second
+	// maxAccessLogLimit bounds the entries listed at once
+	maxAccessLogLimit = 1000
+)
+
+// AccessLogEntry is a request as logged once it was answered
+type AccessLogEntry struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id"`
+	At        time.Time          `json:"at" bson:"at"`
+	RequestID string             `json:"request_id" bson:"request_id"`
+	Method    string             `json:"method" bson:"method"`
+	// Route is the pattern the request matched, like /assignments/{id},
+	// empty if it matched none
+	Route         string  `json:"route" bson:"route"`
+	Path          string  `json:"path" bson:"path"`
+	Status        int     `json:"status" bson:"status"`
+	LatencyMillis float64 `json:"latency_millis" bson:"latency_millis"`
+	Bytes         int     `json:"bytes" bson:"bytes"`
+	// Tenant and UserID are of the authorized caller, empty if it wasn't
+	Tenant string `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	UserID string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+}
+
+// AccessLogFilter selects access log entries, every field left empty
+// matching all
+type AccessLogFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Method string
+	Route  string
+	// MinStatus and MaxStatus bound the status, both included
+	MinStatus  int
+	MaxStatus  int
+	MinLatency time.Duration
+	Tenant     string
+	UserID     string
+	RequestID  string
+	Limit      int
+}
+
+func (f AccessLogFilter) matches(entry AccessLogEntry) bool {
+	return (f.Since.IsZero() || !entry.At.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.At.Before(f.Until)) &&
+		(f.Method == "" || entry.Method == f.Method) &&
+		(f.Route == "" || entry.Route == f.Route) &&
+		(f.MinStatus == 0 || entry.Status >= f.MinStatus) &&
+		(f.MaxStatus == 0 || entry.Status <= f.MaxStatus) &&
+		(f.MinLatency == 0 || entry.LatencyMillis >= float64(f.MinLatency.Milliseconds())) &&
+		(f.Tenant == "" || entry.Tenant == f.Tenant) &&
+		(f.UserID == "" || entry.UserID == f.UserID) &&
+		(f.RequestID == "" || entry.RequestID == f.RequestID)
+}
+
+// accessCaller is filled in by the auth middlewares, since the access log
+// only gets to see the request as it came in
+type accessCaller struct {
+	mu             sync.Mutex
+	tenant, userID string
+}
+
+// recordCaller tells the access log who made the request of ctx
+func recordCaller(ctx context.Context, tenant, userID string) {
+	caller, ok := ctx.Value(accessCallerContextKey).(*accessCaller)
+	if !ok {
+		return
+	}
+	caller.mu.Lock()
+	defer caller.mu.Unlock()
+
+	caller.tenant, caller.userID = tenant, userID
+}
+
+// accessLogger saves the entries of many requests in batches. Entries are
+// dropped rather than slowing requests down when saving falls behind.
+type accessLogger struct {
+	entries chan AccessLogEntry
+}
+
+func newAccessLogger() *accessLogger {
+	return &accessLogger{entries: make(chan AccessLogEntry, 10*accessLogBatchSize)}
+}
+
+// LogAccess logs every request to the access log once it is answered
+func LogAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		caller := &accessCaller{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessCallerContextKey, caller)))
+
+		entry := AccessLogEntry{
+			ID:            primitive.NewObjectID(),
+			At:            start,
+			RequestID:     middleware.GetReqID(r.Context()),
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Status:        ww.Status(),
+			LatencyMillis: float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:         ww.BytesWritten(),
+		}
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			entry.Route = routeContext.RoutePattern()
+		}
+		caller.mu.Lock()
+		entry.Tenant, entry.UserID = caller.tenant, caller.userID
+		caller.mu.Unlock()
+
+		select {
+		case accessLog.entries <- entry:
+		default:
+			accessLogDroppedTotal.Inc()
+		}
+	})
+}
+
+// Run saves batches until the server stops
+func (l *accessLogger) Run() {
+	for {
+		batch := []AccessLogEntry{<-l.entries}
+		deadline := time.After(accessLogInterval)
+	collect:
+		for len(batch) < accessLogBatchSize {
+			select {
+			case entry := <-l.entries:
+				batch = append(batch, entry)
+			case <-deadline:
+				break collect
+			}
+		}
+
+		err := repository.InsertAccessLogs(context.Background(), batch)
+		if err != nil {
+			accessLogDroppedTotal.Add(float64(len(batch)))
+			log.Printf("Failed to save %d access log entries! Error: %s\n", len(batch), err)
+		}
+	}
+}
+
+// parseStatusFilter reads a status like 404, or a class of them like 5xx
+func parseStatusFilter(status string) (int, int, bool) {
+	if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+		class := int(status[0]-'0') * 100
+		return class, class + 99, true
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, false
+	}
+	return code, code, true
+}
+
+// getAccessLogHandler lists the logged requests matching the query params,
+// latest first
+func getAccessLogHandler(w http.ResponseWriter, r *http.Request) {
+	if accessLog == nil {
+		writeProblem(w, problemNotFound, "The access log is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AccessLogFilter{
+		Method:    strings.ToUpper(query.Get("method")),
+		Route:     query.Get("route"),
+		Tenant:    query.Get("tenant"),
+		UserID:    query.Get("user_id"),
+		RequestID: query.Get("request_id"),
+		Limit:     100,
+	}
+	var err error
+	for _, param := range []struct {
+		name string
+		time *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(param.name); value != "" {
+			*param.time, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeProblem(w, problemInvalidRequest, "", FieldError{Field: param.name, Message: "isn't an RFC 3339 time"})
+				return
+			}
+		}
+	}
+	if status := query.Get("status"); status != "" {
+		var valid bool
+		filter.MinStatus, filter.MaxStatus, valid = parseStatusFilter(status)
+		if !valid {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "status", Message: "has to be a status like 503, or a class like 5xx"})
+			return
+		}
+	}
+	if minLatency := query.Get("min_latency"); minLatency != "" {
+		filter.MinLatency, err = time.ParseDuration(minLatency)
+		if err != nil || filter.MinLatency < 0 {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "min_latency", Message: "has to be a duration like 500ms"})
+			return
+		}
+	}
+	if limitParam := query.Get("limit"); limitParam != "" {
+		filter.Limit, err = strconv.Atoi(limitParam)
+		if err != nil || filter.Limit <= 0 {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "limit", Message: "has to be a positive whole number"})
+			return
+		}
+		if filter.Limit > maxAccessLogLimit {
+			filter.Limit = maxAccessLogLimit
+		}
+	}
+
+	entries, err := repository.AccessLogs(r.Context(), filter)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, entries)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed adminui
+var adminUIFiles embed.FS
+
+// adminUIHandler serves the admin dashboard at /admin/ui/. The pages are
+// static and hold no data, so they are served without the admin token; the
+// dashboard asks for it and sends it along to the admin API. Without a
+// configured admin token there is no dashboard either.
+func adminUIHandler() http.Handler {
+	files, err := fs.Sub(adminUIFiles, "adminui")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(files)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminCredential == nil {
+			writeProblem(w, problemNotFound, "")
+			return
+		}
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// getStatsByDayHandler counts the answers of all tenants per day in UTC, for
+// the last 31 days and today
+func getStatsByDayHandler(w http.ResponseWriter, r *http.Request) {
+	counts, err := countByDayLastMonth(r.Context(), time.UTC)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, counts)
+}
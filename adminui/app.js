@@ -0,0 +1,149 @@
+// The admin dashboard calls the admin API with the admin token, which is kept
+// in session storage until the tab is closed or the token is rejected.
+"use strict";
+
+const tokenKey = "adminToken";
+
+class Unauthorized extends Error {}
+
+async function api(path) {
+  const response = await fetch(path, {
+    headers: { "X-Auth-Token": sessionStorage.getItem(tokenKey) || "", Accept: "application/json" },
+  });
+  if (response.status === 401) {
+    throw new Unauthorized();
+  }
+  if (!response.ok) {
+    let detail = response.statusText;
+    try {
+      const problem = await response.json();
+      detail = problem.detail || problem.title || detail;
+    } catch (e) {
+      // not a problem document
+    }
+    throw new Error(`${path}: ${detail}`);
+  }
+  return response.json();
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+}
+
+function percent(rate) {
+  return `${(rate * 100).toFixed(1)}%`;
+}
+
+function showUsers(retention) {
+  document.getElementById("daily-active").textContent = retention.daily_active;
+  document.getElementById("weekly-active").textContent = retention.weekly_active;
+  document.getElementById("monthly-active").textContent = retention.monthly_active;
+}
+
+function showVolume(days) {
+  const chart = document.getElementById("volume");
+  const max = Math.max(1, ...days.map((day) => day.count));
+  chart.replaceChildren(
+    ...days.map((day) => {
+      const bar = document.createElement("div");
+      bar.style.height = `${(day.count / max) * 100}%`;
+      bar.title = `${day.day}: ${day.count} answers`;
+      return bar;
+    })
+  );
+}
+
+function showJobs(jobs) {
+  const body = document.getElementById("jobs");
+  body.replaceChildren();
+  for (const job of jobs) {
+    const row = body.insertRow();
+    const run = job.last_run;
+    cell(row, job.name);
+    cell(row, job.schedule);
+    cell(row, run ? new Date(run.started_at).toLocaleString() : "never");
+    cell(row, run ? run.status : "", run ? run.status : "");
+    cell(row, run && run.error ? run.error : "");
+  }
+}
+
+function showClients(usages) {
+  const body = document.getElementById("clients");
+  body.replaceChildren();
+  const total = { requests: 0, client_errors: 0, server_errors: 0 };
+  for (const usage of usages.filter((usage) => usage.requests > 0)) {
+    const row = body.insertRow();
+    cell(row, usage.platform || "unknown");
+    cell(row, usage.app_version || "unknown");
+    cell(row, usage.requests);
+    cell(row, usage.client_errors);
+    cell(row, usage.server_errors);
+    cell(row, percent(usage.error_rate));
+    total.requests += usage.requests;
+    total.client_errors += usage.client_errors;
+    total.server_errors += usage.server_errors;
+  }
+  const row = body.insertRow();
+  cell(row, "All", "total");
+  cell(row, "");
+  cell(row, total.requests);
+  cell(row, total.client_errors);
+  cell(row, total.server_errors);
+  cell(row, total.requests ? percent((total.client_errors + total.server_errors) / total.requests) : "");
+}
+
+function showSignedIn(signedIn) {
+  document.getElementById("sign-in").hidden = signedIn;
+  document.getElementById("dashboard").hidden = !signedIn;
+  document.getElementById("refresh").hidden = !signedIn;
+  document.getElementById("sign-out").hidden = !signedIn;
+}
+
+function signOut(message) {
+  sessionStorage.removeItem(tokenKey);
+  document.getElementById("sign-in-error").textContent = message || "";
+  showSignedIn(false);
+}
+
+async function load() {
+  const error = document.getElementById("error");
+  error.textContent = "";
+  try {
+    const [retention, volume, jobs, clients, version] = await Promise.all([
+      api("/admin/analytics/retention?weeks=1"),
+      api("/admin/analytics/stats_by_day"),
+      api("/admin/jobs"),
+      api("/admin/analytics/client_versions"),
+      api("/version"),
+    ]);
+    showSignedIn(true);
+    showUsers(retention);
+    showVolume(volume);
+    showJobs(jobs);
+    showClients(clients);
+    document.getElementById("version").textContent = `${version.git_sha.slice(0, 7)} built ${version.build_time}`;
+  } catch (e) {
+    if (e instanceof Unauthorized) {
+      signOut("The admin token is wrong");
+      return;
+    }
+    error.textContent = e.message;
+  }
+}
+
+document.getElementById("sign-in").addEventListener("submit", (event) => {
+  event.preventDefault();
+  sessionStorage.setItem(tokenKey, document.getElementById("token").value);
+  document.getElementById("token").value = "";
+  load();
+});
+document.getElementById("refresh").addEventListener("click", load);
+document.getElementById("sign-out").addEventListener("click", () => signOut());
+
+if (sessionStorage.getItem(tokenKey)) {
+  load();
+}
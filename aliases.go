@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ChordAlias spells a chord extension the way older clients did, like min7
+// for m7. Answers are stored with the extension and aggregated with it
+// whichever of the two they were stored with.
+type ChordAlias struct {
+	Alias     string    `json:"alias" bson:"_id"`
+	Extension string    `json:"chord_extension" bson:"chord_extension"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// validExtensionSpelling tells if extension could be how a client spells
+// a chord extension, and be part of a path
+func validExtensionSpelling(extension string) bool {
+	return extension != "" && utf8.RuneCountInString(extension) <= 32 && !strings.ContainsAny(extension, " /")
+}
+
+// validate returns what is wrong with the alias among the aliases stored,
+// nothing if it is valid. Aliases don't chain, so an extension can't be
+// an alias itself.
+func (a ChordAlias) validate(aliases chordAliasTable) []FieldError {
+	var fieldErrors []FieldError
+	if !validExtensionSpelling(a.Alias) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "alias", Message: "has to be 1 to 32 characters without spaces or /"})
+	}
+	switch {
+	case !validExtensionSpelling(a.Extension):
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_extension", Message: "has to be 1 to 32 characters without spaces or /"})
+	case a.Extension == a.Alias:
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_extension", Message: "can't be the alias itself"})
+	case aliases[a.Extension] != "":
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_extension", Message: "is an alias of " + aliases[a.Extension]})
+	}
+	for alias, extension := range aliases {
+		if extension == a.Alias {
+			fieldErrors = append(fieldErrors, FieldError{Field: "alias", Message: "is the extension of the alias " + alias})
+			break
+		}
+	}
+	return fieldErrors
+}
+
+// chordAliasTable maps aliases to the extensions they spell
+type chordAliasTable map[string]string
+
+// chordAliases returns the aliases configured
+func chordAliases(ctx context.Context) (chordAliasTable, error) {
+	aliases, err := repository.ChordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(chordAliasTable, len(aliases))
+	for _, alias := range aliases {
+		table[alias.Alias] = alias.Extension
+	}
+	return table, nil
+}
+
+// extension returns how extension is spelled now
+func (t chordAliasTable) extension(extension string) string {
+	if spelled, isAlias := t[extension]; isAlias {
+		return spelled
+	}
+	return extension
+}
+
+// stats spells the extension of an answer, and the chord name made of it,
+// the way they are spelled now
+func (t chordAliasTable) stats(stats StatsRaw) StatsRaw {
+	extension := t.extension(stats.ChordExtension)
+	if extension == stats.ChordExtension {
+		return stats
+	}
+	if stats.RootNote != "" && stats.ChordName == stats.RootNote+stats.ChordExtension {
+		stats.ChordName = stats.RootNote + extension
+	}
+	stats.ChordExtension = extension
+	return stats
+}
+
+// mergeCounts adds up the counts of extensions spelled the same way now
+func (t chordAliasTable) mergeCounts(counts []StatsCountByExtension) []StatsCountByExtension {
+	merged := []StatsCountByExtension{}
+	index := make(map[string]int)
+	for _, count := range counts {
+		extension := t.extension(count.Extension)
+		if i, exists := index[extension]; exists {
+			merged[i].Count += count.Count
+			continue
+		}
+		index[extension] = len(merged)
+		merged = append(merged, StatsCountByExtension{Extension: extension, Count: count.Count})
+	}
+	return merged
+}
+
+// mergeDurations averages the durations of extensions spelled the same way
+// now, weighing them by their answers
+func (t chordAliasTable) mergeDurations(durations []StatsDurationByExtension) []StatsDurationByExtension {
+	merged := []StatsDurationByExtension{}
+	index := make(map[string]int)
+	for _, duration := range durations {
+		extension := t.extension(duration.Extension)
+		i, exists := index[extension]
+		if !exists {
+			index[extension] = len(merged)
+			merged = append(merged, StatsDurationByExtension{Extension: extension, AvgDuration: duration.AvgDuration, Count: duration.Count})
+			continue
+		}
+		count := merged[i].Count + duration.Count
+		if count > 0 {
+			merged[i].AvgDuration = (merged[i].AvgDuration*float64(merged[i].Count) + duration.AvgDuration*float64(duration.Count)) / float64(count)
+		}
+		merged[i].Count = count
+	}
+	return merged
+}
+
+func getChordAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	aliases, err := repository.ChordAliases(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, aliases)
+}
+
+// saveChordAliasHandler creates or replaces the alias named in the path
+func saveChordAliasHandler(w http.ResponseWriter, r *http.Request) {
+	var alias ChordAlias
+	err := decodeRequest(r, &alias)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	alias.Alias = chi.URLParam(r, "alias")
+	aliases, err := chordAliases(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if fieldErrors := alias.validate(aliases); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	alias.UpdatedAt = time.Now()
+
+	err = repository.SaveChordAlias(r.Context(), alias)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+
+	writeResponse(w, r, alias)
+}
+
+func deleteChordAliasHandler(w http.ResponseWriter, r *http.Request) {
+	err := repository.DeleteChordAlias(r.Context(), chi.URLParam(r, "alias"))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-redis/redis/v8"
+)
+
+// the headers clients identify themselves with
+const (
+	clientPlatformHeader = "X-Client-Platform"
+	clientVersionHeader  = "X-Client-Version"
+)
+
+// maxTrackedClientVersions bounds the request counts kept in memory, since
+// the headers are chosen by the client
+const maxTrackedClientVersions = 1000
+
+type clientVersion struct {
+	Platform   string
+	AppVersion string
+}
+
+type requestCounts struct {
+	Requests     int
+	ClientErrors int
+	ServerErrors int
+}
+
+// clientRequests counts requests and failed requests per client version since
+// startup, or since they were last moved to Redis when running several
+// replicas
+var clientRequests = struct {
+	mu     sync.Mutex
+	counts map[clientVersion]*requestCounts
+}{counts: make(map[clientVersion]*requestCounts)}
+
+// ClientVersionUsage is the usage of a single client version
+type ClientVersionUsage struct {
+	Platform          string  `json:"platform"`
+	AppVersion        string  `json:"app_version"`
+	StatsCount        int     `json:"stats_count"`
+	AvgDurationMillis float64 `json:"avg_duration_millis"`
+	// the request counts are kept since the server started, or since Redis
+	// did when running several replicas
+	Requests     int     `json:"requests"`
+	ClientErrors int     `json:"client_errors"`
+	ServerErrors int     `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+}
+
+// clientVersionFromHeaders returns the version the client claims to be,
+// truncated so that a misbehaving client can't store arbitrary amounts
+func clientVersionFromHeaders(r *http.Request) clientVersion {
+	return clientVersion{
+		Platform:   truncate(r.Header.Get(clientPlatformHeader), 32),
+		AppVersion: truncate(r.Header.Get(clientVersionHeader), 32),
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
+
+// TrackClientVersions counts requests and failures per client version
+func TrackClientVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		version := clientVersionFromHeaders(r)
+		clientRequests.mu.Lock()
+		defer clientRequests.mu.Unlock()
+
+		counts, exists := clientRequests.counts[version]
+		if !exists {
+			if len(clientRequests.counts) >= maxTrackedClientVersions {
+				version = clientVersion{Platform: "other", AppVersion: "other"}
+			}
+			counts, exists = clientRequests.counts[version]
+			if !exists {
+				counts = &requestCounts{}
+				clientRequests.counts[version] = counts
+			}
+		}
+		counts.Requests++
+		if ww.Status() >= 500 {
+			counts.ServerErrors++
+		} else if ww.Status() >= 400 {
+			counts.ClientErrors++
+		}
+	})
+}
+
+// redisClientRequestsKey is the hash the replicas add their request counts
+// to, with a field per client version and count
+const redisClientRequestsKey = "client_requests"
+
+// shareClientRequests moves the request counts to Redis every interval, so
+// the usage adds up the requests to all replicas
+func shareClientRequests(client *redis.Client, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		clientRequests.mu.Lock()
+		counts := clientRequests.counts
+		clientRequests.counts = make(map[clientVersion]*requestCounts)
+		clientRequests.mu.Unlock()
+		if len(counts) == 0 {
+			continue
+		}
+
+		ctx := context.Background()
+		pipe := client.Pipeline()
+		for version, c := range counts {
+			field := version.Platform + "\n" + version.AppVersion + "\n"
+			pipe.HIncrBy(ctx, redisClientRequestsKey, field+"requests", int64(c.Requests))
+			pipe.HIncrBy(ctx, redisClientRequestsKey, field+"client_errors", int64(c.ClientErrors))
+			pipe.HIncrBy(ctx, redisClientRequestsKey, field+"server_errors", int64(c.ServerErrors))
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil {
+			// keep them to try again, so they aren't lost
+			log.Println("Failed to share request counts! Error:", err)
+			clientRequests.mu.Lock()
+			for version, c := range counts {
+				current, exists := clientRequests.counts[version]
+				if !exists {
+					current = &requestCounts{}
+					clientRequests.counts[version] = current
+				}
+				current.Requests += c.Requests
+				current.ClientErrors += c.ClientErrors
+				current.ServerErrors += c.ServerErrors
+			}
+			clientRequests.mu.Unlock()
+		}
+	}
+}
+
+// countedClientRequests returns the request counts per client version, the
+// ones in Redis included when running several replicas
+func countedClientRequests(ctx context.Context) (map[clientVersion]*requestCounts, error) {
+	result := make(map[clientVersion]*requestCounts)
+	add := func(version clientVersion) *requestCounts {
+		counts, exists := result[version]
+		if !exists {
+			counts = &requestCounts{}
+			result[version] = counts
+		}
+		return counts
+	}
+
+	if redisClient != nil {
+		fields, err := redisClient.HGetAll(ctx, redisClientRequestsKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range fields {
+			parts := strings.Split(field, "\n")
+			count, err := strconv.Atoi(value)
+			if len(parts) != 3 || err != nil {
+				continue
+			}
+			counts := add(clientVersion{Platform: parts[0], AppVersion: parts[1]})
+			switch parts[2] {
+			case "requests":
+				counts.Requests += count
+			case "client_errors":
+				counts.ClientErrors += count
+			case "server_errors":
+				counts.ServerErrors += count
+			}
+		}
+	}
+
+	clientRequests.mu.Lock()
+	defer clientRequests.mu.Unlock()
+	for version, c := range clientRequests.counts {
+		counts := add(version)
+		counts.Requests += c.Requests
+		counts.ClientErrors += c.ClientErrors
+		counts.ServerErrors += c.ServerErrors
+	}
+	return result, nil
+}
+
+// usageByClientVersion merges the stored stats per client version with the
+// request counts
+func usageByClientVersion(ctx context.Context) ([]ClientVersionUsage, error) {
+	stored, err := repository.UsageByClientVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make(map[clientVersion]*ClientVersionUsage)
+	for i := range stored {
+		usages[clientVersion{stored[i].Platform, stored[i].AppVersion}] = &stored[i]
+	}
+
+	requests, err := countedClientRequests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for version, counts := range requests {
+		usage, exists := usages[version]
+		if !exists {
+			usage = &ClientVersionUsage{Platform: version.Platform, AppVersion: version.AppVersion}
+			usages[version] = usage
+		}
+		usage.Requests = counts.Requests
+		usage.ClientErrors = counts.ClientErrors
+		usage.ServerErrors = counts.ServerErrors
+		if counts.Requests > 0 {
+			usage.ErrorRate = float64(counts.ClientErrors+counts.ServerErrors) / float64(counts.Requests)
+		}
+	}
+
+	result := []ClientVersionUsage{}
+	for _, usage := range usages {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		return result[i].AppVersion < result[j].AppVersion
+	})
+	return result, nil
+}
+
+func getClientVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	usages, err := usageByClientVersion(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, usages)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// archiveChunkSize caps the stats in an archive chunk, which keeps chunks
+// far below Mongo's 16MB document limit
+const archiveChunkSize = 5000
+
+// statsArchive is a compressed chunk of archived stats of one tenant. It
+// keeps the rollups that the all-time aggregations still need, so archiving
+// doesn't change them.
+type statsArchive struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	Tenant string             `bson:"tenant"`
+	// From and Until are the created_at of the first and last stats
+	From       time.Time         `bson:"from"`
+	Until      time.Time         `bson:"until"`
+	Count      int               `bson:"count"`
+	Extensions []extensionRollup `bson:"extensions"`
+	// Data is the stats as a gzipped JSON array
+	Data []byte `bson:"data"`
+}
+
+// extensionRollup sums up the archived stats of a chord extension played on
+// an instrument, empty for piano
+type extensionRollup struct {
+	Extension   string `bson:"chord_extension"`
+	Instrument  string `bson:"instrument,omitempty"`
+	Count       int    `bson:"count"`
+	DurationSum int64  `bson:"duration_sum"`
+}
+
+// newStatsArchive compresses stats of a single tenant sorted by created_at
+func newStatsArchive(stats []StatsRaw) (statsArchive, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	err := json.NewEncoder(writer).Encode(stats)
+	if err != nil {
+		return statsArchive{}, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return statsArchive{}, err
+	}
+
+	rollups := make(map[[2]string]*extensionRollup)
+	archive := statsArchive{
+		// the first stats' id makes archiving the same chunk twice collide
+		ID:         stats[0].ID,
+		Tenant:     stats[0].Tenant,
+		From:       stats[0].CreatedAt,
+		Until:      stats[len(stats)-1].CreatedAt,
+		Count:      len(stats),
+		Extensions: []extensionRollup{},
+		Data:       buf.Bytes(),
+	}
+	for _, s := range stats {
+		key := [2]string{s.ChordExtension, s.Instrument}
+		rollup, exists := rollups[key]
+		if !exists {
+			rollup = &extensionRollup{Extension: s.ChordExtension, Instrument: s.Instrument}
+			rollups[key] = rollup
+		}
+		rollup.Count++
+		rollup.DurationSum += int64(s.AnswerDurationMilliSeconds)
+	}
+	for _, rollup := range rollups {
+		archive.Extensions = append(archive.Extensions, *rollup)
+	}
+	return archive, nil
+}
+
+// Stats decompresses the archived stats
+func (a statsArchive) Stats() ([]StatsRaw, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(a.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var stats []StatsRaw
+	err = json.NewDecoder(reader).Decode(&stats)
+	for i := range stats {
+		stats[i].Tenant = a.Tenant
+	}
+	return stats, err
+}
+
+// archiveJob archives the stats older than the given number of months
+// every interval, across all tenants
+func archiveJob(months int, interval time.Duration) *job {
+	return &job{
+		name:     "archive",
+		schedule: "@every " + interval.String(),
+		timeout:  time.Hour,
+		run: func(ctx context.Context) error {
+			archived, err := repository.ArchiveStats(ctx, time.Now().AddDate(0, -months, 0))
+			if archived > 0 {
+				statsArchivedTotal.Add(float64(archived))
+				log.Printf("Archived %d stats\n", archived)
+				if aggregateCache != nil {
+					aggregateCache.Invalidate()
+				}
+			}
+			return err
+		},
+	}
+}
+
+// getArchivedStatsHandler streams the archived stats created between the
+// since and until query params, both optional
+func getArchivedStatsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := StatsFilter{Tag: r.URL.Query().Get("tag")}
+	var err error
+	if since := r.URL.Query().Get("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "since", Message: "isn't an RFC 3339 time"})
+			return
+		}
+	}
+	if until := r.URL.Query().Get("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "until", Message: "isn't an RFC 3339 time"})
+			return
+		}
+	}
+
+	list, err := newListWriter(w, r, statsCSVHeader)
+	if err == errNotAcceptable {
+		writeProblem(w, problemNotAcceptable, "")
+		return
+	}
+	err = repository.EachArchivedStats(r.Context(), filter, func(stats StatsRaw) error {
+		return list.Write(stats)
+	})
+	if err != nil {
+		if !list.Started() {
+			writeInternalError(w, r, err)
+			return
+		}
+		// once the status is sent, all we can do is cut the response short
+		log.Println("Error:", err)
+		reportError(r.Context(), err)
+		return
+	}
+	list.Close()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	assignmentInProgress = "in_progress"
+	assignmentCompleted  = "completed"
+	assignmentOverdue    = "overdue"
+)
+
+// Assignment asks students to practice a set of chords until they answer
+// them with a target accuracy, before a due date
+type Assignment struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	Teacher        string             `json:"teacher" bson:"teacher"`
+	Students       []string           `json:"students" bson:"students"`
+	ChordNames     []string           `json:"chord_names" bson:"chord_names"`
+	TargetAccuracy float64            `json:"target_accuracy" bson:"target_accuracy"`
+	DueAt          time.Time          `json:"due_at" bson:"due_at"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AssignmentProgress is how far a student has come with an assignment,
+// counting the answers between its creation and due date
+type AssignmentProgress struct {
+	Student string `json:"student"`
+	Answers int    `json:"answers"`
+	Correct int    `json:"correct"`
+	// Accuracy is 0 until there are answers
+	Accuracy float64 `json:"accuracy"`
+	// ChordsPracticed counts the chords of the set answered at least once
+	ChordsPracticed int    `json:"chords_practiced"`
+	Status          string `json:"status"`
+}
+
+// AssignmentStatus is an assignment with the progress of each student the
+// caller may see, all of them for the teacher and only their own for a
+// student
+type AssignmentStatus struct {
+	Assignment
+	Progress []AssignmentProgress `json:"progress"`
+}
+
+// validate returns what is wrong with a new assignment, nothing if it is
+// valid
+func (a Assignment) validate() []FieldError {
+	var fieldErrors []FieldError
+	if len(a.Students) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "students", Message: "can't be empty"})
+	}
+	if len(a.ChordNames) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_names", Message: "can't be empty"})
+	}
+	if a.TargetAccuracy < 0 || a.TargetAccuracy > 1 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "target_accuracy", Message: "has to be between 0 and 1"})
+	}
+	if !a.DueAt.After(time.Now()) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "due_at", Message: "has to be in the future"})
+	}
+	return fieldErrors
+}
+
+// evaluateAssignment works out the progress of a student with an assignment
+// from their stats. Completing it takes answering every chord of the set,
+// with the target accuracy over all answers.
+func evaluateAssignment(ctx context.Context, assignment Assignment, student string) (AssignmentProgress, error) {
+	chords := make(map[string]bool)
+	for _, chordName := range assignment.ChordNames {
+		chords[chordName] = false
+	}
+
+	progress := AssignmentProgress{Student: student}
+	filter := StatsFilter{Since: assignment.CreatedAt, Until: assignment.DueAt}
+	err := repository.EachStats(withTenant(ctx, student), filter, func(stats StatsRaw) error {
+		if _, inSet := chords[stats.ChordName]; !inSet {
+			return nil
+		}
+		chords[stats.ChordName] = true
+		progress.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			progress.Correct++
+		}
+		return nil
+	})
+	if err != nil {
+		return AssignmentProgress{}, err
+	}
+
+	for _, practiced := range chords {
+		if practiced {
+			progress.ChordsPracticed++
+		}
+	}
+	if progress.Answers > 0 {
+		progress.Accuracy = float64(progress.Correct) / float64(progress.Answers)
+	}
+
+	switch {
+	case progress.ChordsPracticed == len(chords) && progress.Accuracy >= assignment.TargetAccuracy:
+		progress.Status = assignmentCompleted
+	case time.Now().After(assignment.DueAt):
+		progress.Status = assignmentOverdue
+	default:
+		progress.Status = assignmentInProgress
+	}
+	return progress, nil
+}
+
+// assignmentStatus evaluates an assignment for the students account may see
+// the progress of. Teachers stop seeing the progress of students who revoke
+// the relationship.
+func assignmentStatus(ctx context.Context, assignment Assignment, account string) (AssignmentStatus, error) {
+	status := AssignmentStatus{Assignment: assignment, Progress: []AssignmentProgress{}}
+	for _, student := range assignment.Students {
+		if account != assignment.Teacher && account != student {
+			continue
+		}
+		if account == assignment.Teacher {
+			granted, err := repository.HasStudent(ctx, assignment.Teacher, student)
+			if err != nil {
+				return AssignmentStatus{}, err
+			}
+			if !granted {
+				continue
+			}
+		}
+
+		progress, err := evaluateAssignment(ctx, assignment, student)
+		if err != nil {
+			return AssignmentStatus{}, err
+		}
+		status.Progress = append(status.Progress, progress)
+	}
+	return status, nil
+}
+
+// addAssignmentHandler creates an assignment for students who accepted the
+// caller as their teacher
+func addAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	var assignment Assignment
+	err := decodeRequest(r, &assignment)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := assignment.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	teacher := tenantFromContext(r.Context())
+	for i, student := range assignment.Students {
+		granted, err := repository.HasStudent(r.Context(), teacher, student)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if !granted {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: fmt.Sprintf("students[%d]", i), Message: "hasn't accepted the caller as teacher"})
+			return
+		}
+	}
+
+	assignment.ID = primitive.NewObjectID()
+	assignment.Teacher = teacher
+	assignment.CreatedAt = time.Now()
+	err = repository.SaveAssignment(r.Context(), assignment)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	status, err := assignmentStatus(r.Context(), assignment, teacher)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, status)
+}
+
+func getAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	account := tenantFromContext(r.Context())
+	assignments, err := repository.Assignments(r.Context(), account)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	statuses := []AssignmentStatus{}
+	for _, assignment := range assignments {
+		status, err := assignmentStatus(r.Context(), assignment, account)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	writeResponse(w, r, statuses)
+}
+
+func getAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	account := tenantFromContext(r.Context())
+	assignment, err := repository.Assignment(r.Context(), id, account)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	status, err := assignmentStatus(r.Context(), assignment, account)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, status)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// maxAttempt is the most tries at a prompt an answer can be recorded for
+const maxAttempt = 20
+
+// AttemptOutcome sums up the answers of a chord extension by the try they
+// were given on, telling how often it is right at once from how often it
+// is right in the end
+type AttemptOutcome struct {
+	Extension string `json:"chord_extension" bson:"_id"`
+	Answers   int    `json:"answers" bson:"answers"`
+	// FirstTryCorrect counts the answers right on the first try, Correct
+	// the answers right on any
+	FirstTryCorrect int `json:"first_try_correct" bson:"first_try_correct"`
+	Correct         int `json:"correct" bson:"correct"`
+	// FirstTryAccuracy and EventualAccuracy are 0 until there are answers
+	FirstTryAccuracy float64 `json:"first_try_accuracy" bson:"-"`
+	EventualAccuracy float64 `json:"eventual_accuracy" bson:"-"`
+}
+
+// validateAttempt returns what is wrong with the attempt of an answer,
+// nothing if it is valid or the client doesn't count attempts
+func validateAttempt(stats StatsRaw) []FieldError {
+	if stats.Attempt < 0 || stats.Attempt > maxAttempt {
+		return []FieldError{{Field: "attempt", Message: fmt.Sprintf("has to be from 1 to %d", maxAttempt)}}
+	}
+	return nil
+}
+
+// firstTryCorrect tells if an answer was right on the first try. Answers
+// without an attempt count as first tries, like those of clients that
+// don't count them.
+func firstTryCorrect(stats StatsRaw) bool {
+	return (stats.Correct == nil || *stats.Correct) && stats.Attempt <= 1
+}
+
+// mergeAttempts adds up the outcomes of extensions spelled the same way now
+func (t chordAliasTable) mergeAttempts(outcomes []AttemptOutcome) []AttemptOutcome {
+	merged := []AttemptOutcome{}
+	index := make(map[string]int)
+	for _, outcome := range outcomes {
+		outcome.Extension = t.extension(outcome.Extension)
+		i, exists := index[outcome.Extension]
+		if !exists {
+			index[outcome.Extension] = len(merged)
+			merged = append(merged, outcome)
+			continue
+		}
+		merged[i].Answers += outcome.Answers
+		merged[i].FirstTryCorrect += outcome.FirstTryCorrect
+		merged[i].Correct += outcome.Correct
+	}
+	return merged
+}
+
+// attemptOutcomes returns the first-try and eventual accuracy per chord
+// extension, the answers stored with an alias counting as the extension it
+// spells
+func attemptOutcomes(ctx context.Context) ([]AttemptOutcome, error) {
+	outcomes, err := repository.AttemptOutcomes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	outcomes = aliases.mergeAttempts(outcomes)
+
+	for i, outcome := range outcomes {
+		if outcome.Answers > 0 {
+			outcomes[i].FirstTryAccuracy = float64(outcome.FirstTryCorrect) / float64(outcome.Answers)
+			outcomes[i].EventualAccuracy = float64(outcome.Correct) / float64(outcome.Answers)
+		}
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].Extension < outcomes[j].Extension })
+	return outcomes, nil
+}
+
+func getAttemptOutcomesHandler(w http.ResponseWriter, r *http.Request) {
+	outcomes, err := attemptOutcomes(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, outcomes)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// defaultUserID identifies the owner of the shared auth token, which is
+// everyone as long as there is only one token
+const defaultUserID = "default"
+
+// hashedTokenPrefix marks a token configured as its hash, see hash-token
+const hashedTokenPrefix = "sha256:"
+
+// credentials are the auth tokens of the tenants, including the main one of
+// defaultTenant
+var credentials []credential
+
+// adminCredential is the admin token, nil when the admin API is disabled
+var adminCredential *credential
+
+// credential is an auth token as a salted SHA-256 hash, so the tokens aren't
+// kept in memory after startup
+type credential struct {
+	tenant string
+	salt   []byte
+	hash   []byte
+	// expiresAt is when the token stops matching, never if nil
+	expiresAt *time.Time
+}
+
+var errInvalidHashedToken = errors.New("hashed token has to be sha256:<hex salt>:<hex hash> or sha256:<hex hash>")
+
+// newCredential makes the credential of tenant from a configured token.
+// Tokens are given either as they are, and hashed with a random salt here,
+// or already hashed as sha256:<hex salt>:<hex hash>, the salt being
+// optional.
+func newCredential(tenant, token string) (credential, error) {
+	if strings.HasPrefix(token, hashedTokenPrefix) {
+		parts := strings.Split(strings.TrimPrefix(token, hashedTokenPrefix), ":")
+		if len(parts) == 1 {
+			parts = []string{"", parts[0]}
+		}
+		salt, saltErr := hex.DecodeString(parts[0])
+		hash, hashErr := hex.DecodeString(parts[len(parts)-1])
+		if len(parts) != 2 || saltErr != nil || hashErr != nil || len(hash) != sha256.Size {
+			return credential{}, errInvalidHashedToken
+		}
+		return credential{tenant: tenant, salt: salt, hash: hash}, nil
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return credential{}, err
+	}
+	return credential{tenant: tenant, salt: salt, hash: hashToken(salt, token)}, nil
+}
+
+func hashToken(salt []byte, token string) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(token))
+	return hash.Sum(nil)
+}
+
+// matches compares in constant time, so the time taken doesn't tell how
+// much of a guessed token is right. Expired tokens never match.
+func (c credential) matches(token string) bool {
+	if c.expiresAt != nil && !time.Now().Before(*c.expiresAt) {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashToken(c.salt, token), c.hash) == 1
+}
+
+// runHashToken prints the hashed form of a token, to configure it without
+// the token itself showing up in the environment
+func runHashToken(args []string) {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprintln(os.Stderr, "Usage: hash-token <token>")
+		os.Exit(2)
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s%x:%x\n", hashedTokenPrefix, salt, hashToken(salt, args[0]))
+}
+
+// Authorize lets through requests carrying a valid auth token, a session
+// token of a signed in user, a guest token or, instead of a token, a valid
+// signature, see tenantForSignature. Guests are rate limited and aren't
+// signed in.
+func Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		tokens := r.Header["X-Auth-Token"]
+		if len(tokens) > 0 {
+			token = tokens[0]
+		}
+		failureKeys := authFailureKeys(remoteIP(r), token)
+		if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+			writeBanned(w, bannedFor)
+			return
+		}
+
+		tenant, userID := "", defaultUserID
+		if r.Header.Get("X-Signature") != "" && len(signingSecrets) > 0 {
+			var err error
+			tenant, err = tenantForSignature(r)
+			if err == errInvalidSignature || err == errStaleSignature {
+				delayFailure(r.Context(), authFailures.fail(failureKeys))
+				writeProblem(w, problemUnauthorized, "The signature is invalid: "+err.Error())
+				return
+			}
+			if err != nil {
+				writeInvalidBody(w, err)
+				return
+			}
+		} else if isSessionToken(token) {
+			session, err := sessionForToken(r.Context(), token, remoteIP(r))
+			if err == errNotFound {
+				delayFailure(r.Context(), authFailures.fail(failureKeys))
+				writeProblem(w, problemUnauthorized, "The session is unknown or expired")
+				return
+			}
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			tenant, userID = session.Tenant, session.UserID
+			r = r.WithContext(withSession(r.Context(), session))
+		} else if isGuestToken(token) {
+			session, err := guestForToken(r.Context(), token)
+			if err == errNotFound {
+				delayFailure(r.Context(), authFailures.fail(failureKeys))
+				writeProblem(w, problemUnauthorized, "The guest token is unknown or expired")
+				return
+			}
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			if allowed, retryIn := guestRequests.allow(session.Tenant); !allowed {
+				writeRateLimited(w, retryIn)
+				return
+			}
+			tenant, userID = session.Tenant, session.UserID
+		} else {
+			var valid bool
+			tenant, valid = tenantForToken(r.Context(), token)
+			if !valid {
+				delayFailure(r.Context(), authFailures.fail(failureKeys))
+				writeProblem(w, problemUnauthorized, "")
+				return
+			}
+		}
+
+		recordCaller(r.Context(), tenant, userID)
+		ctx := withTenant(withUser(r.Context(), userID), tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AuthorizeAdmin lets through requests carrying the admin token. Without a
+// configured admin token the admin API is not served at all.
+func AuthorizeAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminCredential == nil {
+			writeProblem(w, problemNotFound, "")
+			return
+		}
+		failureKeys := authFailureKeys(remoteIP(r), r.Header.Get("X-Auth-Token"))
+		if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+			writeBanned(w, bannedFor)
+			return
+		}
+		if !adminCredential.matches(r.Header.Get("X-Auth-Token")) {
+			delayFailure(r.Context(), authFailures.fail(failureKeys))
+			writeProblem(w, problemUnauthorized, "")
+			return
+		}
+
+		recordCaller(r.Context(), allTenants, "")
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), allTenants)))
+	})
+}
+
+// tenantForToken returns the tenant an auth token belongs to, if it is
+// valid. Both the configured and the minted tokens are valid. Every
+// credential is compared, so the time taken doesn't tell which one matched.
+func tenantForToken(ctx context.Context, token string) (string, bool) {
+	if mockMode && len(credentials) == 0 {
+		return defaultTenant, true
+	}
+	if token == "" {
+		return "", false
+	}
+
+	tenant, valid := "", false
+	for _, c := range credentials {
+		if c.matches(token) && !valid {
+			tenant, valid = c.tenant, true
+		}
+	}
+	for _, c := range cachedMintedCredentials(ctx) {
+		if c.matches(token) && !valid {
+			tenant, valid = c.tenant, true
+		}
+	}
+	return tenant, valid
+}
+
+func withUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userContextKey, userID)
+}
+
+// userFromContext returns the ID of the authorized caller
+func userFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userContextKey).(string)
+	return userID
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backupInsertBatch is how many documents a restore inserts at a time
+const backupInsertBatch = 1000
+
+// backupOptions configure where backups are kept, shared by the server and
+// the restore command
+type backupOptions struct {
+	BackupS3Endpoint  string `long:"backup-s3-endpoint" env:"BACKUP_S3_ENDPOINT" default:"https://s3.amazonaws.com" description:"URL of the S3-compatible storage backups are kept in"`
+	BackupS3Region    string `long:"backup-s3-region" env:"BACKUP_S3_REGION" default:"us-east-1" description:"Region of the backup bucket"`
+	BackupS3Bucket    string `long:"backup-s3-bucket" env:"BACKUP_S3_BUCKET" description:"Bucket backups are kept in"`
+	BackupS3Prefix    string `long:"backup-s3-prefix" env:"BACKUP_S3_PREFIX" default:"backups/" description:"Prefix of the keys of backups in the bucket"`
+	BackupS3AccessKey string `long:"backup-s3-access-key" env:"BACKUP_S3_ACCESS_KEY" description:"Access key ID of the backup bucket"`
+	BackupS3SecretKey string `long:"backup-s3-secret-key" env:"BACKUP_S3_SECRET_KEY" description:"Secret access key of the backup bucket"`
+}
+
+func (o backupOptions) s3Client() (*s3Client, error) {
+	if o.BackupS3Bucket == "" || o.BackupS3AccessKey == "" || o.BackupS3SecretKey == "" {
+		return nil, errors.New("backups need an S3 bucket, access key and secret key")
+	}
+	return newS3Client(o.BackupS3Endpoint, o.BackupS3Bucket, o.BackupS3Region, o.BackupS3AccessKey, o.BackupS3SecretKey)
+}
+
+// backupLine is a line of a backup, which is gzipped with one document of
+// any collection per line. Documents are canonical extended JSON, so they
+// are restored with the same types.
+type backupLine struct {
+	Collection string          `json:"collection"`
+	Document   json.RawMessage `json:"document"`
+}
+
+// dumpDatabase writes every document of every collection of db to w. It
+// isn't a consistent snapshot, writes made while dumping may be left out.
+func dumpDatabase(ctx context.Context, db *mongo.Database, w io.Writer) (int, error) {
+	names, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	writer := gzip.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	count := 0
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		cursor, err := db.Collection(name).Find(ctx, bson.M{})
+		if err != nil {
+			return count, err
+		}
+		for cursor.Next(ctx) {
+			document, err := bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				cursor.Close(ctx)
+				return count, err
+			}
+			err = encoder.Encode(backupLine{Collection: name, Document: document})
+			if err != nil {
+				cursor.Close(ctx)
+				return count, err
+			}
+			count++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, writer.Close()
+}
+
+// restoreDatabase inserts the documents of a backup into db. Unless drop is
+// set it refuses to touch collections that already hold documents, so a
+// restore never mixes with live data.
+func restoreDatabase(ctx context.Context, db *mongo.Database, r io.Reader, drop bool) (int, error) {
+	reader, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	prepared := make(map[string]bool)
+	batch := make(map[string][]interface{})
+	flush := func(name string) error {
+		if len(batch[name]) == 0 {
+			return nil
+		}
+		_, err := db.Collection(name).InsertMany(ctx, batch[name])
+		batch[name] = batch[name][:0]
+		return err
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		var line backupLine
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return count, err
+		}
+
+		if !prepared[line.Collection] {
+			err = prepareRestore(ctx, db.Collection(line.Collection), drop)
+			if err != nil {
+				return count, err
+			}
+			prepared[line.Collection] = true
+		}
+
+		var document bson.D
+		err = bson.UnmarshalExtJSON(line.Document, true, &document)
+		if err != nil {
+			return count, err
+		}
+		batch[line.Collection] = append(batch[line.Collection], document)
+		if len(batch[line.Collection]) >= backupInsertBatch {
+			err = flush(line.Collection)
+			if err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+	if err = scanner.Err(); err != nil {
+		return count, err
+	}
+
+	for name := range batch {
+		err = flush(name)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func prepareRestore(ctx context.Context, collection *mongo.Collection, drop bool) error {
+	if drop {
+		return collection.Drop(ctx)
+	}
+	existing, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return fmt.Errorf("collection %s isn't empty, restore with --drop to replace it", collection.Name())
+	}
+	return nil
+}
+
+// backup uploads a dump of db as a new backup, named after when it was taken
+func backup(ctx context.Context, db *mongo.Database, storage *s3Client, prefix string) (string, error) {
+	// the dump is spooled to disk, since uploads need to know their size
+	file, err := ioutil.TempFile("", "backup-*.ndjson.gz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	count, err := dumpDatabase(ctx, db, file)
+	if err != nil {
+		return "", err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	key := prefix + time.Now().UTC().Format("2006-01-02T15-04-05Z") + ".ndjson.gz"
+	err = storage.Put(ctx, key, file, size)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Backed up %d documents to %s\n", count, key)
+	return key, nil
+}
+
+// backupJob takes backups on the given cron schedule, in UTC. Backups only
+// read, so they are taken in maintenance mode too.
+func backupJob(schedule string, db *mongo.Database, storage *s3Client, prefix string) *job {
+	return &job{
+		name:              "backup",
+		schedule:          schedule,
+		timeout:           6 * time.Hour,
+		duringMaintenance: true,
+		run: func(ctx context.Context) error {
+			_, err := backup(ctx, db, storage, prefix)
+			if err != nil {
+				backupFailuresTotal.Inc()
+				return err
+			}
+			lastBackupTimestamp.SetToCurrentTime()
+			return nil
+		},
+	}
+}
+
+// runRestore is the restore command, restoring a backup into Mongo
+func runRestore(args []string) {
+	var options struct {
+		MongoUrl string `short:"u" env:"MONGODB_URL" description:"URL to mongo" required:"true"`
+		Backup   string `long:"backup" description:"Key of the backup to restore, the latest one if empty"`
+		Drop     bool   `long:"drop" description:"Replace the collections in the backup instead of refusing to restore into them when not empty"`
+		backupOptions
+	}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Usage = "restore [OPTIONS]"
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		log.Fatalln("Error parsing input:", err)
+	}
+	storage, err := options.s3Client()
+	if err != nil {
+		log.Fatalln("Error parsing input:", err)
+	}
+
+	ctx := context.Background()
+	key := options.Backup
+	if key == "" {
+		keys, err := storage.List(ctx, options.BackupS3Prefix)
+		if err != nil {
+			log.Fatalln("Failed to list backups! Error:", err)
+		}
+		if len(keys) == 0 {
+			log.Fatalln("No backups found under", options.BackupS3Prefix)
+		}
+		key = keys[len(keys)-1]
+	}
+
+	body, err := storage.Get(ctx, key)
+	if err != nil {
+		log.Fatalln("Failed to download backup! Error:", err)
+	}
+	defer body.Close()
+
+	client := connectToMongo(options.MongoUrl, mongoSettings{
+		MaxPoolSize:            20,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+	})
+	defer client.Disconnect(ctx)
+
+	count, err := restoreDatabase(ctx, client.Database("main"), body, options.Drop)
+	if err != nil {
+		log.Fatalf("Failed to restore %s after %d documents! Error: %s\n", key, count, err)
+	}
+	log.Printf("Restored %d documents from %s\n", count, key)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// badgeTokenPrefix starts every badge token. Badge tokens end up in public
+// pages, so they only grant reading the badge and the feed, see
+// AuthorizeFeed.
+const badgeTokenPrefix = "pcb_"
+
+// badgeMaxAge is how long browsers and image proxies like GitHub's camo
+// may cache a badge
+const badgeMaxAge = 5 * time.Minute
+
+// Badge lets anyone with its token see the streak badge of a user
+type Badge struct {
+	Tenant string `json:"-" bson:"tenant"`
+	UserID string `json:"-" bson:"user"`
+	// Hash is the SHA-256 of the token, which is random enough to be
+	// looked up by hash like session tokens
+	Hash      []byte    `json:"-" bson:"hash"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// Token and URL are only returned when created
+	Token string `json:"token,omitempty" bson:"-"`
+	URL   string `json:"url,omitempty" bson:"-"`
+}
+
+func badgeHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// streakDays returns the number of days in a row, in loc, with answers up
+// to today. A streak without an answer yet today is still counted, it only
+// ends at midnight. Paused and frozen days don't end the streak but don't
+// count either.
+func streakDays(ctx context.Context, loc *time.Location) (int, error) {
+	counts, err := repository.CountByDay(ctx, loc)
+	if err != nil {
+		return 0, err
+	}
+	practiced := make(map[string]bool, len(counts))
+	for _, count := range counts {
+		practiced[count.Day] = count.Count > 0
+	}
+	kept, err := keptDays(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	day := time.Now().In(loc)
+	if !practiced[day.Format("2006-01-02")] {
+		day = day.AddDate(0, 0, -1)
+	}
+	streak := 0
+	for practiced[day.Format("2006-01-02")] || kept[day.Format("2006-01-02")] {
+		if practiced[day.Format("2006-01-02")] {
+			streak++
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak, nil
+}
+
+// badgeSVG renders a badge in the flat style of shields.io, the widths
+// estimated from the average width of Verdana at 11px
+func badgeSVG(label, message, color string) []byte {
+	labelWidth, messageWidth := 10+len([]rune(label))*7, 10+len([]rune(message))*7
+	width := labelWidth + messageWidth
+	label, message = html.EscapeString(label), html.EscapeString(message)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, message)
+	fmt.Fprintf(&svg, `<title>%s: %s</title>`, label, message)
+	svg.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&svg, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&svg, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`, labelWidth, labelWidth, messageWidth, color, width)
+	svg.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&svg, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth/2, label, labelWidth/2, label)
+	fmt.Fprintf(&svg, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, labelWidth+messageWidth/2, message, labelWidth+messageWidth/2, message)
+	svg.WriteString(`</g></svg>`)
+	return []byte(svg.String())
+}
+
+// getStreakBadgeHandler serves the streak badge of the user whose badge
+// token is in the token query param. It is public, so it can be embedded.
+func getStreakBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if !strings.HasPrefix(token, badgeTokenPrefix) {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	badge, err := repository.BadgeByHash(r.Context(), badgeHash(token))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	ctx := withTenant(withUser(r.Context(), badge.UserID), badge.Tenant)
+	loc, err := userLocation(ctx, badge.UserID, "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	streak, err := streakDays(ctx, loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	total, err := repository.CountStats(ctx)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	color := "#9f9f9f"
+	if streak > 0 {
+		color = "#fe7d37"
+	}
+	message := fmt.Sprintf("%d days | %d chords", streak, total)
+	if streak == 1 {
+		message = fmt.Sprintf("1 day | %d chords", total)
+	}
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(badgeMaxAge.Seconds())))
+	w.Write(badgeSVG("streak", message, color))
+}
+
+// createBadgeHandler issues a new badge token for the caller, replacing the
+// previous one, which stops working
+func createBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	token := badgeTokenPrefix + hex.EncodeToString(secret)
+
+	badge := Badge{
+		Tenant:    tenantFromContext(r.Context()),
+		UserID:    userFromContext(r.Context()),
+		Hash:      badgeHash(token),
+		CreatedAt: time.Now(),
+	}
+	err = repository.SaveBadge(r.Context(), badge)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	badge.Token = token
+	badge.URL = "/badge/streak.svg?token=" + token
+	writeResponseStatus(w, r, http.StatusCreated, badge)
+}
+
+// deleteBadgeHandler revokes the caller's badge token
+func deleteBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	err := repository.DeleteBadge(r.Context(), userFromContext(r.Context()))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
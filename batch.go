@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// statsBatcher buffers plain inserts to save them in batches, nil when
+// disabled
+var statsBatcher *insertBatcher
+
+// insertBatcher collects stats from many requests and inserts them with a
+// single round trip per tenant, once size stats are collected or interval
+// has passed since the first one. Like the write queue it lives in memory,
+// so stats collected when the server stops are lost.
+type insertBatcher struct {
+	items    chan queuedStats
+	size     int
+	interval time.Duration
+}
+
+func newInsertBatcher(size int, interval time.Duration) *insertBatcher {
+	return &insertBatcher{
+		items:    make(chan queuedStats, size),
+		size:     size,
+		interval: interval,
+	}
+}
+
+// Push adds stats to the next batch on behalf of the tenant and user of ctx, waiting
+// while the buffer is full. The stats get their id here, so it can be
+// returned before they are saved.
+func (b *insertBatcher) Push(ctx context.Context, stats StatsRaw) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	stats.ID = primitive.NewObjectID()
+	stats.Version = 1
+
+	select {
+	case b.items <- queuedStats{statsCaller: statsCaller{tenant: tenant, user: userFromContext(ctx)}, stats: stats}:
+		return stats, nil
+	case <-ctx.Done():
+		return StatsRaw{}, ctx.Err()
+	}
+}
+
+// Run collects and saves batches until the server stops
+func (b *insertBatcher) Run() {
+	for {
+		batch := []queuedStats{<-b.items}
+		deadline := time.After(b.interval)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case item := <-b.items:
+				batch = append(batch, item)
+			case <-deadline:
+				break collect
+			}
+		}
+		b.flush(batch)
+	}
+}
+
+// flush saves a batch. When Mongo is unavailable the stats are handed to the
+// write queue, if there is one, and otherwise they are dropped.
+func (b *insertBatcher) flush(batch []queuedStats) {
+	// the stats of a tenant are sent by the same user nearly always, their
+	// user is needed once they are saved
+	byCaller := make(map[statsCaller][]StatsRaw)
+	for _, item := range batch {
+		byCaller[item.statsCaller] = append(byCaller[item.statsCaller], item.stats)
+	}
+
+	for caller, stats := range byCaller {
+		ctx := caller.context()
+		err := repository.InsertStats(ctx, stats)
+		if unavailable(err) && statsQueue != nil {
+			for _, s := range stats {
+				err = statsQueue.Push(ctx, s)
+				if err != nil {
+					statsDroppedTotal.Inc()
+					log.Println("Dropped batched stats! Error:", err)
+					reportError(ctx, err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			statsDroppedTotal.Add(float64(len(stats)))
+			log.Println("Dropped batched stats! Error:", err)
+			reportError(ctx, err)
+			continue
+		}
+		recordStatsSaved(ctx, stats...)
+	}
+
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitOpenError is returned instead of calling a database that keeps
+// failing
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("database circuit open, retry after %s", e.retryAfter)
+}
+
+// circuitBreaker stops calls to the database after threshold consecutive
+// transient failures, so requests fail fast instead of piling up behind a
+// dead database. After cooldown a single call is let through as a probe,
+// closing the circuit when it succeeds and opening it again when it fails.
+// A nil circuitBreaker lets every call through.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	// openedAt is zero while the circuit is closed
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow returns a circuitOpenError when a call must not be made, every
+// allowed call has to be followed by Record
+func (b *circuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	wait := b.cooldown - time.Since(b.openedAt)
+	if wait > 0 {
+		return circuitOpenError{retryAfter: wait}
+	}
+	if b.probing {
+		return circuitOpenError{retryAfter: time.Second}
+	}
+	b.probing = true
+	return nil
+}
+
+// Record counts the outcome of an allowed call. Only transient errors count
+// as failures, others still mean the database answered.
+func (b *circuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !transient(err) {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
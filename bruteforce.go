@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// freeAuthFailures is how many failures are answered right away, so a
+	// client with a mistyped token isn't slowed down
+	freeAuthFailures = 3
+	// authFailureDelay is the delay of the first slowed down failure,
+	// doubled for every following one up to maxAuthFailureDelay
+	authFailureDelay    = 100 * time.Millisecond
+	maxAuthFailureDelay = 5 * time.Second
+	// authTokenPrefixLength is how much of a wrong token failures are
+	// counted by, besides the IP, so guessing from many IPs is caught too
+	authTokenPrefixLength = 8
+	// maxAuthFailureEntries bounds the memory taken by failures, forgotten
+	// ones are dropped when it is reached
+	maxAuthFailureEntries = 10000
+)
+
+// authFailures counts failed auth attempts per IP and per token prefix, and
+// bans those with too many. Failures are only forgotten after the ban
+// duration without one, not on success, so a tenant can't keep guessing
+// other tenants' tokens in between valid requests. When running several
+// replicas they count together through Redis.
+var authFailures authFailureCounter = newAuthGuard(20, 15*time.Minute)
+
+type authFailureCounter interface {
+	// bannedFor returns how long any of keys is still banned, 0 if none is
+	bannedFor(keys []string) time.Duration
+	// fail counts a failure for each of keys, banning those reaching the
+	// threshold, and returns how long to delay answering it
+	fail(keys []string) time.Duration
+	// setLimits changes the threshold and the ban duration, for the
+	// failures coming after
+	setLimits(banAfter int, banDuration time.Duration)
+}
+
+type authGuard struct {
+	mu          sync.Mutex
+	entries     map[string]*authFailureEntry
+	banAfter    int
+	banDuration time.Duration
+}
+
+type authFailureEntry struct {
+	count       int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+func newAuthGuard(banAfter int, banDuration time.Duration) *authGuard {
+	return &authGuard{
+		entries:     make(map[string]*authFailureEntry),
+		banAfter:    banAfter,
+		banDuration: banDuration,
+	}
+}
+
+// authFailureKeys returns what failures of a request from ip with token are
+// counted by
+func authFailureKeys(ip, token string) []string {
+	keys := []string{"ip:" + ip}
+	if len(token) >= authTokenPrefixLength {
+		keys = append(keys, "token:"+token[:authTokenPrefixLength])
+	}
+	return keys
+}
+
+func (g *authGuard) bannedFor(keys []string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var longest time.Duration
+	for _, key := range keys {
+		entry := g.entries[key]
+		if entry == nil {
+			continue
+		}
+		if remaining := time.Until(entry.bannedUntil); remaining > longest {
+			longest = remaining
+		}
+	}
+	return longest
+}
+
+func (g *authGuard) fail(keys []string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	authFailuresTotal.Inc()
+	now := time.Now()
+	if len(g.entries) >= maxAuthFailureEntries {
+		g.forget(now)
+	}
+	most := 0
+	for _, key := range keys {
+		entry := g.entries[key]
+		if entry == nil || now.Sub(entry.lastFailure) > g.banDuration {
+			entry = &authFailureEntry{}
+			g.entries[key] = entry
+		}
+		entry.count++
+		entry.lastFailure = now
+		if entry.count == g.banAfter {
+			entry.bannedUntil = now.Add(g.banDuration)
+			authBansTotal.Inc()
+		}
+		if entry.count > most {
+			most = entry.count
+		}
+	}
+
+	return authFailureDelayFor(most)
+}
+
+func (g *authGuard) setLimits(banAfter int, banDuration time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.banAfter, g.banDuration = banAfter, banDuration
+}
+
+// authFailureDelayFor returns how long to delay answering the failures-th
+// failure in a row
+func authFailureDelayFor(failures int) time.Duration {
+	if failures <= freeAuthFailures {
+		return 0
+	}
+	delay := float64(authFailureDelay) * math.Pow(2, float64(failures-freeAuthFailures-1))
+	return time.Duration(math.Min(delay, float64(maxAuthFailureDelay)))
+}
+
+// forget drops the entries that are neither banned nor failed recently, g.mu
+// has to be held
+func (g *authGuard) forget(now time.Time) {
+	for key, entry := range g.entries {
+		if now.After(entry.bannedUntil) && now.Sub(entry.lastFailure) > g.banDuration {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// redisAuthGuard counts failures in Redis, so a guesser spreading requests
+// over the replicas is banned as soon as by a single one. Failures are let
+// through without counting while Redis is unavailable, rather than failing
+// every auth.
+type redisAuthGuard struct {
+	client *redis.Client
+
+	mu          sync.RWMutex
+	banAfter    int
+	banDuration time.Duration
+}
+
+func newRedisAuthGuard(client *redis.Client, banAfter int, banDuration time.Duration) *redisAuthGuard {
+	return &redisAuthGuard{client: client, banAfter: banAfter, banDuration: banDuration}
+}
+
+func (g *redisAuthGuard) setLimits(banAfter int, banDuration time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.banAfter, g.banDuration = banAfter, banDuration
+}
+
+func (g *redisAuthGuard) limits() (int, time.Duration) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.banAfter, g.banDuration
+}
+
+func (g *redisAuthGuard) bannedFor(keys []string) time.Duration {
+	ctx := context.Background()
+	pipe := g.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(ctx, "auth:banned:"+key)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		log.Println("Failed to read auth bans! Error:", err)
+		return 0
+	}
+
+	var longest time.Duration
+	for _, ttl := range ttls {
+		if remaining := ttl.Val(); remaining > longest {
+			longest = remaining
+		}
+	}
+	return longest
+}
+
+func (g *redisAuthGuard) fail(keys []string) time.Duration {
+	authFailuresTotal.Inc()
+	banAfter, banDuration := g.limits()
+	ctx := context.Background()
+	pipe := g.client.TxPipeline()
+	counts := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		counts[i] = pipe.Incr(ctx, "auth:failures:"+key)
+		pipe.PExpire(ctx, "auth:failures:"+key, banDuration)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Println("Failed to count auth failure! Error:", err)
+		return 0
+	}
+
+	most := 0
+	for i, count := range counts {
+		if int(count.Val()) == banAfter {
+			err = g.client.Set(ctx, "auth:banned:"+keys[i], 1, banDuration).Err()
+			if err != nil {
+				log.Println("Failed to ban after auth failures! Error:", err)
+			}
+			authBansTotal.Inc()
+		}
+		if int(count.Val()) > most {
+			most = int(count.Val())
+		}
+	}
+	return authFailureDelayFor(most)
+}
+
+// remoteIP returns the IP of r without the port. TrustProxies has already
+// replaced the address with the one of a trusted proxy's client, if any.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeBanned answers a request from a banned IP or token prefix
+func writeBanned(w http.ResponseWriter, bannedFor time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(bannedFor.Seconds()))))
+	writeProblem(w, problemTooManyAuthFailures, "")
+}
+
+// delayFailure waits before a failure is answered, so guessing is slow even
+// before it gets banned
+func delayFailure(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatsDeletion asks to delete the stats matching its filter, e.g. the ones
+// of a bad import. Without Confirm it is a dry run, only counting the
+// matches, which answers with the Confirm that deletes them.
+type StatsDeletion struct {
+	// Tenant limits the deletion to a tenant, for signed in users the ID of
+	// the user
+	Tenant         string     `json:"tenant,omitempty"`
+	ChordName      string     `json:"chord_name,omitempty"`
+	RootNote       string     `json:"root_note,omitempty"`
+	ChordExtension string     `json:"chord_extension,omitempty"`
+	Since          *time.Time `json:"since,omitempty"`
+	Until          *time.Time `json:"until,omitempty"`
+	Confirm        string     `json:"confirm,omitempty"`
+}
+
+// StatsDeletionReport tells how many stats matched the filter of a deletion,
+// and how many were deleted
+type StatsDeletionReport struct {
+	DryRun  bool `json:"dry_run"`
+	Matched int  `json:"matched"`
+	Deleted int  `json:"deleted"`
+	// Confirm is sent back to delete what the dry run matched
+	Confirm string `json:"confirm,omitempty"`
+}
+
+func (d StatsDeletion) filter() StatsFilter {
+	filter := StatsFilter{ChordName: d.ChordName, RootNote: d.RootNote, ChordExtension: d.ChordExtension}
+	if d.Since != nil {
+		filter.Since = *d.Since
+	}
+	if d.Until != nil {
+		filter.Until = *d.Until
+	}
+	return filter
+}
+
+// validate returns what is wrong with the deletion, nothing if it is valid.
+// Deleting everything takes dropping the database, not a filter matching
+// every answer.
+func (d StatsDeletion) validate() []FieldError {
+	if d.Tenant == "" && d.ChordName == "" && d.RootNote == "" && d.ChordExtension == "" && d.Since == nil && d.Until == nil {
+		return []FieldError{{Message: "at least one of tenant, chord_name, root_note, chord_extension, since and until is required"}}
+	}
+	if d.Tenant == allTenants {
+		return []FieldError{{Field: "tenant", Message: "isn't a tenant"}}
+	}
+	if d.Since != nil && d.Until != nil && !d.Since.Before(*d.Until) {
+		return []FieldError{{Field: "until", Message: "has to be after since"}}
+	}
+	return nil
+}
+
+// confirmation returns the Confirm of a dry run of d that matched matched
+// stats. A deletion only goes ahead with the filter and the number of
+// matches of its dry run, so it never deletes more than was looked at.
+func (d StatsDeletion) confirmation(matched int) string {
+	d.Confirm = ""
+	body, _ := json.Marshal(d)
+	sum := sha256.Sum256(append(body, strconv.Itoa(matched)...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// deleteStatsHandler deletes the stats matching a filter across all tenants
+// or of one, after a dry run. Archived stats aren't deleted.
+func deleteStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var deletion StatsDeletion
+	err := decodeRequest(r, &deletion)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := deletion.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	ctx := r.Context()
+	if deletion.Tenant != "" {
+		ctx = withTenant(ctx, deletion.Tenant)
+	}
+	matched, err := repository.CountMatchingStats(ctx, deletion.filter())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if deletion.Confirm == "" {
+		writeResponse(w, r, StatsDeletionReport{DryRun: true, Matched: matched, Confirm: deletion.confirmation(matched)})
+		return
+	}
+	if deletion.Confirm != deletion.confirmation(matched) {
+		writeProblem(w, problemDryRunOutdated, "The filter or its matches changed since the dry run, run it again")
+		return
+	}
+
+	deleted, err := repository.DeleteStats(ctx, deletion.filter())
+	if deleted > 0 {
+		log.Printf("Deleted %d stats matching %+v\n", deleted, deletion.filter())
+		if aggregateCache != nil {
+			aggregateCache.Invalidate()
+		}
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, StatsDeletionReport{Matched: matched, Deleted: deleted})
+}
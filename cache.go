@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var aggregateCache responseCache
+
+// responseCache stores rendered aggregation responses. It is either kept in
+// process memory or, when running several replicas, shared through Redis.
+type responseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte)
+	Invalidate()
+}
+
+type cacheEntry struct {
+	key       string
+	body      []byte
+	expiresAt time.Time
+}
+
+// memoryCache is a small in-memory TTL cache for aggregation responses. It
+// holds at most maxEntries, evicting the least recently used beyond that, so
+// responses that are never asked for again don't pile up.
+type memoryCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	// recent has the most recently used entry in front
+	recent  *list.List
+	entries map[string]*list.Element
+}
+
+func newMemoryCache(ttl time.Duration, maxEntries int) *memoryCache {
+	return &memoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		recent:     list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.body, true
+}
+
+func (c *memoryCache) Set(key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, body: body, expiresAt: time.Now().Add(c.ttl)}
+	if element, exists := c.entries[key]; exists {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.maxEntries {
+		c.remove(c.recent.Back())
+	}
+}
+
+func (c *memoryCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// Invalidate drops all cached responses, called after every write
+func (c *memoryCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// cacheQueryParams are the query params the cached aggregations read. Only
+// they are part of the cache key, made up params would otherwise give every
+// request a key of its own. A param the aggregations start reading has to be
+// added here.
+var cacheQueryParams = []string{"days", "instrument", "timezone"}
+
+// cacheKey identifies a response by endpoint, tenant, query params and
+// response format. Only the first value of each of cacheQueryParams counts,
+// like the handlers read them, in a fixed order.
+func cacheKey(r *http.Request) string {
+	query, params := r.URL.Query(), url.Values{}
+	for _, param := range cacheQueryParams {
+		if value := query.Get(param); value != "" {
+			params.Set(param, value)
+		}
+	}
+	return r.URL.Path + "|" + tenantFromContext(r.Context()) + "|" + params.Encode() + "|" + responseFormat(r)
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// CacheAggregate serves successful responses from the aggregate cache while
+// they are fresh, so repeated dashboard refreshes don't rerun the pipelines
+func CacheAggregate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if aggregateCache == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if body, exists := aggregateCache.Get(key); exists {
+			if wantsMsgpack(r) {
+				w.Header().Set("Content-Type", contentTypeMsgpack)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(body)
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusOK {
+			aggregateCache.Set(key, recorder.body.Bytes())
+		}
+	})
+}
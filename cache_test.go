@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvicts(t *testing.T) {
+	cache := newMemoryCache(time.Minute, 2)
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	// a is used, so b is the one to go
+	cache.Get("a")
+	cache.Set("c", []byte("3"))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, exists := cache.Get(key); exists != want {
+			t.Errorf("%s cached %t, want %t", key, exists, want)
+		}
+	}
+	if len(cache.entries) != 2 || cache.recent.Len() != 2 {
+		t.Errorf("holds %d entries in the map and %d in the list, want 2", len(cache.entries), cache.recent.Len())
+	}
+
+	cache.Set("a", []byte("4"))
+	if body, _ := cache.Get("a"); string(body) != "4" {
+		t.Errorf("a is %s after setting it again, want 4", body)
+	}
+	cache.Invalidate()
+	if _, exists := cache.Get("a"); exists || cache.recent.Len() != 0 {
+		t.Error("entries are left after invalidating")
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	cache := newMemoryCache(time.Millisecond, 10)
+	cache.Set("a", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+
+	if _, exists := cache.Get("a"); exists {
+		t.Error("expired entry is served")
+	}
+	if len(cache.entries) != 0 || cache.recent.Len() != 0 {
+		t.Error("expired entry is kept after reading it")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := func(target string) string {
+		r := httptest.NewRequest("GET", target, nil)
+		return cacheKey(r.WithContext(withTenant(context.Background(), defaultTenant)))
+	}
+	want := key("/stats/root_coverage?days=7&timezone=UTC")
+
+	tests := []struct {
+		name   string
+		target string
+		same   bool
+	}{
+		{"params in another order", "/stats/root_coverage?timezone=UTC&days=7", true},
+		{"unknown param", "/stats/root_coverage?days=7&timezone=UTC&nocache=123", true},
+		{"repeated param", "/stats/root_coverage?days=7&timezone=UTC&days=30", true},
+		{"other value", "/stats/root_coverage?days=30&timezone=UTC", false},
+		{"param left out", "/stats/root_coverage?days=7", false},
+		{"other instrument", "/stats/root_coverage?days=7&timezone=UTC&instrument=guitar", false},
+		{"other endpoint", "/stats/records?days=7&timezone=UTC", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := key(test.target) == want; same != test.same {
+				t.Errorf("same key %t, want %t", same, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,1153 @@
+// Package client is a Go client for the piano chord training backend HTTP
+// API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is a single answer to a chord prompt
+type Stats struct {
+	// ID is assigned by the server
+	ID                         string    `json:"id,omitempty"`
+	ChordName                  string    `json:"chord_name"`
+	RootNote                   string    `json:"root_note"`
+	ChordExtension             string    `json:"chord_extension"`
+	AnswerDurationMilliSeconds int       `json:"answer_duration_millis"`
+	CreatedAt                  time.Time `json:"created_at"`
+	// ClientID is an optional UUID, resubmitting stats with the same client
+	// ID updates them instead of storing them twice
+	ClientID string `json:"client_id,omitempty"`
+	// UpdatedAt decides which of two writes with the same client ID wins,
+	// it defaults to CreatedAt
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// Platform and AppVersion identify the client that recorded the answer
+	Platform   string `json:"platform,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	// Correct tells if the answer was right, nil counts as right
+	Correct *bool `json:"correct,omitempty"`
+	// Experiments are the variants the caller was in when answering, set by
+	// the server
+	Experiments map[string]string `json:"experiments,omitempty"`
+	// Version is bumped by the server on every accepted write
+	Version int64 `json:"version,omitempty"`
+}
+
+// StatsCorrection corrects a stored answer, only the fields set are changed
+type StatsCorrection struct {
+	// Correct can only be set to false
+	Correct        *bool    `json:"correct,omitempty"`
+	ChordExtension *string  `json:"chord_extension,omitempty"`
+	AddTags        []string `json:"add_tags,omitempty"`
+}
+
+// StatsPage is a page of the stored answers
+type StatsPage struct {
+	Stats []Stats `json:"data"`
+	// NextCursor asks for the next page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total counts the answers of all pages
+	Total int `json:"total"`
+}
+
+type CountByDay struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+type CountByExtension struct {
+	Extension string `json:"chord_extension"`
+	Count     int    `json:"count"`
+}
+
+type DurationByExtension struct {
+	Extension string `json:"chord_extension"`
+	// AvgDuration is the average answer duration in seconds
+	AvgDuration float64 `json:"avg_duration"`
+}
+
+// Tombstone marks a deleted stat
+type Tombstone struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// SyncChanges is a page of changes, see Client.SyncChanges
+type SyncChanges struct {
+	Stats      []Stats     `json:"stats"`
+	Deleted    []Tombstone `json:"deleted"`
+	NextCursor string      `json:"next_cursor"`
+	HasMore    bool        `json:"has_more"`
+}
+
+// Settings are the caller's preferences. Nil fields are left unchanged by
+// UpdateSettings.
+type Settings struct {
+	Version           int64          `json:"version,omitempty"`
+	Theme             *string        `json:"theme,omitempty"`
+	PreferredNotation *string        `json:"preferred_notation,omitempty"`
+	DrillDefaults     *DrillDefaults `json:"drill_defaults,omitempty"`
+	Timezone          *string        `json:"timezone,omitempty"`
+	ModifiedAt        *time.Time     `json:"modified_at,omitempty"`
+}
+
+type DrillDefaults struct {
+	RootNotes       []string `json:"root_notes"`
+	ChordExtensions []string `json:"chord_extensions"`
+	ChordsPerDrill  int      `json:"chords_per_drill"`
+}
+
+// NotificationPreferences tell which notifications the caller gets and how.
+// Nil fields are left unchanged by UpdateNotificationPreferences.
+type NotificationPreferences struct {
+	Channels   *NotificationChannels `json:"channels,omitempty"`
+	Events     *NotificationEvents   `json:"events,omitempty"`
+	QuietHours *QuietHours           `json:"quiet_hours,omitempty"`
+	ModifiedAt *time.Time            `json:"modified_at,omitempty"`
+}
+
+type NotificationChannels struct {
+	Email      bool   `json:"email"`
+	Push       bool   `json:"push"`
+	Webhook    bool   `json:"webhook"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+type NotificationEvents struct {
+	StreakRisk   bool `json:"streak_risk"`
+	WeeklyReport bool `json:"weekly_report"`
+	Achievements bool `json:"achievements"`
+}
+
+// QuietHours are as HH:MM in the timezone of the settings
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Badge is a token for the caller's streak badge, served at URL
+type Badge struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StreakPause is a planned break of the caller from From to Until, days
+// like 2006-01-02 in the timezone of the settings. Its days neither end nor
+// add to the streak.
+type StreakPause struct {
+	ID        string    `json:"id,omitempty"`
+	From      string    `json:"from"`
+	Until     string    `json:"until"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StreakFreezes tells how many streak freezes the caller has left. Freezes
+// are granted by achievements and used up on single missed days.
+type StreakFreezes struct {
+	Remaining int      `json:"remaining"`
+	Earned    int      `json:"earned"`
+	Used      []string `json:"used"`
+}
+
+// Integration posts the caller's milestones to a Slack or Discord channel,
+// through WebhookURL or as a bot with BotToken posting to Channel. The
+// secrets are only sent, never returned.
+type Integration struct {
+	ID            string             `json:"id,omitempty"`
+	Kind          string             `json:"kind"`
+	WebhookURL    string             `json:"webhook_url,omitempty"`
+	BotToken      string             `json:"bot_token,omitempty"`
+	Channel       string             `json:"channel,omitempty"`
+	Events        *IntegrationEvents `json:"events,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	SummarySentAt *time.Time         `json:"summary_sent_at,omitempty"`
+}
+
+type IntegrationEvents struct {
+	Streaks       bool `json:"streaks"`
+	WeeklySummary bool `json:"weekly_summary"`
+}
+
+// Usage is how many answers the caller stores, quota and remaining are nil
+// without a quota
+type Usage struct {
+	StatsCount     int  `json:"stats_count"`
+	StatsQuota     *int `json:"stats_quota,omitempty"`
+	StatsRemaining *int `json:"stats_remaining,omitempty"`
+}
+
+// Relationship lets a teacher see a student's dashboard once Status is
+// "accepted"
+type Relationship struct {
+	ID         string     `json:"id"`
+	Teacher    string     `json:"teacher"`
+	Student    string     `json:"student"`
+	Status     string     `json:"status"`
+	InvitedAt  time.Time  `json:"invited_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// Dashboard holds the aggregate stats of a student
+type Dashboard struct {
+	Student             string                `json:"student"`
+	CountByDay          []CountByDay          `json:"count_by_day"`
+	CountByExtension    []CountByExtension    `json:"count_by_extension"`
+	DurationByExtension []DurationByExtension `json:"duration_by_extension"`
+}
+
+// Assignment asks students to answer a set of chords with a target accuracy
+// before a due date
+type Assignment struct {
+	// ID, Teacher and CreatedAt are set by the server
+	ID             string    `json:"id,omitempty"`
+	Teacher        string    `json:"teacher,omitempty"`
+	Students       []string  `json:"students"`
+	ChordNames     []string  `json:"chord_names"`
+	TargetAccuracy float64   `json:"target_accuracy"`
+	DueAt          time.Time `json:"due_at"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+}
+
+// AssignmentStatus is an assignment with the progress of its students
+type AssignmentStatus struct {
+	Assignment
+	Progress []AssignmentProgress `json:"progress"`
+}
+
+type AssignmentProgress struct {
+	Student         string  `json:"student"`
+	Answers         int     `json:"answers"`
+	Correct         int     `json:"correct"`
+	Accuracy        float64 `json:"accuracy"`
+	ChordsPracticed int     `json:"chords_practiced"`
+	// Status is "in_progress", "completed" or "overdue"
+	Status string `json:"status"`
+}
+
+// ImportReport tells how an import went, rows count from 1 and only
+// imports with invalid rows have Errors
+type ImportReport struct {
+	DryRun   bool             `json:"dry_run"`
+	Rows     int              `json:"rows"`
+	Imported int              `json:"imported"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// User is an account signed in through an identity provider
+type User struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email,omitempty"`
+	EmailVerified bool       `json:"email_verified"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastLoginAt   time.Time  `json:"last_login_at"`
+	TwoFactor     *TwoFactor `json:"two_factor,omitempty"`
+	// DeletionScheduledAt is when the account is deleted, set while the
+	// deletion can be cancelled
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// TwoFactor is the authenticator app of a user, set once enrolling
+type TwoFactor struct {
+	Enabled    bool      `json:"enabled"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
+
+// Enrollment is what EnrollTwoFactor returns, none of it can be read again
+type Enrollment struct {
+	Secret string `json:"secret"`
+	// ProvisioningURI is shown as a QR code for authenticator apps to scan
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+// Session is a signed in user, Token authenticates the requests made for it
+// and is only set on sign-in
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current is set in listings on the client's own session
+	Current bool `json:"current,omitempty"`
+	// Locked sessions are of accounts scheduled for deletion, they can only
+	// cancel it
+	Locked bool   `json:"locked,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// SignIn is what SignIn returns. With TwoFactorRequired the session is
+// pending until VerifyTwoFactor is called with a code.
+type SignIn struct {
+	Session           Session `json:"session"`
+	User              User    `json:"user"`
+	TwoFactorRequired bool    `json:"two_factor_required,omitempty"`
+}
+
+// BuildInfo tells which build of the server is running
+type BuildInfo struct {
+	GitSHA    string   `json:"git_sha"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features"`
+}
+
+// The codes of problems that are worth handling in the app, see the Problem
+// schema in openapi.yaml for all of them
+const (
+	CodeInvalidChord        = "invalid_chord"
+	CodeDuplicateSubmission = "duplicate_submission"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeStorageUnavailable  = "storage_unavailable"
+	CodeMaintenance         = "maintenance"
+	CodeOverloaded          = "overloaded"
+	CodeTooManyAuthFailures = "too_many_auth_failures"
+	CodeRateLimited         = "rate_limited"
+	CodeSignInRequired      = "sign_in_required"
+	CodeTwoFactorEnabled    = "two_factor_enabled"
+	CodeEmailVerified       = "email_verified"
+	CodeAccountLocked       = "account_locked"
+)
+
+// Problem is the body of an error response, as of RFC 7807
+type Problem struct {
+	// Type is a URI telling problems apart, e.g. /problems/quota-exceeded
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Code is a stable code per type, e.g. quota_exceeded
+	Code   string       `json:"code"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is an invalid field or query param, or for imports an invalid
+// row
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Row     int    `json:"row,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is returned when the server answers with a non 2xx status
+type Error struct {
+	StatusCode int
+	Body       string
+	// Problem is the decoded body, nil if the body isn't a problem
+	Problem *Problem
+}
+
+// Code returns the code of the problem, "" if the body isn't a problem
+func (e *Error) Code() string {
+	if e.Problem == nil {
+		return ""
+	}
+	return e.Problem.Code
+}
+
+func (e *Error) Error() string {
+	if e.Problem != nil {
+		message := e.Problem.Title
+		if e.Problem.Detail != "" {
+			message += ": " + e.Problem.Detail
+		}
+		return fmt.Sprintf("server responded with status %d: %s", e.StatusCode, message)
+	}
+	if e.Body == "" {
+		return fmt.Sprintf("server responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Client talks to a backend instance. Its fields may be changed before the
+// first request is made.
+type Client struct {
+	BaseURL   string
+	AuthToken string
+	// SigningSecret, when set, signs requests with HMAC-SHA256 instead of
+	// sending the auth token, so the token can't leak through logs or proxies
+	SigningSecret string
+	HTTPClient    *http.Client
+	// MaxRetries is how many times a failed request is retried. Writes are
+	// only retried for stats with a client ID, otherwise the server can't
+	// tell a retry from a new answer.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every
+	// following one
+	RetryBackoff time.Duration
+}
+
+// New returns a client for the backend at baseURL, e.g.
+// "https://example.com", authenticating with authToken
+func New(baseURL string, authToken string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		AuthToken:    authToken,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		RetryBackoff: 200 * time.Millisecond,
+	}
+}
+
+// Version returns which build of the server is running
+func (c *Client) Version(ctx context.Context) (BuildInfo, error) {
+	var info BuildInfo
+	err := c.get(ctx, "/version", &info)
+	return info, err
+}
+
+// Ping checks that the server is up and accepts the auth token
+func (c *Client) Ping(ctx context.Context) error {
+	return c.get(ctx, "/ping", nil)
+}
+
+// AddStats stores a single answer and returns it as stored. When a newer
+// write with the same client ID is already stored, that one is returned.
+func (c *Client) AddStats(ctx context.Context, stats Stats) (Stats, error) {
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var stored Stats
+	if stats.ClientID != "" {
+		err = c.retry(ctx, http.MethodPost, "/stats", body, &stored)
+		return stored, err
+	}
+	res, err := c.do(ctx, http.MethodPost, "/stats", body)
+	if err == nil {
+		err = readResponse(res, &stored)
+	}
+	return stored, err
+}
+
+// RawStats returns every stored answer
+func (c *Client) RawStats(ctx context.Context) ([]Stats, error) {
+	var stats []Stats
+	err := c.get(ctx, "/stats/raw", &stats)
+	return stats, err
+}
+
+// RawStatsPage returns up to limit stored answers in the order they were
+// stored, starting after the page cursor is the NextCursor of, or at the
+// first one if cursor is empty
+func (c *Client) RawStatsPage(ctx context.Context, cursor string, limit int) (StatsPage, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	var page StatsPage
+	err := c.get(ctx, "/stats/raw?"+query.Encode(), &page)
+	return page, err
+}
+
+// ArchivedStats returns the archived answers created between since and
+// until, either of which may be zero to leave that end open
+func (c *Client) ArchivedStats(ctx context.Context, since, until time.Time) ([]Stats, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		query.Set("until", until.Format(time.RFC3339))
+	}
+	var stats []Stats
+	err := c.get(ctx, "/stats/archive?"+query.Encode(), &stats)
+	return stats, err
+}
+
+// CountByDay returns the number of answers per day for the last month
+func (c *Client) CountByDay(ctx context.Context) ([]CountByDay, error) {
+	var counts []CountByDay
+	err := c.get(ctx, "/stats/count_by_day", &counts)
+	return counts, err
+}
+
+// CountByExtension returns the number of answers per chord extension
+func (c *Client) CountByExtension(ctx context.Context) ([]CountByExtension, error) {
+	var counts []CountByExtension
+	err := c.get(ctx, "/stats/count_by_extension", &counts)
+	return counts, err
+}
+
+// DurationByExtension returns the average answer duration per chord
+// extension
+func (c *Client) DurationByExtension(ctx context.Context) ([]DurationByExtension, error) {
+	var durations []DurationByExtension
+	err := c.get(ctx, "/stats/duration_by_extension", &durations)
+	return durations, err
+}
+
+// SyncChanges returns a page of stats written or deleted after the cursor
+// since, which is empty on the first sync. Keep calling it with NextCursor
+// until HasMore is false.
+func (c *Client) SyncChanges(ctx context.Context, since string) (SyncChanges, error) {
+	var changes SyncChanges
+	err := c.get(ctx, "/sync/changes?since="+url.QueryEscape(since), &changes)
+	return changes, err
+}
+
+// ErrVersionConflict is returned by UpdateStats and UpdateSettingsIfVersion
+// when the stored version has moved on
+var ErrVersionConflict = errors.New("stored version does not match")
+
+// UpdateStats writes stats with a client ID only if the stored version still
+// equals stats.Version, which is 0 for stats not stored yet. Otherwise
+// ErrVersionConflict is returned and the stats should be fetched again.
+func (c *Client) UpdateStats(ctx context.Context, stats Stats) (Stats, error) {
+	if stats.ClientID == "" {
+		return Stats{}, errors.New("client: UpdateStats requires a client ID")
+	}
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, "/stats", body, "If-Match", fmt.Sprintf(`"%d"`, stats.Version))
+	if err != nil {
+		return Stats{}, err
+	}
+	var stored Stats
+	err = readResponse(res, &stored)
+	if statusErr, ok := err.(*Error); ok && statusErr.StatusCode == http.StatusConflict {
+		return Stats{}, ErrVersionConflict
+	}
+	return stored, err
+}
+
+// CorrectStats corrects the answer with the id and returns it as corrected,
+// the server records every correction
+func (c *Client) CorrectStats(ctx context.Context, id string, correction StatsCorrection) (Stats, error) {
+	body, err := json.Marshal(correction)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	res, err := c.do(ctx, http.MethodPatch, "/stats/"+url.PathEscape(id), body)
+	if err != nil {
+		return Stats{}, err
+	}
+	var corrected Stats
+	err = readResponse(res, &corrected)
+	return corrected, err
+}
+
+// Settings returns the caller's preferences
+func (c *Client) Settings(ctx context.Context) (Settings, error) {
+	var settings Settings
+	err := c.get(ctx, "/me/settings", &settings)
+	return settings, err
+}
+
+// UpdateSettings sets the non-nil fields of update and returns the resulting
+// settings
+func (c *Client) UpdateSettings(ctx context.Context, update Settings) (Settings, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	// setting the same fields twice does no harm, so this is safe to retry
+	var settings Settings
+	err = c.retry(ctx, http.MethodPut, "/me/settings", body, &settings)
+	return settings, err
+}
+
+// UpdateSettingsIfVersion is UpdateSettings, only if the stored settings are
+// still at version, 0 for settings not stored yet. Otherwise
+// ErrVersionConflict is returned along with the stored settings.
+func (c *Client) UpdateSettingsIfVersion(ctx context.Context, update Settings, version int64) (Settings, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	res, err := c.do(ctx, http.MethodPut, "/me/settings", body, "If-Match", fmt.Sprintf(`"%d"`, version))
+	if err != nil {
+		return Settings{}, err
+	}
+	if res.StatusCode == http.StatusConflict {
+		defer res.Body.Close()
+		var problem struct {
+			Current Settings `json:"current"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&problem)
+		if err != nil {
+			return Settings{}, err
+		}
+		return problem.Current, ErrVersionConflict
+	}
+	var settings Settings
+	err = readResponse(res, &settings)
+	return settings, err
+}
+
+// NotificationPreferences returns which notifications the caller gets and how
+func (c *Client) NotificationPreferences(ctx context.Context) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := c.get(ctx, "/me/notifications", &preferences)
+	return preferences, err
+}
+
+// UpdateNotificationPreferences sets the non-nil fields of update and returns
+// the resulting preferences
+func (c *Client) UpdateNotificationPreferences(ctx context.Context, update NotificationPreferences) (NotificationPreferences, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return NotificationPreferences{}, err
+	}
+
+	var preferences NotificationPreferences
+	err = c.retry(ctx, http.MethodPut, "/me/notifications", body, &preferences)
+	return preferences, err
+}
+
+// CreateBadge issues a token for the caller's streak badge, revoking the
+// previous one
+func (c *Client) CreateBadge(ctx context.Context) (Badge, error) {
+	var badge Badge
+	res, err := c.do(ctx, http.MethodPost, "/me/badge", nil)
+	if err == nil {
+		err = readResponse(res, &badge)
+	}
+	return badge, err
+}
+
+// DeleteBadge revokes the caller's badge token
+func (c *Client) DeleteBadge(ctx context.Context) error {
+	res, err := c.do(ctx, http.MethodDelete, "/me/badge", nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// PauseStreak plans a break of the caller. Pauses start today at the
+// earliest and have at most 14 days a year.
+func (c *Client) PauseStreak(ctx context.Context, from, until string) (StreakPause, error) {
+	body, err := json.Marshal(StreakPause{From: from, Until: until})
+	if err != nil {
+		return StreakPause{}, err
+	}
+
+	var pause StreakPause
+	res, err := c.do(ctx, http.MethodPost, "/me/streak/pause", body)
+	if err == nil {
+		err = readResponse(res, &pause)
+	}
+	return pause, err
+}
+
+// StreakPauses returns the caller's streak pauses, earliest first
+func (c *Client) StreakPauses(ctx context.Context) ([]StreakPause, error) {
+	var pauses []StreakPause
+	err := c.get(ctx, "/me/streak/pauses", &pauses)
+	return pauses, err
+}
+
+// DeleteStreakPause cancels a streak pause that hasn't started
+func (c *Client) DeleteStreakPause(ctx context.Context, id string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/me/streak/pauses/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// StreakFreezes returns how many streak freezes the caller has left and the
+// days the used ones kept the streak on
+func (c *Client) StreakFreezes(ctx context.Context) (StreakFreezes, error) {
+	var freezes StreakFreezes
+	err := c.get(ctx, "/me/streak/freezes", &freezes)
+	return freezes, err
+}
+
+// Feed returns the caller's achievements, personal bests and weekly reports
+// as an Atom document
+func (c *Client) Feed(ctx context.Context) ([]byte, error) {
+	res, err := c.do(ctx, http.MethodGet, "/me/feed.atom", nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, readResponse(res, nil)
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// Integrations returns the Slack and Discord integrations of the caller
+func (c *Client) Integrations(ctx context.Context) ([]Integration, error) {
+	var integrations []Integration
+	err := c.get(ctx, "/integrations", &integrations)
+	return integrations, err
+}
+
+// AddIntegration starts posting the caller's milestones to a channel, all
+// of them if Events is nil
+func (c *Client) AddIntegration(ctx context.Context, integration Integration) (Integration, error) {
+	body, err := json.Marshal(integration)
+	if err != nil {
+		return Integration{}, err
+	}
+
+	var added Integration
+	res, err := c.do(ctx, http.MethodPost, "/integrations", body)
+	if err == nil {
+		err = readResponse(res, &added)
+	}
+	return added, err
+}
+
+// DeleteIntegration stops posting to the channel of an integration
+func (c *Client) DeleteIntegration(ctx context.Context, id string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/integrations/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// TestIntegration posts a sample card through an integration
+func (c *Client) TestIntegration(ctx context.Context, id string) error {
+	res, err := c.do(ctx, http.MethodPost, "/integrations/"+url.PathEscape(id)+"/test", nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// Flags returns which feature flags are on for the caller, by name. Flags
+// missing from it are off.
+func (c *Client) Flags(ctx context.Context) (map[string]bool, error) {
+	var flags map[string]bool
+	err := c.get(ctx, "/flags", &flags)
+	return flags, err
+}
+
+// ExperimentAssignments returns the variant of every active experiment the
+// caller is in, by experiment name
+func (c *Client) ExperimentAssignments(ctx context.Context) (map[string]string, error) {
+	var assignments map[string]string
+	err := c.get(ctx, "/experiments/assignments", &assignments)
+	return assignments, err
+}
+
+// SignIn trades an ID token the app got from provider, "google" or "apple",
+// for a session, creating the user on first sign-in. The client uses the
+// session token for the requests that follow, so SignIn must not be called
+// while other requests are made.
+func (c *Client) SignIn(ctx context.Context, provider, idToken string) (SignIn, error) {
+	return c.SignInWithNonce(ctx, provider, idToken, "")
+}
+
+// SignInWithNonce is SignIn for an ID token requested with a nonce, which
+// the server checks against the token's
+func (c *Client) SignInWithNonce(ctx context.Context, provider, idToken, nonce string) (SignIn, error) {
+	body, err := json.Marshal(map[string]string{"id_token": idToken, "nonce": nonce})
+	if err != nil {
+		return SignIn{}, err
+	}
+
+	var signIn SignIn
+	res, err := c.do(ctx, http.MethodPost, "/auth/oidc/"+url.PathEscape(provider), body)
+	if err == nil {
+		err = readResponse(res, &signIn)
+	}
+	if err == nil {
+		c.AuthToken = signIn.Session.Token
+	}
+	return signIn, err
+}
+
+// SendMagicLink emails a link to sign in with to email. The link opens the
+// app, which passes its token query param to VerifyMagicLink.
+func (c *Client) SendMagicLink(ctx context.Context, email string) error {
+	body, err := json.Marshal(map[string]string{"email": email})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, "/auth/magic_link", body)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// VerifyMagicLink trades the token of a magic link for a session, like
+// SignIn does with an ID token
+func (c *Client) VerifyMagicLink(ctx context.Context, token string) (SignIn, error) {
+	var signIn SignIn
+	err := c.get(ctx, "/auth/verify?token="+url.QueryEscape(token), &signIn)
+	if err == nil {
+		c.AuthToken = signIn.Session.Token
+	}
+	return signIn, err
+}
+
+// VerifyTwoFactor completes a sign-in that answered TwoFactorRequired, with a
+// code of the authenticator app or a recovery code. The client uses the new
+// session token from then on.
+func (c *Client) VerifyTwoFactor(ctx context.Context, code string) (SignIn, error) {
+	body, err := json.Marshal(map[string]string{"token": c.AuthToken, "code": code})
+	if err != nil {
+		return SignIn{}, err
+	}
+
+	var signIn SignIn
+	res, err := c.do(ctx, http.MethodPost, "/auth/2fa", body)
+	if err == nil {
+		err = readResponse(res, &signIn)
+	}
+	if err == nil {
+		c.AuthToken = signIn.Session.Token
+	}
+	return signIn, err
+}
+
+// DeleteAccount schedules the signed in user's account for deletion after a
+// grace period, locking it until then. Every request but CancelDeletion
+// fails with CodeAccountLocked from then on.
+func (c *Client) DeleteAccount(ctx context.Context) (User, error) {
+	var user User
+	err := c.retry(ctx, http.MethodPost, "/me/delete", nil, &user)
+	return user, err
+}
+
+// CancelDeletion keeps the signed in user's account after all, unlocking it
+func (c *Client) CancelDeletion(ctx context.Context) (User, error) {
+	var user User
+	res, err := c.do(ctx, http.MethodPost, "/me/delete/cancel", nil)
+	if err == nil {
+		err = readResponse(res, &user)
+	}
+	return user, err
+}
+
+// ResendVerification emails the signed in user another link to verify the
+// email with. The link opens the app, which passes its token query param to
+// VerifyEmail.
+func (c *Client) ResendVerification(ctx context.Context) error {
+	res, err := c.do(ctx, http.MethodPost, "/me/email/verification", nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// VerifyEmail verifies the email of a user with the token of a verification
+// link
+func (c *Client) VerifyEmail(ctx context.Context, token string) error {
+	return c.get(ctx, "/auth/verify_email?token="+url.QueryEscape(token), nil)
+}
+
+// Sessions returns the sessions the signed in user is signed in with, latest
+// used first
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	var sessions []Session
+	err := c.get(ctx, "/me/sessions", &sessions)
+	return sessions, err
+}
+
+// RevokeSession signs a session of the signed in user out, the client's own
+// one too
+func (c *Client) RevokeSession(ctx context.Context, id string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/me/sessions/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// EnrollTwoFactor starts setting up an authenticator app for the signed in
+// user, which ConfirmTwoFactor enables
+func (c *Client) EnrollTwoFactor(ctx context.Context) (Enrollment, error) {
+	var enrollment Enrollment
+	res, err := c.do(ctx, http.MethodPost, "/me/2fa/enroll", nil)
+	if err == nil {
+		err = readResponse(res, &enrollment)
+	}
+	return enrollment, err
+}
+
+// ConfirmTwoFactor enables two-factor auth with a first code of the enrolled
+// authenticator app
+func (c *Client) ConfirmTwoFactor(ctx context.Context, code string) (TwoFactor, error) {
+	body, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return TwoFactor{}, err
+	}
+
+	var twoFactor TwoFactor
+	res, err := c.do(ctx, http.MethodPost, "/me/2fa/confirm", body)
+	if err == nil {
+		err = readResponse(res, &twoFactor)
+	}
+	return twoFactor, err
+}
+
+// DisableTwoFactor turns two-factor auth off, with a code of the
+// authenticator app or a recovery code. Codes are used up, so unlike most
+// requests this one isn't retried.
+func (c *Client) DisableTwoFactor(ctx context.Context, code string) error {
+	body, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, "/me/2fa/disable", body)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// Usage returns how many answers the caller stores. Once the quota is
+// reached AddStats fails with a 403 Error.
+func (c *Client) Usage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := c.get(ctx, "/me/usage", &usage)
+	return usage, err
+}
+
+// Relationships returns the relationships the caller is teacher or student
+// in, including pending invitations
+func (c *Client) Relationships(ctx context.Context) ([]Relationship, error) {
+	var relationships []Relationship
+	err := c.get(ctx, "/relationships", &relationships)
+	return relationships, err
+}
+
+// InviteStudent asks a student to share their stats with the caller
+func (c *Client) InviteStudent(ctx context.Context, student string) (Relationship, error) {
+	body, err := json.Marshal(map[string]string{"student": student})
+	if err != nil {
+		return Relationship{}, err
+	}
+
+	// inviting the same student again returns the existing relationship
+	var relationship Relationship
+	err = c.retry(ctx, http.MethodPost, "/relationships", body, &relationship)
+	return relationship, err
+}
+
+// AcceptInvitation accepts an invitation sent to the caller
+func (c *Client) AcceptInvitation(ctx context.Context, id string) (Relationship, error) {
+	var relationship Relationship
+	res, err := c.do(ctx, http.MethodPost, "/relationships/"+url.PathEscape(id)+"/accept", nil)
+	if err == nil {
+		err = readResponse(res, &relationship)
+	}
+	return relationship, err
+}
+
+// DeleteRelationship revokes a relationship or declines an invitation
+func (c *Client) DeleteRelationship(ctx context.Context, id string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/relationships/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return readResponse(res, nil)
+}
+
+// StudentDashboard returns the aggregate stats of a student who accepted
+// the caller's invitation
+func (c *Client) StudentDashboard(ctx context.Context, student string) (Dashboard, error) {
+	var dashboard Dashboard
+	err := c.get(ctx, "/students/"+url.PathEscape(student)+"/dashboard", &dashboard)
+	return dashboard, err
+}
+
+// AddAssignment assigns chords to students who accepted the caller as their
+// teacher
+func (c *Client) AddAssignment(ctx context.Context, assignment Assignment) (AssignmentStatus, error) {
+	body, err := json.Marshal(assignment)
+	if err != nil {
+		return AssignmentStatus{}, err
+	}
+
+	var status AssignmentStatus
+	res, err := c.do(ctx, http.MethodPost, "/assignments", body)
+	if err == nil {
+		err = readResponse(res, &status)
+	}
+	return status, err
+}
+
+// Assignments returns the assignments the caller is teacher or student in
+func (c *Client) Assignments(ctx context.Context) ([]AssignmentStatus, error) {
+	var statuses []AssignmentStatus
+	err := c.get(ctx, "/assignments", &statuses)
+	return statuses, err
+}
+
+func (c *Client) Assignment(ctx context.Context, id string) (AssignmentStatus, error) {
+	var status AssignmentStatus
+	err := c.get(ctx, "/assignments/"+url.PathEscape(id), &status)
+	return status, err
+}
+
+// ErrInvalidImport is returned by Import and ImportCSV along with a report
+// of the invalid rows, nothing is imported then
+var ErrInvalidImport = errors.New("import has invalid rows")
+
+// Import loads answers recorded elsewhere, e.g. exported by RawStats from
+// another instance. With dryRun they are only checked.
+func (c *Client) Import(ctx context.Context, stats []Stats, dryRun bool) (ImportReport, error) {
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return c.importRows(ctx, body, "application/json", dryRun)
+}
+
+// ImportCSV loads answers from a CSV with a header row naming the columns
+// like the JSON fields of Stats. With dryRun they are only checked.
+func (c *Client) ImportCSV(ctx context.Context, csv []byte, dryRun bool) (ImportReport, error) {
+	return c.importRows(ctx, csv, "text/csv", dryRun)
+}
+
+func (c *Client) importRows(ctx context.Context, body []byte, contentType string, dryRun bool) (ImportReport, error) {
+	path := "/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	// imports aren't retried, without client IDs a retry imports twice
+	res, err := c.do(ctx, http.MethodPost, path, body, "Content-Type", contentType)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	var report ImportReport
+	err = readResponse(res, &report)
+	statusErr, ok := err.(*Error)
+	if ok && statusErr.StatusCode == http.StatusUnprocessableEntity && statusErr.Problem != nil {
+		report = ImportReport{DryRun: dryRun, Errors: []ImportRowError{}}
+		for _, fieldErr := range statusErr.Problem.Errors {
+			report.Errors = append(report.Errors, ImportRowError{Row: fieldErr.Row, Error: fieldErr.Message})
+		}
+		return report, ErrInvalidImport
+	}
+	return report, err
+}
+
+// get performs a GET request and decodes the JSON response into v unless it
+// is nil
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	return c.retry(ctx, http.MethodGet, path, nil, v)
+}
+
+// retry performs a request, retrying network errors and 5xx responses, and
+// decodes the JSON response into v unless it is nil
+func (c *Client) retry(ctx context.Context, method string, path string, body []byte, v interface{}) error {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.do(ctx, method, path, body)
+		if err == nil {
+			err = readResponse(res, v)
+		}
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a single request, headers are passed as name, value pairs
+func (c *Client) do(ctx context.Context, method string, path string, body []byte, headers ...string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.SigningSecret != "" {
+		sign(req, c.SigningSecret, body)
+	} else {
+		req.Header.Set("X-Auth-Token", c.AuthToken)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// sign sets the signature headers of req, signing the method, the path with
+// the query, the timestamp and the SHA-256 of the body each on a line of its
+// own
+func sign(req *http.Request, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func readResponse(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+		statusErr := &Error{StatusCode: res.StatusCode, Body: strings.TrimSpace(string(body))}
+		if strings.HasPrefix(res.Header.Get("Content-Type"), "application/problem+json") {
+			var problem Problem
+			if json.Unmarshal(body, &problem) == nil {
+				statusErr.Problem = &problem
+			}
+		}
+		return statusErr
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// retryable reports whether err is worth retrying, canceled requests and
+// 4xx responses are not
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if statusErr, ok := err.(*Error); ok {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	minConfidence = 1
+	maxConfidence = 5
+	// masteryConfidence is the average self-rated confidence from which a
+	// chord whose answers were rated can be mastered, so a chord answered
+	// right but unsure keeps coming up as a challenge
+	masteryConfidence = 4
+)
+
+// ConfidenceOutcome sums up the answers the user rated with a confidence,
+// telling how well the rating matches how they actually did
+type ConfidenceOutcome struct {
+	Confidence int `json:"confidence" bson:"_id"`
+	Answers    int `json:"answers" bson:"answers"`
+	Correct    int `json:"correct" bson:"correct"`
+	// Accuracy is 0 until there are answers
+	Accuracy          float64 `json:"accuracy" bson:"-"`
+	AvgDurationMillis float64 `json:"avg_duration_millis" bson:"avg_duration_millis"`
+}
+
+// validateConfidence returns what is wrong with the confidence of an answer,
+// nothing if it is valid or the answer wasn't rated
+func validateConfidence(stats StatsRaw) []FieldError {
+	if stats.Confidence != 0 && (stats.Confidence < minConfidence || stats.Confidence > maxConfidence) {
+		return []FieldError{{Field: "confidence", Message: fmt.Sprintf("has to be from %d to %d", minConfidence, maxConfidence)}}
+	}
+	return nil
+}
+
+// getOutcomesByConfidenceHandler sums up the rated answers by confidence,
+// least confident first
+func getOutcomesByConfidenceHandler(w http.ResponseWriter, r *http.Request) {
+	outcomes, err := repository.OutcomesByConfidence(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	for i, outcome := range outcomes {
+		if outcome.Answers > 0 {
+			outcomes[i].Accuracy = float64(outcome.Correct) / float64(outcome.Answers)
+		}
+	}
+	writeResponse(w, r, outcomes)
+}
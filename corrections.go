@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxTransactionAttempts is how often a correction is tried when its
+// transaction is aborted by a conflicting one
+const maxTransactionAttempts = 3
+
+// StatsCorrection corrects a stored answer, only the fields set are changed.
+// Corrections are limited to what a user may have got wrong when answering,
+// the rest of an answer is what happened.
+type StatsCorrection struct {
+	// Correct can only be false, answers are marked as incorrect but never
+	// the other way around
+	Correct        *bool   `json:"correct"`
+	ChordExtension *string `json:"chord_extension"`
+	// AddTags are added to the tags the answer has
+	AddTags []string `json:"add_tags"`
+}
+
+// StatsCorrectionEntry records a correction of an answer, for auditing
+type StatsCorrectionEntry struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	StatsID       primitive.ObjectID `json:"stats_id" bson:"stats_id"`
+	Tenant        string             `json:"-" bson:"tenant"`
+	UserID        string             `json:"user_id" bson:"user_id"`
+	Before        CorrectableFields  `json:"before" bson:"before"`
+	After         CorrectableFields  `json:"after" bson:"after"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	SchemaVersion int                `json:"-" bson:"schema_version"`
+}
+
+// CorrectableFields are the fields of an answer a correction may change
+type CorrectableFields struct {
+	Correct        bool     `json:"correct" bson:"correct"`
+	ChordName      string   `json:"chord_name" bson:"chord_name"`
+	ChordExtension string   `json:"chord_extension" bson:"chord_extension"`
+	Tags           []string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+func correctableFields(stats StatsRaw) CorrectableFields {
+	return CorrectableFields{
+		Correct:        stats.Correct == nil || *stats.Correct,
+		ChordName:      stats.ChordName,
+		ChordExtension: stats.ChordExtension,
+		Tags:           stats.Tags,
+	}
+}
+
+// validate returns what is wrong with the correction, nothing if it is valid
+func (c StatsCorrection) validate() []FieldError {
+	if c.Correct == nil && c.ChordExtension == nil && len(c.AddTags) == 0 {
+		return []FieldError{{Message: "has to set correct, chord_extension or add_tags"}}
+	}
+	var fieldErrors []FieldError
+	if c.Correct != nil && *c.Correct {
+		fieldErrors = append(fieldErrors, FieldError{Field: "correct", Message: "can only be false"})
+	}
+	if c.ChordExtension != nil && *c.ChordExtension != "" && !validExtensionSpelling(*c.ChordExtension) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_extension", Message: "has to be up to 32 characters without spaces or /"})
+	}
+	return fieldErrors
+}
+
+// apply returns stats corrected. The chord name follows a corrected
+// extension if it was made of the root note and the extension.
+func (c StatsCorrection) apply(stats StatsRaw, aliases chordAliasTable) StatsRaw {
+	if c.Correct != nil {
+		correct := *c.Correct
+		stats.Correct = &correct
+	}
+	if c.ChordExtension != nil {
+		extension := aliases.extension(*c.ChordExtension)
+		if stats.ChordName == stats.RootNote+stats.ChordExtension {
+			stats.ChordName = stats.RootNote + extension
+		}
+		stats.ChordExtension = extension
+	}
+	if len(c.AddTags) > 0 {
+		stats.Tags = append(append([]string{}, stats.Tags...), c.AddTags...)
+		stats = withTidyNotes(stats)
+	}
+	return stats
+}
+
+// correctStats corrects the answer with the id and records the correction,
+// both or neither. With ifVersion other than anyVersion the answer is only
+// corrected if its stored version equals it. Archived answers can't be
+// corrected, they fail with errNotFound like unknown ones.
+func correctStats(ctx context.Context, id primitive.ObjectID, correction StatsCorrection, ifVersion int64) (StatsRaw, []FieldError, error) {
+	var stored StatsRaw
+	found := false
+	err := repository.EachStats(ctx, StatsFilter{IDs: []primitive.ObjectID{id}, Limit: 1}, func(stats StatsRaw) error {
+		stored, found = stats, true
+		return nil
+	})
+	if err != nil {
+		return StatsRaw{}, nil, err
+	}
+	if !found {
+		return StatsRaw{}, nil, errNotFound
+	}
+	if ifVersion != anyVersion && stored.Version != ifVersion {
+		return stored, nil, errVersionConflict
+	}
+
+	// the extension is stored as sent if the aliases can't be read, it is
+	// aggregated with the extension it spells all the same
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		aliases = chordAliasTable{}
+	}
+	corrected := correction.apply(stored, aliases)
+	if fieldErrors := append(validateChord(corrected), validateNotes(corrected)...); len(fieldErrors) > 0 {
+		return StatsRaw{}, fieldErrors, nil
+	}
+	corrected.UpdatedAt = time.Now()
+
+	entry := StatsCorrectionEntry{
+		ID:        primitive.NewObjectID(),
+		StatsID:   id,
+		UserID:    userFromContext(ctx),
+		Before:    correctableFields(stored),
+		After:     correctableFields(corrected),
+		CreatedAt: corrected.UpdatedAt,
+	}
+	// the sequence number is taken before, the counter everyone writes would
+	// make the transactions conflict with all other writes
+	seq, err := repository.ReserveSyncSeq(ctx)
+	if err != nil {
+		return StatsRaw{}, nil, err
+	}
+	defer releaseSyncSeq(repository, seq)
+	var saved StatsRaw
+	for attempt := 1; ; attempt++ {
+		err = repository.InTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			saved, err = repository.CorrectStats(ctx, corrected, stored.Version, seq)
+			if err != nil {
+				return err
+			}
+			return repository.InsertStatsCorrection(ctx, entry)
+		})
+		if attempt >= maxTransactionAttempts || !abortedTransaction(err) {
+			break
+		}
+	}
+	if err != nil {
+		return saved, nil, err
+	}
+
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+	statsSaved.notify(tenantFromContext(ctx))
+	return saved, nil, nil
+}
+
+// correctStatsHandler corrects a stored answer, see StatsCorrection
+func correctStatsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	var correction StatsCorrection
+	err = decodeRequest(r, &correction)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := correction.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	ifVersion := int64(anyVersion)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		ifVersion, err = parseETag(ifMatch)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "If-Match isn't an ETag")
+			return
+		}
+	}
+
+	corrected, fieldErrors, err := correctStats(r.Context(), id, correction, ifVersion)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err == errVersionConflict {
+		if corrected.Version > 0 {
+			w.Header().Set("ETag", formatETag(corrected.Version))
+		}
+		writeProblem(w, problemVersionConflict, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(corrected.Version))
+	writeResponse(w, r, corrected)
+}
+
+// getStatsCorrectionsHandler lists the corrections of a stored answer,
+// oldest first
+func getStatsCorrectionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	corrections, err := repository.StatsCorrections(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(corrections) == 0 {
+		count, err := repository.CountMatchingStats(r.Context(), StatsFilter{IDs: []primitive.ObjectID{id}})
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if count == 0 {
+			writeProblem(w, problemNotFound, "")
+			return
+		}
+	}
+
+	writeResponse(w, r, corrections)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultCoverageDays is how many days back the root coverage looks unless
+	// asked otherwise
+	defaultCoverageDays = 30
+	maxCoverageDays     = 365
+	// neglectedShare is the part of an even share of the answers below which
+	// a root counts as neglected, an even share being a 12th of them
+	neglectedShare = 0.5
+)
+
+// rootNames are the 12 roots in chromatic order from C, spelled the way
+// piano music mostly spells them
+var rootNames = []string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+// naturalSemitones are the semitones of the natural notes above C
+var naturalSemitones = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// StatsCountByRootNote is the number of answers with a root note
+type StatsCountByRootNote struct {
+	RootNote string `bson:"_id"`
+	Count    int    `bson:"count"`
+}
+
+// RootCoverage tells how the answers since Since spread over the 12 roots
+type RootCoverage struct {
+	Since time.Time `json:"since"`
+	Total int       `json:"total"`
+	// Roots has every root in chromatic order from C, enharmonic spellings
+	// like C# and Db counting as the same root
+	Roots []RootNoteCoverage `json:"roots"`
+	// Neglected are the neglected roots, least practiced first
+	Neglected []string `json:"neglected"`
+}
+
+// RootNoteCoverage is how much a root was practiced
+type RootNoteCoverage struct {
+	RootNote string  `json:"root_note"`
+	Count    int     `json:"count"`
+	Share    float64 `json:"share"`
+	// Neglected is set for roots practiced less than half as much as if
+	// practice were spread evenly
+	Neglected bool `json:"neglected"`
+}
+
+// rootSemitone returns the semitones from C to a root note like F# or Bb,
+// false if it isn't one
+func rootSemitone(rootNote string) (int, bool) {
+	if rootNote == "" || rootNotePattern.FindString(rootNote) != rootNote {
+		return 0, false
+	}
+	semitone := naturalSemitones[rootNote[0]]
+	if len(rootNote) > 1 {
+		switch rootNote[1] {
+		case '#':
+			semitone++
+		case 'b':
+			semitone--
+		}
+	}
+	return (semitone + 12) % 12, true
+}
+
+// rootCoverage returns how the answers since since spread over the roots
+func rootCoverage(ctx context.Context, since time.Time) (RootCoverage, error) {
+	counts, err := repository.CountByRootNote(ctx, since)
+	if err != nil {
+		return RootCoverage{}, err
+	}
+
+	coverage := RootCoverage{Since: since, Roots: make([]RootNoteCoverage, len(rootNames)), Neglected: []string{}}
+	for semitone, name := range rootNames {
+		coverage.Roots[semitone].RootNote = name
+	}
+	for _, count := range counts {
+		semitone, valid := rootSemitone(count.RootNote)
+		if !valid {
+			continue
+		}
+		coverage.Roots[semitone].Count += count.Count
+		coverage.Total += count.Count
+	}
+	if coverage.Total == 0 {
+		return coverage, nil
+	}
+
+	var neglected []RootNoteCoverage
+	for i := range coverage.Roots {
+		root := &coverage.Roots[i]
+		root.Share = float64(root.Count) / float64(coverage.Total)
+		root.Neglected = root.Share < neglectedShare/float64(len(rootNames))
+		if root.Neglected {
+			neglected = append(neglected, *root)
+		}
+	}
+	sort.SliceStable(neglected, func(i, j int) bool {
+		return neglected[i].Count < neglected[j].Count
+	})
+	for _, root := range neglected {
+		coverage.Neglected = append(coverage.Neglected, root.RootNote)
+	}
+	return coverage, nil
+}
+
+// getRootCoverageHandler reports how the answers of the last days spread over
+// the roots, with the days query param telling how many days back to look
+func getRootCoverageHandler(w http.ResponseWriter, r *http.Request) {
+	days := defaultCoverageDays
+	if daysParam := r.URL.Query().Get("days"); daysParam != "" {
+		var err error
+		days, err = strconv.Atoi(daysParam)
+		if err != nil || days <= 0 || days > maxCoverageDays {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "days", Message: "has to be a whole number from 1 to 365"})
+			return
+		}
+	}
+
+	coverage, err := rootCoverage(r.Context(), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, coverage)
+}
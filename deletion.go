@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// accountDeletionGrace is how long after asking for it an account is
+// deleted, the user can change their mind until then
+const accountDeletionGrace = 14 * 24 * time.Hour
+
+// scheduleDeletionHandler schedules the signed in user's account for
+// deletion. The account is locked from then on, its sessions can only cancel
+// the deletion.
+func scheduleDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	if user.DeletionScheduledAt == nil {
+		at := time.Now().Add(accountDeletionGrace)
+		err := repository.ScheduleDeletion(r.Context(), user.ID, &at)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		user.DeletionScheduledAt = &at
+	}
+
+	writeResponseStatus(w, r, http.StatusAccepted, user)
+}
+
+// cancelDeletionHandler keeps the signed in user's account after all,
+// unlocking it
+func cancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	if user.DeletionScheduledAt == nil {
+		writeProblem(w, problemNotFound, "The account isn't scheduled for deletion")
+		return
+	}
+	err := repository.ScheduleDeletion(r.Context(), user.ID, nil)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	user.DeletionScheduledAt = nil
+
+	writeResponse(w, r, user)
+}
+
+// RefuseLockedAccounts answers 403 to sessions of accounts scheduled for
+// deletion, which may only cancel the deletion
+func RefuseLockedAccounts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if session, signedIn := sessionFromContext(r.Context()); signedIn && session.Locked {
+			writeProblem(w, problemAccountLocked, "Cancel the deletion at /me/delete/cancel to use the account again")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// purgeJob deletes the accounts whose grace period is over, along with all
+// their data, every interval
+func purgeJob(interval time.Duration) *job {
+	return &job{
+		name:     "purge_accounts",
+		schedule: "@every " + interval.String(),
+		timeout:  time.Hour,
+		run: func(ctx context.Context) error {
+			purged, err := purgeDueAccounts(ctx)
+			if purged > 0 {
+				accountsPurgedTotal.Add(float64(purged))
+				log.Printf("Purged %d accounts\n", purged)
+				if aggregateCache != nil {
+					aggregateCache.Invalidate()
+				}
+			}
+			return err
+		},
+	}
+}
+
+// purgeDueAccounts deletes the accounts due for deletion, returning how many
+// were. Accounts that fail to be purged are tried again on the next run.
+func purgeDueAccounts(ctx context.Context) (int, error) {
+	users, err := repository.UsersDueForDeletion(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, user := range users {
+		err = repository.PurgeUser(ctx, user.ID)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
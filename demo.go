@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// demoTenant owns the answers written with the demo token
+const demoTenant = "demo"
+
+// demoDataTTL is how long answers written with the demo token are kept, they
+// are deleted by a TTL index of Mongo after it
+const demoDataTTL = 24 * time.Hour
+
+// withExpiry marks the answers of the demo tenant and of guests as demo
+// data, expiring a day after they are written or along with the guest. The
+// repositories mark them as they store them, so answers taking the stream or
+// the write queue are marked too.
+func withExpiry(ctx context.Context, stats StatsRaw) StatsRaw {
+	var expiresAt time.Time
+	switch tenant := tenantFromContext(ctx); {
+	case isGuestTenant(tenant):
+		expiresAt = guestExpiry(tenant)
+	case tenant == demoTenant:
+		expiresAt = time.Now().Add(demoDataTTL)
+	default:
+		return stats
+	}
+	stats.Demo = true
+	stats.ExpiresAt = &expiresAt
+	return stats
+}
+
+// analyticsQuery is tenantQuery for aggregations. Across all tenants they
+// leave out demo answers, which aren't anyone practicing. Like tenantQuery
+// it leaves query as it is.
+func analyticsQuery(ctx context.Context, query bson.M) bson.M {
+	if tenantFromContext(ctx) != allTenants {
+		return tenantQuery(ctx, query)
+	}
+	scoped := bson.M{"demo": bson.M{"$ne": true}}
+	for key, value := range query {
+		scoped[key] = value
+	}
+	return scoped
+}
+
+// analyticsMatches is the in-memory counterpart of analyticsQuery
+func analyticsMatches(ctx context.Context, stats StatsRaw) bool {
+	if stats.Demo && tenantFromContext(ctx) == allTenants {
+		return false
+	}
+	return tenantMatches(ctx, stats.Tenant)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultDuplicateTolerance is how far apart the creation times of
+	// duplicates may be unless asked otherwise
+	defaultDuplicateTolerance = time.Second
+	// maxDuplicateTolerance keeps answers practiced again shortly after from
+	// passing for duplicates
+	maxDuplicateTolerance = time.Minute
+	// maxDuplicateGroups bounds the groups listed in a report, the counts
+	// cover all of them
+	maxDuplicateGroups = 1000
+)
+
+// DuplicatesReport lists the answers that were most likely submitted more
+// than once, by clients from before client IDs made resubmitting safe
+type DuplicatesReport struct {
+	Scanned    int              `json:"scanned"`
+	Duplicates int              `json:"duplicates"`
+	Collapsed  int              `json:"collapsed"`
+	Groups     []DuplicateGroup `json:"groups"`
+}
+
+// DuplicateGroup is an answer with its duplicates: answers of the same
+// tenant and chord, created within the tolerance of it
+type DuplicateGroup struct {
+	Tenant string `json:"tenant"`
+	// Kept is the answer stored first, which collapsing keeps
+	Kept       StatsRaw             `json:"kept"`
+	Duplicates []primitive.ObjectID `json:"duplicates"`
+}
+
+// findDuplicates groups the answers matching filter that were sent without a
+// client ID by tenant and chord, and within those by creation time. It
+// returns the ids of all duplicates besides the report.
+func findDuplicates(ctx context.Context, filter StatsFilter, tolerance time.Duration) (DuplicatesReport, []primitive.ObjectID, error) {
+	report := DuplicatesReport{Groups: []DuplicateGroup{}}
+	type chordKey struct{ tenant, chordName string }
+	byChord := make(map[chordKey][]StatsRaw)
+	filter.WithoutClientID = true
+	err := repository.EachStats(ctx, filter, func(stats StatsRaw) error {
+		report.Scanned++
+		key := chordKey{stats.Tenant, stats.ChordName}
+		byChord[key] = append(byChord[key], stats)
+		return nil
+	})
+	if err != nil {
+		return report, nil, err
+	}
+
+	var groups []DuplicateGroup
+	var ids []primitive.ObjectID
+	for key, stats := range byChord {
+		sort.Slice(stats, func(i, j int) bool {
+			return stats[i].CreatedAt.Before(stats[j].CreatedAt)
+		})
+		for start := 0; start < len(stats); {
+			end := start + 1
+			for end < len(stats) && stats[end].CreatedAt.Sub(stats[start].CreatedAt) <= tolerance {
+				end++
+			}
+			if end-start > 1 {
+				group := duplicateGroup(key.tenant, stats[start:end])
+				groups = append(groups, group)
+				ids = append(ids, group.Duplicates...)
+			}
+			start = end
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Kept.ID.Hex() < groups[j].Kept.ID.Hex()
+	})
+	if len(groups) > maxDuplicateGroups {
+		groups = groups[:maxDuplicateGroups]
+	}
+	report.Duplicates = len(ids)
+	report.Groups = append(report.Groups, groups...)
+	return report, ids, nil
+}
+
+// duplicateGroup keeps the answer of stats with the lowest id, the one
+// stored first
+func duplicateGroup(tenant string, stats []StatsRaw) DuplicateGroup {
+	kept := stats[0]
+	for _, s := range stats[1:] {
+		if s.ID.Hex() < kept.ID.Hex() {
+			kept = s
+		}
+	}
+	group := DuplicateGroup{Tenant: tenant, Kept: kept}
+	for _, s := range stats {
+		if s.ID != kept.ID {
+			group.Duplicates = append(group.Duplicates, s.ID)
+		}
+	}
+	return group
+}
+
+// duplicatesQuery reads the tenant, since, until and tolerance query params
+// shared by listing and collapsing duplicates, answering with a problem if
+// any is invalid
+func duplicatesQuery(w http.ResponseWriter, r *http.Request) (context.Context, StatsFilter, time.Duration, bool) {
+	ctx := r.Context()
+	var filter StatsFilter
+	var err error
+	query := r.URL.Query()
+	if tenant := query.Get("tenant"); tenant != "" && tenant != allTenants {
+		ctx = withTenant(ctx, tenant)
+	}
+	if since := query.Get("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "since", Message: "isn't an RFC 3339 time"})
+			return nil, StatsFilter{}, 0, false
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "until", Message: "isn't an RFC 3339 time"})
+			return nil, StatsFilter{}, 0, false
+		}
+	}
+	tolerance := defaultDuplicateTolerance
+	if toleranceParam := query.Get("tolerance"); toleranceParam != "" {
+		tolerance, err = time.ParseDuration(toleranceParam)
+		if err != nil || tolerance < 0 || tolerance > maxDuplicateTolerance {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "tolerance", Message: "has to be a duration like 2s, at most 1m"})
+			return nil, StatsFilter{}, 0, false
+		}
+	}
+	return ctx, filter, tolerance, true
+}
+
+// getDuplicatesHandler reports the likely duplicates, without touching them
+func getDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, filter, tolerance, ok := duplicatesQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, _, err := findDuplicates(ctx, filter, tolerance)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, report)
+}
+
+// collapseDuplicatesHandler deletes the duplicates that listing them with
+// the same query params reports, keeping the answer stored first of each
+// group. Groups beyond the ones a report lists are collapsed too.
+func collapseDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, filter, tolerance, ok := duplicatesQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, ids, err := findDuplicates(ctx, filter, tolerance)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var deleted int
+		deleted, err = repository.DeleteStats(ctx, StatsFilter{IDs: ids[start:end]})
+		report.Collapsed += deleted
+		if err != nil {
+			break
+		}
+	}
+	if report.Collapsed > 0 {
+		log.Printf("Collapsed %d duplicate stats\n", report.Collapsed)
+		if aggregateCache != nil {
+			aggregateCache.Invalidate()
+		}
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, report)
+}
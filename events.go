@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxEventsPerRequest bounds the events a client sends at once, e.g.
+	// after being offline
+	maxEventsPerRequest = 100
+	// eventRetention is how long events are kept
+	eventRetention = 180 * 24 * time.Hour
+	// maxEventClockSkew is how far in the future of the server's clock the
+	// time of an event may be
+	maxEventClockSkew = 5 * time.Minute
+	// defaultFunnelWindow is how far back a funnel looks unless asked
+	// otherwise
+	defaultFunnelWindow = 30 * 24 * time.Hour
+)
+
+// eventNames are the client actions that are recorded, in the order of the
+// default funnel
+var eventNames = []string{"app_opened", "session_started", "session_completed", "stats_viewed"}
+
+// Event is a client action besides answering chords, recorded to see where
+// users drop off
+type Event struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id"`
+	Tenant string             `json:"-" bson:"tenant"`
+	// Name is one of eventNames
+	Name string `json:"name" bson:"name"`
+	// At is when the action happened, now if left out
+	At         time.Time `json:"at" bson:"at"`
+	Platform   string    `json:"-" bson:"platform,omitempty"`
+	AppVersion string    `json:"-" bson:"app_version,omitempty"`
+}
+
+// EventBatch is the events a client sends at once
+type EventBatch struct {
+	Events []Event `json:"events"`
+}
+
+// TenantEvents are the names of the events a tenant recorded within a funnel
+// window
+type TenantEvents struct {
+	Tenant string   `bson:"_id"`
+	Names  []string `bson:"names"`
+}
+
+// Funnel counts the users at each step of a sequence of events, a user
+// reaching a step if they recorded its event and the events of all steps
+// before it within the window
+type Funnel struct {
+	Since time.Time    `json:"since"`
+	Until time.Time    `json:"until"`
+	Steps []FunnelStep `json:"steps"`
+}
+
+// FunnelStep is a step of a funnel and the users reaching it
+type FunnelStep struct {
+	Event string `json:"event"`
+	Users int    `json:"users"`
+	// Conversion is the share of the users reaching the step before that
+	// reach this one too, 1 for the first step
+	Conversion float64 `json:"conversion"`
+}
+
+func isEventName(name string) bool {
+	for _, n := range eventNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns what is wrong with the batch, nothing if it is valid
+func (b EventBatch) validate(now time.Time) []FieldError {
+	if len(b.Events) == 0 || len(b.Events) > maxEventsPerRequest {
+		return []FieldError{{Field: "events", Message: fmt.Sprintf("has to have 1 to %d events", maxEventsPerRequest)}}
+	}
+	var fieldErrors []FieldError
+	for i, event := range b.Events {
+		if !isEventName(event.Name) {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("events[%d].name", i), Message: "has to be one of " + strings.Join(eventNames, ", ")})
+		}
+		if event.At.After(now.Add(maxEventClockSkew)) {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("events[%d].at", i), Message: "is in the future"})
+		} else if !event.At.IsZero() && event.At.Before(now.Add(-eventRetention)) {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("events[%d].at", i), Message: "is older than events are kept"})
+		}
+	}
+	return fieldErrors
+}
+
+// addEventsHandler records the events of the caller
+func addEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var batch EventBatch
+	err := decodeRequest(r, &batch)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	now := time.Now()
+	if fieldErrors := batch.validate(now); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	version := clientVersionFromHeaders(r)
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		event.ID = primitive.NewObjectID()
+		event.Tenant = tenantFromContext(r.Context())
+		event.Platform = version.Platform
+		event.AppVersion = version.AppVersion
+		if event.At.IsZero() {
+			event.At = now
+		}
+	}
+	err = repository.InsertEvents(r.Context(), batch.Events)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for _, event := range batch.Events {
+		eventsTotal.WithLabelValues(event.Name).Inc()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// funnel counts the users reaching each of steps
+func funnel(events []TenantEvents, steps []string) []FunnelStep {
+	funnelSteps := make([]FunnelStep, len(steps))
+	for _, tenant := range events {
+		recorded := make(map[string]bool)
+		for _, name := range tenant.Names {
+			recorded[name] = true
+		}
+		for i, step := range steps {
+			if !recorded[step] {
+				break
+			}
+			funnelSteps[i].Users++
+		}
+	}
+	for i := range funnelSteps {
+		funnelSteps[i].Event = steps[i]
+		if i == 0 {
+			funnelSteps[i].Conversion = 1
+		} else if funnelSteps[i-1].Users > 0 {
+			funnelSteps[i].Conversion = float64(funnelSteps[i].Users) / float64(funnelSteps[i-1].Users)
+		}
+	}
+	return funnelSteps
+}
+
+// getFunnelHandler reports the funnel of the steps query param, a comma
+// separated list of event names defaulting to all of them, within the since
+// and until query params, defaulting to the last 30 days
+func getFunnelHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	report := Funnel{Until: time.Now()}
+	report.Since = report.Until.Add(-defaultFunnelWindow)
+	var err error
+	if since := query.Get("since"); since != "" {
+		report.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "since", Message: "isn't an RFC 3339 time"})
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		report.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "until", Message: "isn't an RFC 3339 time"})
+			return
+		}
+	}
+	if !report.Since.Before(report.Until) {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "until", Message: "has to be after since"})
+		return
+	}
+	steps := eventNames
+	if stepsParam := query.Get("steps"); stepsParam != "" {
+		steps = strings.Split(stepsParam, ",")
+		for _, step := range steps {
+			if !isEventName(step) {
+				writeProblem(w, problemInvalidRequest, "", FieldError{Field: "steps", Message: "has to be event names out of " + strings.Join(eventNames, ", ")})
+				return
+			}
+		}
+	}
+
+	events, err := repository.TenantEvents(r.Context(), report.Since, report.Until)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	report.Steps = funnel(events, steps)
+
+	writeResponse(w, r, report)
+}
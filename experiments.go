@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// experimentNamePattern leaves out dots, since the names are keys of the
+// experiments field of stats
+var experimentNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// experimentsTTL is how long the experiments are cached for tagging stats,
+// replicas see changes made through another one that much later
+const experimentsTTL = 10 * time.Second
+
+// Experiment splits the accounts into variants, e.g. of the chord selection,
+// so their outcomes can be compared. Accounts are assigned a variant at
+// random but for good, with chances by the variants' weights.
+type Experiment struct {
+	Name        string              `json:"name" bson:"_id"`
+	Description string              `json:"description,omitempty" bson:"description,omitempty"`
+	Variants    []ExperimentVariant `json:"variants" bson:"variants"`
+	// Active experiments assign variants and tag stats with them
+	Active    bool      `json:"active" bson:"active"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type ExperimentVariant struct {
+	Name   string `json:"name" bson:"name"`
+	Weight int    `json:"weight" bson:"weight"`
+}
+
+// VariantOutcome is how the accounts in a variant did, over the stats
+// tagged with it
+type VariantOutcome struct {
+	Variant  string `json:"variant" bson:"_id"`
+	Accounts int    `json:"accounts" bson:"accounts"`
+	Answers  int    `json:"answers" bson:"answers"`
+	Correct  int    `json:"correct" bson:"correct"`
+	// Accuracy is 0 until there are answers
+	Accuracy          float64 `json:"accuracy" bson:"-"`
+	AvgDurationMillis float64 `json:"avg_duration_millis" bson:"avg_duration_millis"`
+}
+
+// validate returns what is wrong with the experiment, nothing if it is valid
+func (e Experiment) validate() []FieldError {
+	var fieldErrors []FieldError
+	if !experimentNamePattern.MatchString(e.Name) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "name", Message: "has to be 1 to 64 of a-z, 0-9, _ and -"})
+	}
+	if len(e.Variants) < 2 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "variants", Message: "needs at least two variants"})
+	}
+	names := make(map[string]bool)
+	for i, variant := range e.Variants {
+		if variant.Name == "" || names[variant.Name] {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("variants[%d].name", i), Message: "has to be set and unique"})
+		}
+		if variant.Weight <= 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("variants[%d].weight", i), Message: "has to be positive"})
+		}
+		names[variant.Name] = true
+	}
+	return fieldErrors
+}
+
+// VariantFor returns the variant of account. Changing the weights or
+// variants reassigns some of the accounts.
+func (e Experiment) VariantFor(account string) string {
+	total := 0
+	for _, variant := range e.Variants {
+		total += variant.Weight
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(e.Name + "/" + account))
+	bucket := int(hash.Sum32() % uint32(total))
+	for _, variant := range e.Variants {
+		if bucket < variant.Weight {
+			return variant.Name
+		}
+		bucket -= variant.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+// activeExperiments caches the active experiments, so tagging stats doesn't
+// cost every write a query
+var activeExperiments = struct {
+	sync.Mutex
+	experiments []Experiment
+	expiresAt   time.Time
+}{}
+
+func cachedActiveExperiments(ctx context.Context) ([]Experiment, error) {
+	activeExperiments.Lock()
+	defer activeExperiments.Unlock()
+
+	if time.Now().Before(activeExperiments.expiresAt) {
+		return activeExperiments.experiments, nil
+	}
+	experiments, err := repository.Experiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := []Experiment{}
+	for _, experiment := range experiments {
+		if experiment.Active {
+			active = append(active, experiment)
+		}
+	}
+	activeExperiments.experiments = active
+	activeExperiments.expiresAt = time.Now().Add(experimentsTTL)
+	return active, nil
+}
+
+func invalidateActiveExperiments() {
+	activeExperiments.Lock()
+	defer activeExperiments.Unlock()
+
+	activeExperiments.expiresAt = time.Time{}
+}
+
+// experimentAssignments returns the variant of account in every active
+// experiment, by experiment name, nil if there are none
+func experimentAssignments(ctx context.Context, account string) (map[string]string, error) {
+	experiments, err := cachedActiveExperiments(ctx)
+	if err != nil || len(experiments) == 0 {
+		return nil, err
+	}
+
+	assignments := make(map[string]string, len(experiments))
+	for _, experiment := range experiments {
+		assignments[experiment.Name] = experiment.VariantFor(account)
+	}
+	return assignments, nil
+}
+
+// tagExperiments returns the variants to tag an answer of the tenant in ctx
+// with. When the experiments can't be read the answer is saved untagged,
+// rather than failing the write.
+func tagExperiments(ctx context.Context) map[string]string {
+	assignments, err := experimentAssignments(ctx, tenantFromContext(ctx))
+	if err != nil {
+		log.Println("Error:", err)
+		return nil
+	}
+	return assignments
+}
+
+// experimentOutcomes compares the variants of an experiment across all
+// tenants
+func experimentOutcomes(ctx context.Context, name string) ([]VariantOutcome, error) {
+	outcomes, err := repository.ExperimentOutcomes(withTenant(ctx, allTenants), name)
+	if err != nil {
+		return nil, err
+	}
+	for i, outcome := range outcomes {
+		if outcome.Answers > 0 {
+			outcomes[i].Accuracy = float64(outcome.Correct) / float64(outcome.Answers)
+		}
+	}
+	return outcomes, nil
+}
+
+// getExperimentAssignmentsHandler tells the client which variant of every
+// active experiment the caller is in
+func getExperimentAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	assignments, err := experimentAssignments(r.Context(), tenantFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if assignments == nil {
+		assignments = map[string]string{}
+	}
+
+	writeResponse(w, r, assignments)
+}
+
+func getExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+	experiments, err := repository.Experiments(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, experiments)
+}
+
+// saveExperimentHandler creates or replaces the experiment named in the path
+func saveExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	var experiment Experiment
+	err := decodeRequest(r, &experiment)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	experiment.Name = chi.URLParam(r, "name")
+	if fieldErrors := experiment.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	experiment.UpdatedAt = time.Now()
+
+	err = repository.SaveExperiment(r.Context(), experiment)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	invalidateActiveExperiments()
+
+	writeResponse(w, r, experiment)
+}
+
+func deleteExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	err := repository.DeleteExperiment(r.Context(), chi.URLParam(r, "name"))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	invalidateActiveExperiments()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getExperimentAnalysisHandler compares the outcomes of the variants, the
+// stats keep their variant after the experiment is deleted
+func getExperimentAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	outcomes, err := experimentOutcomes(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, outcomes)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// feedLength is how many entries the feed has at most, the latest ones
+const feedLength = 50
+
+// answerMilestones are the numbers of answers that are achievements
+var answerMilestones = []int{100, 500, 1000, 5000, 10000, 50000}
+
+// The kinds of feed entries
+const (
+	entryAchievement  = "achievement"
+	entryPersonalBest = "personal-best"
+	entryWeeklyReport = "weekly-report"
+)
+
+// FeedEntry is a milestone or report in the progress of a user
+type FeedEntry struct {
+	Kind string
+	// Key tells the entry apart from the other ones of the user
+	Key     string
+	Title   string
+	Summary string
+	// At is the start of the day, or for weekly reports the week, after
+	// which the entry was reached
+	At time.Time
+}
+
+// progressEntries derives the feed entries of a user from counts, the
+// answers per day in loc, and the days their streak was kept on by a pause
+// or a freeze. Entries are only about days that are over, the latest first.
+func progressEntries(counts []StatsCountByDay, kept map[string]bool, loc *time.Location, now time.Time) []FeedEntry {
+	sorted := append([]StatsCountByDay(nil), counts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Day < sorted[j].Day })
+	today := now.In(loc).Format("2006-01-02")
+
+	var entries []FeedEntry
+	var previous time.Time
+	streak, total, best := 0, 0, 0
+	weeks := map[time.Time][]StatsCountByDay{}
+	for _, count := range sorted {
+		day, err := time.ParseInLocation("2006-01-02", count.Day, loc)
+		if err != nil || count.Count == 0 || count.Day >= today {
+			continue
+		}
+		over := day.AddDate(0, 0, 1)
+
+		if !previous.IsZero() && keptBetween(previous, day, kept) {
+			streak++
+		} else {
+			streak = 1
+		}
+		previous = day
+		for _, milestone := range streakMilestones {
+			if streak == milestone {
+				entries = append(entries, FeedEntry{
+					Kind:    entryAchievement,
+					Key:     fmt.Sprintf("streak-%d-%s", streak, count.Day),
+					Title:   fmt.Sprintf("🔥 %d-day streak!", streak),
+					Summary: fmt.Sprintf("Practiced chords %d days in a row, up to %s.", streak, count.Day),
+					At:      over,
+				})
+			}
+		}
+
+		for _, milestone := range answerMilestones {
+			if total < milestone && total+count.Count >= milestone {
+				entries = append(entries, FeedEntry{
+					Kind:    entryAchievement,
+					Key:     fmt.Sprintf("answers-%d", milestone),
+					Title:   fmt.Sprintf("🎹 %d chords answered", milestone),
+					Summary: fmt.Sprintf("Answered chord number %d on %s.", milestone, count.Day),
+					At:      over,
+				})
+			}
+		}
+		total += count.Count
+
+		if best > 0 && count.Count > best {
+			entries = append(entries, FeedEntry{
+				Kind:    entryPersonalBest,
+				Key:     "best-" + count.Day,
+				Title:   fmt.Sprintf("🏆 New personal best: %d answers in a day", count.Count),
+				Summary: fmt.Sprintf("Answered %d chords on %s, beating the best day so far of %d.", count.Count, count.Day, best),
+				At:      over,
+			})
+		}
+		if count.Count > best {
+			best = count.Count
+		}
+
+		monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		weeks[monday] = append(weeks[monday], count)
+	}
+
+	for monday, days := range weeks {
+		over := monday.AddDate(0, 0, 7)
+		if over.After(now) {
+			continue
+		}
+		answers := 0
+		for _, day := range days {
+			answers += day.Count
+		}
+		entries = append(entries, FeedEntry{
+			Kind:    entryWeeklyReport,
+			Key:     "week-" + monday.Format("2006-01-02"),
+			Title:   fmt.Sprintf("📊 Week of %s", monday.Format("2006-01-02")),
+			Summary: fmt.Sprintf("%d answers on %d of 7 days.", answers, len(days)),
+			At:      over,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].At.Equal(entries[j].At) {
+			return entries[i].At.After(entries[j].At)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > feedLength {
+		entries = entries[:feedLength]
+	}
+	return entries
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Summary  string       `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// AuthorizeFeed lets feed readers, which can't send headers, authorize with
+// a badge token in the token query param. Like the badge, the feed only
+// tells about the progress of a user. Anything else is authorized as usual.
+func AuthorizeFeed(next http.Handler) http.Handler {
+	authorized := Authorize(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			authorized.ServeHTTP(w, r)
+			return
+		}
+		failureKeys := authFailureKeys(remoteIP(r), token)
+		if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+			writeBanned(w, bannedFor)
+			return
+		}
+		badge, err := repository.BadgeByHash(r.Context(), badgeHash(token))
+		if err == errNotFound {
+			delayFailure(r.Context(), authFailures.fail(failureKeys))
+			writeProblem(w, problemUnauthorized, "The badge token is unknown or revoked")
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		recordCaller(r.Context(), badge.Tenant, badge.UserID)
+		ctx := withTenant(withUser(r.Context(), badge.UserID), badge.Tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// feedID identifies the feed of the user in ctx, without telling the
+// tenant or user
+func feedID(ctx context.Context) string {
+	hash := sha256.Sum256([]byte(tenantFromContext(ctx) + "/" + userFromContext(ctx)))
+	return "urn:piano-chord-training:feed:" + hex.EncodeToString(hash[:12])
+}
+
+// getFeedHandler serves the achievements, personal bests and weekly reports
+// of the caller as an Atom feed
+func getFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	loc, err := userLocation(ctx, userFromContext(ctx), "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	counts, err := repository.CountByDay(ctx, loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	kept, err := keptDays(ctx)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	id := feedID(ctx)
+	feed := atomFeed{
+		ID:     id,
+		Title:  "Piano Chord Training progress",
+		Author: atomAuthor{Name: "Piano Chord Training"},
+		Link:   atomLink{Rel: "self", Href: r.URL.Path},
+	}
+	entries := progressEntries(counts, kept, loc, time.Now())
+	updated := time.Unix(0, 0).UTC()
+	for _, entry := range entries {
+		if entry.At.After(updated) {
+			updated = entry.At
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:       id + ":" + entry.Key,
+			Title:    entry.Title,
+			Updated:  entry.At.Format(time.RFC3339),
+			Category: atomCategory{Term: entry.Kind},
+			Summary:  entry.Summary,
+		})
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// Flag turns a feature on or off for the whole deployment, for a share of
+// the accounts or for single accounts. Overrides win over the rollout, which
+// wins over Enabled.
+type Flag struct {
+	Name        string `json:"name" bson:"_id"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Enabled     bool   `json:"enabled" bson:"enabled"`
+	// RolloutPercent turns the flag on for that percentage of the accounts,
+	// the same ones for as long as it doesn't shrink
+	RolloutPercent *int           `json:"rollout_percent,omitempty" bson:"rollout_percent,omitempty"`
+	Overrides      []FlagOverride `json:"overrides" bson:"overrides"`
+	UpdatedAt      time.Time      `json:"updated_at" bson:"updated_at"`
+}
+
+type FlagOverride struct {
+	Account string `json:"account" bson:"account"`
+	Enabled bool   `json:"enabled" bson:"enabled"`
+}
+
+// validate returns what is wrong with the flag, nothing if it is valid
+func (f Flag) validate() []FieldError {
+	var fieldErrors []FieldError
+	if !flagNamePattern.MatchString(f.Name) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "name", Message: "has to be 1 to 64 of a-z, 0-9, _, . and -"})
+	}
+	if f.RolloutPercent != nil && (*f.RolloutPercent < 0 || *f.RolloutPercent > 100) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "rollout_percent", Message: "has to be between 0 and 100"})
+	}
+	accounts := make(map[string]bool)
+	for i, override := range f.Overrides {
+		if override.Account == "" || accounts[override.Account] {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("overrides[%d].account", i), Message: "has to be set and not overridden already"})
+		}
+		accounts[override.Account] = true
+	}
+	return fieldErrors
+}
+
+// EnabledFor tells if the flag is on for account
+func (f Flag) EnabledFor(account string) bool {
+	for _, override := range f.Overrides {
+		if override.Account == account {
+			return override.Enabled
+		}
+	}
+	if f.RolloutPercent != nil {
+		return rolloutBucket(f.Name, account) < *f.RolloutPercent
+	}
+	return f.Enabled
+}
+
+// rolloutBucket places account in one of 100 buckets, differently for every
+// flag so the same accounts don't always get new features first
+func rolloutBucket(flag, account string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(flag + "/" + account))
+	return int(hash.Sum32() % 100)
+}
+
+// flagsFor evaluates every flag for account
+func flagsFor(ctx context.Context, account string) (map[string]bool, error) {
+	flags, err := repository.Flags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluated := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		evaluated[flag.Name] = flag.EnabledFor(account)
+	}
+	return evaluated, nil
+}
+
+// getFlagsHandler tells the client which flags are on for the caller
+func getFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	flags, err := flagsFor(r.Context(), tenantFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, flags)
+}
+
+func getAdminFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	flags, err := repository.Flags(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, flags)
+}
+
+// saveFlagHandler creates or replaces the flag named in the path
+func saveFlagHandler(w http.ResponseWriter, r *http.Request) {
+	var flag Flag
+	err := decodeRequest(r, &flag)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	flag.Name = chi.URLParam(r, "name")
+	if fieldErrors := flag.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	if flag.Overrides == nil {
+		flag.Overrides = []FlagOverride{}
+	}
+	flag.UpdatedAt = time.Now()
+
+	err = repository.SaveFlag(r.Context(), flag)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, flag)
+}
+
+func deleteFlagHandler(w http.ResponseWriter, r *http.Request) {
+	err := repository.DeleteFlag(r.Context(), chi.URLParam(r, "name"))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
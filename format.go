@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeNDJSON  = "application/x-ndjson"
+	contentTypeMsgpack = "application/msgpack"
+	contentTypeCSV     = "text/csv"
+)
+
+// listFormats are the formats lists can be written in, the first one being
+// the default. MessagePack needs the length of an array up front, which a
+// list streamed from a cursor doesn't know, so lists are only MessagePack
+// when paged.
+var listFormats = []string{contentTypeJSON, contentTypeNDJSON, contentTypeCSV}
+
+// listFormatParams are the values of the format query param, which wins
+// over the Accept header for clients that can't set it, like links
+var listFormatParams = map[string]string{
+	"json":   contentTypeJSON,
+	"ndjson": contentTypeNDJSON,
+	"csv":    contentTypeCSV,
+}
+
+var errNotAcceptable = errors.New("none of the accepted formats can be served")
+
+// negotiate picks the offer the Accept header prefers, going by quality and
+// then by the order of the offers. It returns "" if none is acceptable.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			matched := -1
+			switch {
+			case mediaType == offer:
+				matched = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+				matched = 1
+			case mediaType == "*/*":
+				matched = 0
+			}
+			// the most specific range decides, e.g. text/csv;q=0 over */*
+			if matched <= specificity {
+				continue
+			}
+			specificity = matched
+			quality = 1
+			if q, exists := params["q"]; exists {
+				quality, err = strconv.ParseFloat(q, 64)
+				if err != nil {
+					quality = 0
+				}
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// wantsMsgpack reports whether the client prefers MessagePack responses to
+// JSON ones. Clients accepting neither get JSON.
+func wantsMsgpack(r *http.Request) bool {
+	return negotiate(r.Header.Get("Accept"), []string{contentTypeJSON, contentTypeMsgpack}) == contentTypeMsgpack
+}
+
+// responseFormat names the format a response to r is encoded in, used to
+// keep differently encoded responses apart in the cache
+func responseFormat(r *http.Request) string {
+	if wantsMsgpack(r) {
+		return contentTypeMsgpack
+	}
+	return "application/json"
+}
+
+// msgpack uses the json tags so that both formats share field names
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func unmarshalMsgpack(body io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(body)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// requestContentType returns the media type of the request body, without
+// its parameters
+func requestContentType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// RequireContentType answers requests with a body in a format the API can't
+// decode with 415, rather than decoding whatever arrives. Bodies are JSON or
+// MessagePack, imports can also be CSV.
+func RequireContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch requestContentType(r) {
+		case contentTypeJSON, contentTypeMsgpack:
+			next.ServeHTTP(w, r)
+			return
+		case contentTypeCSV:
+			if r.URL.Path == "/import" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeProblem(w, problemUnsupportedMediaType, "Content-Type has to be application/json or application/msgpack")
+	})
+}
+
+// decodeRequest decodes the request body as MessagePack or JSON depending on
+// its Content-Type
+func decodeRequest(r *http.Request, v interface{}) error {
+	if requestContentType(r) == contentTypeMsgpack {
+		return unmarshalMsgpack(r.Body, v)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// writeInvalidBody answers a request whose body can't be decoded, with 413
+// if it was cut off by LimitRequestBody
+func writeInvalidBody(w http.ResponseWriter, err error) {
+	var tooLarge errBodyTooLarge
+	if errors.As(err, &tooLarge) {
+		w.Header().Set("Connection", "close")
+		writeProblem(w, problemTooLarge, fmt.Sprintf("The body may be at most %d bytes", tooLarge.limit))
+		return
+	}
+	writeProblem(w, problemInvalidRequest, "The body can't be decoded: "+err.Error())
+}
+
+// writeInternalError answers a request that failed on the server side. The
+// details are only logged and reported, except that an unavailable database
+// is answered with 503, and with a Retry-After when the circuit breaker knows
+// when to try again.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	if timedOut(r) {
+		writeTimeoutProblem(w, r)
+		return
+	}
+	var openErr circuitOpenError
+	if errors.As(err, &openErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.retryAfter.Seconds()))))
+		writeProblem(w, problemUnavailable, "")
+		return
+	}
+	if unavailable(err) {
+		writeProblem(w, problemUnavailable, "")
+		log.Println("Error:", err)
+		return
+	}
+
+	writeProblem(w, problemInternal, "")
+	log.Println("Error:", err)
+	reportError(r.Context(), err)
+}
+
+// writeResponse encodes v as MessagePack or JSON depending on what the client
+// accepts
+func writeResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	writeResponseStatus(w, r, http.StatusOK, v)
+}
+
+func writeResponseStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var body []byte
+	var err error
+	if wantsMsgpack(r) {
+		body, err = marshalMsgpack(v)
+		w.Header().Set("Content-Type", contentTypeMsgpack)
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// listWriter writes a list of documents to the response one element at a
+// time, as a JSON array, one JSON document per line or CSV. Nothing is sent
+// before the first element, so the caller can still answer with an error
+// status until then.
+type listWriter struct {
+	w         http.ResponseWriter
+	format    string
+	csv       *csv.Writer
+	csvHeader []string
+	started   bool
+	count     int
+}
+
+// csvRecorder is implemented by the documents of lists that can be written
+// as CSV
+type csvRecorder interface {
+	csvRecord() []string
+}
+
+// newListWriter picks the list format from the request. Lists of documents
+// implementing csvRecorder can be written as CSV, with the columns named in
+// csvHeader, others pass a nil csvHeader. It fails with errNotAcceptable if
+// the request accepts none of the formats.
+func newListWriter(w http.ResponseWriter, r *http.Request, csvHeader []string) (*listWriter, error) {
+	offers := listFormats
+	if csvHeader == nil {
+		offers = []string{contentTypeJSON, contentTypeNDJSON}
+	}
+
+	format := negotiate(r.Header.Get("Accept"), offers)
+	if param := r.URL.Query().Get("format"); param != "" {
+		format = listFormatParams[param]
+		if format == contentTypeCSV && csvHeader == nil {
+			format = ""
+		}
+	}
+	if format == "" {
+		return nil, errNotAcceptable
+	}
+
+	return &listWriter{w: w, format: format, csvHeader: csvHeader}, nil
+}
+
+// Started reports whether the status and start of the list have been sent
+func (l *listWriter) Started() bool {
+	return l.started
+}
+
+func (l *listWriter) start() {
+	l.started = true
+	l.w.Header().Set("Content-Type", l.format)
+	l.w.WriteHeader(http.StatusOK)
+	switch l.format {
+	case contentTypeJSON:
+		l.w.Write([]byte("["))
+	case contentTypeCSV:
+		l.csv = csv.NewWriter(l.w)
+		l.csv.Write(l.csvHeader)
+	}
+}
+
+func (l *listWriter) Write(v interface{}) error {
+	if l.format == contentTypeCSV {
+		if !l.started {
+			l.start()
+		}
+		l.count++
+		err := l.csv.Write(v.(csvRecorder).csvRecord())
+		// flushing now and then keeps long exports streaming
+		if err == nil && l.count%100 == 0 {
+			l.csv.Flush()
+			err = l.csv.Error()
+		}
+		return err
+	}
+
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if !l.started {
+		l.start()
+	}
+	if l.format == contentTypeNDJSON {
+		jsonBytes = append(jsonBytes, '\n')
+	} else if l.count > 0 {
+		l.w.Write([]byte(","))
+	}
+	l.count++
+	_, err = l.w.Write(jsonBytes)
+	return err
+}
+
+// Close ends the list. It is skipped when streaming fails halfway, so that
+// a JSON client sees a truncated response rather than a seemingly complete one.
+func (l *listWriter) Close() {
+	if !l.started {
+		l.start()
+	}
+	switch l.format {
+	case contentTypeJSON:
+		l.w.Write([]byte("]"))
+	case contentTypeCSV:
+		l.csv.Flush()
+	}
+}
+
+// formatETag returns the ETag of a document version
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETag returns the document version an If-Match header refers to
+func parseETag(etag string) (int64, error) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	return strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStreakFreezes is how many streak freezes a user can have at once,
+// freezes granted beyond it are lost
+const maxStreakFreezes = 3
+
+// freezeAchievements are the achievements of the feed that grant a streak
+// freeze, by the key of their entry. Streaks grant one every time they are
+// reached.
+var freezeAchievements = []string{"streak-7", "streak-30", "answers-1000", "answers-10000"}
+
+// StreakFreeze records a missed day a streak freeze was used up on, which
+// then keeps the streak like a paused day
+type StreakFreeze struct {
+	// Day is in the user's timezone
+	Day           string    `json:"day" bson:"day"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	Tenant        string    `json:"-" bson:"tenant"`
+	UserID        string    `json:"-" bson:"user"`
+	SchemaVersion int       `json:"-" bson:"schema_version"`
+}
+
+// StreakFreezeReport tells how many streak freezes a user has left
+type StreakFreezeReport struct {
+	Remaining int `json:"remaining"`
+	// Earned counts the freezes ever granted, including the ones lost to a
+	// full inventory
+	Earned int `json:"earned"`
+	// Used are the days freezes were used up on, earliest first
+	Used []string `json:"used"`
+}
+
+func grantsStreakFreeze(entry FeedEntry) bool {
+	if entry.Kind != entryAchievement {
+		return false
+	}
+	for _, achievement := range freezeAchievements {
+		if entry.Key == achievement || strings.HasPrefix(entry.Key, achievement+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// keptDays returns the days that keep the streak of the user in ctx, paused
+// or frozen
+func keptDays(ctx context.Context) (map[string]bool, error) {
+	paused, err := pausedDays(ctx)
+	if err != nil {
+		return nil, err
+	}
+	freezes, err := repository.StreakFreezes(ctx, userFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	for _, freeze := range freezes {
+		paused[freeze.Day] = true
+	}
+	return paused, nil
+}
+
+// streakFreezes works out what is left of the streak freezes of the user in
+// ctx from counts, their answers per day in loc. A freeze only covers days
+// after it was granted. It returns the days that keep the streak, paused or
+// frozen, along with the report.
+func streakFreezes(ctx context.Context, counts []StatsCountByDay, loc *time.Location) (map[string]bool, StreakFreezeReport, error) {
+	paused, err := pausedDays(ctx)
+	if err != nil {
+		return nil, StreakFreezeReport{}, err
+	}
+	freezes, err := repository.StreakFreezes(ctx, userFromContext(ctx))
+	if err != nil {
+		return nil, StreakFreezeReport{}, err
+	}
+
+	kept := make(map[string]bool, len(paused)+len(freezes))
+	for day := range paused {
+		kept[day] = true
+	}
+	frozen := make(map[string]bool, len(freezes))
+	for _, freeze := range freezes {
+		kept[freeze.Day], frozen[freeze.Day] = true, true
+	}
+	first := ""
+	for _, count := range counts {
+		if count.Count > 0 && (first == "" || count.Day < first) {
+			first = count.Day
+		}
+	}
+
+	now := time.Now()
+	var grants []time.Time
+	for _, entry := range progressEntries(counts, kept, loc, now) {
+		if grantsStreakFreeze(entry) {
+			grants = append(grants, entry.At)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].Before(grants[j]) })
+
+	report := StreakFreezeReport{Earned: len(grants), Used: []string{}}
+	day, err := time.ParseInLocation(dayLayout, first, loc)
+	if err != nil {
+		return kept, report, nil
+	}
+	today := now.In(loc).Format(dayLayout)
+	for ; day.Format(dayLayout) <= today; day = day.AddDate(0, 0, 1) {
+		for len(grants) > 0 && !grants[0].After(day) {
+			if report.Remaining < maxStreakFreezes {
+				report.Remaining++
+			}
+			grants = grants[1:]
+		}
+
+		current := day.Format(dayLayout)
+		if frozen[current] {
+			if report.Remaining > 0 {
+				report.Remaining--
+			}
+			report.Used = append(report.Used, current)
+		}
+	}
+	return kept, report, nil
+}
+
+// maxStreakFreezeChecks bounds streakFreezeChecks, past it they are
+// forgotten and looked at again
+const maxStreakFreezeChecks = 10000
+
+// streakFreezeChecks remember the day each user's yesterday was last looked
+// at by useStreakFreeze, by tenant and user, so the answers after the first
+// of a day don't work out the freezes again. Its outcome can't change within
+// a day, freezes are only granted at the start of one.
+var streakFreezeChecks = struct {
+	sync.Mutex
+	days map[statsCaller]string
+}{days: make(map[statsCaller]string)}
+
+// useStreakFreeze uses up a streak freeze of the user in ctx on yesterday
+// if they missed it after a day that kept their streak, once they are back
+// practicing today. It runs whenever stats are saved, failures are only
+// logged. Freezes are saved once per day, so answers saved at once use up
+// one.
+func useStreakFreeze(ctx context.Context) {
+	userID := userFromContext(ctx)
+	loc, err := userLocation(ctx, userID, "")
+	if err != nil {
+		log.Println("Failed to use a streak freeze! Error:", err)
+		return
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	yesterday := today.AddDate(0, 0, -1)
+	caller := statsCaller{tenant: tenantFromContext(ctx), user: userID}
+	streakFreezeChecks.Lock()
+	checked := streakFreezeChecks.days[caller] == today.Format(dayLayout)
+	streakFreezeChecks.Unlock()
+	if checked {
+		return
+	}
+
+	checked, err = checkStreakFreeze(ctx, loc, today, yesterday)
+	if err != nil {
+		log.Println("Failed to use a streak freeze! Error:", err)
+		return
+	}
+	if !checked {
+		return
+	}
+	streakFreezeChecks.Lock()
+	if len(streakFreezeChecks.days) >= maxStreakFreezeChecks {
+		streakFreezeChecks.days = make(map[statsCaller]string)
+	}
+	streakFreezeChecks.days[caller] = today.Format(dayLayout)
+	streakFreezeChecks.Unlock()
+}
+
+// checkStreakFreeze uses up a streak freeze of the user in ctx on yesterday,
+// see useStreakFreeze. It returns false if the user hasn't practiced today
+// yet, yesterday is looked at again once they have.
+func checkStreakFreeze(ctx context.Context, loc *time.Location, today, yesterday time.Time) (bool, error) {
+	answeredYesterday, err := repository.CountMatchingStats(ctx, StatsFilter{Since: yesterday, Until: today})
+	if err != nil || answeredYesterday > 0 {
+		return err == nil, err
+	}
+	answeredToday, err := repository.CountMatchingStats(ctx, StatsFilter{Since: today})
+	if err != nil || answeredToday == 0 {
+		return false, err
+	}
+
+	counts, err := repository.CountByDay(ctx, loc)
+	if err != nil {
+		return false, err
+	}
+	kept, report, err := streakFreezes(ctx, counts, loc)
+	if err != nil {
+		return false, err
+	}
+	before := yesterday.AddDate(0, 0, -1).Format(dayLayout)
+	practicedBefore := false
+	for _, count := range counts {
+		if count.Day == before && count.Count > 0 {
+			practicedBefore = true
+		}
+	}
+	if report.Remaining == 0 || kept[yesterday.Format(dayLayout)] || !(practicedBefore || kept[before]) {
+		return true, nil
+	}
+	err = repository.SaveStreakFreeze(ctx, StreakFreeze{Day: yesterday.Format(dayLayout), CreatedAt: time.Now(), Tenant: tenantFromContext(ctx), UserID: userFromContext(ctx)})
+	return err == nil, err
+}
+
+// getStreakFreezesHandler tells how many streak freezes the caller has left
+func getStreakFreezesHandler(w http.ResponseWriter, r *http.Request) {
+	loc, err := userLocation(r.Context(), userFromContext(r.Context()), "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	counts, err := repository.CountByDay(r.Context(), loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	_, report, err := streakFreezes(r.Context(), counts, loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, report)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreakFreezes(t *testing.T) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	// day returns the day offset days from today
+	day := func(offset int) string {
+		return today.AddDate(0, 0, offset).Format(dayLayout)
+	}
+	// practiced returns answers counts of each day from first to last
+	practiced := func(first, last, answers int) []StatsCountByDay {
+		var counts []StatsCountByDay
+		for offset := first; offset <= last; offset++ {
+			counts = append(counts, StatsCountByDay{Day: day(offset), Count: answers})
+		}
+		return counts
+	}
+
+	tests := []struct {
+		name   string
+		counts []StatsCountByDay
+		frozen []string
+		pauses []StreakPause
+		want   StreakFreezeReport
+	}{
+		{
+			name: "no answers",
+			want: StreakFreezeReport{Used: []string{}},
+		},
+		{
+			name:   "short of a streak",
+			counts: practiced(-10, -5, 10),
+			want:   StreakFreezeReport{Used: []string{}},
+		},
+		{
+			name:   "granted by a streak",
+			counts: practiced(-16, -10, 10),
+			want:   StreakFreezeReport{Remaining: 1, Earned: 1, Used: []string{}},
+		},
+		{
+			name:   "used after the grant",
+			counts: append(practiced(-16, -10, 10), practiced(-8, -7, 10)...),
+			frozen: []string{day(-9)},
+			want:   StreakFreezeReport{Remaining: 0, Earned: 1, Used: []string{day(-9)}},
+		},
+		{
+			name:   "used before the grant",
+			counts: practiced(-16, -10, 10),
+			frozen: []string{day(-12)},
+			want:   StreakFreezeReport{Remaining: 1, Earned: 1, Used: []string{day(-12)}},
+		},
+		{
+			name:   "streak kept by a pause",
+			counts: append(practiced(-16, -14, 10), practiced(-12, -9, 10)...),
+			pauses: []StreakPause{{From: day(-13), Until: day(-13)}},
+			want:   StreakFreezeReport{Remaining: 1, Earned: 1, Used: []string{}},
+		},
+		{
+			name:   "capped",
+			counts: practiced(-31, -2, 5000),
+			want:   StreakFreezeReport{Remaining: maxStreakFreezes, Earned: 4, Used: []string{}},
+		},
+		{
+			name:   "granted again after using one at the cap",
+			counts: practiced(-31, -2, 5000),
+			frozen: []string{day(-20)},
+			want:   StreakFreezeReport{Remaining: maxStreakFreezes, Earned: 4, Used: []string{day(-20)}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(saved Repository) { repository = saved }(repository)
+			repository = newMemoryRepository(nil)
+			ctx := withTenant(withUser(context.Background(), "u"), defaultTenant)
+			for _, frozen := range test.frozen {
+				err := repository.SaveStreakFreeze(ctx, StreakFreeze{Day: frozen, Tenant: defaultTenant, UserID: "u"})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, pause := range test.pauses {
+				pause.Tenant, pause.UserID = defaultTenant, "u"
+				err := repository.SaveStreakPause(ctx, pause)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, report, err := streakFreezes(ctx, test.counts, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, test.want) {
+				t.Errorf("streakFreezes() = %+v, want %+v", report, test.want)
+			}
+		})
+	}
+}
+
+// TestUseStreakFreezeConcurrently has the answers of a day back after a
+// missed one saved at once, which must use up a single freeze
+func TestUseStreakFreezeConcurrently(t *testing.T) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var stats []StatsRaw
+	// a week of practice grants a freeze, then yesterday is missed
+	for offset := -8; offset <= -2; offset++ {
+		stats = append(stats, StatsRaw{ChordName: "C", RootNote: "C", CreatedAt: today.AddDate(0, 0, offset).Add(12 * time.Hour)})
+	}
+	stats = append(stats,
+		StatsRaw{ChordName: "C", RootNote: "C", CreatedAt: now},
+		StatsRaw{ChordName: "D", RootNote: "D", CreatedAt: now},
+	)
+
+	defer func(saved Repository) { repository = saved }(repository)
+	repository = newMemoryRepository(stats)
+	ctx := withTenant(withUser(context.Background(), "u"), defaultTenant)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			useStreakFreeze(ctx)
+		}()
+	}
+	wg.Wait()
+
+	freezes, err := repository.StreakFreezes(ctx, "u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := today.AddDate(0, 0, -1).Format(dayLayout)
+	if len(freezes) != 1 || freezes[0].Day != yesterday {
+		t.Fatalf("StreakFreezes() = %+v, want one on %s", freezes, yesterday)
+	}
+	counts, err := repository.CountByDay(ctx, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, report, err := streakFreezes(ctx, counts, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Remaining != 0 || report.Earned != 1 {
+		t.Errorf("streakFreezes() = %+v, want 1 earned and none remaining", report)
+	}
+}
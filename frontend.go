@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// embeddedFrontend is the compiled frontend built into the binary with the
+// embed_frontend build tag, nil without it
+var embeddedFrontend fs.FS
+
+// frontendHandler serves the files of the compiled frontend in files for
+// the paths the API doesn't have, handing the rest to fallback. Pages
+// navigated to in a browser that aren't files get index.html, so the routes
+// of the single page app work when opened directly. Other requests, like
+// API calls to paths that don't exist, get what fallback answers.
+func frontendHandler(files fs.FS, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			fallback(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if serveFrontendFile(w, r, files, name) {
+			return
+		}
+		if path.Ext(name) != "" || !strings.Contains(r.Header.Get("Accept"), "text/html") || !serveFrontendFile(w, r, files, "index.html") {
+			fallback(w, r)
+		}
+	}
+}
+
+// serveFrontendFile serves the file name of files, returning false if there
+// is no such file
+func serveFrontendFile(w http.ResponseWriter, r *http.Request, files fs.FS, name string) bool {
+	file, err := files.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		return false
+	}
+	content, seekable := file.(io.ReadSeeker)
+	if !seekable {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(data)
+	}
+
+	// the assets of a build have hashed names, but index.html keeps its
+	// name and has to be fetched again to pick up a deploy
+	if name == "index.html" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, stat.ModTime(), content)
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build embed_frontend
+// +build embed_frontend
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// frontendFiles is the compiled frontend copied to ./frontend before
+// building, see make build-with-frontend
+//
+//go:embed frontend
+var frontendFiles embed.FS
+
+func init() {
+	files, err := fs.Sub(frontendFiles, "frontend")
+	if err != nil {
+		panic(err)
+	}
+	embeddedFrontend = files
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleServiceAccount calls Google APIs as a service account, with access
+// tokens limited to scope
+type googleServiceAccount struct {
+	email     string
+	projectID string
+	key       *rsa.PrivateKey
+	tokenURL  string
+	scope     string
+	http      *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// googleError is a Google API answering with an error status
+type googleError struct {
+	StatusCode int
+	Status     string
+	Message    []byte
+}
+
+func (e googleError) Error() string {
+	return fmt.Sprintf("google answered %s: %s", e.Status, e.Message)
+}
+
+// newGoogleServiceAccount reads the JSON key of a service account, or the
+// file holding it
+func newGoogleServiceAccount(key, scope string) (*googleServiceAccount, error) {
+	data := []byte(key)
+	if !strings.HasPrefix(strings.TrimSpace(key), "{") {
+		var err error
+		data, err = ioutil.ReadFile(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		ProjectID   string `json:"project_id"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	err := json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if account.ClientEmail == "" || block == nil {
+		return nil, errors.New("not a service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the key of the service account isn't an RSA key")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &googleServiceAccount{
+		email:     account.ClientEmail,
+		projectID: account.ProjectID,
+		key:       rsaKey,
+		tokenURL:  account.TokenURI,
+		scope:     scope,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// accessToken returns a token of the service account, exchanging a signed
+// assertion for a new one when the last one is about to expire
+func (a *googleServiceAccount) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Now().Add(time.Minute).Before(a.expiresAt) {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   a.email,
+		"scope": a.scope,
+		"aud":   a.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = a.do(req, &token)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.expiresAt = token.AccessToken, now.Add(time.Duration(token.ExpiresIn)*time.Second)
+	return a.token, nil
+}
+
+// post sends body as JSON to endpoint with an access token, and decodes the
+// answer into v unless it is nil
+func (a *googleServiceAccount) post(ctx context.Context, endpoint string, body, v interface{}) error {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.do(req, v)
+}
+
+func (a *googleServiceAccount) do(req *http.Request, v interface{}) error {
+	res, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return googleError{StatusCode: res.StatusCode, Status: res.Status, Message: bytes.TrimSpace(message)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
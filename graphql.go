@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphqlSchema = `
+	schema {
+		query: Query
+	}
+
+	scalar Time
+
+	type Query {
+		stats(
+			chordName: String
+			rootNote: String
+			chordExtension: String
+			since: Time
+			until: Time
+			limit: Int
+		): [Stats!]!
+		# days are counted in timezone, or the user's timezone if not given
+		countByDay(timezone: String): [DayCount!]!
+		countByExtension: [ExtensionCount!]!
+		durationByExtension: [ExtensionDuration!]!
+	}
+
+	type Stats {
+		chordName: String!
+		rootNote: String!
+		chordExtension: String!
+		answerDurationMillis: Int!
+		createdAt: Time!
+	}
+
+	type DayCount {
+		day: String!
+		count: Int!
+	}
+
+	type ExtensionCount {
+		chordExtension: String!
+		count: Int!
+	}
+
+	type ExtensionDuration {
+		chordExtension: String!
+		# average answer duration in seconds
+		avgDuration: Float!
+	}
+`
+
+// newGraphqlHandler serves the GraphQL API, resolved with the same logic as
+// the HTTP and gRPC APIs
+func newGraphqlHandler() *relay.Handler {
+	schema := graphql.MustParseSchema(
+		graphqlSchema,
+		&graphqlResolver{},
+		graphql.UseFieldResolvers(),
+	)
+	return &relay.Handler{Schema: schema}
+}
+
+var errInternal = errors.New("internal error")
+
+type graphqlResolver struct{}
+
+type graphqlStats struct {
+	ChordName            string
+	RootNote             string
+	ChordExtension       string
+	AnswerDurationMillis int32
+	CreatedAt            graphql.Time
+}
+
+type graphqlDayCount struct {
+	Day   string
+	Count int32
+}
+
+type graphqlExtensionCount struct {
+	ChordExtension string
+	Count          int32
+}
+
+type graphqlExtensionDuration struct {
+	ChordExtension string
+	AvgDuration    float64
+}
+
+type graphqlStatsArgs struct {
+	ChordName      *string
+	RootNote       *string
+	ChordExtension *string
+	Since          *graphql.Time
+	Until          *graphql.Time
+	Limit          *int32
+}
+
+func (r *graphqlResolver) Stats(ctx context.Context, args graphqlStatsArgs) ([]*graphqlStats, error) {
+	var filter StatsFilter
+	if args.ChordName != nil {
+		filter.ChordName = *args.ChordName
+	}
+	if args.RootNote != nil {
+		filter.RootNote = *args.RootNote
+	}
+	if args.ChordExtension != nil {
+		filter.ChordExtension = *args.ChordExtension
+	}
+	if args.Since != nil {
+		filter.Since = args.Since.Time
+	}
+	if args.Until != nil {
+		filter.Until = args.Until.Time
+	}
+	if args.Limit != nil {
+		filter.Limit = int(*args.Limit)
+	}
+
+	result := []*graphqlStats{}
+	err := repository.EachStats(ctx, filter, func(stats StatsRaw) error {
+		result = append(result, &graphqlStats{
+			ChordName:            stats.ChordName,
+			RootNote:             stats.RootNote,
+			ChordExtension:       stats.ChordExtension,
+			AnswerDurationMillis: int32(stats.AnswerDurationMilliSeconds),
+			CreatedAt:            graphql.Time{Time: stats.CreatedAt},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+	return result, nil
+}
+
+func (r *graphqlResolver) CountByDay(ctx context.Context, args struct{ Timezone *string }) ([]*graphqlDayCount, error) {
+	var name string
+	if args.Timezone != nil {
+		name = *args.Timezone
+	}
+	loc, err := userLocation(ctx, userFromContext(ctx), name)
+	if err == errInvalidTimezone {
+		return nil, err
+	}
+	if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+
+	countByDays, err := countByDayLastMonth(ctx, loc)
+	if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+
+	result := []*graphqlDayCount{}
+	for _, count := range countByDays {
+		result = append(result, &graphqlDayCount{
+			Day:   count.Day,
+			Count: int32(count.Count),
+		})
+	}
+	return result, nil
+}
+
+func (r *graphqlResolver) CountByExtension(ctx context.Context) ([]*graphqlExtensionCount, error) {
+	countByExtensions, err := countByExtension(ctx)
+	if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+
+	result := []*graphqlExtensionCount{}
+	for _, count := range countByExtensions {
+		result = append(result, &graphqlExtensionCount{
+			ChordExtension: count.Extension,
+			Count:          int32(count.Count),
+		})
+	}
+	return result, nil
+}
+
+func (r *graphqlResolver) DurationByExtension(ctx context.Context) ([]*graphqlExtensionDuration, error) {
+	durationByExtensions, err := avgDurationByExtension(ctx)
+	if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+
+	result := []*graphqlExtensionDuration{}
+	for _, duration := range durationByExtensions {
+		result = append(result, &graphqlExtensionDuration{
+			ChordExtension: duration.Extension,
+			AvgDuration:    duration.AvgDuration,
+		})
+	}
+	return result, nil
+}
+
+// graphqlInternalError logs err and hides its details from the client
+func graphqlInternalError(ctx context.Context, err error) error {
+	log.Println("Error:", err)
+	reportError(ctx, err)
+	return errInternal
+}
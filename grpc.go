@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/mathenri/piano-chord-training-backend/statspb"
+)
+
+// grpcStatsServer serves the stats API over gRPC, sharing its logic with the
+// HTTP handlers
+type grpcStatsServer struct {
+	statspb.UnimplementedStatsServiceServer
+}
+
+func (s *grpcStatsServer) AddStats(ctx context.Context, req *statspb.AddStatsRequest) (*statspb.AddStatsResponse, error) {
+	if _, enabled := inMaintenance(ctx); enabled {
+		return nil, status.Error(codes.Unavailable, "down for maintenance")
+	}
+
+	stats := StatsRaw{
+		ChordName:                  req.GetStats().GetChordName(),
+		RootNote:                   req.GetStats().GetRootNote(),
+		ChordExtension:             req.GetStats().GetChordExtension(),
+		AnswerDurationMilliSeconds: int(req.GetStats().GetAnswerDurationMillis()),
+		ClientID:                   req.GetStats().GetClientId(),
+		Platform:                   req.GetStats().GetPlatform(),
+		AppVersion:                 req.GetStats().GetAppVersion(),
+		Correct:                    req.GetStats().Correct,
+		Instrument:                 req.GetStats().GetInstrument(),
+		BPM:                        int(req.GetStats().GetBpm()),
+		BeatsToAnswer:              req.GetStats().GetBeatsToAnswer(),
+		Confidence:                 int(req.GetStats().GetConfidence()),
+		Attempt:                    int(req.GetStats().GetAttempt()),
+		Tags:                       req.GetStats().GetTags(),
+		Note:                       req.GetStats().GetNote(),
+	}
+	if req.GetStats().GetCreatedAt() != nil {
+		stats.CreatedAt = req.GetStats().GetCreatedAt().AsTime()
+	}
+	if req.GetStats().GetUpdatedAt() != nil {
+		stats.UpdatedAt = req.GetStats().GetUpdatedAt().AsTime()
+	}
+	if fieldErrors := validateChord(stats); len(fieldErrors) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+	if fieldErrors := validateTempo(stats); len(fieldErrors) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+	if fieldErrors := validateConfidence(stats); len(fieldErrors) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+	if fieldErrors := validateAttempt(stats); len(fieldErrors) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+	if fieldErrors := validateNotes(stats); len(fieldErrors) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+	if stats.ClientID != "" && !validUUID(stats.ClientID) {
+		return nil, status.Error(codes.InvalidArgument, "client_id is not a UUID")
+	}
+
+	ifVersion := int64(anyVersion)
+	if req.IfVersion != nil {
+		if stats.ClientID == "" {
+			return nil, status.Error(codes.InvalidArgument, "if_version requires a client_id")
+		}
+		ifVersion = req.GetIfVersion()
+	}
+
+	stored, err := addStats(ctx, stats, ifVersion)
+	if err == errVersionConflict {
+		return nil, status.Errorf(codes.Aborted, "stored version is %d", stored.Version)
+	}
+	if err == errQuotaExceeded {
+		return nil, status.Error(codes.ResourceExhausted, "stats quota exceeded")
+	}
+	if err == errQueueFull {
+		return nil, status.Error(codes.Unavailable, "database unavailable")
+	}
+	if err == errQueued {
+		// queued stats only get an id and version once they are saved
+		return &statspb.AddStatsResponse{Stats: statsToProto(stored)}, nil
+	}
+	if err != nil {
+		return nil, grpcInternalError(ctx, err)
+	}
+	return &statspb.AddStatsResponse{Stats: statsToProto(stored)}, nil
+}
+
+func (s *grpcStatsServer) ListStats(req *statspb.ListStatsRequest, stream statspb.StatsService_ListStatsServer) error {
+	err := repository.EachStats(stream.Context(), StatsFilter{}, func(stats StatsRaw) error {
+		return stream.Send(statsToProto(stats))
+	})
+	if err != nil {
+		return grpcInternalError(stream.Context(), err)
+	}
+	return nil
+}
+
+func (s *grpcStatsServer) CountByDay(ctx context.Context, req *statspb.CountByDayRequest) (*statspb.CountByDayResponse, error) {
+	loc, err := userLocation(ctx, userFromContext(ctx), req.GetTimezone())
+	if err == errInvalidTimezone {
+		return nil, status.Error(codes.InvalidArgument, "invalid timezone")
+	}
+	if err != nil {
+		return nil, grpcInternalError(ctx, err)
+	}
+
+	countByDays, err := countByDayLastMonth(ctx, loc)
+	if err != nil {
+		return nil, grpcInternalError(ctx, err)
+	}
+
+	res := &statspb.CountByDayResponse{}
+	for _, count := range countByDays {
+		res.Counts = append(res.Counts, &statspb.DayCount{
+			Day:   count.Day,
+			Count: int64(count.Count),
+		})
+	}
+	return res, nil
+}
+
+func (s *grpcStatsServer) CountByExtension(ctx context.Context, req *statspb.CountByExtensionRequest) (*statspb.CountByExtensionResponse, error) {
+	countByExtensions, err := countByExtension(ctx)
+	if err != nil {
+		return nil, grpcInternalError(ctx, err)
+	}
+
+	res := &statspb.CountByExtensionResponse{}
+	for _, count := range countByExtensions {
+		res.Counts = append(res.Counts, &statspb.ExtensionCount{
+			ChordExtension: count.Extension,
+			Count:          int64(count.Count),
+		})
+	}
+	return res, nil
+}
+
+func (s *grpcStatsServer) DurationByExtension(ctx context.Context, req *statspb.DurationByExtensionRequest) (*statspb.DurationByExtensionResponse, error) {
+	durationByExtensions, err := avgDurationByExtension(ctx)
+	if err != nil {
+		return nil, grpcInternalError(ctx, err)
+	}
+
+	res := &statspb.DurationByExtensionResponse{}
+	for _, duration := range durationByExtensions {
+		res.Durations = append(res.Durations, &statspb.ExtensionDuration{
+			ChordExtension: duration.Extension,
+			AvgDuration:    duration.AvgDuration,
+		})
+	}
+	return res, nil
+}
+
+func statsToProto(stats StatsRaw) *statspb.Stats {
+	pb := &statspb.Stats{
+		Id:                   stats.ID.Hex(),
+		ChordName:            stats.ChordName,
+		RootNote:             stats.RootNote,
+		ChordExtension:       stats.ChordExtension,
+		AnswerDurationMillis: int64(stats.AnswerDurationMilliSeconds),
+		CreatedAt:            timestamppb.New(stats.CreatedAt),
+		ClientId:             stats.ClientID,
+		Version:              stats.Version,
+		Correct:              stats.Correct,
+		Instrument:           stats.Instrument,
+		Bpm:                  int32(stats.BPM),
+		BeatsToAnswer:        stats.BeatsToAnswer,
+		Confidence:           int32(stats.Confidence),
+		Attempt:              int32(stats.Attempt),
+		Tags:                 stats.Tags,
+		Note:                 stats.Note,
+		Platform:             stats.Platform,
+		AppVersion:           stats.AppVersion,
+	}
+	if !stats.UpdatedAt.IsZero() {
+		pb.UpdatedAt = timestamppb.New(stats.UpdatedAt)
+	}
+	return pb
+}
+
+// grpcInternalError logs err and hides its details from the client, like the
+// HTTP handlers do
+func grpcInternalError(ctx context.Context, err error) error {
+	log.Println("Error:", err)
+	if unavailable(err) {
+		return status.Error(codes.Unavailable, "database unavailable")
+	}
+	reportError(ctx, err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// grpcToken reads the auth token from the x-auth-token metadata, the gRPC
+// counterpart of the X-Auth-Token header
+func grpcToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get("x-auth-token")
+	if len(tokens) > 0 {
+		return tokens[0]
+	}
+	return ""
+}
+
+// grpcAuthorize returns ctx with the tenant and user of the caller's auth
+// token, a session, guest or configured one, counting failures and limiting
+// guests like Authorize does
+func grpcAuthorize(ctx context.Context) (context.Context, error) {
+	token := grpcToken(ctx)
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	failureKeys := authFailureKeys(ip, token)
+	if authFailures.bannedFor(failureKeys) > 0 {
+		return nil, status.Error(codes.ResourceExhausted, "too many failed auth attempts")
+	}
+
+	if isSessionToken(token) {
+		session, err := sessionForToken(ctx, token, ip)
+		if err == errNotFound {
+			delayFailure(ctx, authFailures.fail(failureKeys))
+			return nil, status.Error(codes.Unauthenticated, "unknown or expired session")
+		}
+		if err != nil {
+			return nil, grpcInternalError(ctx, err)
+		}
+		if session.Locked {
+			return nil, status.Error(codes.PermissionDenied, "account scheduled for deletion")
+		}
+		return withSession(withTenant(withUser(ctx, session.UserID), session.Tenant), session), nil
+	}
+	if isGuestToken(token) {
+		session, err := guestForToken(ctx, token)
+		if err == errNotFound {
+			delayFailure(ctx, authFailures.fail(failureKeys))
+			return nil, status.Error(codes.Unauthenticated, "unknown or expired guest token")
+		}
+		if err != nil {
+			return nil, grpcInternalError(ctx, err)
+		}
+		if allowed, retryIn := guestRequests.allow(session.Tenant); !allowed {
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limited, retry in %s", retryIn.Round(time.Second))
+		}
+		return withTenant(withUser(ctx, session.UserID), session.Tenant), nil
+	}
+
+	tenant, valid := tenantForToken(ctx, token)
+	if !valid {
+		delayFailure(ctx, authFailures.fail(failureKeys))
+		return nil, status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+	return withTenant(withUser(ctx, defaultUserID), tenant), nil
+}
+
+func grpcAuthorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcAuthorizeStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthorize(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &userServerStream{ServerStream: stream, ctx: ctx})
+}
+
+// userServerStream carries the authorized user and tenant in its context
+type userServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *userServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// serveGRPC serves the gRPC API on port, it blocks like http.ListenAndServe.
+// Of the timeouts, the connection handshake is held to the read header
+// timeout and idle connections are closed like by the HTTP server. Calls
+// have their deadlines set by the clients.
+func serveGRPC(port string, timeouts serverTimeouts) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcAuthorizeUnary),
+		grpc.StreamInterceptor(grpcAuthorizeStream),
+		grpc.ConnectionTimeout(timeouts.ReadHeaderTimeout),
+		grpc.MaxHeaderListSize(uint32(timeouts.MaxHeaderSize)),
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: timeouts.IdleTimeout}),
+	)
+	statspb.RegisterStatsServiceServer(server, &grpcStatsServer{})
+	return server.Serve(listener)
+}
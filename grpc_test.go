@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestGRPCAuthorizeGuest authorizes gRPC calls with guest tokens, one after
+// another against a limit of two requests
+func TestGRPCAuthorizeGuest(t *testing.T) {
+	defer func(saved Repository) { repository = saved }(repository)
+	defer func(saved authFailureCounter) { authFailures = saved }(authFailures)
+	defer func(saved requestLimiter) { guestRequests = saved }(guestRequests)
+	repository = newMemoryRepository(nil)
+	authFailures = newAuthGuard(100, time.Minute)
+	guestRequests = newRateLimiter(2, time.Minute)
+
+	now := time.Now()
+	for token, expiresAt := range map[string]time.Time{
+		guestTokenPrefix + "live":    now.Add(time.Hour),
+		guestTokenPrefix + "expired": now.Add(-time.Hour),
+	} {
+		id := primitive.NewObjectID()
+		err := repository.SaveSession(context.Background(), Session{
+			ID:        id,
+			UserID:    guestUserID,
+			Tenant:    guestTenantPrefix + id.Hex(),
+			Hash:      sessionHash(token),
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+			Guest:     true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  codes.Code
+	}{
+		{"guest", guestTokenPrefix + "live", codes.OK},
+		{"expired guest", guestTokenPrefix + "expired", codes.Unauthenticated},
+		{"unknown guest", guestTokenPrefix + "unknown", codes.Unauthenticated},
+		{"second request", guestTokenPrefix + "live", codes.OK},
+		{"over the rate limit", guestTokenPrefix + "live", codes.ResourceExhausted},
+	}
+	for _, test := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-auth-token", test.token))
+
+		authorized, err := grpcAuthorize(ctx)
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.code)
+			continue
+		}
+		if err == nil && (!isGuestTenant(tenantFromContext(authorized)) || userFromContext(authorized) != guestUserID) {
+			t.Errorf("%s: authorized as %s of %s, want the guest", test.name, userFromContext(authorized), tenantFromContext(authorized))
+		}
+	}
+}
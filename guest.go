@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// guestTokenPrefix starts every guest token, telling them apart from
+	// session tokens, which only signed in users have
+	guestTokenPrefix = "pcg_"
+	// guestTenantPrefix starts the tenant of every guest, each guest having
+	// a tenant of their own
+	guestTenantPrefix = "guest_"
+	// guestUserID is the user of every guest within their tenant
+	guestUserID = "guest"
+	// guestsPerIP is how many guest tokens an IP gets per guestsPerIPWindow
+	guestsPerIP       = 5
+	guestsPerIPWindow = time.Hour
+	// maxRateLimitEntries bounds the memory taken by rate limits, windows
+	// that are over are dropped when it is reached
+	maxRateLimitEntries = 10000
+)
+
+var (
+	// guestsEnabled lets anyone get a guest token at /auth/guest
+	guestsEnabled bool
+	// guestTTL is how long a guest token and the answers of its guest last
+	guestTTL = 24 * time.Hour
+	// guestQuota is how many answers a guest may store
+	guestQuota = 200
+	// guestRequests limits the requests of each guest, guestIssues the guest
+	// tokens issued to each IP. When running several replicas they count
+	// together through Redis.
+	guestRequests requestLimiter = newRateLimiter(60, time.Minute)
+	guestIssues   requestLimiter = newRateLimiter(guestsPerIP, guestsPerIPWindow)
+)
+
+type requestLimiter interface {
+	// allow counts a request for key, telling if it is within the limit
+	// and, if it isn't, how long until the next window
+	allow(key string) (bool, time.Duration)
+	// setLimit changes how many requests a window lets through, counting
+	// the ones of the current window against it
+	setLimit(limit int)
+}
+
+// isGuestToken tells if token was issued to a guest
+func isGuestToken(token string) bool {
+	return strings.HasPrefix(token, guestTokenPrefix)
+}
+
+// isGuestTenant tells if tenant is of a guest
+func isGuestTenant(tenant string) bool {
+	return strings.HasPrefix(tenant, guestTenantPrefix)
+}
+
+// guestExpiry returns when the guest of tenant expires, and their answers
+// along with them. Their tenant is made of the ID of their session, which
+// tells when it was issued.
+func guestExpiry(tenant string) time.Time {
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(tenant, guestTenantPrefix))
+	if err != nil {
+		return time.Now().Add(guestTTL)
+	}
+	return id.Timestamp().Add(guestTTL)
+}
+
+// guestSignInHandler issues a guest token, which lets someone try the app
+// without an account until it expires. Its answers expire along with it.
+func guestSignInHandler(w http.ResponseWriter, r *http.Request) {
+	if !guestsEnabled {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if allowed, retryIn := guestIssues.allow(remoteIP(r)); !allowed {
+		writeRateLimited(w, retryIn)
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	token := guestTokenPrefix + hex.EncodeToString(secret)
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	tenant := guestTenantPrefix + id.Hex()
+	session := Session{
+		ID:         id,
+		UserID:     guestUserID,
+		Tenant:     tenant,
+		Hash:       sessionHash(token),
+		UserAgent:  r.UserAgent(),
+		IP:         remoteIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  guestExpiry(tenant),
+		Guest:      true,
+	}
+	err = repository.SaveSession(r.Context(), session)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	session.Token = token
+	writeResponse(w, r, session)
+}
+
+// guestForToken returns the session of a guest token, failing with
+// errNotFound if the token is unknown or expired
+func guestForToken(ctx context.Context, token string) (Session, error) {
+	session, err := liveSession(ctx, token)
+	if err != nil {
+		return Session{}, err
+	}
+	if !session.Guest {
+		return Session{}, errNotFound
+	}
+	return session, nil
+}
+
+// writeRateLimited answers a request over its rate limit
+func writeRateLimited(w http.ResponseWriter, retryIn time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryIn.Seconds()))))
+	writeProblem(w, problemRateLimited, "")
+}
+
+// rateLimiter lets through limit requests per key in every window. Windows
+// are fixed and counted by each replica on its own.
+type rateLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*rateWindow
+	limit   int
+	window  time.Duration
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		entries: make(map[string]*rateWindow),
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// allow counts a request for key, telling if it is within the limit and, if
+// it isn't, how long until the next window
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entry, exists := l.entries[key]
+	if !exists || now.Sub(entry.start) >= l.window {
+		if !exists && len(l.entries) >= maxRateLimitEntries {
+			l.forget(now)
+		}
+		entry = &rateWindow{start: now}
+		l.entries[key] = entry
+	}
+	if entry.count >= l.limit {
+		return false, entry.start.Add(l.window).Sub(now)
+	}
+	entry.count++
+	return true, 0
+}
+
+func (l *rateLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+// forget drops the entries whose window is over, l.mu has to be held
+func (l *rateLimiter) forget(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.start) >= l.window {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// redisRateLimiter counts the requests of all replicas in Redis, in fixed
+// windows like rateLimiter. Requests are let through without counting
+// while Redis is unavailable, rather than failing all of them.
+type redisRateLimiter struct {
+	client *redis.Client
+	name   string
+	window time.Duration
+
+	mu    sync.RWMutex
+	limit int
+}
+
+func newRedisRateLimiter(client *redis.Client, name string, limit int, window time.Duration) *redisRateLimiter {
+	return &redisRateLimiter{client: client, name: name, limit: limit, window: window}
+}
+
+func (l *redisRateLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+func (l *redisRateLimiter) allow(key string) (bool, time.Duration) {
+	ctx := context.Background()
+	key = "ratelimit:" + l.name + ":" + key
+	pipe := l.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Println("Failed to count a rate limited request! Error:", err)
+		return true, 0
+	}
+
+	retryIn := ttl.Val()
+	// the first request of a window starts it
+	if retryIn < 0 {
+		retryIn = l.window
+		err = l.client.PExpire(ctx, key, l.window).Err()
+		if err != nil {
+			log.Println("Failed to start a rate limit window! Error:", err)
+		}
+	}
+	l.mu.RLock()
+	limit := l.limit
+	l.mu.RUnlock()
+	if int(count.Val()) > limit {
+		return false, retryIn
+	}
+	return true, 0
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// importMaxRows caps the answers of a single import, larger histories
+	// have to be split up
+	importMaxRows = 100000
+	// importInsertBatch is how many answers are inserted at a time
+	importInsertBatch = 1000
+)
+
+var errTooManyRows = fmt.Errorf("an import holds at most %d answers", importMaxRows)
+
+// rootNotePattern matches the root note at the start of a chord name
+var rootNotePattern = regexp.MustCompile(`^[A-G][#b]?`)
+
+// ImportReport tells how an import of only valid answers went
+type ImportReport struct {
+	DryRun bool `json:"dry_run"`
+	Rows   int  `json:"rows"`
+	// Imported is 0 on dry runs
+	Imported int `json:"imported"`
+}
+
+// importRow is an answer being imported, or why it can't be
+type importRow struct {
+	stats StatsRaw
+	err   error
+}
+
+// importCSVColumns are the columns a CSV import understands, named like the
+// JSON fields. Other columns are ignored, so spreadsheets can keep notes.
+var importCSVColumns = map[string]func(*StatsRaw, string) error{
+	"chord_name": func(s *StatsRaw, v string) error {
+		s.ChordName = v
+		return nil
+	},
+	"root_note": func(s *StatsRaw, v string) error {
+		s.RootNote = v
+		return nil
+	},
+	"chord_extension": func(s *StatsRaw, v string) error {
+		s.ChordExtension = v
+		return nil
+	},
+	"answer_duration_millis": func(s *StatsRaw, v string) error {
+		duration, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("answer_duration_millis isn't a whole number")
+		}
+		s.AnswerDurationMilliSeconds = duration
+		return nil
+	},
+	"created_at": func(s *StatsRaw, v string) error {
+		createdAt, err := parseImportTime(v)
+		if err != nil {
+			return errors.New("created_at isn't a time like 2021-04-01T18:30:00Z")
+		}
+		s.CreatedAt = createdAt
+		return nil
+	},
+	"client_id": func(s *StatsRaw, v string) error {
+		s.ClientID = v
+		return nil
+	},
+	"correct": func(s *StatsRaw, v string) error {
+		if v == "" {
+			return nil
+		}
+		correct, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("correct isn't true or false")
+		}
+		s.Correct = &correct
+		return nil
+	},
+	"instrument": func(s *StatsRaw, v string) error {
+		s.Instrument = v
+		return nil
+	},
+	"bpm": func(s *StatsRaw, v string) error {
+		if v == "" {
+			return nil
+		}
+		bpm, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("bpm isn't a whole number")
+		}
+		s.BPM = bpm
+		return nil
+	},
+	"beats_to_answer": func(s *StatsRaw, v string) error {
+		if v == "" {
+			return nil
+		}
+		beats, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("beats_to_answer isn't a number")
+		}
+		s.BeatsToAnswer = beats
+		return nil
+	},
+	"confidence": func(s *StatsRaw, v string) error {
+		if v == "" {
+			return nil
+		}
+		confidence, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("confidence isn't a whole number")
+		}
+		s.Confidence = confidence
+		return nil
+	},
+	"attempt": func(s *StatsRaw, v string) error {
+		if v == "" {
+			return nil
+		}
+		attempt, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("attempt isn't a whole number")
+		}
+		s.Attempt = attempt
+		return nil
+	},
+	"tags": func(s *StatsRaw, v string) error {
+		s.Tags = parseTags(v)
+		return nil
+	},
+	"note": func(s *StatsRaw, v string) error {
+		s.Note = v
+		return nil
+	},
+}
+
+// statsCSVHeader are the columns stats are exported as CSV with, the ones
+// imports understand so an export can be imported again
+var statsCSVHeader = []string{"chord_name", "root_note", "chord_extension", "answer_duration_millis", "created_at", "client_id", "correct", "instrument", "bpm", "beats_to_answer", "confidence", "attempt", "tags", "note"}
+
+func (s StatsRaw) csvRecord() []string {
+	correct := ""
+	if s.Correct != nil {
+		correct = strconv.FormatBool(*s.Correct)
+	}
+	bpm, beats := "", ""
+	if s.BPM > 0 {
+		bpm = strconv.Itoa(s.BPM)
+		beats = strconv.FormatFloat(s.BeatsToAnswer, 'f', -1, 64)
+	}
+	confidence, attempt := "", ""
+	if s.Confidence > 0 {
+		confidence = strconv.Itoa(s.Confidence)
+	}
+	if s.Attempt > 0 {
+		attempt = strconv.Itoa(s.Attempt)
+	}
+	return []string{
+		s.ChordName,
+		s.RootNote,
+		s.ChordExtension,
+		strconv.Itoa(s.AnswerDurationMilliSeconds),
+		s.CreatedAt.UTC().Format(time.RFC3339Nano),
+		s.ClientID,
+		correct,
+		s.Instrument,
+		bpm,
+		beats,
+		confidence,
+		attempt,
+		strings.Join(s.Tags, tagSeparator),
+		s.Note,
+	}
+}
+
+// parseImportTime accepts RFC 3339 and the plain UTC times spreadsheets tend
+// to export
+func parseImportTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", v, time.UTC)
+}
+
+// readImportCSV reads answers from a CSV with a header row
+func readImportCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]func(*StatsRaw, string) error, len(header))
+	for i, name := range header {
+		// spreadsheets like to start their exports with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[i] = importCSVColumns[name]
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if len(rows) >= importMaxRows {
+			return nil, errTooManyRows
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			rows = append(rows, importRow{err: fmt.Errorf("has %d fields, the header has %d", len(record), len(header))})
+			continue
+		}
+
+		var row importRow
+		for i, value := range record {
+			if columns[i] == nil {
+				continue
+			}
+			row.err = columns[i](&row.stats, strings.TrimSpace(value))
+			if row.err != nil {
+				break
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readImportJSON reads answers from a JSON array of stats, as listed by
+// /stats/raw
+func readImportJSON(r io.Reader) ([]importRow, error) {
+	var documents []json.RawMessage
+	err := json.NewDecoder(r).Decode(&documents)
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) > importMaxRows {
+		return nil, errTooManyRows
+	}
+
+	rows := make([]importRow, len(documents))
+	for i, document := range documents {
+		rows[i].err = json.Unmarshal(document, &rows[i].stats)
+	}
+	return rows, nil
+}
+
+// prepareImport fills in what older exports lack and checks the answer,
+// which has to hold up as if the app had recorded it
+func prepareImport(stats StatsRaw) (StatsRaw, error) {
+	stats.ChordName = strings.TrimSpace(stats.ChordName)
+	if stats.ChordName == "" {
+		return StatsRaw{}, errors.New("chord_name is missing")
+	}
+	// exports from before chord extensions were recorded only have the name
+	if stats.RootNote == "" {
+		stats.RootNote = rootNotePattern.FindString(stats.ChordName)
+	}
+	if !rootNotePattern.MatchString(stats.RootNote) {
+		return StatsRaw{}, errors.New("root_note is missing and can't be told from chord_name")
+	}
+	if stats.ChordExtension == "" {
+		stats.ChordExtension = strings.TrimPrefix(stats.ChordName, stats.RootNote)
+	}
+
+	if stats.AnswerDurationMilliSeconds <= 0 {
+		return StatsRaw{}, errors.New("answer_duration_millis has to be positive")
+	}
+	if stats.CreatedAt.IsZero() {
+		return StatsRaw{}, errors.New("created_at is missing")
+	}
+	if stats.CreatedAt.After(time.Now()) {
+		return StatsRaw{}, errors.New("created_at is in the future")
+	}
+	if stats.ClientID != "" && !validUUID(stats.ClientID) {
+		return StatsRaw{}, errors.New("client_id isn't a UUID")
+	}
+	if stats.Instrument != "" && !validInstrument(stats.Instrument) {
+		return StatsRaw{}, errors.New("instrument has to be one of " + strings.Join(instruments, ", "))
+	}
+	if fieldErrors := validateTempo(stats); len(fieldErrors) > 0 {
+		return StatsRaw{}, errors.New(fieldErrors[0].Field + " " + fieldErrors[0].Message)
+	}
+	if fieldErrors := validateConfidence(stats); len(fieldErrors) > 0 {
+		return StatsRaw{}, errors.New(fieldErrors[0].Field + " " + fieldErrors[0].Message)
+	}
+	if fieldErrors := validateAttempt(stats); len(fieldErrors) > 0 {
+		return StatsRaw{}, errors.New(fieldErrors[0].Field + " " + fieldErrors[0].Message)
+	}
+	if fieldErrors := validateNotes(stats); len(fieldErrors) > 0 {
+		return StatsRaw{}, errors.New(fieldErrors[0].Field + " " + fieldErrors[0].Message)
+	}
+	stats = withBeats(stats)
+	stats = withTidyNotes(stats)
+
+	// ids and versions are only ever assigned by the server
+	stats.ID = primitive.NewObjectID()
+	stats.Version = 1
+	// imported answers were given before any experiment, so aren't tagged
+	stats.Experiments = nil
+	if stats.ClientID != "" {
+		stats.ClientID = strings.ToLower(stats.ClientID)
+		stats.UpdatedAt = stats.CreatedAt
+	}
+	return stats, nil
+}
+
+// importStats checks every row and, unless it is a dry run, inserts them
+// once all of them are valid. Nothing is imported when a row is invalid, so
+// fixing the rows and importing again doesn't duplicate the rest, and the
+// invalid rows are returned instead. Row numbers count the answers from 1,
+// not counting the header of a CSV. Answers with a client ID that is already
+// stored are left as stored.
+func importStats(ctx context.Context, rows []importRow, dryRun bool) (ImportReport, []FieldError, error) {
+	report := ImportReport{DryRun: dryRun, Rows: len(rows)}
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return report, nil, err
+	}
+	var rowErrors []FieldError
+	stats := make([]StatsRaw, 0, len(rows))
+	for i, row := range rows {
+		err := row.err
+		if err == nil {
+			row.stats, err = prepareImport(aliases.stats(row.stats))
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, FieldError{Row: i + 1, Message: err.Error()})
+			continue
+		}
+		stats = append(stats, row.stats)
+	}
+	if len(rowErrors) > 0 || len(stats) == 0 {
+		return report, rowErrors, nil
+	}
+
+	err = checkQuotaRoom(ctx, len(stats))
+	if err != nil || dryRun {
+		return report, nil, err
+	}
+
+	for start := 0; start < len(stats); start += importInsertBatch {
+		end := start + importInsertBatch
+		if end > len(stats) {
+			end = len(stats)
+		}
+		err = repository.InsertStats(ctx, stats[start:end])
+		if err != nil {
+			return report, nil, err
+		}
+		recordStatsSaved(ctx, stats[start:end]...)
+		report.Imported = end
+	}
+
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+	return report, nil, nil
+}
+
+// importHandler imports answers from a CSV, when sent as text/csv, or from
+// a JSON array. With dry_run=true the answers are only checked.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	if err != nil && r.URL.Query().Get("dry_run") != "" {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "dry_run", Message: "has to be true or false"})
+		return
+	}
+
+	var rows []importRow
+	if requestContentType(r) == contentTypeCSV {
+		rows, err = readImportCSV(r.Body)
+	} else {
+		rows, err = readImportJSON(r.Body)
+	}
+	if err == errTooManyRows {
+		writeProblem(w, problemTooLarge, fmt.Sprintf("An import holds at most %d answers", importMaxRows))
+		return
+	}
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	report, rowErrors, err := importStats(r.Context(), rows, dryRun)
+	if err == errQuotaExceeded {
+		writeProblem(w, problemQuotaExceeded, "The import doesn't fit in the quota")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if len(rowErrors) > 0 {
+		detail := fmt.Sprintf("%d of %d answers are invalid, none were imported", len(rowErrors), report.Rows)
+		writeProblem(w, problemInvalidImport, detail, rowErrors...)
+		return
+	}
+	writeResponse(w, r, report)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// insightWeek is how long the periods are that are compared, the last
+	// one against the one before it
+	insightWeek = 7 * 24 * time.Hour
+	// minInsightAnswers is how many answers of a chord extension each period
+	// needs for its changes to be more than chance
+	minInsightAnswers = 20
+	// accuracyDropThreshold is how many percentage points the accuracy of
+	// a chord extension has to drop by to be flagged
+	accuracyDropThreshold = 0.15
+	// slowdownThreshold is how much slower, as a part of the answer duration
+	// before, a chord extension has to get to be flagged
+	slowdownThreshold = 0.25
+)
+
+// The kinds of insights
+const (
+	insightAccuracyDrop = "accuracy_drop"
+	insightSlowdown     = "slowdown"
+)
+
+// extensionNames name the chord extensions in insights, others are named as
+// they are
+var extensionNames = map[string]string{
+	"maj":  "major",
+	"m":    "minor",
+	"7":    "dominant 7th",
+	"maj7": "major 7th",
+	"m7":   "minor 7th",
+	"dim":  "diminished",
+	"aug":  "augmented",
+}
+
+// InsightReport is the latest analysis of the practice of a tenant
+type InsightReport struct {
+	Tenant     string     `json:"-" bson:"_id"`
+	AnalyzedAt *time.Time `json:"analyzed_at,omitempty" bson:"analyzed_at"`
+	Insights   []Insight  `json:"insights" bson:"insights"`
+}
+
+// Insight is an unusual change in the practice of a chord extension over the
+// last week, compared to the week before
+type Insight struct {
+	Kind           string `json:"kind" bson:"kind"`
+	ChordExtension string `json:"chord_extension" bson:"chord_extension"`
+	// Before and After are the accuracy, from 0 to 1, or the average answer
+	// duration in seconds, depending on the kind
+	Before  float64 `json:"before" bson:"before"`
+	After   float64 `json:"after" bson:"after"`
+	Message string  `json:"message" bson:"message"`
+}
+
+// periodOutcome sums up the answers of a chord extension in a period
+type periodOutcome struct {
+	Answers       int
+	Correct       int
+	DurationMilli int
+}
+
+func (o periodOutcome) accuracy() float64 {
+	return float64(o.Correct) / float64(o.Answers)
+}
+
+func (o periodOutcome) avgDuration() float64 {
+	return float64(o.DurationMilli) / float64(o.Answers) / 1000
+}
+
+func insightsJob() *job {
+	return &job{
+		name:     "insights",
+		schedule: "30 4 * * *",
+		timeout:  time.Hour,
+		run: func(ctx context.Context) error {
+			return analyzePractice(ctx, time.Now())
+		},
+	}
+}
+
+// analyzePractice replaces the insights of every tenant that practiced in
+// the two weeks up to now
+func analyzePractice(ctx context.Context, now time.Time) error {
+	activity, err := repository.TenantActivity(ctx, now.Add(-2*insightWeek))
+	if err != nil {
+		return err
+	}
+	for _, tenant := range activity {
+		insights, err := practiceInsights(withTenant(ctx, tenant.Tenant), now)
+		if err != nil {
+			return err
+		}
+		err = repository.SaveInsights(ctx, InsightReport{Tenant: tenant.Tenant, AnalyzedAt: &now, Insights: insights})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// practiceInsights compares the answers of the tenant in ctx of the week up
+// to now to the week before, by chord extension
+func practiceInsights(ctx context.Context, now time.Time) ([]Insight, error) {
+	weekAgo := now.Add(-insightWeek)
+	before := make(map[string]periodOutcome)
+	after := make(map[string]periodOutcome)
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = repository.EachStats(ctx, StatsFilter{Since: weekAgo.Add(-insightWeek), Until: now}, func(stats StatsRaw) error {
+		stats = aliases.stats(stats)
+		outcomes := before
+		if !stats.CreatedAt.Before(weekAgo) {
+			outcomes = after
+		}
+		outcome := outcomes[stats.ChordExtension]
+		outcome.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			outcome.Correct++
+		}
+		outcome.DurationMilli += stats.AnswerDurationMilliSeconds
+		outcomes[stats.ChordExtension] = outcome
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	insights := []Insight{}
+	for extension, previous := range before {
+		current := after[extension]
+		if previous.Answers < minInsightAnswers || current.Answers < minInsightAnswers {
+			continue
+		}
+		name := extensionName(extension)
+		if drop := previous.accuracy() - current.accuracy(); drop >= accuracyDropThreshold {
+			insights = append(insights, Insight{
+				Kind:           insightAccuracyDrop,
+				ChordExtension: extension,
+				Before:         previous.accuracy(),
+				After:          current.accuracy(),
+				Message:        fmt.Sprintf("Your accuracy on %s chords dropped from %.0f%% to %.0f%% this week", name, previous.accuracy()*100, current.accuracy()*100),
+			})
+		}
+		if slowdown := current.avgDuration()/previous.avgDuration() - 1; previous.DurationMilli > 0 && slowdown >= slowdownThreshold {
+			insights = append(insights, Insight{
+				Kind:           insightSlowdown,
+				ChordExtension: extension,
+				Before:         previous.avgDuration(),
+				After:          current.avgDuration(),
+				Message:        fmt.Sprintf("Your %s chords got %.0f%% slower this week", name, math.Round(slowdown*100)),
+			})
+		}
+	}
+	sort.Slice(insights, func(i, j int) bool {
+		if insights[i].ChordExtension != insights[j].ChordExtension {
+			return insights[i].ChordExtension < insights[j].ChordExtension
+		}
+		return insights[i].Kind < insights[j].Kind
+	})
+	return insights, nil
+}
+
+// extensionName names a chord extension for people
+func extensionName(extension string) string {
+	if name, exists := extensionNames[extension]; exists {
+		return name
+	}
+	return extension
+}
+
+// getInsightsHandler lists the insights of the latest analysis of the
+// caller's practice, none before the first analysis
+func getInsightsHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := repository.Insights(r.Context())
+	if err == errNotFound {
+		report = InsightReport{Insights: []Insight{}}
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, report)
+}
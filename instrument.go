@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const instrumentContextKey contextKey = "instrument"
+
+// The instruments chords are practiced on
+const (
+	instrumentPiano   = "piano"
+	instrumentGuitar  = "guitar"
+	instrumentUkulele = "ukulele"
+)
+
+var instruments = []string{instrumentPiano, instrumentGuitar, instrumentUkulele}
+
+// defaultInstrument is the instrument of answers recorded without one,
+// including everything stored before instruments existed
+const defaultInstrument = instrumentPiano
+
+func validInstrument(instrument string) bool {
+	for _, known := range instruments {
+		if instrument == known {
+			return true
+		}
+	}
+	return false
+}
+
+// withInstrument limits the aggregations in ctx to the answers on instrument
+func withInstrument(ctx context.Context, instrument string) context.Context {
+	return context.WithValue(ctx, instrumentContextKey, instrument)
+}
+
+// instrumentFromContext returns the instrument the aggregations in ctx are
+// limited to, empty for all of them
+func instrumentFromContext(ctx context.Context) string {
+	instrument, _ := ctx.Value(instrumentContextKey).(string)
+	return instrument
+}
+
+// instrumentQuery limits query to the answers on instrument, unless it is
+// empty
+func instrumentQuery(field, instrument string, query bson.M) bson.M {
+	switch instrument {
+	case "":
+	case defaultInstrument:
+		// a null or empty string matches the answers recorded without an
+		// instrument too, upserts store the latter
+		query[field] = bson.M{"$in": bson.A{instrument, nil, ""}}
+	default:
+		query[field] = instrument
+	}
+	return query
+}
+
+// instrumentMatches tells if an answer on instrument is one of the answers
+// on the instrument wanted, all of them if it is empty
+func instrumentMatches(wanted, instrument string) bool {
+	if instrument == "" {
+		instrument = defaultInstrument
+	}
+	return wanted == "" || wanted == instrument
+}
+
+// ScopeInstrument limits the aggregations of a request to the instrument in
+// its instrument query param, if it has one
+func ScopeInstrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instrument := r.URL.Query().Get("instrument")
+		if instrument == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validInstrument(instrument) {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "instrument", Message: "has to be one of " + strings.Join(instruments, ", ")})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withInstrument(r.Context(), instrument)))
+	})
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The chat apps milestones can be posted to
+const (
+	integrationSlack   = "slack"
+	integrationDiscord = "discord"
+)
+
+// maxIntegrations is how many integrations a user can have
+const maxIntegrations = 10
+
+// streakMilestones are the streak lengths in days that are posted
+var streakMilestones = []int{3, 7, 14, 30}
+
+// summaryHour is when on Mondays the weekly summaries are posted at the
+// earliest, in the user's timezone
+const summaryHour = 9
+
+var integrationClient = &http.Client{Timeout: 10 * time.Second}
+
+// Integration posts milestone cards of a user to a Slack or Discord
+// channel, either through an incoming webhook or as a bot
+type Integration struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id"`
+	Kind string             `json:"kind" bson:"kind"`
+	// WebhookURL and BotToken are secrets, responses leave them out
+	WebhookURL string `json:"webhook_url,omitempty" bson:"webhook_url,omitempty"`
+	BotToken   string `json:"bot_token,omitempty" bson:"bot_token,omitempty"`
+	// Channel is posted to by the bot, required with BotToken
+	Channel string `json:"channel,omitempty" bson:"channel,omitempty"`
+	// Events default to all of them when not sent
+	Events        *IntegrationEvents `json:"events,omitempty" bson:"events"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	SummarySentAt *time.Time         `json:"summary_sent_at,omitempty" bson:"summary_sent_at,omitempty"`
+	// StreakAnnounced is the key of the latest streak milestone posted, so
+	// each is posted once
+	StreakAnnounced string `json:"-" bson:"streak_announced,omitempty"`
+	Tenant          string `json:"-" bson:"tenant"`
+	UserID          string `json:"-" bson:"user"`
+}
+
+// IntegrationEvents are which milestones an integration posts
+type IntegrationEvents struct {
+	Streaks       bool `json:"streaks" bson:"streaks"`
+	WeeklySummary bool `json:"weekly_summary" bson:"weekly_summary"`
+}
+
+var (
+	slackChannelPattern   = regexp.MustCompile(`^[A-Za-z0-9#_-]{1,80}$`)
+	discordChannelPattern = regexp.MustCompile(`^[0-9]{1,20}$`)
+)
+
+// validate returns what is wrong with a new integration, nothing if it is
+// valid
+func (i Integration) validate() []FieldError {
+	var fieldErrors []FieldError
+	if i.Kind != integrationSlack && i.Kind != integrationDiscord {
+		return []FieldError{{Field: "kind", Message: "has to be slack or discord"}}
+	}
+	if (i.WebhookURL == "") == (i.BotToken == "") {
+		return []FieldError{{Field: "webhook_url", Message: "has to be given, unless a bot_token is"}}
+	}
+
+	if i.WebhookURL != "" {
+		webhook, err := url.Parse(i.WebhookURL)
+		valid := err == nil && webhook.Scheme == "https" && len(i.WebhookURL) <= 2048
+		if valid && i.Kind == integrationSlack {
+			valid = webhook.Host == "hooks.slack.com" && strings.HasPrefix(webhook.Path, "/services/")
+		}
+		if valid && i.Kind == integrationDiscord {
+			valid = (webhook.Host == "discord.com" || webhook.Host == "discordapp.com") && strings.HasPrefix(webhook.Path, "/api/webhooks/")
+		}
+		if !valid {
+			fieldErrors = append(fieldErrors, FieldError{Field: "webhook_url", Message: "has to be an incoming webhook URL of " + i.Kind})
+		}
+		if i.Channel != "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: "channel", Message: "is set by the webhook"})
+		}
+		return fieldErrors
+	}
+
+	if len(i.BotToken) > 256 || (i.Kind == integrationSlack && !strings.HasPrefix(i.BotToken, "xoxb-")) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "bot_token", Message: "has to be a bot token of " + i.Kind})
+	}
+	if i.Kind == integrationSlack && !slackChannelPattern.MatchString(i.Channel) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "channel", Message: "has to be a channel ID or name"})
+	}
+	if i.Kind == integrationDiscord && !discordChannelPattern.MatchString(i.Channel) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "channel", Message: "has to be a channel ID"})
+	}
+	return fieldErrors
+}
+
+// redacted leaves out the secrets, for responses
+func (i Integration) redacted() Integration {
+	i.WebhookURL, i.BotToken = "", ""
+	return i
+}
+
+// Card is a milestone as shown in a chat message
+type Card struct {
+	Title  string
+	Text   string
+	Fields []CardField
+	// Color is the accent of the card as RGB
+	Color int
+}
+
+type CardField struct {
+	Name  string
+	Value string
+}
+
+// streakCard congratulates on a streak of days
+func streakCard(days int) Card {
+	return Card{
+		Title: fmt.Sprintf("🔥 %d-day streak!", days),
+		Text:  fmt.Sprintf("Practiced chords %d days in a row. Keep it going tomorrow!", days),
+		Color: 0xf97316,
+	}
+}
+
+// summaryCard sums up the week before the last day of counts, which are the
+// answers per day ending today
+func summaryCard(counts []StatsCountByDay, kept map[string]bool) Card {
+	week := counts[len(counts)-8 : len(counts)-1]
+	answers, practiced, best := 0, 0, week[0]
+	for _, day := range week {
+		answers += day.Count
+		if day.Count > 0 {
+			practiced++
+		}
+		if day.Count > best.Count {
+			best = day
+		}
+	}
+
+	card := Card{
+		Title: "📊 Your week at the piano",
+		Text:  fmt.Sprintf("Week of %s to %s", week[0].Day, week[len(week)-1].Day),
+		Fields: []CardField{
+			{Name: "Answers", Value: fmt.Sprint(answers)},
+			{Name: "Days practiced", Value: fmt.Sprintf("%d of 7", practiced)},
+			{Name: "Streak", Value: fmt.Sprintf("%d days", currentStreak(counts[:len(counts)-1], kept))},
+		},
+		Color: 0x6366f1,
+	}
+	if best.Count > 0 {
+		card.Fields = append(card.Fields, CardField{Name: "Best day", Value: fmt.Sprintf("%s, %d answers", best.Day, best.Count)})
+	}
+	return card
+}
+
+// currentStreak returns the number of days in a row up to the last of
+// counts with answers, skipping over the days kept by a pause or a freeze
+func currentStreak(counts []StatsCountByDay, kept map[string]bool) int {
+	streak := 0
+	for i := len(counts) - 1; i >= 0 && (counts[i].Count > 0 || kept[counts[i].Day]); i-- {
+		if counts[i].Count > 0 {
+			streak++
+		}
+	}
+	return streak
+}
+
+// slackMessage formats card with Slack's Block Kit, text being the fallback
+// of notifications
+func slackMessage(card Card) map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": card.Title, "emoji": true}},
+		{"type": "section", "text": map[string]interface{}{"type": "mrkdwn", "text": card.Text}},
+	}
+	if len(card.Fields) > 0 {
+		fields := make([]map[string]interface{}, 0, len(card.Fields))
+		for _, field := range card.Fields {
+			fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": "*" + field.Name + "*\n" + field.Value})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	return map[string]interface{}{"text": card.Title, "blocks": blocks}
+}
+
+// discordMessage formats card as a Discord embed
+func discordMessage(card Card) map[string]interface{} {
+	fields := make([]map[string]interface{}, 0, len(card.Fields))
+	for _, field := range card.Fields {
+		fields = append(fields, map[string]interface{}{"name": field.Name, "value": field.Value, "inline": true})
+	}
+	embed := map[string]interface{}{"title": card.Title, "description": card.Text, "color": card.Color, "fields": fields}
+	return map[string]interface{}{"embeds": []interface{}{embed}}
+}
+
+// postCard posts card to the channel of integration
+func postCard(ctx context.Context, integration Integration, card Card) error {
+	var message map[string]interface{}
+	target, authorization := integration.WebhookURL, ""
+	switch integration.Kind {
+	case integrationSlack:
+		message = slackMessage(card)
+		if integration.BotToken != "" {
+			message["channel"] = integration.Channel
+			target, authorization = "https://slack.com/api/chat.postMessage", "Bearer "+integration.BotToken
+		}
+	case integrationDiscord:
+		message = discordMessage(card)
+		if integration.BotToken != "" {
+			target = "https://discord.com/api/v10/channels/" + integration.Channel + "/messages"
+			authorization = "Bot " + integration.BotToken
+		}
+	default:
+		return fmt.Errorf("unknown integration kind %q", integration.Kind)
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	res, err := integrationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	response, err := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s: %s", integration.Kind, res.Status, response)
+	}
+	// the Slack API answers 200 to failed calls too
+	if integration.Kind == integrationSlack && integration.BotToken != "" {
+		var result struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(response, &result) != nil || !result.OK {
+			return fmt.Errorf("slack failed to post: %s", result.Error)
+		}
+	}
+	return nil
+}
+
+// postCards posts card to every integration wanting it, logging failures
+// rather than returning them since one broken webhook shouldn't keep the
+// others from being posted to
+func postCards(ctx context.Context, integrations []Integration, card Card) {
+	for _, integration := range integrations {
+		err := postCard(ctx, integration, card)
+		integrationPostsTotal.WithLabelValues(integration.Kind, outcomeLabel(err)).Inc()
+		if err != nil {
+			log.Printf("Failed to post to integration %s! Error: %s\n", integration.ID.Hex(), err)
+		}
+	}
+}
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// announceStreak posts the streak of the user in ctx to the user's
+// integrations, if answers of today make the streak a milestone. It is
+// called whenever stats are saved, so it is cheap for users without
+// integrations. An integration is marked as posted to before posting, so a
+// milestone is posted once however many answers reach it together.
+func announceStreak(ctx context.Context) {
+	userID := userFromContext(ctx)
+	integrations, err := repository.Integrations(ctx, userID)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+	wanted := integrations[:0]
+	for _, integration := range integrations {
+		if integration.Events.Streaks {
+			wanted = append(wanted, integration)
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	loc, err := userLocation(ctx, userID, "")
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+	counts, err := countByDayLastMonth(ctx, loc)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+	today := counts[len(counts)-1]
+	if today.Count == 0 {
+		return
+	}
+	kept, err := keptDays(ctx)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+	streak := currentStreak(counts, kept)
+	reached := false
+	for _, milestone := range streakMilestones {
+		reached = reached || streak == milestone
+	}
+	if !reached {
+		return
+	}
+
+	key := fmt.Sprintf("streak-%d-%s", streak, today.Day)
+	for _, integration := range wanted {
+		marked, err := repository.MarkStreakAnnounced(ctx, integration.ID, key)
+		if err != nil {
+			log.Println("Error:", err)
+			continue
+		}
+		if marked {
+			postCards(ctx, []Integration{integration}, streakCard(streak))
+		}
+	}
+}
+
+// weeklySummariesJob posts the summary of the past week to the
+// integrations that want one, checking every hour for users whose Monday
+// morning it is. Each integration is tried once a week, failed posts aren't
+// retried.
+func weeklySummariesJob() *job {
+	return &job{
+		name:     "weekly_summaries",
+		schedule: "@every 1h",
+		timeout:  time.Hour,
+		run: func(ctx context.Context) error {
+			return postWeeklySummaries(ctx, time.Now())
+		},
+	}
+}
+
+// postWeeklySummaries posts the summaries due at now. An integration is
+// marked as posted to before posting, so a summary is never posted twice.
+func postWeeklySummaries(ctx context.Context, now time.Time) error {
+	integrations, err := repository.AllIntegrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, integration := range integrations {
+		if !integration.Events.WeeklySummary || (integration.SummarySentAt != nil && now.Sub(*integration.SummarySentAt) < 6*24*time.Hour) {
+			continue
+		}
+		userCtx := withTenant(withUser(ctx, integration.UserID), integration.Tenant)
+		loc, err := userLocation(userCtx, integration.UserID, "")
+		if err != nil {
+			return err
+		}
+		if local := now.In(loc); local.Weekday() != time.Monday || local.Hour() < summaryHour {
+			continue
+		}
+		counts, err := countByDayLastMonth(userCtx, loc)
+		if err != nil {
+			return err
+		}
+		kept, err := keptDays(userCtx)
+		if err != nil {
+			return err
+		}
+		err = repository.MarkSummarySent(ctx, integration.ID, now)
+		if err != nil {
+			return err
+		}
+		postCards(userCtx, []Integration{integration}, summaryCard(counts, kept))
+	}
+	return nil
+}
+
+func getIntegrationsHandler(w http.ResponseWriter, r *http.Request) {
+	integrations, err := repository.Integrations(r.Context(), userFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	for i := range integrations {
+		integrations[i] = integrations[i].redacted()
+	}
+	writeResponse(w, r, integrations)
+}
+
+func addIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	var integration Integration
+	err := decodeRequest(r, &integration)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := integration.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	userID := userFromContext(r.Context())
+	existing, err := repository.Integrations(r.Context(), userID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(existing) >= maxIntegrations {
+		writeProblem(w, problemInvalidRequest, fmt.Sprintf("There can be at most %d integrations, delete one first", maxIntegrations))
+		return
+	}
+
+	integration.ID = primitive.NewObjectID()
+	integration.CreatedAt = time.Now()
+	integration.SummarySentAt = nil
+	integration.Tenant, integration.UserID = tenantFromContext(r.Context()), userID
+	if integration.Events == nil {
+		integration.Events = &IntegrationEvents{Streaks: true, WeeklySummary: true}
+	}
+	err = repository.SaveIntegration(r.Context(), integration)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponseStatus(w, r, http.StatusCreated, integration.redacted())
+}
+
+func deleteIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	err = repository.DeleteIntegration(r.Context(), userFromContext(r.Context()), id)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testIntegrationHandler posts a sample card, so the user sees right away
+// if the integration works
+func testIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	integrations, err := repository.Integrations(r.Context(), userFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	for _, integration := range integrations {
+		if integration.ID != id {
+			continue
+		}
+		card := Card{
+			Title: "🎹 Piano Chord Training is connected",
+			Text:  "Streaks and weekly summaries will be posted here.",
+			Color: 0x22c55e,
+		}
+		err = postCard(r.Context(), integration, card)
+		integrationPostsTotal.WithLabelValues(integration.Kind, outcomeLabel(err)).Inc()
+		if err != nil {
+			writeProblem(w, problemIntegrationFailed, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeProblem(w, problemNotFound, "")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAnnounceStreakOnce has the answers reaching a streak milestone saved
+// at once, the milestone must be posted once
+func TestAnnounceStreakOnce(t *testing.T) {
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	stats := []StatsRaw{
+		{ChordName: "C", RootNote: "C", CreatedAt: today.AddDate(0, 0, -2).Add(12 * time.Hour)},
+		{ChordName: "C", RootNote: "C", CreatedAt: today.AddDate(0, 0, -1).Add(12 * time.Hour)},
+		{ChordName: "C", RootNote: "C", CreatedAt: now},
+		{ChordName: "D", RootNote: "D", CreatedAt: now},
+	}
+	defer func(saved Repository) { repository = saved }(repository)
+	repository = newMemoryRepository(stats)
+	ctx := withTenant(withUser(context.Background(), "u"), defaultTenant)
+	err := repository.SaveIntegration(ctx, Integration{
+		ID:         primitive.NewObjectID(),
+		Kind:       integrationSlack,
+		WebhookURL: server.URL,
+		Events:     &IntegrationEvents{Streaks: true},
+		Tenant:     defaultTenant,
+		UserID:     "u",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			announceStreak(ctx)
+		}()
+	}
+	wg.Wait()
+	if posts := atomic.LoadInt32(&posts); posts != 1 {
+		t.Errorf("posted the 3-day streak %d times, want once", posts)
+	}
+}
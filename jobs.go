@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxJobRunsLimit bounds the runs listed at once
+	maxJobRunsLimit = 100
+	// jobRunRetention is how long the runs of jobs are kept
+	jobRunRetention = 90 * 24 * time.Hour
+	// jobCheckInterval is how often the leading replica checks which jobs
+	// are due, the precision of the schedules
+	jobCheckInterval = 15 * time.Second
+)
+
+// errJobRunning means another run of the job holds its lock
+var errJobRunning = errors.New("job is running already")
+
+// jobs are the background jobs by name, registered in main before the
+// scheduler starts
+var jobs = make(map[string]*job)
+
+// job is a background job, like archiving or backups. The leading replica
+// runs it on its schedule, and a run holds the lock of the job in the
+// repository, so neither a change of leader nor an admin triggering it runs
+// it twice at once.
+type job struct {
+	name string
+	// schedule is a cron spec in UTC, like "0 3 * * *" or "@every 1h"
+	schedule string
+	// timeout bounds a run, and is how long the lock is held when the
+	// replica running it dies
+	timeout time.Duration
+	// duringMaintenance runs the job in maintenance mode too, for jobs that
+	// don't write to the database
+	duringMaintenance bool
+	run               func(ctx context.Context) error
+}
+
+// JobRun is a run of a job, saved when it starts and again when it finished
+type JobRun struct {
+	ID  primitive.ObjectID `json:"id" bson:"_id"`
+	Job string             `json:"job" bson:"job"`
+	// Trigger is schedule or admin
+	Trigger string `json:"trigger" bson:"trigger"`
+	// Status is running, succeeded or failed, or abandoned when the replica
+	// running it stopped before it finished
+	Status     string     `json:"status" bson:"status"`
+	StartedAt  time.Time  `json:"started_at" bson:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	// Error is empty if the run worked or is still running
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// the statuses of job runs
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobAbandoned = "abandoned"
+)
+
+// JobStatus is a job with its latest run, if it ever ran
+type JobStatus struct {
+	Name     string  `json:"name"`
+	Schedule string  `json:"schedule"`
+	LastRun  *JobRun `json:"last_run,omitempty"`
+}
+
+func registerJob(j *job) {
+	jobs[j.name] = j
+}
+
+// scheduleJobs runs the registered jobs on their schedules. A job is due
+// when its schedule came up since its latest run started, so the schedules
+// carry on across restarts, and a job that never ran is due right away.
+func scheduleJobs() error {
+	schedules := make(map[*job]cron.Schedule)
+	for _, j := range jobs {
+		schedule, err := cron.ParseStandard(j.schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", j.name, err)
+		}
+		schedules[j] = schedule
+	}
+
+	go func() {
+		for {
+			for j, schedule := range schedules {
+				runIfDue(j, schedule)
+			}
+			time.Sleep(jobCheckInterval)
+		}
+	}()
+	return nil
+}
+
+// runIfDue runs j if this replica leads and it is due. Runs missed while no
+// replica was running are made up for with a single one.
+func runIfDue(j *job, schedule cron.Schedule) {
+	ctx := withTenant(context.Background(), allTenants)
+	if !leadership.IsLeader() {
+		return
+	}
+	if _, enabled := inMaintenance(ctx); enabled && !j.duringMaintenance {
+		return
+	}
+	runs, err := repository.JobRuns(ctx, j.name, 1)
+	if err != nil {
+		log.Printf("Failed to check if job %s is due! Error: %s\n", j.name, err)
+		return
+	}
+	if len(runs) > 0 && time.Now().Before(schedule.Next(runs[0].StartedAt.UTC())) {
+		return
+	}
+
+	run, err := startJob(ctx, j, "schedule")
+	if err == errJobRunning {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start job %s! Error: %s\n", j.name, err)
+		reportError(ctx, err)
+		return
+	}
+	finishJob(j, run)
+}
+
+// startJob takes the lock of j and saves the run, failing with
+// errJobRunning if another run holds the lock. The lock is held by the run,
+// so it is released by finishJob.
+func startJob(ctx context.Context, j *job, trigger string) (JobRun, error) {
+	run := JobRun{ID: primitive.NewObjectID(), Job: j.name, Trigger: trigger, Status: jobRunning, StartedAt: time.Now()}
+	locked, err := repository.LockJob(ctx, j.name, run.ID.Hex(), run.StartedAt.Add(j.timeout))
+	if err != nil {
+		return JobRun{}, err
+	}
+	if !locked {
+		return JobRun{}, errJobRunning
+	}
+	err = repository.SaveJobRun(ctx, run)
+	if err != nil {
+		repository.UnlockJob(ctx, j.name, run.ID.Hex())
+		return JobRun{}, err
+	}
+	return run, nil
+}
+
+// finishJob runs j, records how it went and releases the lock
+func finishJob(j *job, run JobRun) {
+	ctx, cancel := context.WithTimeout(withTenant(context.Background(), allTenants), j.timeout)
+	defer cancel()
+
+	err := j.run(ctx)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	jobDurationSeconds.WithLabelValues(j.name).Observe(finished.Sub(run.StartedAt).Seconds())
+	if err != nil {
+		run.Status = jobFailed
+		run.Error = err.Error()
+		jobRunsTotal.WithLabelValues(j.name, jobFailed).Inc()
+		log.Printf("Job %s failed! Error: %s\n", j.name, err)
+		reportError(ctx, err)
+	} else {
+		run.Status = jobSucceeded
+		jobRunsTotal.WithLabelValues(j.name, jobSucceeded).Inc()
+		jobLastSuccessTimestamp.WithLabelValues(j.name).Set(float64(finished.Unix()))
+	}
+
+	// the run's context may be done, recording it shouldn't fail for that
+	ctx = withTenant(context.Background(), allTenants)
+	err = repository.SaveJobRun(ctx, run)
+	if err != nil {
+		log.Printf("Failed to save run of job %s! Error: %s\n", j.name, err)
+	}
+	err = repository.UnlockJob(ctx, j.name, run.ID.Hex())
+	if err != nil {
+		log.Printf("Failed to unlock job %s, it runs again once the lock expired! Error: %s\n", j.name, err)
+	}
+}
+
+// abandoned marks run as abandoned if it is still running past the timeout
+// of j, since the replica running it stopped without finishing it
+func (j *job) abandoned(run JobRun) JobRun {
+	if run.Status == jobRunning && time.Since(run.StartedAt) > j.timeout {
+		run.Status = jobAbandoned
+	}
+	return run
+}
+
+// getJobsHandler lists the jobs sorted by name, with their latest runs
+func getJobsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []JobStatus{}
+	for _, j := range jobs {
+		status := JobStatus{Name: j.name, Schedule: j.schedule}
+		runs, err := repository.JobRuns(r.Context(), j.name, 1)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if len(runs) > 0 {
+			last := j.abandoned(runs[0])
+			status.LastRun = &last
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, k int) bool {
+		return statuses[i].Name < statuses[k].Name
+	})
+
+	writeResponse(w, r, statuses)
+}
+
+// getJobRunsHandler lists the runs of the job in the path, latest first
+func getJobRunsHandler(w http.ResponseWriter, r *http.Request) {
+	j := jobs[chi.URLParam(r, "name")]
+	if j == nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	limit := 20
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "limit", Message: "has to be a positive whole number"})
+			return
+		}
+		if limit > maxJobRunsLimit {
+			limit = maxJobRunsLimit
+		}
+	}
+
+	runs, err := repository.JobRuns(r.Context(), j.name, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for i := range runs {
+		runs[i] = j.abandoned(runs[i])
+	}
+
+	writeResponse(w, r, runs)
+}
+
+// triggerJobHandler runs the job in the path right away, on this replica
+// whether or not it leads, e.g. to redo a rollup after fixing the data. It
+// answers once the run started, the run can be followed through the runs
+// of the job.
+func triggerJobHandler(w http.ResponseWriter, r *http.Request) {
+	j := jobs[chi.URLParam(r, "name")]
+	if j == nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if state, enabled := inMaintenance(r.Context()); enabled && !j.duringMaintenance {
+		w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+		writeProblem(w, problemMaintenance, "The job writes to the database, it doesn't run in maintenance mode")
+		return
+	}
+
+	run, err := startJob(r.Context(), j, "admin")
+	if err == errJobRunning {
+		writeProblem(w, problemJobRunning, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	go finishJob(j, run)
+
+	writeResponseStatus(w, r, http.StatusAccepted, run)
+}
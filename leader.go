@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisLeaderKey = "leader:jobs"
+	// leaderLease is how long a replica leads without renewing, so the
+	// background jobs move to another replica this long after the leader
+	// died at the latest
+	leaderLease = 30 * time.Second
+)
+
+// leadership decides which replica runs the background jobs, like archiving
+// and backups. On its own a replica always leads, with several of them the
+// leader is elected through Redis.
+var leadership leaderElector = localLeader{}
+
+type leaderElector interface {
+	IsLeader() bool
+}
+
+// localLeader leads without asking anyone, for a single replica
+type localLeader struct{}
+
+func (localLeader) IsLeader() bool {
+	return true
+}
+
+// campaignScript takes the lease if nobody holds it, or extends it if the
+// replica already does, and returns 1 if the replica holds it then
+var campaignScript = redis.NewScript(`
+local holder = redis.call("get", KEYS[1])
+if holder == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+if not holder then
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// redisLeader holds a lease in Redis while it leads. It stops leading a
+// third of the lease before it runs out when it can't renew, so two replicas
+// never lead at once as long as their clocks run at the same pace.
+type redisLeader struct {
+	client *redis.Client
+	id     string
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+func newRedisLeader(client *redis.Client) *redisLeader {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &redisLeader{client: client, id: hex.EncodeToString(id)}
+}
+
+func (l *redisLeader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return time.Now().Before(l.until)
+}
+
+// Run takes or renews the lease every third of it, until the server stops
+func (l *redisLeader) Run() {
+	for {
+		l.campaign(context.Background())
+		time.Sleep(leaderLease / 3)
+	}
+}
+
+func (l *redisLeader) campaign(ctx context.Context) {
+	started := time.Now()
+	leading := l.IsLeader()
+	held, err := campaignScript.Run(ctx, l.client, []string{redisLeaderKey}, l.id, leaderLease.Milliseconds()).Int64()
+	if err != nil {
+		// the lease runs out on its own if Redis stays unavailable
+		log.Println("Failed to campaign for leader! Error:", err)
+		return
+	}
+
+	l.mu.Lock()
+	if held == 1 {
+		l.until = started.Add(leaderLease - leaderLease/3)
+	} else {
+		l.until = time.Time{}
+	}
+	l.mu.Unlock()
+
+	if held == 1 && !leading {
+		log.Println("Leading the background jobs")
+	} else if held != 1 && leading {
+		log.Println("Stopped leading the background jobs")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// serverLimits keep a single misbehaving client from holding on to memory or
+// crowding out the others
+type serverLimits struct {
+	MaxBodySize             int64 `long:"max-body-size" env:"MAX_BODY_SIZE" default:"1048576" description:"Max bytes of a request body"`
+	MaxImportSize           int64 `long:"max-import-size" env:"MAX_IMPORT_SIZE" default:"33554432" description:"Max bytes of an import request body"`
+	MaxInFlightWrites       int   `long:"max-in-flight-writes" env:"MAX_IN_FLIGHT_WRITES" default:"100" description:"Max answers and imports being saved at once, more are answered with 503, 0 meaning unlimited"`
+	MaxInFlightAggregations int   `long:"max-in-flight-aggregations" env:"MAX_IN_FLIGHT_AGGREGATIONS" default:"10" description:"Max uncached aggregations and GraphQL queries served at once, more are answered with 503, 0 meaning unlimited"`
+}
+
+// LimitRequestBody answers requests announcing a body bigger than allowed
+// with 413, and cuts off the bodies of the rest at the limit, so that
+// decoding them fails like for any other invalid body. Imports get a limit
+// of their own, since they hold up to 100000 answers.
+func LimitRequestBody(limits serverLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := limits.MaxBodySize
+			if r.URL.Path == "/import" {
+				limit = limits.MaxImportSize
+			}
+			if r.ContentLength > limit {
+				w.Header().Set("Connection", "close")
+				writeProblem(w, problemTooLarge, fmt.Sprintf("The body may be at most %d bytes", limit))
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errBodyTooLarge is what reading a body cut off by LimitRequestBody fails
+// with, the error of http.MaxBytesReader can't be told apart before Go 1.19
+type errBodyTooLarge struct {
+	limit int64
+}
+
+func (e errBodyTooLarge) Error() string {
+	return fmt.Sprintf("the body is over the limit of %d bytes", e.limit)
+}
+
+// limitedBody is a body read through http.MaxBytesReader, telling its
+// failures at the limit apart from the rest
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		return n, errBodyTooLarge{limit: b.limit}
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor systemd passes sockets on
+const systemdFirstFD = 3
+
+const unixSocketContextKey contextKey = "unix_socket"
+
+// listen returns the listener of the API. A socket passed by systemd socket
+// activation wins over address, which is a TCP address like :8080 or a unix
+// socket like unix:/run/pct.sock.
+func listen(address string) (net.Listener, error) {
+	listener, err := systemdListener()
+	if listener != nil || err != nil {
+		return listener, err
+	}
+	if address == "" {
+		return nil, errors.New("a port or listen address is required, unless started by systemd socket activation")
+	}
+
+	path := strings.TrimPrefix(address, "unix:")
+	if path == address {
+		return net.Listen("tcp", address)
+	}
+	// the socket of a previous run is left behind when it didn't stop
+	// cleanly, and is in the way
+	if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// the proxy in front connects as another user, sharing the group
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// systemdListener returns the socket systemd passed if it started the
+// server through socket activation, nil if it didn't. Only the first socket
+// is served, the API has a single one.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// the variables are meant for this process only, not for any it starts
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds > 1 {
+		log.Printf("Got %d sockets from systemd, serving only the first!\n", fds)
+	}
+
+	file := os.NewFile(systemdFirstFD, "systemd socket")
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("socket from systemd: %w", err)
+	}
+	file.Close()
+	return listener, nil
+}
+
+// markUnixSocket tells the requests of connections over a unix socket
+// apart, they come from a proxy on the same host
+func markUnixSocket(ctx context.Context, conn net.Conn) context.Context {
+	if _, isUnix := conn.(*net.UnixConn); isUnix {
+		return context.WithValue(ctx, unixSocketContextKey, true)
+	}
+	return ctx
+}
+
+func fromUnixSocket(r *http.Request) bool {
+	fromUnix, _ := r.Context().Value(unixSocketContextKey).(bool)
+	return fromUnix
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// magicLinkTTL is how long a magic link can be used to sign in
+	magicLinkTTL = 15 * time.Minute
+	// magicLinkInterval is how often a link is emailed to the same address
+	// at most, so the endpoints can't be used to flood someone's inbox
+	magicLinkInterval = time.Minute
+	// emailIdentityProvider is the provider of identities signed in with a
+	// magic link, the subject being the lowercased address
+	emailIdentityProvider = "email"
+)
+
+// magicLinkSecret signs the links that are emailed, magic links and email
+// verification links, nil when magic links are disabled
+var magicLinkSecret []byte
+
+// magicLinkURL is the page of the app magic links open, with the token
+// appended as the token query param
+var magicLinkURL string
+
+var errInvalidMagicLink = errors.New("invalid magic link")
+
+// emailsSent remembers when a link was last emailed to an address. When
+// running several replicas, Redis remembers it instead.
+var emailsSent = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// signEmailLink returns a token for fields until expiresAt, the purpose
+// keeping tokens of one kind of link from being used for another. Tokens
+// aren't stored, so one can be used again and again until it expires.
+func signEmailLink(purpose string, fields []string, expiresAt time.Time) string {
+	payload := strings.Join(append(fields, strconv.FormatInt(expiresAt.Unix(), 10)), "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(emailLinkMAC(purpose, payload))
+}
+
+func emailLinkMAC(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, magicLinkSecret)
+	mac.Write([]byte(purpose + "\n" + payload))
+	return mac.Sum(nil)
+}
+
+// verifyEmailLink returns the fields a token of purpose was signed for, if
+// it is valid and not expired
+func verifyEmailLink(purpose, token string, fieldCount int) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errInvalidMagicLink
+	}
+	payload, payloadErr := base64.RawURLEncoding.DecodeString(parts[0])
+	signature, signatureErr := base64.RawURLEncoding.DecodeString(parts[1])
+	if payloadErr != nil || signatureErr != nil || !hmac.Equal(signature, emailLinkMAC(purpose, string(payload))) {
+		return nil, errInvalidMagicLink
+	}
+
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != fieldCount+1 {
+		return nil, errInvalidMagicLink
+	}
+	expiresAt, err := strconv.ParseInt(fields[fieldCount], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiresAt, 0)) {
+		return nil, errInvalidMagicLink
+	}
+	return fields[:fieldCount], nil
+}
+
+// signMagicLink returns a token for signing in as the owner of email until
+// expiresAt
+func signMagicLink(email string, expiresAt time.Time) string {
+	return signEmailLink("magic-link", []string{email}, expiresAt)
+}
+
+// verifyMagicLink returns the email a token was signed for, if it is valid
+// and not expired
+func verifyMagicLink(token string) (string, error) {
+	fields, err := verifyEmailLink("magic-link", token, 1)
+	if err != nil {
+		return "", err
+	}
+	return fields[0], nil
+}
+
+// linkTo appends token to the page as the token query param
+func linkTo(page, token string) string {
+	separator := "?"
+	if strings.Contains(page, "?") {
+		separator = "&"
+	}
+	return page + separator + "token=" + token
+}
+
+// claimEmailSlot tells if a link may be emailed to email now, and if so
+// counts it as sent
+func claimEmailSlot(email string) bool {
+	if redisClient != nil {
+		claimed, err := redisClient.SetNX(context.Background(), "emails:sent:"+email, 1, magicLinkInterval).Result()
+		if err != nil {
+			// rather not send than send a flood of links
+			log.Println("Failed to claim email slot! Error:", err)
+			return false
+		}
+		return claimed
+	}
+
+	emailsSent.Lock()
+	defer emailsSent.Unlock()
+
+	now := time.Now()
+	if now.Sub(emailsSent.at[email]) < magicLinkInterval {
+		return false
+	}
+	if len(emailsSent.at) >= 1000 {
+		for address, at := range emailsSent.at {
+			if now.Sub(at) >= magicLinkInterval {
+				delete(emailsSent.at, address)
+			}
+		}
+	}
+	emailsSent.at[email] = now
+	return true
+}
+
+// sendMagicLinkHandler emails a link to sign in with. It answers 202 whether
+// or not there is a user with the address, so it doesn't tell who uses the
+// app, and also when a link was sent to the address within the last minute.
+func sendMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if magicLinkSecret == nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := decodeRequest(r, &body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	email, err := parseAddress(body.Email)
+	if err != nil || email != body.Email {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "email", Message: "has to be an address like name@example.com"})
+		return
+	}
+	email = strings.ToLower(email)
+
+	if claimEmailSlot(email) {
+		link := linkTo(magicLinkURL, signMagicLink(email, time.Now().Add(magicLinkTTL)))
+		err = emailSender.Send(r.Context(), email, "Sign in to Piano Chord Training", fmt.Sprintf(
+			"Open this link within %d minutes to sign in:\n\n%s\n\nIf you didn't ask to sign in, you can ignore this email.\n",
+			int(magicLinkTTL.Minutes()), link,
+		))
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyMagicLinkHandler trades the token of a magic link for a session
+// token, creating the user on first sign-in
+func verifyMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if magicLinkSecret == nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	failureKeys := authFailureKeys(remoteIP(r), "")
+	if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+		writeBanned(w, bannedFor)
+		return
+	}
+
+	email, err := verifyMagicLink(r.URL.Query().Get("token"))
+	if err != nil {
+		delayFailure(r.Context(), authFailures.fail(failureKeys))
+		writeProblem(w, problemUnauthorized, "The magic link is invalid or expired")
+		return
+	}
+
+	now := time.Now()
+	user, err := repository.ProvisionUser(r.Context(), Identity{Provider: emailIdentityProvider, Subject: email}, User{
+		ID:            primitive.NewObjectID().Hex(),
+		Email:         email,
+		EmailVerified: true,
+		CreatedAt:     now,
+		LastLoginAt:   now,
+	})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeSignIn(w, r, user)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisMaintenanceKey = "maintenance"
+	// defaultMaintenanceRetryAfter is how long clients are told to wait when
+	// maintenance is turned on without saying for how long
+	defaultMaintenanceRetryAfter = 300
+)
+
+// maintenance tells if the API is in maintenance mode. It is either kept in
+// process memory or, when running several replicas, shared through Redis.
+var maintenance maintenanceStore = &memoryMaintenance{}
+
+// Maintenance is the state of maintenance mode. While it is enabled, writes
+// are answered with 503 and reads are served as usual, so the database can
+// be migrated without writes getting in the way.
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+	// RetryAfter is the seconds clients are told to wait before writing
+	// again
+	RetryAfter int    `json:"retry_after,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// Since is when maintenance was enabled
+	Since *time.Time `json:"since,omitempty"`
+}
+
+type maintenanceStore interface {
+	Get(ctx context.Context) (Maintenance, error)
+	Set(ctx context.Context, state Maintenance) error
+}
+
+type memoryMaintenance struct {
+	mu    sync.Mutex
+	state Maintenance
+}
+
+func (m *memoryMaintenance) Get(ctx context.Context) (Maintenance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state, nil
+}
+
+func (m *memoryMaintenance) Set(ctx context.Context, state Maintenance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = state
+	return nil
+}
+
+// redisMaintenance shares maintenance mode between replicas, so turning it
+// on through any of them turns it on for all
+type redisMaintenance struct {
+	client *redis.Client
+}
+
+func (m *redisMaintenance) Get(ctx context.Context) (Maintenance, error) {
+	body, err := m.client.Get(ctx, redisMaintenanceKey).Bytes()
+	if err == redis.Nil {
+		return Maintenance{}, nil
+	}
+	if err != nil {
+		return Maintenance{}, err
+	}
+
+	var state Maintenance
+	err = json.Unmarshal(body, &state)
+	return state, err
+}
+
+func (m *redisMaintenance) Set(ctx context.Context, state Maintenance) error {
+	if !state.Enabled {
+		return m.client.Del(ctx, redisMaintenanceKey).Err()
+	}
+	body, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return m.client.Set(ctx, redisMaintenanceKey, body, 0).Err()
+}
+
+// inMaintenance tells if writes have to wait. When the state can't be read,
+// writes are let through rather than failing all of them.
+func inMaintenance(ctx context.Context) (Maintenance, bool) {
+	state, err := maintenance.Get(ctx)
+	if err != nil {
+		log.Println("Error:", err)
+		return Maintenance{}, false
+	}
+	return state, state.Enabled
+}
+
+// enableMaintenance turns maintenance mode on, keeping when it started if it
+// already was
+func enableMaintenance(ctx context.Context, state Maintenance) (Maintenance, error) {
+	current, err := maintenance.Get(ctx)
+	if err != nil {
+		return Maintenance{}, err
+	}
+
+	state.Enabled = true
+	if state.RetryAfter <= 0 {
+		state.RetryAfter = defaultMaintenanceRetryAfter
+	}
+	state.Since = current.Since
+	if !current.Enabled || state.Since == nil {
+		now := time.Now()
+		state.Since = &now
+	}
+	return state, maintenance.Set(ctx, state)
+}
+
+// RejectWritesInMaintenance answers writes with 503 and a Retry-After while
+// in maintenance mode. GraphQL is let through, since it only has queries.
+func RejectWritesInMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if read || r.URL.Path == "/graphql" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		state, enabled := inMaintenance(r.Context())
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+		message := state.Message
+		if message == "" {
+			message = "Writes are paused for maintenance, try again later"
+		}
+		writeProblem(w, problemMaintenance, message)
+	})
+}
+
+func getMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := maintenance.Get(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, state)
+}
+
+// updateMaintenanceHandler turns maintenance mode on or off
+func updateMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var state Maintenance
+	err := decodeRequest(r, &state)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if state.RetryAfter < 0 {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "retry_after", Message: "can't be negative"})
+		return
+	}
+
+	if state.Enabled {
+		state, err = enableMaintenance(r.Context(), state)
+	} else {
+		state = Maintenance{}
+		err = maintenance.Set(r.Context(), state)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	log.Printf("Maintenance mode enabled: %t\n", state.Enabled)
+	writeResponse(w, r, state)
+}
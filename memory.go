@@ -0,0 +1,1868 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps stats in process memory. It backs the --mock mode
+// used for frontend development, where no Mongo is around.
+type memoryRepository struct {
+	mu         sync.RWMutex
+	stats      []StatsRaw
+	tombstones []Tombstone
+	syncSeq    int64
+	// syncPending are the sync sequence numbers reserved for writes still
+	// in flight, with when they were reserved
+	syncPending map[int64]time.Time
+	// statsCorrections are kept in correction order
+	statsCorrections []StatsCorrectionEntry
+	// practiceSessions are kept in start order
+	practiceSessions []PracticeSession
+	settings         map[string]Settings
+	// notificationPreferences are keyed like settings
+	notificationPreferences map[string]NotificationPreferences
+	// integrations are kept in creation order
+	integrations []Integration
+	// streakPauses are kept in creation order
+	streakPauses []StreakPause
+	// streakFreezes are kept in the order they were used up
+	streakFreezes []StreakFreeze
+	// badges are keyed like settings
+	badges map[string]Badge
+	// relationships are kept in invitation order
+	relationships []Relationship
+	assignments   []Assignment
+	flags         map[string]Flag
+	// chordAliases are keyed by alias
+	chordAliases map[string]ChordAlias
+	experiments  map[string]Experiment
+	// insights are keyed by tenant
+	insights map[string]InsightReport
+	// sheetConnections are keyed by tenant
+	sheetConnections map[string]SheetConnection
+	// warehouseExports are keyed by name
+	warehouseExports map[string]WarehouseExport
+	// jobLocks are keyed by job name
+	jobLocks map[string]jobLock
+	// jobRuns are kept in start order
+	jobRuns []JobRun
+	// events are kept in recording order
+	events []Event
+	// accessLogs are kept in insertion order, the oldest ones dropped past
+	// maxMemoryAccessLogs
+	accessLogs []AccessLogEntry
+	// tokens are kept in minting order
+	tokens   []APIToken
+	users    map[string]User
+	sessions []Session
+}
+
+func newMemoryRepository(stats []StatsRaw) *memoryRepository {
+	m := &memoryRepository{
+		syncPending:             make(map[int64]time.Time),
+		settings:                make(map[string]Settings),
+		notificationPreferences: make(map[string]NotificationPreferences),
+		badges:                  make(map[string]Badge),
+		insights:                make(map[string]InsightReport),
+		sheetConnections:        make(map[string]SheetConnection),
+		warehouseExports:        make(map[string]WarehouseExport),
+		jobLocks:                make(map[string]jobLock),
+		flags:                   make(map[string]Flag),
+		chordAliases:            make(map[string]ChordAlias),
+		experiments:             make(map[string]Experiment),
+		users:                   make(map[string]User),
+	}
+	ctx := withTenant(context.Background(), defaultTenant)
+	for _, s := range stats {
+		m.SaveStats(ctx, s, anyVersion)
+	}
+	return m
+}
+
+func (f StatsFilter) matches(stats StatsRaw) bool {
+	if f.ChordName != "" && stats.ChordName != f.ChordName {
+		return false
+	}
+	if f.RootNote != "" && stats.RootNote != f.RootNote {
+		return false
+	}
+	if f.ChordExtension != "" && stats.ChordExtension != f.ChordExtension {
+		return false
+	}
+	if !instrumentMatches(f.Instrument, stats.Instrument) {
+		return false
+	}
+	if f.Tag != "" && !containsString(stats.Tags, f.Tag) {
+		return false
+	}
+	if !f.Since.IsZero() && stats.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !stats.CreatedAt.Before(f.Until) {
+		return false
+	}
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			found = found || id == stats.ID
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.WithoutClientID && stats.ClientID != "" {
+		return false
+	}
+	if !f.AfterID.IsZero() && bytes.Compare(stats.ID[:], f.AfterID[:]) <= 0 {
+		return false
+	}
+	return true
+}
+
+func (m *memoryRepository) SaveStats(ctx context.Context, stats StatsRaw, ifVersion int64) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	stats.Tenant = tenant
+	stats = withExpiry(ctx, stats)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.syncSeq++
+	stats.SyncSeq = m.syncSeq
+
+	if stats.ClientID != "" {
+		for i, stored := range m.stats {
+			if stored.Tenant != tenant || stored.ClientID != stats.ClientID {
+				continue
+			}
+			if ifVersion != anyVersion && stored.Version != ifVersion {
+				return stored, errVersionConflict
+			}
+			if ifVersion == anyVersion && !stored.UpdatedAt.Before(stats.UpdatedAt) {
+				return stored, nil
+			}
+
+			// move the stats to the end, keeping the slice in sequence order
+			stats.ID = stored.ID
+			stats.Version = stored.Version + 1
+			m.stats = append(append(m.stats[:i:i], m.stats[i+1:]...), stats)
+			return stats, nil
+		}
+	}
+
+	if stats.ClientID != "" && ifVersion > 0 {
+		return StatsRaw{}, errVersionConflict
+	}
+	stats.ID = primitive.NewObjectID()
+	stats.Version = 1
+	m.stats = append(m.stats, stats)
+	return stats, nil
+}
+
+func (m *memoryRepository) InsertStats(ctx context.Context, stats []StatsRaw) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range stats {
+		m.syncSeq++
+		s.SyncSeq = m.syncSeq
+		s.Tenant = tenant
+		s = withExpiry(ctx, s)
+		m.stats = append(m.stats, s)
+	}
+	return nil
+}
+
+// EachArchivedStats finds nothing, since the mock data is never archived
+func (m *memoryRepository) EachArchivedStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	return nil
+}
+
+func (m *memoryRepository) ArchiveStats(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryRepository) EachStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matching []StatsRaw
+	for _, stats := range m.stats {
+		if tenantMatches(ctx, stats.Tenant) && filter.matches(stats) {
+			matching = append(matching, stats)
+		}
+	}
+	if filter.Limit > 0 {
+		sort.Slice(matching, func(i, j int) bool {
+			return bytes.Compare(matching[i].ID[:], matching[j].ID[:]) < 0
+		})
+		if len(matching) > filter.Limit {
+			matching = matching[:filter.Limit]
+		}
+	}
+
+	for _, stats := range matching {
+		err := fn(stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepository) CountStats(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, stats := range m.stats {
+		if tenantMatches(ctx, stats.Tenant) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryRepository) CountMatchingStats(ctx context.Context, filter StatsFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, stats := range m.stats {
+		if tenantMatches(ctx, stats.Tenant) && filter.matches(stats) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryRepository) DeleteStats(ctx context.Context, filter StatsFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	kept := m.stats[:0]
+	deleted := 0
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !filter.matches(stats) {
+			kept = append(kept, stats)
+			continue
+		}
+		m.syncSeq++
+		m.tombstones = append(m.tombstones, Tombstone{ID: stats.ID, DeletedAt: now, SyncSeq: m.syncSeq, Tenant: stats.Tenant})
+		deleted++
+	}
+	m.stats = kept
+	return deleted, nil
+}
+
+func (m *memoryRepository) ReserveSyncSeq(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.syncSeq++
+	m.syncPending[m.syncSeq] = time.Now()
+	return m.syncSeq, nil
+}
+
+func (m *memoryRepository) ReleaseSyncSeq(ctx context.Context, seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.syncPending, seq)
+	return nil
+}
+
+// syncWatermark returns the latest sync sequence number up to which every
+// write is done. Other writes take theirs under the lock, so only the ones
+// of ReserveSyncSeq can be in flight.
+func (m *memoryRepository) syncWatermark() int64 {
+	watermark := m.syncSeq
+	for seq, reservedAt := range m.syncPending {
+		if time.Since(reservedAt) < syncSeqOverlap && seq <= watermark {
+			watermark = seq - 1
+		}
+	}
+	return watermark
+}
+
+func (m *memoryRepository) CorrectStats(ctx context.Context, stats StatsRaw, ifVersion, seq int64) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, stored := range m.stats {
+		if stored.Tenant != tenant || stored.ID != stats.ID {
+			continue
+		}
+		if stored.Version != ifVersion {
+			return StatsRaw{}, errVersionConflict
+		}
+
+		stored.Correct = stats.Correct
+		stored.ChordName = stats.ChordName
+		stored.ChordExtension = stats.ChordExtension
+		stored.Tags = stats.Tags
+		stored.UpdatedAt = stats.UpdatedAt
+		stored.SyncSeq = seq
+		stored.Version++
+		// move the stats behind the ones with lower numbers, keeping the
+		// slice in sequence order even if later numbers were written first
+		rest := append(m.stats[:i:i], m.stats[i+1:]...)
+		at := sort.Search(len(rest), func(j int) bool { return rest[j].SyncSeq > seq })
+		m.stats = append(rest[:at:at], append([]StatsRaw{stored}, rest[at:]...)...)
+		return stored, nil
+	}
+	return StatsRaw{}, errNotFound
+}
+
+func (m *memoryRepository) RetagStats(ctx context.Context, filter StatsFilter, add, remove []string) (StatsRetagReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var report StatsRetagReport
+	var kept, changed []StatsRaw
+	now := time.Now()
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !filter.matches(stats) {
+			kept = append(kept, stats)
+			continue
+		}
+		report.Matched++
+		tags := retagged(stats.Tags, add, remove)
+		if len(tags) > maxTags {
+			report.Full++
+		}
+		if len(tags) > maxTags || sameTags(tags, stats.Tags) {
+			kept = append(kept, stats)
+			continue
+		}
+		m.syncSeq++
+		stats.Tags = tags
+		stats.UpdatedAt = now
+		stats.SyncSeq = m.syncSeq
+		stats.Version++
+		changed = append(changed, stats)
+	}
+	// the changed stats go to the end, keeping the slice in sequence order
+	m.stats = append(kept, changed...)
+	report.Modified = len(changed)
+	return report, nil
+}
+
+func (m *memoryRepository) InsertStatsCorrection(ctx context.Context, entry StatsCorrectionEntry) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Tenant = tenant
+	m.statsCorrections = append(m.statsCorrections, entry)
+	return nil
+}
+
+func (m *memoryRepository) StatsCorrections(ctx context.Context, statsID primitive.ObjectID) ([]StatsCorrectionEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	corrections := []StatsCorrectionEntry{}
+	for _, entry := range m.statsCorrections {
+		if entry.StatsID == statsID && tenantMatches(ctx, entry.Tenant) {
+			corrections = append(corrections, entry)
+		}
+	}
+	return corrections, nil
+}
+
+func (m *memoryRepository) SavePracticeSession(ctx context.Context, session PracticeSession) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session.Tenant = tenant
+	session.Progress = nil
+	m.practiceSessions = append(m.practiceSessions, session)
+	return nil
+}
+
+func (m *memoryRepository) PracticeSession(ctx context.Context, id primitive.ObjectID) (PracticeSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, session := range m.practiceSessions {
+		if session.ID == id && tenantMatches(ctx, session.Tenant) {
+			return session, nil
+		}
+	}
+	return PracticeSession{}, errNotFound
+}
+
+func (m *memoryRepository) EndPracticeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.practiceSessions {
+		if session.ID == id && tenantMatches(ctx, session.Tenant) {
+			if session.EndedAt == nil {
+				m.practiceSessions[i].EndedAt = &at
+			}
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) OpenPracticeSessions(ctx context.Context, since time.Time) ([]PracticeSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := []PracticeSession{}
+	for _, session := range m.practiceSessions {
+		if tenantMatches(ctx, session.Tenant) && !session.StartedAt.Before(since) && session.EndedAt == nil && session.GoalMetAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *memoryRepository) MarkPracticeGoalMet(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.practiceSessions {
+		if session.ID == id && tenantMatches(ctx, session.Tenant) && session.GoalMetAt == nil {
+			m.practiceSessions[i].GoalMetAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepository) CountByDay(ctx context.Context, loc *time.Location) ([]StatsCountByDay, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, stats := range m.stats {
+		if !analyticsMatches(ctx, stats) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) {
+			continue
+		}
+		counts[stats.CreatedAt.In(loc).Format("2006-01-02")]++
+	}
+
+	countByDays := []StatsCountByDay{}
+	for day, count := range counts {
+		countByDays = append(countByDays, StatsCountByDay{Day: day, Count: count})
+	}
+	return countByDays, nil
+}
+
+func (m *memoryRepository) CountByDayAndExtension(ctx context.Context, loc *time.Location) ([]StatsCountByDayAndExtension, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type dayAndExtension struct{ day, extension string }
+	counts := make(map[dayAndExtension]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) {
+			continue
+		}
+		counts[dayAndExtension{stats.CreatedAt.In(loc).Format("2006-01-02"), stats.ChordExtension}]++
+	}
+
+	countByDays := []StatsCountByDayAndExtension{}
+	for key, count := range counts {
+		countByDays = append(countByDays, StatsCountByDayAndExtension{Day: key.day, Extension: key.extension, Count: count})
+	}
+	return countByDays, nil
+}
+
+func (m *memoryRepository) CountByExtension(ctx context.Context) ([]StatsCountByExtension, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) {
+			continue
+		}
+		counts[stats.ChordExtension]++
+	}
+
+	countByExtensions := []StatsCountByExtension{}
+	for extension, count := range counts {
+		countByExtensions = append(countByExtensions, StatsCountByExtension{Extension: extension, Count: count})
+	}
+	return countByExtensions, nil
+}
+
+func (m *memoryRepository) CountByRootNote(ctx context.Context, since time.Time) ([]StatsCountByRootNote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) || stats.CreatedAt.Before(since) {
+			continue
+		}
+		counts[stats.RootNote]++
+	}
+
+	countByRootNotes := []StatsCountByRootNote{}
+	for rootNote, count := range counts {
+		countByRootNotes = append(countByRootNotes, StatsCountByRootNote{RootNote: rootNote, Count: count})
+	}
+	return countByRootNotes, nil
+}
+
+func (m *memoryRepository) OutcomesByTempo(ctx context.Context) ([]TempoOutcome, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byTempo := make(map[int]*TempoOutcome)
+	beats := make(map[int]float64)
+	durationMilli := make(map[int]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) || stats.BPM <= 0 {
+			continue
+		}
+		outcome, exists := byTempo[stats.BPM]
+		if !exists {
+			outcome = &TempoOutcome{BPM: stats.BPM}
+			byTempo[stats.BPM] = outcome
+		}
+		outcome.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			outcome.Correct++
+		}
+		beats[stats.BPM] += stats.BeatsToAnswer
+		durationMilli[stats.BPM] += stats.AnswerDurationMilliSeconds
+	}
+
+	outcomes := []TempoOutcome{}
+	for bpm, outcome := range byTempo {
+		outcome.AvgBeatsToAnswer = beats[bpm] / float64(outcome.Answers)
+		outcome.AvgDurationMillis = float64(durationMilli[bpm]) / float64(outcome.Answers)
+		outcomes = append(outcomes, *outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].BPM < outcomes[j].BPM })
+	return outcomes, nil
+}
+
+func (m *memoryRepository) OutcomesByConfidence(ctx context.Context) ([]ConfidenceOutcome, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byConfidence := make(map[int]*ConfidenceOutcome)
+	durationMilli := make(map[int]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) || stats.Confidence <= 0 {
+			continue
+		}
+		outcome, exists := byConfidence[stats.Confidence]
+		if !exists {
+			outcome = &ConfidenceOutcome{Confidence: stats.Confidence}
+			byConfidence[stats.Confidence] = outcome
+		}
+		outcome.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			outcome.Correct++
+		}
+		durationMilli[stats.Confidence] += stats.AnswerDurationMilliSeconds
+	}
+
+	outcomes := []ConfidenceOutcome{}
+	for confidence, outcome := range byConfidence {
+		outcome.AvgDurationMillis = float64(durationMilli[confidence]) / float64(outcome.Answers)
+		outcomes = append(outcomes, *outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].Confidence < outcomes[j].Confidence })
+	return outcomes, nil
+}
+
+func (m *memoryRepository) AttemptOutcomes(ctx context.Context) ([]AttemptOutcome, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byExtension := make(map[string]*AttemptOutcome)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) {
+			continue
+		}
+		outcome, exists := byExtension[stats.ChordExtension]
+		if !exists {
+			outcome = &AttemptOutcome{Extension: stats.ChordExtension}
+			byExtension[stats.ChordExtension] = outcome
+		}
+		outcome.Answers++
+		if firstTryCorrect(stats) {
+			outcome.FirstTryCorrect++
+		}
+		if stats.Correct == nil || *stats.Correct {
+			outcome.Correct++
+		}
+	}
+
+	outcomes := []AttemptOutcome{}
+	for _, outcome := range byExtension {
+		outcomes = append(outcomes, *outcome)
+	}
+	return outcomes, nil
+}
+
+func (m *memoryRepository) AvgDurationByExtension(ctx context.Context) ([]StatsDurationByExtension, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sums := make(map[string]int)
+	counts := make(map[string]int)
+	for _, stats := range m.stats {
+		if !tenantMatches(ctx, stats.Tenant) || !instrumentMatches(instrumentFromContext(ctx), stats.Instrument) {
+			continue
+		}
+		sums[stats.ChordExtension] += stats.AnswerDurationMilliSeconds
+		counts[stats.ChordExtension]++
+	}
+
+	durationByExtensions := []StatsDurationByExtension{}
+	for extension, count := range counts {
+		durationByExtensions = append(durationByExtensions, StatsDurationByExtension{
+			Extension:   extension,
+			AvgDuration: float64(sums[extension]) / float64(count),
+			Count:       count,
+		})
+	}
+	return durationByExtensions, nil
+}
+
+func (m *memoryRepository) Changes(ctx context.Context, since int64, limit int) ([]StatsRaw, []Tombstone, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// both slices are kept in sequence order, since every write appends
+	watermark := m.syncWatermark()
+	stats := []StatsRaw{}
+	for _, s := range m.stats {
+		if tenantMatches(ctx, s.Tenant) && s.SyncSeq > since && s.SyncSeq <= watermark && len(stats) < limit {
+			stats = append(stats, s)
+		}
+	}
+	tombstones := []Tombstone{}
+	for _, t := range m.tombstones {
+		if tenantMatches(ctx, t.Tenant) && t.SyncSeq > since && t.SyncSeq <= watermark && len(tombstones) < limit {
+			tombstones = append(tombstones, t)
+		}
+	}
+	return stats, tombstones, nil
+}
+
+func (m *memoryRepository) UsageByClientVersion(ctx context.Context) ([]ClientVersionUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usages := make(map[clientVersion]*ClientVersionUsage)
+	for _, stats := range m.stats {
+		if !analyticsMatches(ctx, stats) {
+			continue
+		}
+		version := clientVersion{stats.Platform, stats.AppVersion}
+		usage, exists := usages[version]
+		if !exists {
+			usage = &ClientVersionUsage{Platform: stats.Platform, AppVersion: stats.AppVersion}
+			usages[version] = usage
+		}
+		usage.StatsCount++
+		usage.AvgDurationMillis += float64(stats.AnswerDurationMilliSeconds)
+	}
+
+	result := []ClientVersionUsage{}
+	for _, usage := range usages {
+		usage.AvgDurationMillis /= float64(usage.StatsCount)
+		result = append(result, *usage)
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) TenantActivity(ctx context.Context, since time.Time) ([]TenantActivity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type tenantDays struct {
+		first string
+		days  map[string]bool
+	}
+	byTenant := make(map[string]*tenantDays)
+	sinceDay := since.UTC().Format(dayLayout)
+	for _, stats := range m.stats {
+		if !analyticsMatches(ctx, stats) {
+			continue
+		}
+		day := stats.CreatedAt.UTC().Format(dayLayout)
+		tenant, exists := byTenant[stats.Tenant]
+		if !exists {
+			tenant = &tenantDays{first: day, days: make(map[string]bool)}
+			byTenant[stats.Tenant] = tenant
+		}
+		if day < tenant.first {
+			tenant.first = day
+		}
+		if day >= sinceDay {
+			tenant.days[day] = true
+		}
+	}
+
+	activity := []TenantActivity{}
+	for name, tenant := range byTenant {
+		if len(tenant.days) == 0 {
+			continue
+		}
+		a := TenantActivity{Tenant: name, First: tenant.first}
+		for day := range tenant.days {
+			a.Days = append(a.Days, day)
+		}
+		sort.Strings(a.Days)
+		activity = append(activity, a)
+	}
+	return activity, nil
+}
+
+func (m *memoryRepository) InsertEvents(ctx context.Context, events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, events...)
+	return nil
+}
+
+func (m *memoryRepository) TenantEvents(ctx context.Context, since, until time.Time) ([]TenantEvents, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make(map[string]map[string]bool)
+	for _, event := range m.events {
+		if !tenantMatches(ctx, event.Tenant) || event.At.Before(since) || !event.At.Before(until) {
+			continue
+		}
+		if names[event.Tenant] == nil {
+			names[event.Tenant] = make(map[string]bool)
+		}
+		names[event.Tenant][event.Name] = true
+	}
+
+	events := []TenantEvents{}
+	for tenant, recorded := range names {
+		tenantEvents := TenantEvents{Tenant: tenant}
+		for name := range recorded {
+			tenantEvents.Names = append(tenantEvents.Names, name)
+		}
+		events = append(events, tenantEvents)
+	}
+	return events, nil
+}
+
+// settingsKey keeps the settings of same named users in different tenants
+// apart
+func settingsKey(ctx context.Context, userID string) string {
+	return tenantFromContext(ctx) + "/" + userID
+}
+
+func (m *memoryRepository) GetSettings(ctx context.Context, userID string) (Settings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.settings[settingsKey(ctx, userID)], nil
+}
+
+func (m *memoryRepository) UpdateSettings(ctx context.Context, userID string, update Settings, ifVersion int64) (Settings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := settingsKey(ctx, userID)
+	settings := m.settings[key]
+	if ifVersion != anyVersion && settings.Version != ifVersion {
+		return settings, errVersionConflict
+	}
+	settings.Version++
+	if update.Theme != nil {
+		settings.Theme = update.Theme
+	}
+	if update.PreferredNotation != nil {
+		settings.PreferredNotation = update.PreferredNotation
+	}
+	if update.DrillDefaults != nil {
+		settings.DrillDefaults = update.DrillDefaults
+	}
+	if update.Timezone != nil {
+		settings.Timezone = update.Timezone
+	}
+	if update.ModifiedAt != nil {
+		settings.ModifiedAt = update.ModifiedAt
+	}
+	m.settings[key] = settings
+	return settings, nil
+}
+
+func (m *memoryRepository) GetNotificationPreferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.notificationPreferences[settingsKey(ctx, userID)], nil
+}
+
+func (m *memoryRepository) UpdateNotificationPreferences(ctx context.Context, userID string, update NotificationPreferences) (NotificationPreferences, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := settingsKey(ctx, userID)
+	preferences := m.notificationPreferences[key]
+	if update.Channels != nil {
+		preferences.Channels = update.Channels
+	}
+	if update.Events != nil {
+		preferences.Events = update.Events
+	}
+	if update.QuietHours != nil {
+		preferences.QuietHours = update.QuietHours
+	}
+	if update.ModifiedAt != nil {
+		preferences.ModifiedAt = update.ModifiedAt
+	}
+	m.notificationPreferences[key] = preferences
+	return preferences, nil
+}
+
+func (m *memoryRepository) Integrations(ctx context.Context, userID string) ([]Integration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	integrations := []Integration{}
+	for _, integration := range m.integrations {
+		if integration.Tenant == tenantFromContext(ctx) && integration.UserID == userID {
+			integrations = append(integrations, integration)
+		}
+	}
+	return integrations, nil
+}
+
+func (m *memoryRepository) AllIntegrations(ctx context.Context) ([]Integration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	integrations := []Integration{}
+	for _, integration := range m.integrations {
+		if tenantMatches(ctx, integration.Tenant) {
+			integrations = append(integrations, integration)
+		}
+	}
+	return integrations, nil
+}
+
+func (m *memoryRepository) SaveIntegration(ctx context.Context, integration Integration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.integrations = append(m.integrations, integration)
+	return nil
+}
+
+func (m *memoryRepository) DeleteIntegration(ctx context.Context, userID string, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, integration := range m.integrations {
+		if integration.ID == id && integration.Tenant == tenantFromContext(ctx) && integration.UserID == userID {
+			m.integrations = append(m.integrations[:i], m.integrations[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) MarkSummarySent(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, integration := range m.integrations {
+		if integration.ID == id && tenantMatches(ctx, integration.Tenant) {
+			m.integrations[i].SummarySentAt = &at
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) MarkStreakAnnounced(ctx context.Context, id primitive.ObjectID, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, integration := range m.integrations {
+		if integration.ID == id && tenantMatches(ctx, integration.Tenant) && integration.StreakAnnounced != key {
+			m.integrations[i].StreakAnnounced = key
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRepository) StreakPauses(ctx context.Context, userID string) ([]StreakPause, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pauses := []StreakPause{}
+	for _, pause := range m.streakPauses {
+		if pause.Tenant == tenantFromContext(ctx) && pause.UserID == userID {
+			pauses = append(pauses, pause)
+		}
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i].From < pauses[j].From })
+	return pauses, nil
+}
+
+func (m *memoryRepository) SaveStreakPause(ctx context.Context, pause StreakPause) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.streakPauses = append(m.streakPauses, pause)
+	return nil
+}
+
+func (m *memoryRepository) DeleteStreakPause(ctx context.Context, userID string, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, pause := range m.streakPauses {
+		if pause.ID == id && pause.Tenant == tenantFromContext(ctx) && pause.UserID == userID {
+			m.streakPauses = append(m.streakPauses[:i], m.streakPauses[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) StreakFreezes(ctx context.Context, userID string) ([]StreakFreeze, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	freezes := []StreakFreeze{}
+	for _, freeze := range m.streakFreezes {
+		if freeze.Tenant == tenantFromContext(ctx) && freeze.UserID == userID {
+			freezes = append(freezes, freeze)
+		}
+	}
+	sort.Slice(freezes, func(i, j int) bool { return freezes[i].Day < freezes[j].Day })
+	return freezes, nil
+}
+
+func (m *memoryRepository) SaveStreakFreeze(ctx context.Context, freeze StreakFreeze) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.streakFreezes {
+		if stored.Tenant == freeze.Tenant && stored.UserID == freeze.UserID && stored.Day == freeze.Day {
+			return nil
+		}
+	}
+	m.streakFreezes = append(m.streakFreezes, freeze)
+	return nil
+}
+
+func (m *memoryRepository) SaveBadge(ctx context.Context, badge Badge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.badges[badge.Tenant+"/"+badge.UserID] = badge
+	return nil
+}
+
+func (m *memoryRepository) BadgeByHash(ctx context.Context, hash []byte) (Badge, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, badge := range m.badges {
+		if bytes.Equal(badge.Hash, hash) {
+			return badge, nil
+		}
+	}
+	return Badge{}, errNotFound
+}
+
+func (m *memoryRepository) DeleteBadge(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := settingsKey(ctx, userID)
+	if _, exists := m.badges[key]; !exists {
+		return errNotFound
+	}
+	delete(m.badges, key)
+	return nil
+}
+
+func (m *memoryRepository) Insights(ctx context.Context) (InsightReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	report, exists := m.insights[tenantFromContext(ctx)]
+	if !exists {
+		return InsightReport{}, errNotFound
+	}
+	return report, nil
+}
+
+func (m *memoryRepository) SaveInsights(ctx context.Context, report InsightReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insights[report.Tenant] = report
+	return nil
+}
+
+func (m *memoryRepository) SheetConnections(ctx context.Context) ([]SheetConnection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	connections := []SheetConnection{}
+	for _, connection := range m.sheetConnections {
+		connections = append(connections, connection)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].Tenant < connections[j].Tenant })
+	return connections, nil
+}
+
+func (m *memoryRepository) SheetConnection(ctx context.Context, tenant string) (SheetConnection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	connection, exists := m.sheetConnections[tenant]
+	if !exists {
+		return SheetConnection{}, errNotFound
+	}
+	return connection, nil
+}
+
+func (m *memoryRepository) SaveSheetConnection(ctx context.Context, connection SheetConnection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sheetConnections[connection.Tenant] = connection
+	return nil
+}
+
+func (m *memoryRepository) DeleteSheetConnection(ctx context.Context, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.sheetConnections[tenant]; !exists {
+		return errNotFound
+	}
+	delete(m.sheetConnections, tenant)
+	delete(m.insights, tenant)
+	return nil
+}
+
+func (m *memoryRepository) UpdateSheetExport(ctx context.Context, tenant string, from, to SheetExport) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connection, exists := m.sheetConnections[tenant]
+	if !exists || connection.Export.Cursor != from.Cursor || connection.Export.Day != from.Day {
+		return false, nil
+	}
+	connection.Export = to
+	m.sheetConnections[tenant] = connection
+	return true, nil
+}
+
+func (m *memoryRepository) LatestSyncSeq(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	watermark := m.syncWatermark()
+	var latest int64
+	for _, s := range m.stats {
+		if tenantMatches(ctx, s.Tenant) && s.SyncSeq > latest && s.SyncSeq <= watermark {
+			latest = s.SyncSeq
+		}
+	}
+	for _, t := range m.tombstones {
+		if tenantMatches(ctx, t.Tenant) && t.SyncSeq > latest && t.SyncSeq <= watermark {
+			latest = t.SyncSeq
+		}
+	}
+	return latest, nil
+}
+
+func (m *memoryRepository) WarehouseExport(ctx context.Context, name string) (WarehouseExport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	export, exists := m.warehouseExports[name]
+	if !exists {
+		return WarehouseExport{Name: name}, nil
+	}
+	return export, nil
+}
+
+func (m *memoryRepository) UpdateWarehouseExport(ctx context.Context, from, to WarehouseExport) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	export, exists := m.warehouseExports[from.Name]
+	if !exists {
+		export = WarehouseExport{Name: from.Name}
+	}
+	if export.Cursor != from.Cursor || export.Day != from.Day {
+		return false, nil
+	}
+	m.warehouseExports[from.Name] = to
+	return true, nil
+}
+
+type jobLock struct {
+	holder string
+	until  time.Time
+}
+
+func (m *memoryRepository) LockJob(ctx context.Context, name, holder string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, exists := m.jobLocks[name]
+	if exists && lock.holder != holder && time.Now().Before(lock.until) {
+		return false, nil
+	}
+	m.jobLocks[name] = jobLock{holder: holder, until: until}
+	return true, nil
+}
+
+func (m *memoryRepository) UnlockJob(ctx context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.jobLocks[name].holder == holder {
+		delete(m.jobLocks, name)
+	}
+	return nil
+}
+
+func (m *memoryRepository) SaveJobRun(ctx context.Context, run JobRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.jobRuns {
+		if m.jobRuns[i].ID == run.ID {
+			m.jobRuns[i] = run
+			return nil
+		}
+	}
+	// runs are forgotten after the retention, as in Mongo
+	kept := m.jobRuns[:0]
+	for _, r := range m.jobRuns {
+		if time.Since(r.StartedAt) < jobRunRetention {
+			kept = append(kept, r)
+		}
+	}
+	m.jobRuns = append(kept, run)
+	return nil
+}
+
+func (m *memoryRepository) JobRuns(ctx context.Context, job string, limit int) ([]JobRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := []JobRun{}
+	for i := len(m.jobRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		if m.jobRuns[i].Job == job {
+			runs = append(runs, m.jobRuns[i])
+		}
+	}
+	return runs, nil
+}
+
+// maxMemoryAccessLogs caps the access log in memory, as the size of the
+// capped collection does in Mongo
+const maxMemoryAccessLogs = 10000
+
+func (m *memoryRepository) InsertAccessLogs(ctx context.Context, entries []AccessLogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accessLogs = append(m.accessLogs, entries...)
+	if excess := len(m.accessLogs) - maxMemoryAccessLogs; excess > 0 {
+		m.accessLogs = append([]AccessLogEntry{}, m.accessLogs[excess:]...)
+	}
+	return nil
+}
+
+func (m *memoryRepository) AccessLogs(ctx context.Context, filter AccessLogFilter) ([]AccessLogEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := []AccessLogEntry{}
+	for i := len(m.accessLogs) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		if filter.matches(m.accessLogs[i]) {
+			entries = append(entries, m.accessLogs[i])
+		}
+	}
+	return entries, nil
+}
+
+var mockRootNotes = []string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+var mockChordExtensions = []string{"maj", "m", "7", "maj7", "m7", "dim", "aug", "sus4"}
+
+// generateMockStats makes up a month of practice, with a few hundred answers
+// on most days and slower answers for the harder extensions
+func generateMockStats() []StatsRaw {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+
+	stats := []StatsRaw{}
+	for daysAgo := 31; daysAgo >= 0; daysAgo-- {
+		if random.Intn(4) == 0 {
+			continue
+		}
+
+		day := now.AddDate(0, 0, -daysAgo)
+		for i := random.Intn(300); i >= 0; i-- {
+			rootNote := mockRootNotes[random.Intn(len(mockRootNotes))]
+			extensionIndex := random.Intn(len(mockChordExtensions))
+			extension := mockChordExtensions[extensionIndex]
+			stats = append(stats, StatsRaw{
+				ChordName:                  rootNote + extension,
+				RootNote:                   rootNote,
+				ChordExtension:             extension,
+				AnswerDurationMilliSeconds: 800 + 250*extensionIndex + random.Intn(2000),
+				CreatedAt:                  day.Add(-time.Duration(random.Intn(3600)) * time.Second),
+			})
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].CreatedAt.Before(stats[j].CreatedAt)
+	})
+	return stats
+}
+
+func (m *memoryRepository) SaveRelationship(ctx context.Context, relationship Relationship) (Relationship, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.relationships {
+		if stored.Teacher == relationship.Teacher && stored.Student == relationship.Student {
+			return stored, nil
+		}
+	}
+	m.relationships = append(m.relationships, relationship)
+	return relationship, nil
+}
+
+func (m *memoryRepository) Relationships(ctx context.Context, account string) ([]Relationship, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	relationships := []Relationship{}
+	for _, relationship := range m.relationships {
+		if relationship.Teacher == account || relationship.Student == account {
+			relationships = append(relationships, relationship)
+		}
+	}
+	return relationships, nil
+}
+
+func (m *memoryRepository) AcceptRelationship(ctx context.Context, id primitive.ObjectID, student string) (Relationship, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, relationship := range m.relationships {
+		if relationship.ID == id && relationship.Student == student && relationship.Status == relationshipPending {
+			now := time.Now()
+			relationship.Status = relationshipAccepted
+			relationship.AcceptedAt = &now
+			m.relationships[i] = relationship
+			return relationship, nil
+		}
+	}
+	return Relationship{}, errNotFound
+}
+
+func (m *memoryRepository) DeleteRelationship(ctx context.Context, id primitive.ObjectID, account string) (Relationship, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, relationship := range m.relationships {
+		if relationship.ID == id && (relationship.Teacher == account || relationship.Student == account) {
+			m.relationships = append(m.relationships[:i], m.relationships[i+1:]...)
+			return relationship, nil
+		}
+	}
+	return Relationship{}, errNotFound
+}
+
+func (m *memoryRepository) HasStudent(ctx context.Context, teacher, student string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, relationship := range m.relationships {
+		if relationship.Teacher == teacher && relationship.Student == student {
+			return relationship.Status == relationshipAccepted, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRepository) SaveAssignment(ctx context.Context, assignment Assignment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.assignments = append(m.assignments, assignment)
+	return nil
+}
+
+func (a Assignment) involves(account string) bool {
+	if a.Teacher == account {
+		return true
+	}
+	for _, student := range a.Students {
+		if student == account {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryRepository) Assignments(ctx context.Context, account string) ([]Assignment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	assignments := []Assignment{}
+	for _, assignment := range m.assignments {
+		if assignment.involves(account) {
+			assignments = append(assignments, assignment)
+		}
+	}
+	sort.SliceStable(assignments, func(i, j int) bool {
+		return assignments[i].DueAt.Before(assignments[j].DueAt)
+	})
+	return assignments, nil
+}
+
+func (m *memoryRepository) Assignment(ctx context.Context, id primitive.ObjectID, account string) (Assignment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, assignment := range m.assignments {
+		if assignment.ID == id && assignment.involves(account) {
+			return assignment, nil
+		}
+	}
+	return Assignment{}, errNotFound
+}
+
+func (m *memoryRepository) RemoveAssignmentStudent(ctx context.Context, teacher, student string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, assignment := range m.assignments {
+		if assignment.Teacher != teacher {
+			continue
+		}
+		students := []string{}
+		for _, s := range assignment.Students {
+			if s != student {
+				students = append(students, s)
+			}
+		}
+		m.assignments[i].Students = students
+	}
+	return nil
+}
+
+func (m *memoryRepository) Flags(ctx context.Context) ([]Flag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	flags := []Flag{}
+	for _, flag := range m.flags {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags, nil
+}
+
+func (m *memoryRepository) SaveFlag(ctx context.Context, flag Flag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flags[flag.Name] = flag
+	return nil
+}
+
+func (m *memoryRepository) DeleteFlag(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.flags[name]; !exists {
+		return errNotFound
+	}
+	delete(m.flags, name)
+	return nil
+}
+
+func (m *memoryRepository) ChordAliases(ctx context.Context) ([]ChordAlias, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	aliases := []ChordAlias{}
+	for _, alias := range m.chordAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Alias < aliases[j].Alias
+	})
+	return aliases, nil
+}
+
+func (m *memoryRepository) SaveChordAlias(ctx context.Context, alias ChordAlias) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chordAliases[alias.Alias] = alias
+	return nil
+}
+
+func (m *memoryRepository) DeleteChordAlias(ctx context.Context, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chordAliases[alias]; !exists {
+		return errNotFound
+	}
+	delete(m.chordAliases, alias)
+	return nil
+}
+
+func (m *memoryRepository) Experiments(ctx context.Context) ([]Experiment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	experiments := []Experiment{}
+	for _, experiment := range m.experiments {
+		experiments = append(experiments, experiment)
+	}
+	sort.Slice(experiments, func(i, j int) bool {
+		return experiments[i].Name < experiments[j].Name
+	})
+	return experiments, nil
+}
+
+func (m *memoryRepository) SaveExperiment(ctx context.Context, experiment Experiment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.experiments[experiment.Name] = experiment
+	return nil
+}
+
+func (m *memoryRepository) DeleteExperiment(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.experiments[name]; !exists {
+		return errNotFound
+	}
+	delete(m.experiments, name)
+	return nil
+}
+
+func (m *memoryRepository) ExperimentOutcomes(ctx context.Context, name string) ([]VariantOutcome, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type sums struct {
+		outcome     VariantOutcome
+		tenants     map[string]bool
+		durationSum int
+	}
+	byVariant := make(map[string]*sums)
+	for _, stats := range m.stats {
+		variant, tagged := stats.Experiments[name]
+		if !tagged || !tenantMatches(ctx, stats.Tenant) {
+			continue
+		}
+		s := byVariant[variant]
+		if s == nil {
+			s = &sums{outcome: VariantOutcome{Variant: variant}, tenants: make(map[string]bool)}
+			byVariant[variant] = s
+		}
+		s.tenants[stats.Tenant] = true
+		s.outcome.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			s.outcome.Correct++
+		}
+		s.durationSum += stats.AnswerDurationMilliSeconds
+	}
+
+	outcomes := []VariantOutcome{}
+	for _, s := range byVariant {
+		s.outcome.Accounts = len(s.tenants)
+		s.outcome.AvgDurationMillis = float64(s.durationSum) / float64(s.outcome.Answers)
+		outcomes = append(outcomes, s.outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].Variant < outcomes[j].Variant
+	})
+	return outcomes, nil
+}
+
+func (m *memoryRepository) Tokens(ctx context.Context) ([]APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]APIToken{}, m.tokens...), nil
+}
+
+func (m *memoryRepository) SaveToken(ctx context.Context, token APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *memoryRepository) DeleteToken(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, token := range m.tokens {
+		if token.ID == id {
+			m.tokens = append(m.tokens[:i], m.tokens[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) ProvisionUser(ctx context.Context, identity Identity, user User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, existing := range m.users {
+		for _, known := range existing.Identities {
+			if known == identity {
+				existing.Email, existing.EmailVerified, existing.LastLoginAt = user.Email, user.EmailVerified, user.LastLoginAt
+				m.users[id] = existing
+				return existing, nil
+			}
+		}
+	}
+	user.Identities = []Identity{identity}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *memoryRepository) User(ctx context.Context, id string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, exists := m.users[id]
+	if !exists {
+		return User{}, errNotFound
+	}
+	return user, nil
+}
+
+func (m *memoryRepository) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[userID]
+	if !exists || user.Email != email {
+		return false, nil
+	}
+	user.VerifiedEmail = email
+	m.users[userID] = user
+	return true, nil
+}
+
+func (m *memoryRepository) ScheduleDeletion(ctx context.Context, userID string, at *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[userID]
+	if !exists {
+		return errNotFound
+	}
+	user.DeletionScheduledAt = at
+	m.users[userID] = user
+	for i := range m.sessions {
+		if m.sessions[i].UserID == userID {
+			m.sessions[i].Locked = at != nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepository) UsersDueForDeletion(ctx context.Context, before time.Time) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []User{}
+	for _, user := range m.users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(before) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *memoryRepository) PurgeUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant := User{ID: userID}.Tenant()
+	stats := m.stats[:0]
+	for _, s := range m.stats {
+		if s.Tenant != tenant {
+			stats = append(stats, s)
+		}
+	}
+	m.stats = stats
+	tombstones := m.tombstones[:0]
+	for _, tombstone := range m.tombstones {
+		if tombstone.Tenant != tenant {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	m.tombstones = tombstones
+	corrections := m.statsCorrections[:0]
+	for _, entry := range m.statsCorrections {
+		if entry.Tenant != tenant {
+			corrections = append(corrections, entry)
+		}
+	}
+	m.statsCorrections = corrections
+	practiceSessions := m.practiceSessions[:0]
+	for _, session := range m.practiceSessions {
+		if session.Tenant != tenant {
+			practiceSessions = append(practiceSessions, session)
+		}
+	}
+	m.practiceSessions = practiceSessions
+	events := m.events[:0]
+	for _, event := range m.events {
+		if event.Tenant != tenant {
+			events = append(events, event)
+		}
+	}
+	m.events = events
+	for key := range m.settings {
+		if strings.HasPrefix(key, tenant+"/") {
+			delete(m.settings, key)
+		}
+	}
+	for key := range m.notificationPreferences {
+		if strings.HasPrefix(key, tenant+"/") {
+			delete(m.notificationPreferences, key)
+		}
+	}
+	for key := range m.badges {
+		if strings.HasPrefix(key, tenant+"/") {
+			delete(m.badges, key)
+		}
+	}
+	delete(m.sheetConnections, tenant)
+	integrations := m.integrations[:0]
+	for _, integration := range m.integrations {
+		if integration.Tenant != tenant {
+			integrations = append(integrations, integration)
+		}
+	}
+	m.integrations = integrations
+	streakPauses := m.streakPauses[:0]
+	for _, pause := range m.streakPauses {
+		if pause.Tenant != tenant {
+			streakPauses = append(streakPauses, pause)
+		}
+	}
+	m.streakPauses = streakPauses
+	streakFreezes := m.streakFreezes[:0]
+	for _, freeze := range m.streakFreezes {
+		if freeze.Tenant != tenant {
+			streakFreezes = append(streakFreezes, freeze)
+		}
+	}
+	m.streakFreezes = streakFreezes
+	relationships := m.relationships[:0]
+	for _, relationship := range m.relationships {
+		if relationship.Teacher != tenant && relationship.Student != tenant {
+			relationships = append(relationships, relationship)
+		}
+	}
+	m.relationships = relationships
+	assignments := m.assignments[:0]
+	for _, assignment := range m.assignments {
+		if assignment.Teacher == tenant {
+			continue
+		}
+		students := []string{}
+		for _, student := range assignment.Students {
+			if student != tenant {
+				students = append(students, student)
+			}
+		}
+		assignment.Students = students
+		assignments = append(assignments, assignment)
+	}
+	m.assignments = assignments
+	sessions := m.sessions[:0]
+	for _, session := range m.sessions {
+		if session.UserID != userID {
+			sessions = append(sessions, session)
+		}
+	}
+	m.sessions = sessions
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *memoryRepository) SaveTwoFactor(ctx context.Context, userID string, twoFactor *TwoFactor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[userID]
+	if !exists {
+		return errNotFound
+	}
+	user.TwoFactor = twoFactor
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryRepository) UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := m.users[userID]
+	if user.TwoFactor == nil || user.TwoFactor.LastStep >= step {
+		return false, nil
+	}
+	twoFactor := *user.TwoFactor
+	twoFactor.LastStep = step
+	user.TwoFactor = &twoFactor
+	m.users[userID] = user
+	return true, nil
+}
+
+func (m *memoryRepository) UseRecoveryCode(ctx context.Context, userID string, hash []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := m.users[userID]
+	if user.TwoFactor == nil {
+		return false, nil
+	}
+	for i, code := range user.TwoFactor.RecoveryCodes {
+		if bytes.Equal(code, hash) {
+			twoFactor := *user.TwoFactor
+			twoFactor.RecoveryCodes = append(append([][]byte{}, twoFactor.RecoveryCodes[:i]...), twoFactor.RecoveryCodes[i+1:]...)
+			user.TwoFactor = &twoFactor
+			m.users[userID] = user
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRepository) SaveSession(ctx context.Context, session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = append(m.sessions, session)
+	return nil
+}
+
+func (m *memoryRepository) SessionByHash(ctx context.Context, hash []byte) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, session := range m.sessions {
+		if bytes.Equal(session.Hash, hash) {
+			return session, nil
+		}
+	}
+	return Session{}, errNotFound
+}
+
+func (m *memoryRepository) Sessions(ctx context.Context, userID string) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := []Session{}
+	for _, session := range m.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *memoryRepository) TouchSession(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.sessions {
+		if session.ID == id {
+			m.sessions[i].LastSeenAt, m.sessions[i].IP = at, ip
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (m *memoryRepository) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.sessions {
+		if session.ID == id {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// InTransaction doesn't isolate fn, which is fine for the single developer
+// using the mock mode
+func (m *memoryRepository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
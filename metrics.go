@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxExtensionLabels caps the chord extensions the metrics tell apart, since
+// clients can send any extension and every label value is a new series
+const maxExtensionLabels = 50
+
+var (
+	statsSavedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_stats_saved_total",
+		Help: "Answers saved, by chord extension. Extensions beyond the first few dozen seen are counted as \"other\".",
+	}, []string{"chord_extension"})
+	statsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_stats_dropped_total",
+		Help: "Answers that were accepted with 202 but failed to be saved later.",
+	})
+	eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_events_total",
+		Help: "Client actions recorded, by event.",
+	}, []string{"event"})
+	accessLogDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_access_log_dropped_total",
+		Help: "Access log entries dropped because saving them fell behind or failed.",
+	})
+	statsArchivedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_stats_archived_total",
+		Help: "Answers moved to the archive.",
+	})
+	accountsPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_accounts_purged_total",
+		Help: "Accounts deleted with their data after the grace period.",
+	})
+	backupFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_backup_failures_total",
+		Help: "Scheduled backups that failed.",
+	})
+	lastBackupTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pct_last_backup_timestamp_seconds",
+		Help: "When the last scheduled backup succeeded, 0 until one does.",
+	})
+	requestsShedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_requests_shed_total",
+		Help: "Requests answered with 503 because too many of their class were in flight.",
+	}, []string{"class"})
+	integrationPostsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_integration_posts_total",
+		Help: "Milestone cards posted to Slack and Discord, by kind and outcome.",
+	}, []string{"kind", "outcome"})
+	warehouseRowsExportedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_warehouse_rows_exported_total",
+		Help: "Rows written to the data warehouse, by table.",
+	}, []string{"table"})
+	warehouseExportFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_warehouse_export_failures_total",
+		Help: "Exports to the data warehouse that failed, to be tried again on the next run.",
+	})
+	jobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_job_runs_total",
+		Help: "Runs of the background jobs, by job and outcome.",
+	}, []string{"job", "outcome"})
+	jobDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pct_job_duration_seconds",
+		Help:    "How long the background jobs ran, by job.",
+		Buckets: []float64{0.1, 1, 10, 60, 300, 1800, 3600},
+	}, []string{"job"})
+	jobLastSuccessTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pct_job_last_success_timestamp_seconds",
+		Help: "When a run of the job last succeeded on the replica, by job.",
+	}, []string{"job"})
+	routeLatencyP95 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pct_route_latency_p95_seconds",
+		Help: "Rolling p95 latency of the routes with a latency budget on the replica, by route.",
+	}, []string{"route"})
+	latencyBudgetBreachesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_latency_budget_breaches_total",
+		Help: "Times the rolling p95 latency of a route went over its budget, by route.",
+	}, []string{"route"})
+	requestTimeoutsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_request_timeouts_total",
+		Help: "Requests answered with a timeout problem for taking longer than the timeout of their route.",
+	})
+	configReloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pct_config_reloads_total",
+		Help: "Reloads of the config file on SIGHUP, by result.",
+	}, []string{"result"})
+	authFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_auth_failures_total",
+		Help: "Requests with a wrong or missing auth token or signature.",
+	})
+	authBansTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pct_auth_bans_total",
+		Help: "IPs and token prefixes banned for failing auth too often.",
+	})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pct_write_queue_length",
+		Help: "Answers held in memory while the database is unavailable.",
+	}, func() float64 {
+		if statsQueue == nil {
+			return 0
+		}
+		return float64(statsQueue.Len())
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pct_leader",
+		Help: "1 while the replica runs the background jobs, 0 while another one does.",
+	}, func() float64 {
+		if leadership.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+}
+
+var extensionLabels = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// extensionLabel returns the label value counting stats of extension
+func extensionLabel(extension string) string {
+	extensionLabels.Lock()
+	defer extensionLabels.Unlock()
+
+	if extensionLabels.seen[extension] {
+		return extension
+	}
+	if len(extensionLabels.seen) >= maxExtensionLabels {
+		return "other"
+	}
+	extensionLabels.seen[extension] = true
+	return extension
+}
+
+// recordStatsSaved counts stats of the tenant and user in ctx that made it
+// into the repository, adds them to the count quotas are checked against,
+// wakes the polls waiting for them on every replica, records the practice
+// goals they meet, uses a streak freeze on a missed yesterday and announces
+// streak milestones. Every way of saving stats ends here, however late they
+// are saved.
+func recordStatsSaved(ctx context.Context, stats ...StatsRaw) {
+	for _, s := range stats {
+		statsSavedTotal.WithLabelValues(extensionLabel(s.ChordExtension)).Inc()
+	}
+	if len(stats) == 0 {
+		return
+	}
+	tenant, userID := tenantFromContext(ctx), userFromContext(ctx)
+	addToQuotaCount(tenant, len(stats))
+	statsSaved.notify(tenant)
+	if redisClient != nil {
+		go publishStatsSaved(redisClient, tenant)
+	}
+	// the write doesn't wait for the rest, and may be over before it is done
+	go markPracticeGoalsMet(withTenant(context.Background(), tenant))
+	if userID == "" {
+		return
+	}
+	go func(ctx context.Context) {
+		useStreakFreeze(ctx)
+		announceStreak(ctx)
+	}(withTenant(withUser(context.Background(), userID), tenant))
+}
+
+// serveMetrics serves the metrics for Prometheus to scrape, on a port of its
+// own so they stay out of the public API
+func serveMetrics(port string, timeouts serverTimeouts) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return newServer(":"+port, mux, timeouts).ListenAndServe()
+}
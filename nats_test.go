@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a NATS server for the tests, greeting and accepting every
+// connection and handing it to the test after the client subscribed to its
+// inbox
+type fakeNATS struct {
+	listener net.Listener
+	conns    chan *fakeNATSConn
+	// refuse answers the connect with -ERR
+	refuse bool
+}
+
+type fakeNATSConn struct {
+	net.Conn
+	r *bufio.Reader
+	// inbox is the subject the client subscribed to, with its wildcard
+	inbox string
+}
+
+func startFakeNATS(t *testing.T, refuse bool) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeNATS{listener: listener, conns: make(chan *fakeNATSConn, 10), refuse: refuse}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.greet(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeNATS) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeNATS) greet(conn net.Conn) {
+	c := &fakeNATSConn{Conn: conn, r: bufio.NewReader(conn)}
+	fmt.Fprint(conn, "INFO {\"headers\":true}\r\n")
+	for _, want := range []string{"CONNECT ", "PING"} {
+		if line := c.line(); !strings.HasPrefix(line, want) {
+			conn.Close()
+			return
+		}
+	}
+	if s.refuse {
+		fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+		conn.Close()
+		return
+	}
+	fmt.Fprint(conn, "PONG\r\n")
+	fields := strings.Fields(c.line())
+	if len(fields) != 3 || fields[0] != "SUB" {
+		conn.Close()
+		return
+	}
+	c.inbox = strings.TrimSuffix(fields[1], "*")
+	s.conns <- c
+}
+
+// line reads the next line the client sent, without its line break
+func (c *fakeNATSConn) line() string {
+	line, _ := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// accept returns the next connection of the client
+func (s *fakeNATS) accept(t *testing.T) *fakeNATSConn {
+	select {
+	case conn := <-s.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client didn't connect")
+		return nil
+	}
+}
+
+func TestReadNATSMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		payload string
+		want    natsMsg
+		wantErr bool
+	}{
+		{name: "message", line: "MSG pct.stats 1 5", payload: "hello\r\n", want: natsMsg{Subject: "pct.stats", Data: []byte("hello")}},
+		{name: "message with a reply", line: "MSG pct.stats 1 _INBOX.x 5", payload: "hello\r\n", want: natsMsg{Subject: "pct.stats", Reply: "_INBOX.x", Data: []byte("hello")}},
+		{name: "empty message", line: "MSG pct.stats 1 0", payload: "\r\n", want: natsMsg{Subject: "pct.stats", Data: []byte{}}},
+		{
+			name:    "status",
+			line:    "HMSG _INBOX.x 1 28 28",
+			payload: "NATS/1.0 404 No Messages\r\n\r\n\r\n",
+			want:    natsMsg{Subject: "_INBOX.x", Status: 404, Data: []byte{}},
+		},
+		{
+			name:    "headers and data",
+			line:    "HMSG pct.stats 1 _INBOX.x 18 23",
+			payload: "NATS/1.0\r\nA: b\r\n\r\nhello\r\n",
+			want:    natsMsg{Subject: "pct.stats", Reply: "_INBOX.x", Data: []byte("hello")},
+		},
+		{name: "missing size", line: "MSG pct.stats 1", wantErr: true},
+		{name: "size not a number", line: "MSG pct.stats 1 five", payload: "hello\r\n", wantErr: true},
+		{name: "short payload", line: "MSG pct.stats 1 10", payload: "hello\r\n", wantErr: true},
+		{name: "header bigger than the message", line: "HMSG pct.stats 1 30 5", payload: "hello\r\n", wantErr: true},
+		{name: "missing header size", line: "HMSG pct.stats 1 5", payload: "hello\r\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := strings.Fields(test.line)
+			msg, err := readNATSMsg(bufio.NewReader(strings.NewReader(test.payload)), fields[0], fields[1:])
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("readNATSMsg() = %+v, want an error", msg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Subject != test.want.Subject || msg.Reply != test.want.Reply || msg.Status != test.want.Status || string(msg.Data) != string(test.want.Data) {
+				t.Errorf("readNATSMsg() = %+v, want %+v", msg, test.want)
+			}
+		})
+	}
+}
+
+func TestDialNATSRefused(t *testing.T) {
+	server := startFakeNATS(t, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := dialNATS(ctx, server.url(), "test")
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("dialNATS() = %v, want the refusal", err)
+	}
+}
+
+func TestNATSPing(t *testing.T) {
+	server := startFakeNATS(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialNATS(ctx, server.url(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	serverConn := server.accept(t)
+
+	fmt.Fprint(serverConn, "PING\r\n")
+	serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if line := serverConn.line(); line != "PONG" {
+		t.Errorf("answered PING with %q, want PONG", line)
+	}
+}
+
+func TestNATSRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// reply answers the request to the reply subject
+		reply   func(conn *fakeNATSConn, reply string)
+		max     int
+		timeout time.Duration
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "reply",
+			reply: func(conn *fakeNATSConn, reply string) {
+				fmt.Fprintf(conn, "MSG %s 1 2\r\nok\r\n", reply)
+			},
+			max:  1,
+			want: []string{"ok"},
+		},
+		{
+			name: "replies up to a status",
+			reply: func(conn *fakeNATSConn, reply string) {
+				fmt.Fprintf(conn, "MSG %s 1 sub 1\r\na\r\nMSG %s 1 sub 1\r\nb\r\n", reply, reply)
+				fmt.Fprintf(conn, "HMSG %s 1 32 32\r\nNATS/1.0 408 Request Timeout\r\n\r\n\r\n", reply)
+			},
+			max:  10,
+			want: []string{"a", "b"},
+		},
+		{
+			name: "replies up to the timeout",
+			reply: func(conn *fakeNATSConn, reply string) {
+				fmt.Fprintf(conn, "MSG %s 1 1\r\na\r\n", reply)
+			},
+			max:     10,
+			timeout: 100 * time.Millisecond,
+			want:    []string{"a"},
+		},
+		{
+			name:    "no reply",
+			reply:   func(conn *fakeNATSConn, reply string) {},
+			max:     1,
+			timeout: 100 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "reply to another request",
+			reply: func(conn *fakeNATSConn, reply string) {
+				fmt.Fprintf(conn, "MSG %s 1 5\r\nstale\r\n", conn.inbox+"999")
+			},
+			max:     1,
+			timeout: 100 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "connection closed",
+			reply: func(conn *fakeNATSConn, reply string) {
+				conn.Close()
+			},
+			max:     1,
+			wantErr: errNATSClosed,
+		},
+		{
+			name: "server error",
+			reply: func(conn *fakeNATSConn, reply string) {
+				fmt.Fprint(conn, "-ERR 'Stale Connection'\r\n")
+			},
+			max:     1,
+			wantErr: errNATSClosed,
+		},
+	}
+	for _, test := range tests {
+		// the fake server's goroutine holds on to it
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeNATS(t, false)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := dialNATS(ctx, server.url(), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			serverConn := server.accept(t)
+			go func() {
+				// PUB subject reply size, then the payload
+				fields := strings.Fields(serverConn.line())
+				serverConn.line()
+				if len(fields) == 4 && fields[0] == "PUB" {
+					test.reply(serverConn, fields[2])
+				}
+			}()
+
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			msgs, err := conn.Request(ctx, "pct.api", []byte("{}"), test.max)
+			if err != test.wantErr {
+				t.Fatalf("Request() failed with %v, want %v", err, test.wantErr)
+			}
+			var got []string
+			for _, msg := range msgs {
+				got = append(got, string(msg.Data))
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Request() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJetStreamReconnects(t *testing.T) {
+	server := startFakeNATS(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s := &jetStream{url: server.url(), name: "test"}
+
+	first, err := s.connection(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	server.accept(t).Close()
+	select {
+	case <-first.closed:
+	case <-ctx.Done():
+		t.Fatal("the client didn't notice the connection closing")
+	}
+	if first.Err() == nil {
+		t.Error("Err() = nil on a closed connection")
+	}
+
+	second, err := s.connection(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	server.accept(t)
+	if second == first || second.Err() != nil {
+		t.Error("connection() didn't dial again after the connection closed")
+	}
+	again, err := s.connection(ctx)
+	if err != nil || again != second {
+		t.Errorf("connection() = %p, %v, want the open connection %p", again, err, second)
+	}
+}
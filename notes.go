@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTags       = 10
+	maxTagLength  = 32
+	maxNoteLength = 500
+	// tagSeparator separates the tags of an answer in a CSV column
+	tagSeparator = ";"
+)
+
+// validateNotes returns what is wrong with the tags and note of an answer,
+// nothing if they are valid
+func validateNotes(stats StatsRaw) []FieldError {
+	var fieldErrors []FieldError
+	if len(stats.Tags) > maxTags {
+		fieldErrors = append(fieldErrors, FieldError{Field: "tags", Message: fmt.Sprintf("can't be more than %d", maxTags)})
+	}
+	for _, tag := range stats.Tags {
+		if !validTag(tag) {
+			fieldErrors = append(fieldErrors, FieldError{Field: "tags", Message: fmt.Sprintf("have to be 1 to %d characters without %s", maxTagLength, tagSeparator)})
+			break
+		}
+	}
+	if utf8.RuneCountInString(stats.Note) > maxNoteLength {
+		fieldErrors = append(fieldErrors, FieldError{Field: "note", Message: fmt.Sprintf("can't be longer than %d characters", maxNoteLength)})
+	}
+	return fieldErrors
+}
+
+// validTag tells if tag, trimmed, can be stored
+func validTag(tag string) bool {
+	tag = strings.TrimSpace(tag)
+	return tag != "" && utf8.RuneCountInString(tag) <= maxTagLength && !strings.Contains(tag, tagSeparator)
+}
+
+// withTidyNotes trims the tags and note of an answer and drops repeated tags,
+// so filtering by a tag finds it however it was typed around
+func withTidyNotes(stats StatsRaw) StatsRaw {
+	stats.Tags = tidyTags(stats.Tags)
+	stats.Note = strings.TrimSpace(stats.Note)
+	return stats
+}
+
+// tidyTags trims tags and drops empty and repeated ones
+func tidyTags(tags []string) []string {
+	var tidy []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tidy = append(tidy, tag)
+		}
+	}
+	return tidy
+}
+
+// parseTags splits the tags of a CSV column
+func parseTags(v string) []string {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	return strings.Split(v, tagSeparator)
+}
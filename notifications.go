@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The events notifications can be sent about
+const (
+	eventStreakRisk   = "streak_risk"
+	eventWeeklyReport = "weekly_report"
+	eventAchievement  = "achievement"
+)
+
+// The channels notifications can be sent through
+const (
+	channelEmail   = "email"
+	channelPush    = "push"
+	channelWebhook = "webhook"
+)
+
+// NotificationPreferences tell which notifications a user gets and how.
+// Every field is optional, a PUT only changes the fields it sends and each
+// of them is replaced as a whole. Fields never set read as the defaults.
+type NotificationPreferences struct {
+	Channels   *NotificationChannels `json:"channels,omitempty" bson:"channels,omitempty"`
+	Events     *NotificationEvents   `json:"events,omitempty" bson:"events,omitempty"`
+	QuietHours *QuietHours           `json:"quiet_hours,omitempty" bson:"quiet_hours,omitempty"`
+	ModifiedAt *time.Time            `json:"modified_at,omitempty" bson:"modified_at,omitempty"`
+}
+
+// NotificationChannels are the ways notifications are sent, every enabled
+// one gets each notification
+type NotificationChannels struct {
+	// Email is only sent to verified addresses
+	Email   bool `json:"email" bson:"email"`
+	Push    bool `json:"push" bson:"push"`
+	Webhook bool `json:"webhook" bson:"webhook"`
+	// WebhookURL is posted the notifications as JSON, required with Webhook
+	WebhookURL string `json:"webhook_url,omitempty" bson:"webhook_url,omitempty"`
+}
+
+// NotificationEvents are what notifications are sent about
+type NotificationEvents struct {
+	StreakRisk   bool `json:"streak_risk" bson:"streak_risk"`
+	WeeklyReport bool `json:"weekly_report" bson:"weekly_report"`
+	Achievements bool `json:"achievements" bson:"achievements"`
+}
+
+// QuietHours are when no notifications are sent, as HH:MM in the user's
+// timezone. The end may be before the start, for quiet hours across
+// midnight.
+type QuietHours struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// defaultNotificationPreferences are emails about every event, without
+// quiet hours
+var defaultNotificationPreferences = NotificationPreferences{
+	Channels: &NotificationChannels{Email: true},
+	Events:   &NotificationEvents{StreakRisk: true, WeeklyReport: true, Achievements: true},
+}
+
+// withDefaults fills in the fields never set
+func (p NotificationPreferences) withDefaults() NotificationPreferences {
+	if p.Channels == nil {
+		p.Channels = defaultNotificationPreferences.Channels
+	}
+	if p.Events == nil {
+		p.Events = defaultNotificationPreferences.Events
+	}
+	return p
+}
+
+// validate returns what is wrong with the preferences, nothing if they are
+// valid
+func (p NotificationPreferences) validate() []FieldError {
+	var fieldErrors []FieldError
+	if p.Channels != nil && (p.Channels.Webhook || p.Channels.WebhookURL != "") {
+		webhook, err := url.Parse(p.Channels.WebhookURL)
+		if err != nil || webhook.Scheme != "https" || webhook.Host == "" || len(p.Channels.WebhookURL) > 2048 {
+			fieldErrors = append(fieldErrors, FieldError{Field: "channels.webhook_url", Message: "has to be an https URL of up to 2048 characters"})
+		}
+	}
+	if p.QuietHours != nil {
+		start, startErr := time.Parse("15:04", p.QuietHours.Start)
+		end, endErr := time.Parse("15:04", p.QuietHours.End)
+		if startErr != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "quiet_hours.start", Message: "has to be a time like 22:00"})
+		}
+		if endErr != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "quiet_hours.end", Message: "has to be a time like 07:00"})
+		}
+		if startErr == nil && endErr == nil && start.Equal(end) {
+			fieldErrors = append(fieldErrors, FieldError{Field: "quiet_hours.end", Message: "can't be the start"})
+		}
+	}
+	return fieldErrors
+}
+
+// channelsFor returns the channels a notification about event is to be sent
+// through at now, in the user's timezone loc. It is none during quiet hours
+// or for events the user turned off. The dispatcher still has to leave out
+// email for users without a verified address.
+func (p NotificationPreferences) channelsFor(event string, now time.Time, loc *time.Location) []string {
+	p = p.withDefaults()
+	wanted := map[string]bool{
+		eventStreakRisk:   p.Events.StreakRisk,
+		eventWeeklyReport: p.Events.WeeklyReport,
+		eventAchievement:  p.Events.Achievements,
+	}
+	if !wanted[event] || p.QuietHours.contains(now.In(loc)) {
+		return nil
+	}
+
+	var channels []string
+	if p.Channels.Email {
+		channels = append(channels, channelEmail)
+	}
+	if p.Channels.Push {
+		channels = append(channels, channelPush)
+	}
+	if p.Channels.Webhook {
+		channels = append(channels, channelWebhook)
+	}
+	return channels
+}
+
+// contains tells if the wall clock time of t is within the quiet hours,
+// nil quiet hours containing no time
+func (q *QuietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	start, startErr := time.Parse("15:04", q.Start)
+	end, endErr := time.Parse("15:04", q.End)
+	if startErr != nil || endErr != nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	from, until := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from < until {
+		return minute >= from && minute < until
+	}
+	return minute >= from || minute < until
+}
+
+func getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	preferences, err := repository.GetNotificationPreferences(r.Context(), userFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, preferences.withDefaults())
+}
+
+func updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var update NotificationPreferences
+	err := decodeRequest(r, &update)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := update.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	now := time.Now()
+	update.ModifiedAt = &now
+	preferences, err := repository.UpdateNotificationPreferences(r.Context(), userFromContext(r.Context()), update)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, preferences.withDefaults())
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// oidcKeysTTL is how long the signing keys of a provider are cached,
+	// unknown key IDs refetch them sooner
+	oidcKeysTTL = time.Hour
+	// oidcKeysMinRefetch keeps ID tokens with made up key IDs from making the
+	// server fetch the keys over and over
+	oidcKeysMinRefetch = time.Minute
+	// oidcClockSkew is how far the provider's clock may be off the server's
+	oidcClockSkew = time.Minute
+)
+
+var errInvalidIDToken = errors.New("invalid ID token")
+
+// oidcProviders are the identity providers users can sign in with, by name,
+// only those with client IDs configured
+var oidcProviders = map[string]*oidcProvider{}
+
+// oidcProvider verifies the ID tokens an identity provider issues to the app.
+// The app signs in with the provider's SDK and hands the ID token over, so the
+// backend never sees the user's credentials or a client secret.
+type oidcProvider struct {
+	name    string
+	issuers []string
+	keysURL string
+	// clientIDs are the app's IDs with the provider, ID tokens have to be
+	// issued to one of them
+	clientIDs []string
+	client    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newGoogleProvider(clientIDs []string) *oidcProvider {
+	return &oidcProvider{
+		name:      "google",
+		issuers:   []string{"https://accounts.google.com", "accounts.google.com"},
+		keysURL:   "https://www.googleapis.com/oauth2/v3/certs",
+		clientIDs: clientIDs,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func newAppleProvider(clientIDs []string) *oidcProvider {
+	return &oidcProvider{
+		name:      "apple",
+		issuers:   []string{"https://appleid.apple.com"},
+		keysURL:   "https://appleid.apple.com/auth/keys",
+		clientIDs: clientIDs,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// idTokenClaims are the claims of an ID token the backend looks at
+type idTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	ExpiresAt     int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	NotBefore     int64        `json:"nbf"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+}
+
+// audience is a single client ID or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// flexibleBool is a boolean that may be given as a string, like Apple does
+// with email_verified
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	*b = flexibleBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// verify checks the signature, issuer, audience and validity period of an ID
+// token and returns its claims. A token with a nonce is only valid along with
+// the nonce the app signed in with, as is or hashed with SHA-256 like Apple's
+// SDK wants it. Errors about the token itself wrap errInvalidIDToken.
+func (p *oidcProvider) verify(ctx context.Context, idToken, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, fmt.Errorf("%w: not a JWT", errInvalidIDToken)
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil || header.Algorithm != "RS256" {
+		return idTokenClaims{}, fmt.Errorf("%w: not signed with RS256", errInvalidIDToken)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("%w: malformed signature", errInvalidIDToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return idTokenClaims{}, fmt.Errorf("%w: wrong signature", errInvalidIDToken)
+	}
+
+	var claims idTokenClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("%w: malformed claims", errInvalidIDToken)
+	}
+	if !containsString(p.issuers, claims.Issuer) {
+		return idTokenClaims{}, fmt.Errorf("%w: issued by %s", errInvalidIDToken, claims.Issuer)
+	}
+	issuedToApp := false
+	for _, clientID := range claims.Audience {
+		issuedToApp = issuedToApp || containsString(p.clientIDs, clientID)
+	}
+	if !issuedToApp {
+		return idTokenClaims{}, fmt.Errorf("%w: issued to another client", errInvalidIDToken)
+	}
+	now := time.Now()
+	if time.Unix(claims.ExpiresAt, 0).Add(oidcClockSkew).Before(now) {
+		return idTokenClaims{}, fmt.Errorf("%w: expired", errInvalidIDToken)
+	}
+	if time.Unix(claims.IssuedAt, 0).Add(-oidcClockSkew).After(now) {
+		return idTokenClaims{}, fmt.Errorf("%w: issued in the future", errInvalidIDToken)
+	}
+	if claims.NotBefore != 0 && time.Unix(claims.NotBefore, 0).Add(-oidcClockSkew).After(now) {
+		return idTokenClaims{}, fmt.Errorf("%w: not valid yet", errInvalidIDToken)
+	}
+	if (claims.Nonce != "" || nonce != "") && !nonceMatches(claims.Nonce, nonce) {
+		return idTokenClaims{}, fmt.Errorf("%w: wrong nonce", errInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return idTokenClaims{}, fmt.Errorf("%w: no subject", errInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// nonceMatches reports whether the nonce claim of an ID token is nonce, or
+// its SHA-256 in hex
+func nonceMatches(claim, nonce string) bool {
+	if claim == "" || nonce == "" {
+		return false
+	}
+	hashed := sha256.Sum256([]byte(nonce))
+	return subtle.ConstantTimeCompare([]byte(claim), []byte(nonce)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(claim), []byte(hex.EncodeToString(hashed[:]))) == 1
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider's signing key with keyID, fetching the keys when
+// they are stale or, since providers rotate them, keyID is unknown
+func (p *oidcProvider) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := p.keys[keyID]
+	sinceFetch := time.Since(p.fetchedAt)
+	if (key != nil && sinceFetch < oidcKeysTTL) || (key == nil && sinceFetch < oidcKeysMinRefetch) {
+		if key == nil {
+			return nil, fmt.Errorf("%w: unknown signing key", errInvalidIDToken)
+		}
+		return key, nil
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		if key != nil {
+			log.Println("Error:", err)
+			return key, nil
+		}
+		return nil, err
+	}
+	p.keys, p.fetchedAt = keys, time.Now()
+	if keys[keyID] == nil {
+		return nil, fmt.Errorf("%w: unknown signing key", errInvalidIDToken)
+	}
+	return keys[keyID], nil
+}
+
+// fetchKeys reads the provider's RSA signing keys from its JWKS, by key ID
+func (p *oidcProvider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.keysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing keys of %s: status %d", p.name, res.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		n, nErr := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(jwk.E)
+		if jwk.KeyType != "RSA" || nErr != nil || eErr != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// oidcSignInHandler trades an ID token of a provider for a session token,
+// creating the user on first sign-in
+func oidcSignInHandler(w http.ResponseWriter, r *http.Request) {
+	provider := oidcProviders[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	failureKeys := authFailureKeys(remoteIP(r), "")
+	if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+		writeBanned(w, bannedFor)
+		return
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Nonce   string `json:"nonce"`
+	}
+	err := decodeRequest(r, &body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if body.IDToken == "" {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "id_token", Message: "is required"})
+		return
+	}
+
+	claims, err := provider.verify(r.Context(), body.IDToken, body.Nonce)
+	if errors.Is(err, errInvalidIDToken) {
+		delayFailure(r.Context(), authFailures.fail(failureKeys))
+		writeProblem(w, problemUnauthorized, "The ID token is invalid: "+strings.TrimPrefix(err.Error(), errInvalidIDToken.Error()+": "))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	now, id := time.Now(), primitive.NewObjectID().Hex()
+	user, err := repository.ProvisionUser(r.Context(), Identity{Provider: provider.name, Subject: claims.Subject}, User{
+		ID:            id,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		CreatedAt:     now,
+		LastLoginAt:   now,
+	})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	welcomeUser(r.Context(), user, user.ID == id)
+	writeSignIn(w, r, user)
+}
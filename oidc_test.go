@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signIDToken returns an ID token of the header and claims, signed with key
+func signIDToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+	signingInput := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// serveJWKS serves the public keys by key ID as a JWKS and counts the
+// fetches
+func serveJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) (*httptest.Server, *int32) {
+	t.Helper()
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		var jwks struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		for keyID, key := range keys {
+			jwks.Keys = append(jwks.Keys, map[string]string{
+				"kty": "RSA",
+				"kid": keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+func TestOIDCVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := serveJWKS(t, map[string]*rsa.PrivateKey{"k1": key})
+
+	now := time.Now()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://accounts.google.com",
+			"sub": "user-1",
+			"aud": "app",
+			"exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(),
+		}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+	hashedNonce := sha256.Sum256([]byte("n-1"))
+
+	// HS256 with the public key as the secret, which a verifier trusting
+	// the token's alg would check against the provider's key
+	publicKey := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	hs256Input := encodeJWTPart(t, map[string]interface{}{"alg": "HS256", "kid": "k1"}) + "." + encodeJWTPart(t, validClaims())
+	mac := hmac.New(sha256.New, publicKey)
+	mac.Write([]byte(hs256Input))
+	hs256 := hs256Input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	// the claims of another user under a valid signature
+	signed := strings.Split(signIDToken(t, key, rs256, validClaims()), ".")
+	tampered := signed[0] + "." + encodeJWTPart(t, with("sub", "user-2")) + "." + signed[2]
+
+	tests := []struct {
+		name    string
+		idToken string
+		nonce   string
+		valid   bool
+	}{
+		{"valid", signIDToken(t, key, rs256, validClaims()), "", true},
+		{"audience list", signIDToken(t, key, rs256, with("aud", []string{"other", "app"})), "", true},
+		{"second issuer", signIDToken(t, key, rs256, with("iss", "accounts.google.com")), "", true},
+		{"within clock skew", signIDToken(t, key, rs256, with("exp", now.Add(-oidcClockSkew/2).Unix())), "", true},
+		{"bad signature", signIDToken(t, otherKey, rs256, validClaims()), "", false},
+		{"tampered claims", tampered, "", false},
+		{"wrong issuer", signIDToken(t, key, rs256, with("iss", "https://evil.example.com")), "", false},
+		{"wrong audience", signIDToken(t, key, rs256, with("aud", "other")), "", false},
+		{"audience list without the app", signIDToken(t, key, rs256, with("aud", []string{"other", "third"})), "", false},
+		{"no audience", signIDToken(t, key, rs256, with("aud", nil)), "", false},
+		{"expired", signIDToken(t, key, rs256, with("exp", now.Add(-2*oidcClockSkew).Unix())), "", false},
+		{"issued in the future", signIDToken(t, key, rs256, with("iat", now.Add(2*oidcClockSkew).Unix())), "", false},
+		{"not valid yet", signIDToken(t, key, rs256, with("nbf", now.Add(2*oidcClockSkew).Unix())), "", false},
+		{"valid from now", signIDToken(t, key, rs256, with("nbf", now.Unix())), "", true},
+		{"no subject", signIDToken(t, key, rs256, with("sub", nil)), "", false},
+		{"nonce", signIDToken(t, key, rs256, with("nonce", "n-1")), "n-1", true},
+		{"hashed nonce", signIDToken(t, key, rs256, with("nonce", hex.EncodeToString(hashedNonce[:]))), "n-1", true},
+		{"wrong nonce", signIDToken(t, key, rs256, with("nonce", "n-1")), "n-2", false},
+		{"nonce not sent", signIDToken(t, key, rs256, with("nonce", "n-1")), "", false},
+		{"no nonce in the token", signIDToken(t, key, rs256, validClaims()), "n-1", false},
+		{"alg none", encodeJWTPart(t, map[string]interface{}{"alg": "none", "kid": "k1"}) + "." + encodeJWTPart(t, validClaims()) + ".", "", false},
+		{"alg HS256", hs256, "", false},
+		{"not a JWT", "abc.def", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newGoogleProvider([]string{"app"})
+			provider.keysURL = server.URL
+
+			claims, err := provider.verify(context.Background(), test.idToken, test.nonce)
+			if test.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Subject != "user-1" {
+					t.Errorf("subject %q, want user-1", claims.Subject)
+				}
+			} else if !errors.Is(err, errInvalidIDToken) {
+				t.Errorf("got %v, want an invalid ID token", err)
+			}
+		})
+	}
+}
+
+// TestOIDCUnknownKey has the provider rotate its keys, tokens signed with
+// the new key refetch the keys, but at most once a minute
+func TestOIDCUnknownKey(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fetches := serveJWKS(t, map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey})
+	claims := map[string]interface{}{
+		"iss": "https://appleid.apple.com",
+		"sub": "user-1",
+		"aud": "app",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		name       string
+		sinceFetch time.Duration
+		keyID      string
+		valid      bool
+		fetches    int32
+	}{
+		{"known key", time.Second, "old", true, 0},
+		{"stale keys", oidcKeysTTL, "old", true, 1},
+		{"rotated key", oidcKeysMinRefetch, "new", true, 1},
+		{"rotated key just after a fetch", time.Second, "new", false, 0},
+		{"key the provider doesn't have", oidcKeysMinRefetch, "gone", false, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newAppleProvider([]string{"app"})
+			provider.keysURL = server.URL
+			provider.keys = map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}
+			provider.fetchedAt = time.Now().Add(-test.sinceFetch)
+			key := oldKey
+			if test.keyID != "old" {
+				key = newKey
+			}
+			before := atomic.LoadInt32(fetches)
+
+			_, err := provider.verify(context.Background(), signIDToken(t, key, map[string]interface{}{"alg": "RS256", "kid": test.keyID}, claims), "")
+			if test.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !test.valid && !errors.Is(err, errInvalidIDToken) {
+				t.Errorf("got %v, want an invalid ID token", err)
+			}
+			if fetched := atomic.LoadInt32(fetches) - before; fetched != test.fetches {
+				t.Errorf("fetched the keys %d times, want %d", fetched, test.fetches)
+			}
+		})
+	}
+}
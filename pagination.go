@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// defaultPageLimit is the size of a page when only the cursor is given
+	defaultPageLimit = 100
+	// maxPageLimit bounds the size of a page, bigger limits are lowered
+	maxPageLimit = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Page is the envelope of every paged list. Lists are paged when the limit
+// or cursor query param is given, and answered whole as before otherwise.
+type Page struct {
+	Data interface{} `json:"data"`
+	// NextCursor is the cursor query param of the next page, left out on the
+	// last one
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total counts the items of all pages
+	Total int `json:"total"`
+}
+
+// pageRequest is the page a client asked for. The cursor is opaque to
+// clients, every list has its own kind.
+type pageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+// parsePageRequest returns the page asked for by the query params of r,
+// false if the whole list is asked for, and what is wrong with the params
+func parsePageRequest(r *http.Request) (pageRequest, bool, []FieldError) {
+	query := r.URL.Query()
+	page := pageRequest{Cursor: query.Get("cursor"), Limit: defaultPageLimit}
+	limitParam := query.Get("limit")
+	if page.Cursor == "" && limitParam == "" {
+		return page, false, nil
+	}
+	if limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			return page, true, []FieldError{{Field: "limit", Message: "has to be a positive whole number"}}
+		}
+		page.Limit = limit
+		if page.Limit > maxPageLimit {
+			page.Limit = maxPageLimit
+		}
+	}
+	return page, true, nil
+}
+
+// offsetBounds returns the part of a list of total items the page covers,
+// for lists paged by position, and the cursor of the next page, empty if
+// this is the last one
+func (p pageRequest) offsetBounds(total int) (start, end int, next string, err error) {
+	if p.Cursor != "" {
+		start, err = strconv.Atoi(p.Cursor)
+		if err != nil || start < 0 {
+			return 0, 0, "", errInvalidCursor
+		}
+	}
+	if start > total {
+		start = total
+	}
+	end = start + p.Limit
+	if end >= total {
+		return start, total, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+// writePage answers with a page of a list, linking the first and next pages
+// as of RFC 5988 for clients that follow links instead of reading the cursor
+func writePage(w http.ResponseWriter, r *http.Request, request pageRequest, page Page) {
+	links := pageLink(r, request.Limit, "", "first")
+	if page.NextCursor != "" {
+		links += ", " + pageLink(r, request.Limit, page.NextCursor, "next")
+	}
+	w.Header().Set("Link", links)
+	writeResponse(w, r, page)
+}
+
+// pageLink links the page of r at cursor, keeping the other query params
+func pageLink(r *http.Request, limit int, cursor, rel string) string {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return "<" + link.String() + `>; rel="` + rel + `"`
+}
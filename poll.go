@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+	// pollRecheckInterval is how often a waiting poll looks for changes on
+	// its own, for the ones it isn't woken for, like stats saved by another
+	// replica or deletions
+	pollRecheckInterval = 2 * time.Second
+	// pollDeadlineMargin is left of the timeout of the request to answer in
+	pollDeadlineMargin = time.Second
+)
+
+// changeNotifier wakes everyone waiting for a change of a tenant at once
+type changeNotifier struct {
+	mu      sync.Mutex
+	changed map[string]chan struct{}
+}
+
+// statsSaved wakes the polls waiting for new stats of a tenant whenever
+// stats of it are saved
+var statsSaved = &changeNotifier{changed: make(map[string]chan struct{})}
+
+// wait returns a channel that is closed on the next change of the tenant,
+// of any tenant for allTenants
+func (n *changeNotifier) wait(tenant string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	changed, exists := n.changed[tenant]
+	if !exists {
+		changed = make(chan struct{})
+		n.changed[tenant] = changed
+	}
+	return changed
+}
+
+func (n *changeNotifier) notify(tenant string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, waiting := range []string{tenant, allTenants} {
+		if changed, exists := n.changed[waiting]; exists {
+			close(changed)
+			delete(n.changed, waiting)
+		}
+	}
+}
+
+// pollStatsHandler is the sync for clients that can't keep a connection open
+// to be pushed changes. It answers right away if there are changes after the
+// since cursor, and otherwise holds the request until there are or the
+// timeout passes, answering with no changes and the same cursor then.
+func pollStatsHandler(w http.ResponseWriter, r *http.Request) {
+	since, valid := parseSyncCursor(r)
+	if !valid {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "since", Message: "has to be a cursor returned by an earlier sync"})
+		return
+	}
+	timeout := defaultPollTimeout
+	if timeoutParam := r.URL.Query().Get("timeout"); timeoutParam != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutParam)
+		if err != nil || timeout < 0 {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "timeout", Message: "has to be a duration like 30s"})
+			return
+		}
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+	// answering with no changes beats being cut off by the route timeout
+	if deadline, exists := r.Context().Deadline(); exists && time.Until(deadline)-pollDeadlineMargin < timeout {
+		timeout = time.Until(deadline) - pollDeadlineMargin
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+	recheck := time.NewTicker(pollRecheckInterval)
+	defer recheck.Stop()
+	for {
+		// waiting for the change before looking misses none in between
+		saved := statsSaved.wait(tenantFromContext(r.Context()))
+		changes, err := syncChanges(r.Context(), since, defaultSyncLimit)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if len(changes.Stats)+len(changes.Deleted) > 0 {
+			writeResponse(w, r, changes)
+			return
+		}
+
+		select {
+		case <-saved:
+		case <-recheck.C:
+		case <-expired.C:
+			writeResponse(w, r, changes)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// parseSyncCursor returns the since cursor of a sync, 0 if there is none,
+// false if it isn't one
+func parseSyncCursor(r *http.Request) (int64, bool) {
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam == "" {
+		return 0, true
+	}
+	since, err := strconv.ParseInt(sinceParam, 10, 64)
+	return since, err == nil && since >= 0
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	maxGoalAnswers = 1000
+	maxGoalMinutes = 240
+	// practiceSessionMaxAge is how long after their start open practice
+	// sessions are still checked for their goal as answers are saved, older
+	// ones are taken as abandoned
+	practiceSessionMaxAge = 24 * time.Hour
+	// minAccuracyGoalAnswers is how many answers an accuracy goal is judged
+	// on at least, so a right first answer doesn't meet it
+	minAccuracyGoalAnswers = 20
+)
+
+// The types of the events pushed over the live WebSocket of a practice
+// session
+const (
+	practiceProgressEvent = "progress"
+	practiceGoalMetEvent  = "goal_met"
+	practiceEndedEvent    = "ended"
+)
+
+var errOriginNotAllowed = errors.New("origin not allowed")
+
+// PracticeGoal is what a practice session sets out to do, one of a number
+// of answers, minutes of practice or an accuracy
+type PracticeGoal struct {
+	Answers int `json:"answers,omitempty" bson:"answers,omitempty"`
+	Minutes int `json:"minutes,omitempty" bson:"minutes,omitempty"`
+	// Accuracy is the part of the answers to get right, from 0 to 1, over
+	// at least minAccuracyGoalAnswers answers
+	Accuracy float64 `json:"accuracy,omitempty" bson:"accuracy,omitempty"`
+}
+
+// PracticeSession is a stretch of practice of a user with a goal. Its
+// answers are the user's answers created from its start until its end.
+type PracticeSession struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Tenant    string             `json:"-" bson:"tenant"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Goal      PracticeGoal       `json:"goal" bson:"goal"`
+	StartedAt time.Time          `json:"started_at" bson:"started_at"`
+	EndedAt   *time.Time         `json:"ended_at,omitempty" bson:"ended_at,omitempty"`
+	// GoalMetAt is when the answer meeting the goal was saved, once it is
+	// the goal stays met
+	GoalMetAt *time.Time `json:"goal_met_at,omitempty" bson:"goal_met_at,omitempty"`
+	// Progress is worked out from the answers whenever the session is read
+	Progress      *PracticeProgress `json:"progress,omitempty" bson:"-"`
+	SchemaVersion int               `json:"-" bson:"schema_version"`
+}
+
+// PracticeProgress is how far a practice session is along its goal
+type PracticeProgress struct {
+	Answers int `json:"answers"`
+	Correct int `json:"correct"`
+	// Accuracy is 0 until there are answers
+	Accuracy float64 `json:"accuracy"`
+	// Minutes is the time from the start of the session to its latest
+	// answer
+	Minutes float64 `json:"minutes"`
+	// Done is the part of the goal done, from 0 to 1
+	Done    float64 `json:"done"`
+	GoalMet bool    `json:"goal_met"`
+}
+
+// PracticeEvent is pushed over the live WebSocket of a practice session
+type PracticeEvent struct {
+	Type     string           `json:"type"`
+	Progress PracticeProgress `json:"progress"`
+}
+
+// validate returns what is wrong with the goal, nothing if it is valid
+func (g PracticeGoal) validate() []FieldError {
+	set := 0
+	var fieldErrors []FieldError
+	if g.Answers != 0 {
+		set++
+		if g.Answers < 1 || g.Answers > maxGoalAnswers {
+			fieldErrors = append(fieldErrors, FieldError{Field: "goal.answers", Message: fmt.Sprintf("has to be from 1 to %d", maxGoalAnswers)})
+		}
+	}
+	if g.Minutes != 0 {
+		set++
+		if g.Minutes < 1 || g.Minutes > maxGoalMinutes {
+			fieldErrors = append(fieldErrors, FieldError{Field: "goal.minutes", Message: fmt.Sprintf("has to be from 1 to %d", maxGoalMinutes)})
+		}
+	}
+	if g.Accuracy != 0 {
+		set++
+		if g.Accuracy < 0 || g.Accuracy > 1 {
+			fieldErrors = append(fieldErrors, FieldError{Field: "goal.accuracy", Message: "has to be from 0 to 1"})
+		}
+	}
+	if set != 1 {
+		return []FieldError{{Field: "goal", Message: "has to set one of answers, minutes and accuracy"}}
+	}
+	return fieldErrors
+}
+
+// progress returns how far along the goal the answers of the session are
+func (s PracticeSession) progress(ctx context.Context) (PracticeProgress, error) {
+	filter := StatsFilter{Since: s.StartedAt}
+	if s.EndedAt != nil {
+		filter.Until = *s.EndedAt
+	}
+	var progress PracticeProgress
+	var latest time.Time
+	err := repository.EachStats(ctx, filter, func(stats StatsRaw) error {
+		progress.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			progress.Correct++
+		}
+		if stats.CreatedAt.After(latest) {
+			latest = stats.CreatedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return PracticeProgress{}, err
+	}
+
+	if progress.Answers > 0 {
+		progress.Accuracy = float64(progress.Correct) / float64(progress.Answers)
+		progress.Minutes = latest.Sub(s.StartedAt).Minutes()
+	}
+	switch {
+	case s.Goal.Answers > 0:
+		progress.Done = float64(progress.Answers) / float64(s.Goal.Answers)
+	case s.Goal.Minutes > 0:
+		progress.Done = progress.Minutes / float64(s.Goal.Minutes)
+	case s.Goal.Accuracy > 0:
+		progress.Done = math.Min(float64(progress.Answers)/minAccuracyGoalAnswers, progress.Accuracy/s.Goal.Accuracy)
+	}
+	progress.Done = math.Min(progress.Done, 1)
+	progress.GoalMet = progress.Done >= 1 || s.GoalMetAt != nil
+	if progress.GoalMet {
+		progress.Done = 1
+	}
+	return progress, nil
+}
+
+// practiceSession returns the practice session with the id along with its
+// progress
+func practiceSession(ctx context.Context, id primitive.ObjectID) (PracticeSession, error) {
+	session, err := repository.PracticeSession(ctx, id)
+	if err != nil {
+		return PracticeSession{}, err
+	}
+	progress, err := session.progress(ctx)
+	if err != nil {
+		return PracticeSession{}, err
+	}
+	session.Progress = &progress
+	return session, nil
+}
+
+// markPracticeGoalsMet records the goals of the open practice sessions of
+// the tenant in ctx met by the answers saved so far. It runs after answers
+// are saved, failures are only logged.
+func markPracticeGoalsMet(ctx context.Context) {
+	now := time.Now()
+	sessions, err := repository.OpenPracticeSessions(ctx, now.Add(-practiceSessionMaxAge))
+	if err != nil {
+		log.Println("Failed to look for met practice goals! Error:", err)
+		return
+	}
+	for _, session := range sessions {
+		progress, err := session.progress(ctx)
+		if err == nil && progress.GoalMet {
+			err = repository.MarkPracticeGoalMet(ctx, session.ID, now)
+		}
+		if err != nil {
+			log.Println("Failed to record a met practice goal! Error:", err)
+		}
+	}
+}
+
+// startPracticeSessionHandler starts a practice session with the goal posted
+func startPracticeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var session PracticeSession
+	err := decodeRequest(r, &session)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := session.Goal.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	session = PracticeSession{
+		ID:        primitive.NewObjectID(),
+		UserID:    userFromContext(r.Context()),
+		Goal:      session.Goal,
+		StartedAt: time.Now(),
+		Progress:  &PracticeProgress{},
+	}
+	err = repository.SavePracticeSession(r.Context(), session)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponseStatus(w, r, http.StatusCreated, session)
+}
+
+func getPracticeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	session, err := practiceSession(r.Context(), id)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, session)
+}
+
+// endPracticeSessionHandler ends a practice session, answers given after it
+// no longer count towards its goal. Ending it again changes nothing.
+func endPracticeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	err = repository.EndPracticeSession(r.Context(), id, time.Now())
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	session, err := practiceSession(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, session)
+}
+
+// livePracticeSessionHandler upgrades to a WebSocket pushing the progress of
+// a practice session as answers come in, until the session ends or the
+// client goes away. Browsers are held to the CORS origins, apps send no
+// origin.
+func livePracticeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	_, err = repository.PracticeSession(r.Context(), id)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if origin := r.Header.Get("Origin"); origin != "" && !allowCORSOrigin(r, origin) {
+				return errOriginNotAllowed
+			}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			pushPracticeProgress(r.Context(), conn, id)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// pushPracticeProgress sends the progress of the practice session with the
+// id whenever it changes, looking for it whenever stats are saved like the
+// polls do
+func pushPracticeProgress(ctx context.Context, conn *websocket.Conn, id primitive.ObjectID) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// the server's timeouts are for requests, not for connections held open
+	conn.SetDeadline(time.Time{})
+	go func() {
+		// the client sends nothing, reading only tells when it goes away
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	recheck := time.NewTicker(pollRecheckInterval)
+	defer recheck.Stop()
+	var sent *PracticeProgress
+	for {
+		// waiting for the change before looking misses none in between
+		saved := statsSaved.wait(tenantFromContext(ctx))
+		session, err := practiceSession(ctx, id)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("Failed to push the progress of a practice session! Error:", err)
+			}
+			return
+		}
+
+		progress := *session.Progress
+		var events []PracticeEvent
+		if sent == nil || progress != *sent {
+			events = append(events, PracticeEvent{Type: practiceProgressEvent, Progress: progress})
+		}
+		if progress.GoalMet && (sent == nil || !sent.GoalMet) {
+			events = append(events, PracticeEvent{Type: practiceGoalMetEvent, Progress: progress})
+		}
+		if session.EndedAt != nil {
+			events = append(events, PracticeEvent{Type: practiceEndedEvent, Progress: progress})
+		}
+		for _, event := range events {
+			if websocket.JSON.Send(conn, event) != nil {
+				return
+			}
+		}
+		if session.EndedAt != nil {
+			return
+		}
+		sent = &progress
+
+		select {
+		case <-saved:
+		case <-recheck.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
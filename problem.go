@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const contentTypeProblem = "application/problem+json"
+
+// Problem is the body of every error response, as of RFC 7807. Type tells
+// problems apart, Title is the same for every problem of a type and Detail
+// explains this one.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Code is the type as one of a stable set of codes, for clients to
+	// branch on
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+	// Errors tells which parts of the request are invalid
+	Errors []FieldError `json:"errors,omitempty"`
+	// Current is the stored resource a conditional write didn't match
+	Current interface{} `json:"current,omitempty"`
+}
+
+// FieldError is a single invalid field or query param, or an invalid answer
+// of an import
+type FieldError struct {
+	// Field is named like in JSON, e.g. variants[1].weight
+	Field string `json:"field,omitempty"`
+	// Row is the answer of an import it is about, counting from 1
+	Row     int    `json:"row,omitempty"`
+	Message string `json:"message"`
+}
+
+// problemType is a kind of problem, its name becomes the type URI
+type problemType struct {
+	name   string
+	code   string
+	title  string
+	status int
+}
+
+// The type URIs are relative, they are documented with the Problem schema in
+// openapi.yaml. The codes are part of the API, once released they must not
+// change.
+var (
+	problemInvalidRequest       = problemType{"invalid-request", "invalid_request", "The request is invalid", http.StatusBadRequest}
+	problemInvalidChord         = problemType{"invalid-chord", "invalid_chord", "The chord of the answer is invalid", http.StatusBadRequest}
+	problemUnauthorized         = problemType{"unauthorized", "unauthorized", "The auth token is missing or wrong", http.StatusUnauthorized}
+	problemQuotaExceeded        = problemType{"quota-exceeded", "quota_exceeded", "The answer quota is used up", http.StatusForbidden}
+	problemSignInRequired       = problemType{"sign-in-required", "sign_in_required", "Only signed in users can do this", http.StatusForbidden}
+	problemAccountLocked        = problemType{"account-locked", "account_locked", "The account is scheduled for deletion", http.StatusForbidden}
+	problemTooManyAuthFailures  = problemType{"too-many-auth-failures", "too_many_auth_failures", "Auth failed too often, try again later", http.StatusTooManyRequests}
+	problemRateLimited          = problemType{"rate-limited", "rate_limited", "Too many requests, try again later", http.StatusTooManyRequests}
+	problemNotFound             = problemType{"not-found", "not_found", "There is no such resource", http.StatusNotFound}
+	problemMethodNotAllowed     = problemType{"method-not-allowed", "method_not_allowed", "The resource doesn't support the method", http.StatusMethodNotAllowed}
+	problemNotAcceptable        = problemType{"not-acceptable", "not_acceptable", "None of the accepted formats can be served", http.StatusNotAcceptable}
+	problemVersionConflict      = problemType{"version-conflict", "duplicate_submission", "The answer was submitted with another version in the meantime", http.StatusConflict}
+	problemEmailVerified        = problemType{"email-verified", "email_verified", "The email is verified already", http.StatusConflict}
+	problemTwoFactorEnabled     = problemType{"two-factor-enabled", "two_factor_enabled", "Two-factor auth is enabled already", http.StatusConflict}
+	problemJobRunning           = problemType{"job-running", "job_running", "The job is running already", http.StatusConflict}
+	problemDryRunOutdated       = problemType{"dry-run-outdated", "dry_run_outdated", "The dry run is outdated", http.StatusConflict}
+	problemSettingsChanged      = problemType{"settings-changed", "settings_changed", "The settings were changed in the meantime", http.StatusConflict}
+	problemTooLarge             = problemType{"too-large", "too_large", "The request is too large", http.StatusRequestEntityTooLarge}
+	problemUnsupportedMediaType = problemType{"unsupported-media-type", "unsupported_media_type", "The body is in a format that isn't supported", http.StatusUnsupportedMediaType}
+	problemInvalidImport        = problemType{"invalid-import", "invalid_import", "Some answers of the import are invalid", http.StatusUnprocessableEntity}
+	problemIntegrationFailed    = problemType{"integration-failed", "integration_failed", "The connected service refused the request", http.StatusBadGateway}
+	problemInternal             = problemType{"internal", "internal_error", "Something went wrong on the server", http.StatusInternalServerError}
+	problemUnavailable          = problemType{"unavailable", "storage_unavailable", "The database is unavailable", http.StatusServiceUnavailable}
+	problemOverloaded           = problemType{"overloaded", "overloaded", "Too many requests are being served", http.StatusServiceUnavailable}
+	problemMaintenance          = problemType{"maintenance", "maintenance", "Down for maintenance", http.StatusServiceUnavailable}
+	problemTimeout              = problemType{"timeout", "timeout", "The request took too long", http.StatusServiceUnavailable}
+)
+
+// writeProblem answers with a problem of type t. Problems are always JSON,
+// also to clients that asked for MessagePack.
+func writeProblem(w http.ResponseWriter, t problemType, detail string, errors ...FieldError) {
+	body, _ := json.Marshal(Problem{
+		Type:   "/problems/" + t.name,
+		Title:  t.title,
+		Status: t.status,
+		Code:   t.code,
+		Detail: detail,
+		Errors: errors,
+	})
+
+	w.Header().Set("Content-Type", contentTypeProblem)
+	w.WriteHeader(t.status)
+	w.Write(body)
+}
+
+// writeConflict answers with a problem of type t that carries the stored
+// resource, so the client can merge its change without fetching it again
+func writeConflict(w http.ResponseWriter, t problemType, current interface{}) {
+	body, _ := json.Marshal(Problem{
+		Type:    "/problems/" + t.name,
+		Title:   t.title,
+		Status:  t.status,
+		Code:    t.code,
+		Current: current,
+	})
+
+	w.Header().Set("Content-Type", contentTypeProblem)
+	w.WriteHeader(t.status)
+	w.Write(body)
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, problemNotFound, "")
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, problemMethodNotAllowed, "")
+}
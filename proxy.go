@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks of the reverse proxies in front of the
+// API, the only ones whose forwarding headers are believed
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses CIDRs, or single IPs
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q isn't a CIDR or IP", cidr)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (t trustedProxies) contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustProxies replaces the address of requests from a trusted proxy with
+// the one of the client it forwarded the request for. Every proxy appends
+// the address it got the request from to X-Forwarded-For, so the client is
+// the last address that isn't a trusted proxy; what comes before it was
+// sent by the client and could be anything. X-Real-IP is used by proxies
+// that don't send X-Forwarded-For. Requests over a unix socket come from a
+// proxy on the same host and are trusted too. Requests from anywhere else
+// keep the address they came from, whatever headers they send.
+func TrustProxies(proxies trustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer := net.ParseIP(remoteIP(r))
+			if fromUnixSocket(r) || (peer != nil && proxies.contains(peer)) {
+				if client := forwardedClient(r, proxies); client != nil {
+					r.RemoteAddr = client.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the address of the client a trusted proxy
+// forwarded r for, nil if the headers don't tell
+func forwardedClient(r *http.Request, proxies trustedProxies) net.IP {
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			// nothing before an address that is made up can be believed
+			return nil
+		}
+		if i == 0 || !proxies.contains(ip) {
+			return ip
+		}
+	}
+	return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestForwardedClient(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{name: "no headers", want: ""},
+		{name: "client alone", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "client behind trusted proxies", forwarded: []string{"203.0.113.7, 10.0.0.2, 192.168.1.1"}, want: "203.0.113.7"},
+		{name: "spoofed entry before the client", forwarded: []string{"198.51.100.1, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "spoofed header before the proxy's", forwarded: []string{"198.51.100.1", "203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "spoofed trusted address before the client", forwarded: []string{"10.0.0.9, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "unparseable entry before the client", forwarded: []string{"bogus, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "unparseable entry after the client", forwarded: []string{"203.0.113.7, bogus, 10.0.0.2"}, want: ""},
+		{name: "unparseable entry last", forwarded: []string{"203.0.113.7, bogus"}, realIP: "203.0.113.9", want: ""},
+		{name: "only trusted proxies", forwarded: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "spaces around entries", forwarded: []string{" 203.0.113.7 ,10.0.0.2 "}, want: "203.0.113.7"},
+		{name: "IPv6 client", forwarded: []string{"2001:db8::1, 10.0.0.2"}, want: "2001:db8::1"},
+		{name: "X-Real-IP alone", realIP: "203.0.113.9", want: "203.0.113.9"},
+		{name: "X-Forwarded-For over X-Real-IP", forwarded: []string{"203.0.113.7"}, realIP: "203.0.113.9", want: "203.0.113.7"},
+		{name: "unparseable X-Real-IP", realIP: "bogus", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, header := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+
+			got := ""
+			if client := forwardedClient(r, proxies); client != nil {
+				got = client.String()
+			}
+			if got != test.want {
+				t.Errorf("forwardedClient() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// statsQueue holds the stats written while Mongo is unavailable, nil when
+// disabled
+var statsQueue *writeQueue
+
+// errQueued means the stats are accepted, but saved later
+var errQueued = errors.New("stats queued to be saved later")
+
+var errQueueFull = errors.New("write queue is full")
+
+const (
+	queueMinBackoff = time.Second
+	queueMaxBackoff = 30 * time.Second
+)
+
+// statsCaller is who stats are saved on behalf of
+type statsCaller struct {
+	tenant, user string
+}
+
+// context returns the context stats of the caller are saved in
+func (c statsCaller) context() context.Context {
+	return withTenant(withUser(context.Background(), c.user), c.tenant)
+}
+
+// queuedStats are stats waiting to be saved
+type queuedStats struct {
+	statsCaller
+	stats StatsRaw
+}
+
+// writeQueue keeps stats that couldn't be saved because Mongo was
+// unavailable, and saves them in order once it is back. It lives in memory
+// and is bounded, so stats queued when the server stops are lost, and stats
+// beyond its size are refused.
+type writeQueue struct {
+	mu    sync.Mutex
+	items []queuedStats
+	size  int
+	// wake is signaled when the queue goes from empty to non-empty
+	wake chan struct{}
+}
+
+func newWriteQueue(size int) *writeQueue {
+	return &writeQueue{size: size, wake: make(chan struct{}, 1)}
+}
+
+// Push queues stats on behalf of the tenant and user of ctx
+func (q *writeQueue) Push(ctx context.Context, stats StatsRaw) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) >= q.size {
+		return errQueueFull
+	}
+	q.items = append(q.items, queuedStats{statsCaller: statsCaller{tenant: tenant, user: userFromContext(ctx)}, stats: stats})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *writeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+func (q *writeQueue) peek() (queuedStats, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return queuedStats{}, false
+	}
+	return q.items[0], true
+}
+
+func (q *writeQueue) pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items = q.items[1:]
+}
+
+// Run saves the queued stats as they come in, backing off while Mongo stays
+// unavailable. Stats that fail for any other reason are logged and dropped,
+// since retrying won't help them.
+func (q *writeQueue) Run() {
+	backoff := queueMinBackoff
+	for {
+		item, queued := q.peek()
+		if !queued {
+			<-q.wake
+			continue
+		}
+
+		ctx := item.context()
+		// queued stats are writes too, they wait for maintenance to end
+		if _, enabled := inMaintenance(ctx); enabled {
+			time.Sleep(queueMinBackoff)
+			continue
+		}
+		err := checkQuota(ctx)
+		if err == nil && !item.stats.ID.IsZero() {
+			// batched stats keep the id they were acknowledged with
+			err = repository.InsertStats(ctx, []StatsRaw{item.stats})
+		} else if err == nil {
+			_, err = repository.SaveStats(ctx, item.stats, anyVersion)
+		}
+		if unavailable(err) {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > queueMaxBackoff {
+				backoff = queueMaxBackoff
+			}
+			continue
+		}
+
+		backoff = queueMinBackoff
+		q.pop()
+		if err != nil {
+			statsDroppedTotal.Inc()
+			log.Println("Dropped queued stats! Error:", err)
+			reportError(ctx, err)
+			continue
+		}
+		recordStatsSaved(ctx, item.stats)
+		if aggregateCache != nil {
+			aggregateCache.Invalidate()
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// practiceSessionGap is the longest pause between two answers of the same
+// practice session, a longer one starts a new session
+const practiceSessionGap = 10 * time.Minute
+
+// PersonalRecords are the bests of the answers so far. Records without any
+// answers to set them are left out.
+type PersonalRecords struct {
+	// FastestByChord has the fastest right answer of every chord answered
+	// right, by chord name
+	FastestByChord []FastestAnswer `json:"fastest_by_chord"`
+	BestDay        *BestDay        `json:"best_day,omitempty"`
+	LongestSession *LongestSession `json:"longest_session,omitempty"`
+	LongestStreak  *LongestStreak  `json:"longest_streak,omitempty"`
+}
+
+// FastestAnswer is the fastest right answer of a chord
+type FastestAnswer struct {
+	ChordName                  string    `json:"chord_name"`
+	AnswerDurationMilliSeconds int       `json:"answer_duration_millis"`
+	AnsweredAt                 time.Time `json:"answered_at"`
+}
+
+// BestDay is the day with the most answers, the first of them if tied
+type BestDay struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// LongestSession is the longest practice session, from its first answer to
+// its last
+type LongestSession struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Answers         int       `json:"answers"`
+}
+
+// LongestStreak is the most days in a row with answers, the first of them if
+// tied. Days kept by a pause or a freeze carry it on without adding to it.
+type LongestStreak struct {
+	Days  int    `json:"days"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// personalRecords returns the records of the stored and archived stats on the
+// instrument of ctx, with days in loc
+func personalRecords(ctx context.Context, loc *time.Location) (PersonalRecords, error) {
+	fastest := make(map[string]FastestAnswer)
+	countByDay := make(map[string]int)
+	var answeredAt []time.Time
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return PersonalRecords{}, err
+	}
+	collect := func(stats StatsRaw) error {
+		stats = aliases.stats(stats)
+		answeredAt = append(answeredAt, stats.CreatedAt)
+		countByDay[stats.CreatedAt.In(loc).Format("2006-01-02")]++
+		if stats.Correct != nil && !*stats.Correct {
+			return nil
+		}
+		best, exists := fastest[stats.ChordName]
+		if !exists || stats.AnswerDurationMilliSeconds < best.AnswerDurationMilliSeconds ||
+			stats.AnswerDurationMilliSeconds == best.AnswerDurationMilliSeconds && stats.CreatedAt.Before(best.AnsweredAt) {
+			fastest[stats.ChordName] = FastestAnswer{
+				ChordName:                  stats.ChordName,
+				AnswerDurationMilliSeconds: stats.AnswerDurationMilliSeconds,
+				AnsweredAt:                 stats.CreatedAt,
+			}
+		}
+		return nil
+	}
+	filter := StatsFilter{Instrument: instrumentFromContext(ctx)}
+	err = repository.EachArchivedStats(ctx, filter, collect)
+	if err != nil {
+		return PersonalRecords{}, err
+	}
+	err = repository.EachStats(ctx, filter, collect)
+	if err != nil {
+		return PersonalRecords{}, err
+	}
+
+	records := PersonalRecords{FastestByChord: []FastestAnswer{}}
+	for _, answer := range fastest {
+		records.FastestByChord = append(records.FastestByChord, answer)
+	}
+	sort.Slice(records.FastestByChord, func(i, j int) bool {
+		return records.FastestByChord[i].ChordName < records.FastestByChord[j].ChordName
+	})
+	kept, err := keptDays(ctx)
+	if err != nil {
+		return PersonalRecords{}, err
+	}
+	records.BestDay, records.LongestStreak = dayRecords(countByDay, kept)
+	records.LongestSession = longestSession(answeredAt)
+	return records, nil
+}
+
+// dayRecords returns the day with the most answers and the longest streak
+// of days with answers, carried on over the days kept by a pause or a
+// freeze, nil if there are no answers
+func dayRecords(countByDay map[string]int, kept map[string]bool) (*BestDay, *LongestStreak) {
+	days := make([]string, 0, len(countByDay))
+	for day := range countByDay {
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, nil
+	}
+	sort.Strings(days)
+
+	best := &BestDay{}
+	longest := &LongestStreak{}
+	var current LongestStreak
+	var previous time.Time
+	for _, day := range days {
+		if countByDay[day] > best.Count {
+			best = &BestDay{Day: day, Count: countByDay[day]}
+		}
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			continue
+		}
+		if current.Days > 0 && keptBetween(previous, date, kept) {
+			current.Days++
+			current.End = day
+		} else {
+			current = LongestStreak{Days: 1, Start: day, End: day}
+		}
+		if current.Days > longest.Days {
+			*longest = current
+		}
+		previous = date
+	}
+	return best, longest
+}
+
+// longestSession returns the longest practice session of the answers at
+// answeredAt, nil if there are no answers
+func longestSession(answeredAt []time.Time) *LongestSession {
+	if len(answeredAt) == 0 {
+		return nil
+	}
+	sort.Slice(answeredAt, func(i, j int) bool {
+		return answeredAt[i].Before(answeredAt[j])
+	})
+
+	current := LongestSession{Start: answeredAt[0], End: answeredAt[0], Answers: 1}
+	longest := current
+	for _, at := range answeredAt[1:] {
+		if at.Sub(current.End) > practiceSessionGap {
+			current = LongestSession{Start: at, End: at, Answers: 1}
+		} else {
+			current.End = at
+			current.Answers++
+		}
+		// the longest session keeps counting its answers while it goes on
+		if current.End.Sub(current.Start) > longest.End.Sub(longest.Start) || current.Start.Equal(longest.Start) {
+			longest = current
+		}
+	}
+	longest.DurationSeconds = longest.End.Sub(longest.Start).Seconds()
+	return &longest
+}
+
+// getRecordsHandler reports the personal records, counting days in the
+// timezone query param if given, otherwise the user's timezone
+func getRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	loc, err := userLocation(
+		r.Context(),
+		userFromContext(r.Context()),
+		r.URL.Query().Get("timezone"),
+	)
+	if err == errInvalidTimezone {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "timezone", Message: "isn't an IANA timezone"})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	records, err := personalRecords(r.Context(), loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, records)
+}
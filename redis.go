@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var redisClient *redis.Client
+
+const redisCacheGenerationKey = "cache:aggregate:generation"
+
+// redisStatsSavedChannel carries the tenants whose stats were saved, so
+// the polls and practice sockets waiting on other replicas wake up, and so
+// do the ones waiting for stats saved by a worker
+const redisStatsSavedChannel = "stats:saved"
+
+// redisCache shares cached aggregation responses between replicas. Instead
+// of scanning for keys on invalidation every entry is namespaced by a
+// generation counter, and invalidating bumps that counter so old entries
+// simply stop being read and expire on their own.
+type redisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func newRedisCache(client *redis.Client, ttl time.Duration) *redisCache {
+	return &redisCache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func (c *redisCache) entryKey(key string) (string, error) {
+	generation, err := c.client.Get(context.Background(), redisCacheGenerationKey).Int64()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return fmt.Sprintf("cache:aggregate:%d:%s", generation, key), nil
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool) {
+	entryKey, err := c.entryKey(key)
+	if err != nil {
+		log.Println("Error:", err)
+		return nil, false
+	}
+
+	body, err := c.client.Get(context.Background(), entryKey).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println("Error:", err)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+func (c *redisCache) Set(key string, body []byte) {
+	entryKey, err := c.entryKey(key)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+
+	err = c.client.Set(context.Background(), entryKey, body, c.ttl).Err()
+	if err != nil {
+		log.Println("Error:", err)
+	}
+}
+
+func (c *redisCache) Invalidate() {
+	err := c.client.Incr(context.Background(), redisCacheGenerationKey).Err()
+	if err != nil {
+		log.Println("Error:", err)
+	}
+}
+
+// publishStatsSaved tells the other replicas that stats of the tenant were
+// saved
+func publishStatsSaved(client *redis.Client, tenant string) {
+	err := client.Publish(context.Background(), redisStatsSavedChannel, tenant).Err()
+	if err != nil {
+		log.Println("Failed to publish saved stats! Error:", err)
+	}
+}
+
+// subscribeStatsSaved wakes the polls and practice sockets waiting for the
+// stats saved by other replicas and workers. The subscription reconnects on
+// its own, saves published meanwhile are picked up by the rechecks.
+func subscribeStatsSaved(client *redis.Client) {
+	subscription := client.Subscribe(context.Background(), redisStatsSavedChannel)
+	for msg := range subscription.Channel() {
+		statsSaved.notify(msg.Payload)
+	}
+}
+
+func connectToRedis(url string) *redis.Client {
+	redisOptions, err := redis.ParseURL(url)
+	if err != nil {
+		log.Fatalln("Failed to parse Redis URL! Error:", err)
+	}
+	client := redis.NewClient(redisOptions)
+	err = client.Ping(context.Background()).Err()
+	if err != nil {
+		log.Fatalln("Failed to ping Redis! Error:", err)
+	}
+	return client
+}
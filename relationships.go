@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	relationshipPending  = "pending"
+	relationshipAccepted = "accepted"
+)
+
+var errNotFound = errors.New("not found")
+
+// Relationship grants a teacher read access to the aggregate stats of a
+// student, once the student accepts the teacher's invitation. Teachers and
+// students are accounts, which are tenants until there are user accounts.
+type Relationship struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Teacher    string             `json:"teacher" bson:"teacher"`
+	Student    string             `json:"student" bson:"student"`
+	Status     string             `json:"status" bson:"status"`
+	InvitedAt  time.Time          `json:"invited_at" bson:"invited_at"`
+	AcceptedAt *time.Time         `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
+
+type Invitation struct {
+	Student string `json:"student"`
+}
+
+// Dashboard is what a teacher sees of a student
+type Dashboard struct {
+	Student             string                     `json:"student"`
+	CountByDay          []StatsCountByDay          `json:"count_by_day"`
+	CountByExtension    []StatsCountByExtension    `json:"count_by_extension"`
+	DurationByExtension []StatsDurationByExtension `json:"duration_by_extension"`
+}
+
+// inviteStudent invites a student to share their stats with the teacher. An
+// existing relationship between the two is returned as is.
+func inviteStudent(ctx context.Context, teacher, student string) (Relationship, error) {
+	relationship := Relationship{
+		ID:        primitive.NewObjectID(),
+		Teacher:   teacher,
+		Student:   student,
+		Status:    relationshipPending,
+		InvitedAt: time.Now(),
+	}
+	return repository.SaveRelationship(ctx, relationship)
+}
+
+// revokeRelationship deletes a relationship that account is part of, along
+// with the student's part in the teacher's assignments
+func revokeRelationship(ctx context.Context, id primitive.ObjectID, account string) error {
+	return repository.InTransaction(ctx, func(ctx context.Context) error {
+		relationship, err := repository.DeleteRelationship(ctx, id, account)
+		if err != nil {
+			return err
+		}
+		return repository.RemoveAssignmentStudent(ctx, relationship.Teacher, relationship.Student)
+	})
+}
+
+// studentDashboard aggregates the stats of a student the same way the stats
+// endpoints do for the student themselves, timezone overriding the student's
+func studentDashboard(ctx context.Context, student string, timezone string) (Dashboard, error) {
+	ctx = withTenant(ctx, student)
+	loc, err := userLocation(ctx, defaultUserID, timezone)
+	if err != nil {
+		return Dashboard{}, err
+	}
+
+	dashboard := Dashboard{Student: student}
+	dashboard.CountByDay, err = countByDayLastMonth(ctx, loc)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	dashboard.CountByExtension, err = countByExtension(ctx)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	dashboard.DurationByExtension, err = avgDurationByExtension(ctx)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	return dashboard, nil
+}
+
+func getRelationshipsHandler(w http.ResponseWriter, r *http.Request) {
+	relationships, err := repository.Relationships(r.Context(), tenantFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, relationships)
+}
+
+func inviteStudentHandler(w http.ResponseWriter, r *http.Request) {
+	var invitation Invitation
+	err := decodeRequest(r, &invitation)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	teacher := tenantFromContext(r.Context())
+	if invitation.Student == "" || invitation.Student == teacher || len(invitation.Student) > 64 {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "student", Message: "has to be another account, of up to 64 characters"})
+		return
+	}
+
+	relationship, err := inviteStudent(r.Context(), teacher, invitation.Student)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, relationship)
+}
+
+// acceptInvitationHandler lets the invited student accept
+func acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	relationship, err := repository.AcceptRelationship(r.Context(), id, tenantFromContext(r.Context()))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, relationship)
+}
+
+// deleteRelationshipHandler lets either the teacher or the student revoke
+// the relationship, or decline the invitation
+func deleteRelationshipHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	err = revokeRelationship(r.Context(), id, tenantFromContext(r.Context()))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getStudentDashboardHandler answers 404 rather than 403 without an
+// accepted relationship, so teachers can't probe for accounts
+func getStudentDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	student := chi.URLParam(r, "id")
+	granted, err := repository.HasStudent(r.Context(), tenantFromContext(r.Context()), student)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !granted {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	dashboard, err := studentDashboard(r.Context(), student, r.URL.Query().Get("timezone"))
+	if err == errInvalidTimezone {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "timezone", Message: "isn't an IANA timezone"})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, dashboard)
+}
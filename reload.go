@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// reloadableOptions are the settings that can be changed without restarting
+// the server. They are read from the config file on top of the environment
+// and command line, and read again on SIGHUP.
+type reloadableOptions struct {
+	CORSOrigins     []string          `long:"cors-origin" env:"CORS_ORIGINS" env-delim:"," default:"https://*" default:"http://*" description:"Origin browsers may call the API from, a * standing for anything, may be repeated"`
+	TenantQuotas    map[string]int    `long:"tenant-quota" env:"TENANT_QUOTAS" env-delim:"," description:"Max number of stats a tenant can store as tenant:count, 0 meaning unlimited, may be repeated"`
+	StatsQuota      int               `long:"stats-quota" env:"STATS_QUOTA" description:"Max number of stats a tenant without a quota of its own can store, 0 meaning unlimited. Signed-in users are tenants of their own, each with this quota"`
+	LatencyBudgets  map[string]string `long:"latency-budget" env:"LATENCY_BUDGETS" env-delim:"," description:"Budget of the p95 latency of a route as route:duration, e.g. /stats:200ms, * being every route without a budget of its own, may be repeated"`
+	RequestTimeout  time.Duration     `long:"request-timeout" env:"REQUEST_TIMEOUT" default:"60s" description:"How long a request may take unless its route has a timeout of its own, 0 meaning no limit"`
+	RouteTimeouts   map[string]string `long:"route-timeout" env:"ROUTE_TIMEOUTS" env-delim:"," default:"/ping:2s" default:"POST /stats:5s" default:"POST /events:5s" default:"POST /import:2m" default:"GET /stats/raw:2m" default:"GET /stats/archive:2m" default:"GET /stats/poll:65s" default:"GET /practice_sessions/{id}/live:4h" description:"How long requests to a route may take as route:duration, the route being a pattern like /users/{id} with or without the method, or a method alone like POST, may be repeated"`
+	LogLevel        string            `long:"log-level" env:"LOG_LEVEL" default:"info" choice:"debug" choice:"info" choice:"warn" choice:"error" description:"Lowest level of requests logged: info logs successful ones, warn failed ones and error those failing on the server, debug logs every request without sampling"`
+	LogSamples      map[string]int    `long:"log-sample" env:"LOG_SAMPLES" env-delim:"," description:"Log one in this many successful requests of a route as route:n, e.g. POST /stats:10, the route being a pattern like /users/{id} with or without the method, may be repeated"`
+	AuthBanAfter    int               `long:"auth-ban-after" env:"AUTH_BAN_AFTER" default:"20" description:"Failed auth attempts of an IP or token prefix until it is banned, 0 disables banning"`
+	AuthBanDuration time.Duration     `long:"auth-ban-duration" env:"AUTH_BAN_DURATION" default:"15m" description:"How long a ban for failed auth attempts lasts, and how long failures are remembered"`
+	GuestRateLimit  int               `long:"guest-rate-limit" env:"GUEST_RATE_LIMIT" default:"60" description:"Max number of requests per minute of a guest"`
+}
+
+// corsOrigins are the origins browsers may call the API from
+var corsOrigins []string
+
+// corsOriginsMu guards corsOrigins, which are replaced when the config is
+// reloaded
+var corsOriginsMu sync.RWMutex
+
+// readConfigFile returns options with the settings of the config file at
+// path, an ini file of the long names of the options like:
+//
+//	cors-origin = https://app.example.com
+//	tenant-quota = acme:100000
+//
+// Settings missing from the file keep their value in options.
+func readConfigFile(path string, options reloadableOptions) (reloadableOptions, error) {
+	parser := flags.NewParser(&options, flags.None)
+	err := flags.NewIniParser(parser).ParseFile(path)
+	if err != nil {
+		return options, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return options, nil
+}
+
+// apply puts the settings in effect. Nothing changes if any of them is
+// invalid.
+func (o reloadableOptions) apply() error {
+	for _, origin := range o.CORSOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("CORS origin %s may have only one *", origin)
+		}
+	}
+	budgets, err := parseLatencyBudgets(o.LatencyBudgets)
+	if err != nil {
+		return err
+	}
+	if len(budgets) > 0 && latencies == nil {
+		return errors.New("latency budgets can only be added without a restart when started with some")
+	}
+	timeouts, err := parseRouteTimeouts(o.RouteTimeouts)
+	if err != nil {
+		return err
+	}
+	if guestsEnabled && o.GuestRateLimit <= 0 {
+		return errors.New("the guest rate limit has to be positive")
+	}
+	err = setRequestLogging(o.LogLevel, o.LogSamples)
+	if err != nil {
+		return err
+	}
+
+	corsOriginsMu.Lock()
+	corsOrigins = o.CORSOrigins
+	corsOriginsMu.Unlock()
+	setQuotas(o.TenantQuotas, o.StatsQuota)
+	setTimeouts(o.RequestTimeout, timeouts)
+	authFailures.setLimits(o.AuthBanAfter, o.AuthBanDuration)
+	guestRequests.setLimit(o.GuestRateLimit)
+	if latencies != nil {
+		latencies.setBudgets(budgets)
+	}
+	return nil
+}
+
+// reloadOnHangup rereads the config file at path on top of base whenever the
+// process gets SIGHUP, the signal to reload its config. Requests being
+// served are left alone, the settings apply to the ones coming after.
+func reloadOnHangup(path string, base reloadableOptions) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if path == "" {
+			log.Println("Got SIGHUP without a config file, nothing to reload")
+			continue
+		}
+		options, err := readConfigFile(path, base)
+		if err == nil {
+			err = options.apply()
+		}
+		if err != nil {
+			configReloadsTotal.WithLabelValues("failed").Inc()
+			log.Println("Failed to reload config, keeping the current one! Error:", err)
+			continue
+		}
+		configReloadsTotal.WithLabelValues("reloaded").Inc()
+		log.Println("Reloaded config from", path)
+	}
+}
+
+// allowCORSOrigin tells if a browser may call the API from origin
+func allowCORSOrigin(r *http.Request, origin string) bool {
+	corsOriginsMu.RLock()
+	defer corsOriginsMu.RUnlock()
+
+	origin = strings.ToLower(origin)
+	for _, allowed := range corsOrigins {
+		allowed = strings.ToLower(allowed)
+		star := strings.Index(allowed, "*")
+		if star < 0 {
+			if origin == allowed {
+				return true
+			}
+			continue
+		}
+		prefix, suffix := allowed[:star], allowed[star+1:]
+		if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
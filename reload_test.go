@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestApplyLimits reloads the guest rate limit and the auth bans, the
+// limiters in use have to follow them
+func TestApplyLimits(t *testing.T) {
+	defer func(saved authFailureCounter) { authFailures = saved }(authFailures)
+	defer func(saved requestLimiter) { guestRequests = saved }(guestRequests)
+	defer func(saved bool) { guestsEnabled = saved }(guestsEnabled)
+	authFailures = newAuthGuard(20, time.Minute)
+	guestRequests = newRateLimiter(1, time.Minute)
+	guestsEnabled = true
+
+	options := reloadableOptions{LogLevel: "info", AuthBanAfter: 2, AuthBanDuration: time.Hour, GuestRateLimit: 3}
+	err := options.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := guestRequests.allow("guest"); !allowed {
+			t.Fatalf("request %d of 3 isn't allowed", i+1)
+		}
+	}
+	if allowed, _ := guestRequests.allow("guest"); allowed {
+		t.Error("request over the reloaded limit is allowed")
+	}
+	keys := authFailureKeys("192.0.2.1", "")
+	authFailures.fail(keys)
+	authFailures.fail(keys)
+	if bannedFor := authFailures.bannedFor(keys); bannedFor < 59*time.Minute {
+		t.Errorf("banned for %s after the reloaded threshold, want an hour", bannedFor)
+	}
+
+	options.GuestRateLimit = 0
+	if options.apply() == nil {
+		t.Error("reloaded a guest rate limit of 0")
+	}
+	if allowed, _ := guestRequests.allow("other guest"); !allowed {
+		t.Error("the invalid reload changed the rate limit")
+	}
+}
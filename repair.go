@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// maxRepairFindings bounds the findings listed in a repair report, the
+// counts cover all of them
+const maxRepairFindings = 1000
+
+// RepairReport is what a repair found and did. Repaired stats are fixed in
+// place, quarantined ones can't be and are moved to the quarantined_stats
+// collection with their problems, for a look by hand.
+type RepairReport struct {
+	DryRun      bool `json:"dry_run"`
+	Scanned     int  `json:"scanned"`
+	Repaired    int  `json:"repaired"`
+	Quarantined int  `json:"quarantined"`
+	// Problems counts the stats with each problem
+	Problems map[string]int  `json:"problems"`
+	Findings []RepairFinding `json:"findings"`
+}
+
+// RepairFinding is a malformed stat and what was done about it
+type RepairFinding struct {
+	ID       primitive.ObjectID `json:"id"`
+	Tenant   string             `json:"tenant,omitempty"`
+	Problems []string           `json:"problems"`
+	// Action is repaired or quarantined
+	Action string `json:"action"`
+}
+
+// quarantinedStats is a stat moved out of the stats by a repair, stored as
+// it was since it may not even decode
+type quarantinedStats struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Stats         bson.Raw           `bson:"stats"`
+	Problems      []string           `bson:"problems"`
+	QuarantinedAt time.Time          `bson:"quarantined_at"`
+}
+
+// runRepair is the repair command, scanning the stats for malformed ones
+// stored before answers were validated and printing a report of them
+func runRepair(args []string) {
+	var options struct {
+		MongoUrl string `short:"u" env:"MONGODB_URL" description:"URL to mongo" required:"true"`
+		DryRun   bool   `long:"dry-run" description:"Only report the malformed stats, without repairing or quarantining them"`
+	}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Usage = "repair [OPTIONS]"
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		log.Fatalln("Error parsing input:", err)
+	}
+
+	ctx := context.Background()
+	client := connectToMongo(options.MongoUrl, mongoSettings{
+		MaxPoolSize:            20,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+	})
+	defer client.Disconnect(ctx)
+
+	report, err := repairStats(ctx, newMongoRepository(client, readpref.Primary(), readpref.Primary()), options.DryRun)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if err != nil {
+		log.Fatalf("Failed to repair after scanning %d stats! Error: %s\n", report.Scanned, err)
+	}
+	log.Printf("Scanned %d stats, repaired %d and quarantined %d\n", report.Scanned, report.Repaired, report.Quarantined)
+}
+
+// repairStats scans every stat, repairing what can be derived from the rest
+// of it and quarantining what can't. Both bump the sync sequence number,
+// so syncing clients pick up the repair or the deletion.
+func repairStats(ctx context.Context, m *mongoRepository, dryRun bool) (RepairReport, error) {
+	report := RepairReport{DryRun: dryRun, Problems: make(map[string]int), Findings: []RepairFinding{}}
+	cursor, err := m.statistics().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		report.Scanned++
+		raw := cursor.Current
+		var stats StatsRaw
+		decodeErr := bson.Unmarshal(raw, &stats)
+		if decodeErr != nil {
+			// the id is all that is known for sure
+			id, _ := raw.Lookup("_id").ObjectIDOK()
+			stats = StatsRaw{ID: id}
+		}
+		repaired, problems := repairedStats(stats)
+		if decodeErr != nil {
+			problems = append(problems, "doesn't decode: "+decodeErr.Error())
+		}
+		if len(problems) == 0 {
+			continue
+		}
+
+		finding := RepairFinding{ID: stats.ID, Tenant: stats.Tenant, Problems: problems, Action: "repaired"}
+		if decodeErr != nil || validateChord(repaired) != nil || repaired.Tenant == "" || repaired.AnswerDurationMilliSeconds < 0 {
+			finding.Action = "quarantined"
+		}
+		for _, problem := range problems {
+			report.Problems[strings.SplitN(problem, ":", 2)[0]]++
+		}
+		if len(report.Findings) < maxRepairFindings {
+			report.Findings = append(report.Findings, finding)
+		}
+
+		if finding.Action == "repaired" {
+			report.Repaired++
+			if !dryRun {
+				err = m.saveRepairedStats(ctx, repaired)
+			}
+		} else {
+			report.Quarantined++
+			if !dryRun {
+				err = m.quarantineStats(ctx, stats, raw, problems)
+			}
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, cursor.Err()
+}
+
+// repairedStats returns stats with what can be derived filled in, and what
+// was wrong with them. Stats that are still invalid after it have to be
+// quarantined.
+func repairedStats(stats StatsRaw) (StatsRaw, []string) {
+	var problems []string
+	if stats.CreatedAt.IsZero() && !stats.ID.IsZero() {
+		problems = append(problems, "created_at is missing")
+		stats.CreatedAt = stats.ID.Timestamp()
+	}
+	if stats.RootNote == "" && stats.ChordName != "" {
+		problems = append(problems, "root_note is missing")
+		stats.RootNote = rootNotePattern.FindString(stats.ChordName)
+		if stats.RootNote != "" && stats.ChordExtension == "" {
+			stats.ChordExtension = strings.TrimPrefix(stats.ChordName, stats.RootNote)
+		}
+	}
+	if stats.ChordName == "" && stats.RootNote != "" {
+		problems = append(problems, "chord_name is missing")
+		stats.ChordName = stats.RootNote + stats.ChordExtension
+	}
+	if stats.ChordName == "" && stats.RootNote == "" {
+		problems = append(problems, "chord_name and root_note are missing")
+	}
+	if stats.Tenant == "" {
+		problems = append(problems, "tenant is missing")
+	}
+	if stats.AnswerDurationMilliSeconds < 0 {
+		problems = append(problems, "answer_duration_millis is negative")
+	}
+	if stats.Version == 0 {
+		problems = append(problems, "version is missing")
+		stats.Version = 1
+	}
+	for _, fieldError := range validateChord(stats) {
+		if stats.ChordName != "" && stats.RootNote != "" {
+			problems = append(problems, fieldError.Field+" "+fieldError.Message)
+		}
+	}
+	return stats, problems
+}
+
+func (m *mongoRepository) saveRepairedStats(ctx context.Context, stats StatsRaw) error {
+	seq, release, err := m.nextSyncSeq(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = m.statistics().UpdateByID(ctx, stats.ID, bson.M{
+		"$set": bson.M{
+			"created_at":      stats.CreatedAt,
+			"chord_name":      stats.ChordName,
+			"root_note":       stats.RootNote,
+			"chord_extension": stats.ChordExtension,
+			"version":         stats.Version + 1,
+			"sync_seq":        seq,
+		},
+	})
+	return err
+}
+
+// quarantineStats moves stats to the quarantine and leaves a tombstone. It
+// is written to the quarantine first, so a repair failing halfway leaves the
+// stats in both rather than in neither.
+func (m *mongoRepository) quarantineStats(ctx context.Context, stats StatsRaw, raw bson.Raw, problems []string) error {
+	_, err := m.client.Database("main").Collection("quarantined_stats").ReplaceOne(
+		ctx,
+		bson.M{"_id": stats.ID},
+		quarantinedStats{ID: stats.ID, Stats: raw, Problems: problems, QuarantinedAt: time.Now()},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	if stats.Tenant != "" {
+		seq, release, err := m.nextSyncSeq(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = m.tombstones().InsertOne(ctx, Tombstone{ID: stats.ID, DeletedAt: time.Now(), SyncSeq: seq, Tenant: stats.Tenant})
+		release()
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	_, err = m.statistics().DeleteOne(ctx, bson.M{"_id": stats.ID})
+	return err
+}
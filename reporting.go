@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// redactedHeaders are left out of error reports, since they hold secrets
+var redactedHeaders = []string{"X-Auth-Token", "Authorization", "Cookie"}
+
+// setupErrorReporting sends errors and panics to Sentry, or anything else
+// speaking its protocol. Until it is called errors are only logged.
+func setupErrorReporting(dsn string, environment string) error {
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		BeforeSend: func(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+			if event.Request != nil {
+				for _, header := range redactedHeaders {
+					delete(event.Request.Headers, header)
+				}
+			}
+			return event
+		},
+	})
+}
+
+// flushErrorReports waits a little for reports not sent yet
+func flushErrorReports() {
+	sentry.Flush(2 * time.Second)
+}
+
+// ReportPanics reports panics with the request they happened in, before
+// passing them on to the recoverer
+func ReportPanics(next http.Handler) http.Handler {
+	return sentryhttp.New(sentryhttp.Options{Repanic: true}).Handle(next)
+}
+
+// reportError reports err with what ctx tells about the request it
+// happened in, if any
+func reportError(ctx context.Context, err error) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+	hub.WithScope(func(scope *sentry.Scope) {
+		if tenant := tenantFromContext(ctx); tenant != "" {
+			scope.SetTag("tenant", tenant)
+		}
+		if requestID := middleware.GetReqID(ctx); requestID != "" {
+			scope.SetTag("request_id", requestID)
+		}
+		hub.CaptureException(err)
+	})
+}
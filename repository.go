@@ -0,0 +1,2875 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+var repository Repository
+
+// anyVersion makes a write unconditional
+const anyVersion = -1
+
+var errVersionConflict = errors.New("stored version does not match")
+
+// unavailable tells if err is Mongo being unreachable, rather than the
+// operation failing
+func unavailable(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	var openErr circuitOpenError
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.As(err, &selectionErr) || errors.As(err, &openErr)
+}
+
+// Repository is the storage behind both the HTTP and the gRPC API. Every
+// method only sees the data of the tenant in ctx, see tenantQuery.
+type Repository interface {
+	// SaveStats stores stats and returns them as stored. Stats with a client
+	// ID are upserted on it, unless the stored ones have a later UpdatedAt.
+	// With ifVersion other than anyVersion the stats are only written if the
+	// stored version equals it, 0 meaning not stored yet, and otherwise
+	// errVersionConflict is returned along with the stored stats.
+	SaveStats(ctx context.Context, stats StatsRaw, ifVersion int64) (StatsRaw, error)
+	// InsertStats inserts stats that already have their id and version in
+	// one go. Stats that turn out to be inserted already are skipped, which
+	// makes it safe to repeat.
+	InsertStats(ctx context.Context, stats []StatsRaw) error
+	// EachStats calls fn for every stored stat matching filter in turn and
+	// stops at the first error, which is returned
+	EachStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error
+	// EachArchivedStats is EachStats for the archived stats
+	EachArchivedStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error
+	// ArchiveStats moves the stats created before the given time out of the
+	// hot stats, and returns how many it moved. Aggregations over all time
+	// still count them.
+	ArchiveStats(ctx context.Context, before time.Time) (int, error)
+	// CountStats counts all stored stats
+	CountStats(ctx context.Context) (int, error)
+	// CountMatchingStats counts the stored stats matching filter, ignoring
+	// its limit
+	CountMatchingStats(ctx context.Context, filter StatsFilter) (int, error)
+	// DeleteStats deletes the stored stats matching filter, ignoring its
+	// limit, and returns how many it deleted. Each leaves a tombstone, so
+	// syncing clients and exports learn about it.
+	DeleteStats(ctx context.Context, filter StatsFilter) (int, error)
+	// CorrectStats writes the fields a correction may change of stats over
+	// the stored stats with its id, if their version is ifVersion, and
+	// returns them as stored with the sync sequence number seq. Fails with
+	// errNotFound if there are no such stats and with errVersionConflict if
+	// their version is another.
+	CorrectStats(ctx context.Context, stats StatsRaw, ifVersion, seq int64) (StatsRaw, error)
+	// RetagStats removes the tags remove from the stored stats matching
+	// filter, ignoring its limit, and adds the tags add. Each stats changed
+	// gets a new version, so syncing clients learn about it.
+	RetagStats(ctx context.Context, filter StatsFilter, add, remove []string) (StatsRetagReport, error)
+	InsertStatsCorrection(ctx context.Context, entry StatsCorrectionEntry) error
+	// StatsCorrections returns the corrections of the stats with the id,
+	// oldest first
+	StatsCorrections(ctx context.Context, statsID primitive.ObjectID) ([]StatsCorrectionEntry, error)
+	SavePracticeSession(ctx context.Context, session PracticeSession) error
+	// PracticeSession returns the practice session with the id, failing with
+	// errNotFound if there is none
+	PracticeSession(ctx context.Context, id primitive.ObjectID) (PracticeSession, error)
+	// EndPracticeSession ends the practice session with the id at at, unless
+	// it has ended already. Fails with errNotFound if there is none.
+	EndPracticeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// OpenPracticeSessions returns the practice sessions started since since
+	// that neither ended nor met their goal yet
+	OpenPracticeSessions(ctx context.Context, since time.Time) ([]PracticeSession, error)
+	// MarkPracticeGoalMet records that the goal of the practice session with
+	// the id was met at at, unless it was recorded already
+	MarkPracticeGoalMet(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// CountByDay counts the stats per day, with days starting at midnight in
+	// loc
+	CountByDay(ctx context.Context, loc *time.Location) ([]StatsCountByDay, error)
+	// CountByDayAndExtension counts the stats per day and chord extension,
+	// with days starting at midnight in loc
+	CountByDayAndExtension(ctx context.Context, loc *time.Location) ([]StatsCountByDayAndExtension, error)
+	CountByExtension(ctx context.Context) ([]StatsCountByExtension, error)
+	// CountByRootNote counts the stats created since since per root note as
+	// stored, without merging enharmonic spellings
+	CountByRootNote(ctx context.Context, since time.Time) ([]StatsCountByRootNote, error)
+	// OutcomesByTempo sums up the stats given against a metronome per tempo,
+	// slowest first, leaving their accuracies to the caller
+	OutcomesByTempo(ctx context.Context) ([]TempoOutcome, error)
+	// OutcomesByConfidence sums up the rated stats per confidence, least
+	// confident first, leaving their accuracies to the caller
+	OutcomesByConfidence(ctx context.Context) ([]ConfidenceOutcome, error)
+	// AttemptOutcomes sums up the stats per chord extension as stored by the
+	// try they were right on, leaving their accuracies to the caller
+	AttemptOutcomes(ctx context.Context) ([]AttemptOutcome, error)
+	AvgDurationByExtension(ctx context.Context) ([]StatsDurationByExtension, error)
+	// Changes returns the stats and tombstones written after the sync
+	// sequence number since, each ordered by sequence number and at most limit
+	// of each
+	Changes(ctx context.Context, since int64, limit int) ([]StatsRaw, []Tombstone, error)
+	// UsageByClientVersion counts the stats and averages the answer
+	// durations per platform and app version, leaving the request counts empty
+	UsageByClientVersion(ctx context.Context) ([]ClientVersionUsage, error)
+	// TenantActivity returns the activity of every tenant that practiced
+	// since since
+	TenantActivity(ctx context.Context, since time.Time) ([]TenantActivity, error)
+	// InsertEvents records client actions
+	InsertEvents(ctx context.Context, events []Event) error
+	// TenantEvents returns the names of the events every tenant recorded
+	// from since until until, of the tenant in ctx
+	TenantEvents(ctx context.Context, since, until time.Time) ([]TenantEvents, error)
+	// GetSettings returns the settings of a user, empty if none are stored
+	GetSettings(ctx context.Context, userID string) (Settings, error)
+	// GetNotificationPreferences returns the notification preferences of a
+	// user, empty if none are stored
+	GetNotificationPreferences(ctx context.Context, userID string) (NotificationPreferences, error)
+	// UpdateNotificationPreferences sets the non-nil fields of update and
+	// returns the resulting preferences
+	UpdateNotificationPreferences(ctx context.Context, userID string, update NotificationPreferences) (NotificationPreferences, error)
+	// UpdateSettings sets the non-nil fields of update and returns the
+	// resulting settings. With ifVersion other than anyVersion the settings are only updated if
+	// the stored version matches, else errVersionConflict is returned along
+	// with the stored settings.
+	UpdateSettings(ctx context.Context, userID string, update Settings, ifVersion int64) (Settings, error)
+	// Integrations returns the integrations of a user, oldest first
+	Integrations(ctx context.Context, userID string) ([]Integration, error)
+	// AllIntegrations returns the integrations of every user, for posting
+	// the weekly summaries
+	AllIntegrations(ctx context.Context) ([]Integration, error)
+	SaveIntegration(ctx context.Context, integration Integration) error
+	// DeleteIntegration fails with errNotFound if the user has no
+	// integration with the ID
+	DeleteIntegration(ctx context.Context, userID string, id primitive.ObjectID) error
+	// MarkSummarySent records when the weekly summary was posted to the
+	// integration with the ID
+	MarkSummarySent(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// MarkStreakAnnounced records the streak milestone with the key as
+	// posted to the integration with the ID, and returns false if it was
+	// already
+	MarkStreakAnnounced(ctx context.Context, id primitive.ObjectID, key string) (bool, error)
+	// StreakPauses returns the streak pauses of a user, earliest first
+	StreakPauses(ctx context.Context, userID string) ([]StreakPause, error)
+	SaveStreakPause(ctx context.Context, pause StreakPause) error
+	// DeleteStreakPause fails with errNotFound if the user has no pause with
+	// the ID
+	DeleteStreakPause(ctx context.Context, userID string, id primitive.ObjectID) error
+	// StreakFreezes returns the streak freezes a user used up, earliest day
+	// first
+	StreakFreezes(ctx context.Context, userID string) ([]StreakFreeze, error)
+	// SaveStreakFreeze records a freeze used up, once per day and user
+	SaveStreakFreeze(ctx context.Context, freeze StreakFreeze) error
+
+	// SaveBadge stores the badge of a user, replacing the user's previous one
+	SaveBadge(ctx context.Context, badge Badge) error
+	// BadgeByHash fails with errNotFound if there is no badge with the token
+	// hash. Badges are looked up across tenants.
+	BadgeByHash(ctx context.Context, hash []byte) (Badge, error)
+	// DeleteBadge fails with errNotFound if the user has no badge
+	DeleteBadge(ctx context.Context, userID string) error
+
+	// Relationships span tenants, so these methods take the accounts
+	// explicitly instead of scoping to the tenant in ctx
+
+	// SaveRelationship stores a new relationship, unless the teacher and
+	// student already have one, which is returned instead
+	SaveRelationship(ctx context.Context, relationship Relationship) (Relationship, error)
+	// Relationships returns the relationships account is teacher or student in
+	Relationships(ctx context.Context, account string) ([]Relationship, error)
+	// AcceptRelationship accepts the invitation with id sent to student, and
+	// fails with errNotFound if there is none
+	AcceptRelationship(ctx context.Context, id primitive.ObjectID, student string) (Relationship, error)
+	// DeleteRelationship deletes the relationship with id that account is
+	// part of and returns it, and fails with errNotFound if there is none
+	DeleteRelationship(ctx context.Context, id primitive.ObjectID, account string) (Relationship, error)
+	// HasStudent tells if the student has accepted the teacher's invitation
+	HasStudent(ctx context.Context, teacher, student string) (bool, error)
+	SaveAssignment(ctx context.Context, assignment Assignment) error
+	// Assignments returns the assignments account is teacher or student in,
+	// ordered by due date
+	Assignments(ctx context.Context, account string) ([]Assignment, error)
+	// Assignment returns the assignment with id that account is teacher or
+	// student in, and fails with errNotFound if there is none
+	Assignment(ctx context.Context, id primitive.ObjectID, account string) (Assignment, error)
+	// RemoveAssignmentStudent takes student off the assignments of teacher
+	RemoveAssignmentStudent(ctx context.Context, teacher, student string) error
+
+	// Flags returns every feature flag sorted by name, flags belong to the
+	// deployment rather than a tenant
+	Flags(ctx context.Context) ([]Flag, error)
+	// SaveFlag creates or replaces the flag of the same name
+	SaveFlag(ctx context.Context, flag Flag) error
+	// DeleteFlag fails with errNotFound if there is no flag named name
+	DeleteFlag(ctx context.Context, name string) error
+
+	// ChordAliases returns every chord alias sorted by alias, aliases belong
+	// to the deployment rather than a tenant
+	ChordAliases(ctx context.Context) ([]ChordAlias, error)
+	// SaveChordAlias creates or replaces the chord alias of the same alias
+	SaveChordAlias(ctx context.Context, alias ChordAlias) error
+	// DeleteChordAlias fails with errNotFound if there is no such alias
+	DeleteChordAlias(ctx context.Context, alias string) error
+
+	// Experiments returns every experiment sorted by name, experiments
+	// belong to the deployment rather than a tenant
+	Experiments(ctx context.Context) ([]Experiment, error)
+	// SaveExperiment creates or replaces the experiment of the same name
+	SaveExperiment(ctx context.Context, experiment Experiment) error
+	// DeleteExperiment fails with errNotFound if there is no experiment
+	// named name, the stats tagged with it are kept
+	DeleteExperiment(ctx context.Context, name string) error
+	// ExperimentOutcomes sums up the stats tagged with a variant of the
+	// experiment named name, by variant sorted by name
+	ExperimentOutcomes(ctx context.Context, name string) ([]VariantOutcome, error)
+
+	// Insights returns the latest analysis of the practice of the tenant in
+	// ctx, errNotFound if it wasn't analyzed yet
+	Insights(ctx context.Context) (InsightReport, error)
+	// SaveInsights replaces the analysis of the tenant of report
+	SaveInsights(ctx context.Context, report InsightReport) error
+
+	// SheetConnections returns the Google Sheets every tenant is connected
+	// to, connections belong to the deployment rather than a tenant
+	SheetConnections(ctx context.Context) ([]SheetConnection, error)
+	// SheetConnection fails with errNotFound if the tenant isn't connected
+	SheetConnection(ctx context.Context, tenant string) (SheetConnection, error)
+	// SaveSheetConnection creates or replaces the connection of the tenant
+	SaveSheetConnection(ctx context.Context, connection SheetConnection) error
+	// DeleteSheetConnection fails with errNotFound if the tenant isn't
+	// connected
+	DeleteSheetConnection(ctx context.Context, tenant string) error
+	// UpdateSheetExport moves the export of a tenant from from to to, and
+	// returns false if its cursor or day aren't the ones of from anymore
+	UpdateSheetExport(ctx context.Context, tenant string, from, to SheetExport) (bool, error)
+	// ReserveSyncSeq hands out a sync sequence number for a write made
+	// later, so the counter everyone writes stays out of transactions. The
+	// delta sync holds back everything from seq on until ReleaseSyncSeq is
+	// called once the write is done, or syncSeqOverlap passed.
+	ReserveSyncSeq(ctx context.Context) (int64, error)
+	// ReleaseSyncSeq lets the delta sync move past a sync sequence number
+	// handed out by ReserveSyncSeq
+	ReleaseSyncSeq(ctx context.Context, seq int64) error
+	// LatestSyncSeq returns the sync sequence number of the latest change
+	// of the tenant in ctx, 0 if there is none
+	LatestSyncSeq(ctx context.Context) (int64, error)
+
+	// WarehouseExport returns the export named name, at its start if it
+	// hasn't exported anything yet
+	WarehouseExport(ctx context.Context, name string) (WarehouseExport, error)
+	// UpdateWarehouseExport moves the export from from to to, and returns
+	// false if its cursor or day aren't the ones of from anymore
+	UpdateWarehouseExport(ctx context.Context, from, to WarehouseExport) (bool, error)
+
+	// LockJob takes the lock of the job named name for holder until until,
+	// and returns false if another holder has it and it hasn't expired
+	LockJob(ctx context.Context, name, holder string, until time.Time) (bool, error)
+	// UnlockJob releases the lock of the job named name if holder has it
+	UnlockJob(ctx context.Context, name, holder string) error
+	// SaveJobRun creates or replaces a run of a job
+	SaveJobRun(ctx context.Context, run JobRun) error
+	// JobRuns returns up to limit runs of the job named job, latest first
+	JobRuns(ctx context.Context, job string, limit int) ([]JobRun, error)
+
+	// InsertAccessLogs appends entries to the access log, in which the
+	// oldest entries make room for new ones
+	InsertAccessLogs(ctx context.Context, entries []AccessLogEntry) error
+	// AccessLogs returns up to filter.Limit entries matching filter, latest
+	// first
+	AccessLogs(ctx context.Context, filter AccessLogFilter) ([]AccessLogEntry, error)
+
+	// Tokens returns every minted auth token, oldest first, expired ones
+	// included
+	Tokens(ctx context.Context) ([]APIToken, error)
+	// SaveToken stores a newly minted token
+	SaveToken(ctx context.Context, token APIToken) error
+	// DeleteToken fails with errNotFound if there is no token with the ID
+	DeleteToken(ctx context.Context, id primitive.ObjectID) error
+
+	// ProvisionUser returns the user with identity, with its email and last
+	// login updated from user, or creates user with identity if there is
+	// none
+	ProvisionUser(ctx context.Context, identity Identity, user User) (User, error)
+	// User fails with errNotFound if there is no user with the ID
+	User(ctx context.Context, id string) (User, error)
+	// VerifyEmail records that the user verified email, returning false if
+	// the user's email isn't email (anymore)
+	VerifyEmail(ctx context.Context, userID, email string) (bool, error)
+	// ScheduleDeletion sets when a user is deleted, nil cancelling it, and
+	// locks or unlocks the user's sessions to match. It fails with
+	// errNotFound if there is no user with the ID.
+	ScheduleDeletion(ctx context.Context, userID string, at *time.Time) error
+	// UsersDueForDeletion returns the users scheduled for deletion before
+	UsersDueForDeletion(ctx context.Context, before time.Time) ([]User, error)
+	// PurgeUser deletes a user along with the user's sessions and all data
+	// of the user's tenant, doing nothing if there is no user with the ID
+	PurgeUser(ctx context.Context, userID string) error
+	// SaveTwoFactor replaces the two-factor auth of a user, nil removing it,
+	// and fails with errNotFound if there is no user with the ID
+	SaveTwoFactor(ctx context.Context, userID string, twoFactor *TwoFactor) error
+	// UseTwoFactorStep records that a code of step was used, returning false
+	// if one of it or a later step was used already
+	UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error)
+	// UseRecoveryCode removes a recovery code by its hash, returning false if
+	// the user has no such code
+	UseRecoveryCode(ctx context.Context, userID string, hash []byte) (bool, error)
+	// SaveSession stores a newly issued session
+	SaveSession(ctx context.Context, session Session) error
+	// SessionByHash fails with errNotFound if there is no session with the
+	// token hash, expired sessions may still be returned
+	SessionByHash(ctx context.Context, hash []byte) (Session, error)
+	// Sessions returns the sessions of a user, expired ones may be included
+	Sessions(ctx context.Context, userID string) ([]Session, error)
+	// TouchSession records the latest use of a session, failing with
+	// errNotFound if there is no session with the ID
+	TouchSession(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error
+	// DeleteSession fails with errNotFound if there is no session with the ID
+	DeleteSession(ctx context.Context, id primitive.ObjectID) error
+
+	// InTransaction calls fn with a ctx that makes the repository calls in
+	// fn all or nothing, and commits them if fn returns nil. Where the
+	// storage can't do transactions, fn is simply called with ctx.
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// StatsFilter narrows down which stats are read, zero values match anything
+type StatsFilter struct {
+	ChordName      string
+	RootNote       string
+	ChordExtension string
+	Since          time.Time
+	Until          time.Time
+	// IDs limits the stats to the ones with these ids, unless empty
+	IDs []primitive.ObjectID
+	// WithoutClientID limits the stats to the ones sent without a client
+	// ID, which may have been sent twice
+	WithoutClientID bool
+	// Instrument limits the stats to the ones played on it, the ones without
+	// an instrument being piano
+	Instrument string
+	// Tag limits the stats to the ones tagged with it
+	Tag string
+	// AfterID limits the stats to the ones with a greater id, for paging
+	// through them
+	AfterID primitive.ObjectID
+	// Limit limits the stats to the first ones by id, 0 meaning no limit
+	Limit int
+}
+
+func (f StatsFilter) bson() bson.M {
+	query := bson.M{}
+	if f.ChordName != "" {
+		query["chord_name"] = f.ChordName
+	}
+	if f.RootNote != "" {
+		query["root_note"] = f.RootNote
+	}
+	if f.ChordExtension != "" {
+		query["chord_extension"] = f.ChordExtension
+	}
+	instrumentQuery("instrument", f.Instrument, query)
+	if f.Tag != "" {
+		query["tags"] = f.Tag
+	}
+	createdAt := bson.M{}
+	if !f.Since.IsZero() {
+		createdAt["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		createdAt["$lt"] = f.Until
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+	id := bson.M{}
+	if len(f.IDs) > 0 {
+		id["$in"] = f.IDs
+	}
+	if !f.AfterID.IsZero() {
+		id["$gt"] = f.AfterID
+	}
+	if len(id) > 0 {
+		query["_id"] = id
+	}
+	if f.WithoutClientID {
+		query["client_id"] = bson.M{"$exists": false}
+	}
+	return query
+}
+
+type mongoRepository struct {
+	client *mongo.Client
+	// transactions tells if Mongo supports them, which a standalone server
+	// doesn't
+	transactions bool
+	// reads and aggregations are the read preferences of listing stats and
+	// aggregating them, everything else stays on the primary
+	reads        *readpref.ReadPref
+	aggregations *readpref.ReadPref
+}
+
+func newMongoRepository(client *mongo.Client, reads, aggregations *readpref.ReadPref) *mongoRepository {
+	return &mongoRepository{client: client, reads: reads, aggregations: aggregations}
+}
+
+func (m *mongoRepository) statistics() *mongo.Collection {
+	return m.client.Database("main").Collection("statistics")
+}
+
+// readCollection is a collection read with readPref
+func (m *mongoRepository) readCollection(name string, readPref *readpref.ReadPref) *mongo.Collection {
+	return m.client.Database("main").Collection(
+		name,
+		options.Collection().SetReadPreference(readPref),
+	)
+}
+
+func (m *mongoRepository) tombstones() *mongo.Collection {
+	return m.client.Database("main").Collection("tombstones")
+}
+
+func (m *mongoRepository) statsCorrections() *mongo.Collection {
+	return m.client.Database("main").Collection("stats_corrections")
+}
+
+func (m *mongoRepository) practiceSessions() *mongo.Collection {
+	return m.client.Database("main").Collection("practice_sessions")
+}
+
+func (m *mongoRepository) settings() *mongo.Collection {
+	return m.client.Database("main").Collection("settings")
+}
+
+func (m *mongoRepository) notificationPreferences() *mongo.Collection {
+	return m.client.Database("main").Collection("notification_preferences")
+}
+
+func (m *mongoRepository) integrations() *mongo.Collection {
+	return m.client.Database("main").Collection("integrations")
+}
+
+func (m *mongoRepository) streakPauses() *mongo.Collection {
+	return m.client.Database("main").Collection("streak_pauses")
+}
+
+func (m *mongoRepository) streakFreezes() *mongo.Collection {
+	return m.client.Database("main").Collection("streak_freezes")
+}
+
+func (m *mongoRepository) badges() *mongo.Collection {
+	return m.client.Database("main").Collection("badges")
+}
+
+func (m *mongoRepository) insights() *mongo.Collection {
+	return m.client.Database("main").Collection("insights")
+}
+
+func (m *mongoRepository) sheetConnections() *mongo.Collection {
+	return m.client.Database("main").Collection("sheet_connections")
+}
+
+func (m *mongoRepository) warehouseExports() *mongo.Collection {
+	return m.client.Database("main").Collection("warehouse_exports")
+}
+
+func (m *mongoRepository) jobLocks() *mongo.Collection {
+	return m.client.Database("main").Collection("job_locks")
+}
+
+func (m *mongoRepository) jobRuns() *mongo.Collection {
+	return m.client.Database("main").Collection("job_runs")
+}
+
+func (m *mongoRepository) events() *mongo.Collection {
+	return m.client.Database("main").Collection("events")
+}
+
+func (m *mongoRepository) accessLogs() *mongo.Collection {
+	return m.client.Database("main").Collection("access_logs")
+}
+
+func (m *mongoRepository) relationships() *mongo.Collection {
+	return m.client.Database("main").Collection("relationships")
+}
+
+func (m *mongoRepository) assignments() *mongo.Collection {
+	return m.client.Database("main").Collection("assignments")
+}
+
+func (m *mongoRepository) archives() *mongo.Collection {
+	return m.client.Database("main").Collection("statistics_archive")
+}
+
+func (m *mongoRepository) flags() *mongo.Collection {
+	return m.client.Database("main").Collection("flags")
+}
+
+func (m *mongoRepository) chordAliases() *mongo.Collection {
+	return m.client.Database("main").Collection("chord_aliases")
+}
+
+func (m *mongoRepository) experiments() *mongo.Collection {
+	return m.client.Database("main").Collection("experiments")
+}
+
+func (m *mongoRepository) tokens() *mongo.Collection {
+	return m.client.Database("main").Collection("tokens")
+}
+
+func (m *mongoRepository) users() *mongo.Collection {
+	return m.client.Database("main").Collection("users")
+}
+
+func (m *mongoRepository) sessions() *mongo.Collection {
+	return m.client.Database("main").Collection("sessions")
+}
+
+func (m *mongoRepository) counters() *mongo.Collection {
+	return m.client.Database("main").Collection("counters")
+}
+
+// Prepare creates the indexes the queries rely on and brings documents
+// written by older versions up to date. It is run on every startup.
+func (m *mongoRepository) Prepare(ctx context.Context) error {
+	var err error
+	m.transactions, err = m.supportsTransactions(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = m.backfillTenants(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range []*mongo.Collection{m.statistics(), m.tombstones()} {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{"tenant", 1}, {"sync_seq", 1}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// client IDs used to be unique across all stats, now they are per tenant
+	_, err = m.statistics().Indexes().DropOne(ctx, "client_id_1")
+	if err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	_, err = m.statistics().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"client_id", 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+			bson.M{"client_id": bson.M{"$exists": true}},
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.statistics().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"created_at", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	// demo answers are deleted by Mongo once they expire, the others don't
+	// have an expiry
+	_, err = m.statistics().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"expires_at", 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.archives().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"from", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.statsCorrections().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"stats_id", 1}, {"created_at", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.practiceSessions().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"_id", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.practiceSessions().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"started_at", 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.settings().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"tenant", 1}, {"user", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.notificationPreferences().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"tenant", 1}, {"user", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.integrations().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"user", 1}, {"created_at", 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.streakPauses().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"tenant", 1}, {"user", 1}, {"from", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.streakFreezes().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"tenant", 1}, {"user", 1}, {"day", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.badges().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"tenant", 1}, {"user", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{"hash", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.relationships().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"teacher", 1}, {"student", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{"student", 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.assignments().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"teacher", 1}, {"due_at", 1}}},
+		{Keys: bson.D{{"students", 1}, {"due_at", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.users().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"identities.provider", 1}, {"identities.subject", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{"deletion_scheduled_at", 1}},
+			Options: options.Index().SetPartialFilterExpression(
+				bson.M{"deletion_scheduled_at": bson.M{"$exists": true}},
+			),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// runs are deleted by Mongo after jobRunRetention
+	_, err = m.jobRuns().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"job", 1}, {"started_at", -1}}},
+		{Keys: bson.D{{"started_at", 1}}, Options: options.Index().SetExpireAfterSeconds(int32(jobRunRetention.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	// events are deleted by Mongo after eventRetention
+	_, err = m.events().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"tenant", 1}, {"at", 1}}},
+		{Keys: bson.D{{"at", 1}}, Options: options.Index().SetExpireAfterSeconds(int32(eventRetention.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	// expired sessions are deleted by Mongo
+	_, err = m.sessions().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"hash", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"user_id", 1}}},
+		{Keys: bson.D{{"expires_at", 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.backfillSyncSeq(ctx)
+}
+
+// supportsTransactions tells if Mongo is a replica set member or a mongos,
+// the deployments that support transactions
+func (m *mongoRepository) supportsTransactions(ctx context.Context) (bool, error) {
+	var result struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := m.client.Database("admin").RunCommand(ctx, bson.D{{"isMaster", 1}}).Decode(&result)
+	return result.SetName != "" || result.Msg == "isdbgrid", err
+}
+
+func (m *mongoRepository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !m.transactions {
+		return fn(ctx)
+	}
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	return mongo.WithSession(ctx, session, func(sessionCtx mongo.SessionContext) error {
+		err := sessionCtx.StartTransaction()
+		if err != nil {
+			return err
+		}
+
+		err = fn(sessionCtx)
+		if err != nil {
+			// the abort error is of no interest next to the one that caused it
+			sessionCtx.AbortTransaction(sessionCtx)
+			return err
+		}
+		return sessionCtx.CommitTransaction(sessionCtx)
+	})
+}
+
+// backfillTenants hands the data stored before tenants existed to the
+// default tenant. Settings used to be keyed on the user ID alone.
+func (m *mongoRepository) backfillTenants(ctx context.Context) error {
+	noTenant := bson.M{"tenant": bson.M{"$exists": false}}
+	for _, collection := range []*mongo.Collection{m.statistics(), m.tombstones()} {
+		_, err := collection.UpdateMany(ctx, noTenant, bson.M{"$set": bson.M{"tenant": defaultTenant}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := m.settings().UpdateMany(
+		ctx,
+		noTenant,
+		mongo.Pipeline{bson.D{{"$set", bson.D{
+			{"tenant", defaultTenant},
+			{"user", "$_id"},
+		}}}},
+	)
+	return err
+}
+
+// isIndexNotFound tells if err is Mongo failing to drop a missing index
+func isIndexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && commandErr.Name == "IndexNotFound"
+}
+
+// backfillSyncSeq numbers the stats stored before the delta sync existed,
+// in insertion order
+func (m *mongoRepository) backfillSyncSeq(ctx context.Context) error {
+	cursor, err := m.statistics().Find(
+		ctx,
+		bson.M{"sync_seq": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{"_id", 1}}).SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return err
+		}
+
+		seq, release, err := m.nextSyncSeq(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = m.statistics().UpdateByID(ctx, doc.ID, bson.M{"$set": bson.M{"sync_seq": seq}})
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// nextSyncSeq hands out a sync sequence number from a counter document, see
+// reserveSyncSeqs
+func (m *mongoRepository) nextSyncSeq(ctx context.Context) (int64, func(), error) {
+	return m.reserveSyncSeqs(ctx, 1)
+}
+
+// reserveSyncSeqs hands out n sync sequence numbers at once, the last of
+// which is returned. Writes commit in another order than they take their
+// numbers, so the numbers are pending until release is called once the
+// write is done, and the delta sync holds back everything from the first
+// pending number on, see syncWatermark. Reservations older than
+// syncSeqOverlap are dropped as abandoned.
+func (m *mongoRepository) reserveSyncSeqs(ctx context.Context, n int) (int64, func(), error) {
+	now := time.Now()
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := m.counters().FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": "sync_seq"},
+		mongo.Pipeline{
+			{{"$set", bson.M{
+				"seq": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$seq", 0}}, n}},
+				"pending": bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$pending", bson.A{}}},
+					"cond":  bson.M{"$gt": bson.A{"$$this.at", now.Add(-syncSeqOverlap)}},
+				}},
+			}}},
+			{{"$set", bson.M{
+				"pending": bson.M{"$concatArrays": bson.A{"$pending", bson.A{bson.M{
+					"first": bson.M{"$subtract": bson.A{"$seq", n - 1}},
+					"at":    now,
+				}}}},
+			}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	first := counter.Seq - int64(n-1)
+	return counter.Seq, func() { releaseSyncSeq(m, first) }, nil
+}
+
+// syncWatermark returns the latest sync sequence number up to which every
+// write is done, the delta sync goes no further
+func (m *mongoRepository) syncWatermark(ctx context.Context) (int64, error) {
+	var counter struct {
+		Seq     int64 `bson:"seq"`
+		Pending []struct {
+			First int64     `bson:"first"`
+			At    time.Time `bson:"at"`
+		} `bson:"pending"`
+	}
+	err := m.counters().FindOne(ctx, bson.M{"_id": "sync_seq"}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	watermark := counter.Seq
+	for _, pending := range counter.Pending {
+		if time.Since(pending.At) < syncSeqOverlap && pending.First <= watermark {
+			watermark = pending.First - 1
+		}
+	}
+	return watermark, nil
+}
+
+func (m *mongoRepository) SaveStats(ctx context.Context, stats StatsRaw, ifVersion int64) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	stats.Tenant = tenant
+	stats = withExpiry(ctx, stats)
+
+	seq, release, err := m.nextSyncSeq(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	defer release()
+	stats.SyncSeq = seq
+	stats.SchemaVersion = statsSchemaVersion
+
+	if stats.ClientID == "" || ifVersion == 0 {
+		stats.ID = primitive.NewObjectID()
+		stats.Version = 1
+		_, err = m.statistics().InsertOne(ctx, stats)
+		if ifVersion == 0 && mongo.IsDuplicateKeyError(err) {
+			return m.conflictingStats(ctx, stats.ClientID)
+		}
+		return stats, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"chord_name":             stats.ChordName,
+			"root_note":              stats.RootNote,
+			"chord_extension":        stats.ChordExtension,
+			"answer_duration_millis": stats.AnswerDurationMilliSeconds,
+			"created_at":             stats.CreatedAt,
+			"updated_at":             stats.UpdatedAt,
+			"platform":               stats.Platform,
+			"app_version":            stats.AppVersion,
+			"correct":                stats.Correct,
+			"instrument":             stats.Instrument,
+			"bpm":                    stats.BPM,
+			"beats_to_answer":        stats.BeatsToAnswer,
+			"confidence":             stats.Confidence,
+			"attempt":                stats.Attempt,
+			"tags":                   stats.Tags,
+			"note":                   stats.Note,
+			"demo":                   stats.Demo,
+			"expires_at":             stats.ExpiresAt,
+			"experiments":            stats.Experiments,
+			"sync_seq":               stats.SyncSeq,
+			"schema_version":         stats.SchemaVersion,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	if ifVersion != anyVersion {
+		result, err := m.statistics().UpdateOne(
+			ctx,
+			bson.M{"tenant": tenant, "client_id": stats.ClientID, "version": ifVersion},
+			update,
+		)
+		if err != nil {
+			return StatsRaw{}, err
+		}
+		if result.MatchedCount == 0 {
+			return m.conflictingStats(ctx, stats.ClientID)
+		}
+	} else {
+		// only an older stored version matches the filter, when a newer one is
+		// stored the upsert collides with it on the unique client_id index.
+		// The upsert takes the tenant from the filter.
+		_, err = m.statistics().UpdateOne(
+			ctx,
+			bson.M{
+				"tenant":     tenant,
+				"client_id":  stats.ClientID,
+				"updated_at": bson.M{"$lt": stats.UpdatedAt},
+			},
+			update,
+			options.Update().SetUpsert(true),
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return StatsRaw{}, err
+		}
+	}
+
+	raw, err := m.statistics().FindOne(ctx, bson.M{"tenant": tenant, "client_id": stats.ClientID}).DecodeBytes()
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	return decodeStats(raw)
+}
+
+func (m *mongoRepository) InsertStats(ctx context.Context, stats []StatsRaw) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+	last, release, err := m.reserveSyncSeqs(ctx, len(stats))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	docs := make([]interface{}, len(stats))
+	for i, s := range stats {
+		s.Tenant = tenant
+		s = withExpiry(ctx, s)
+		s.SyncSeq = last - int64(len(stats)-1-i)
+		s.SchemaVersion = statsSchemaVersion
+		docs[i] = s
+	}
+
+	// unordered, so one already inserted document doesn't stop the rest
+	_, err = m.statistics().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeys(err) {
+		return err
+	}
+	return nil
+}
+
+// onlyDuplicateKeys tells if every write of a bulk insert failed for its key
+// already being stored
+func onlyDuplicateKeys(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// conflictingStats returns the stored stats that kept a conditional write
+// from being applied, if there are any
+func (m *mongoRepository) conflictingStats(ctx context.Context, clientID string) (StatsRaw, error) {
+	raw, err := m.statistics().FindOne(ctx, tenantQuery(ctx, bson.M{"client_id": clientID})).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return StatsRaw{}, errVersionConflict
+	}
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	stored, err := decodeStats(raw)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	return stored, errVersionConflict
+}
+
+func (m *mongoRepository) EachStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	cursor, err := m.readCollection("statistics", m.reads).Find(
+		ctx,
+		tenantQuery(ctx, filter.bson()),
+		statsFindOptions(filter),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		stats, err := decodeStats(cursor.Current)
+		if err != nil {
+			return err
+		}
+
+		err = fn(stats)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// statsFindOptions sorts stats by id when limited, so the limit cuts them
+// off in the same place every time
+func statsFindOptions(filter StatsFilter) *options.FindOptions {
+	if filter.Limit <= 0 {
+		return options.Find()
+	}
+	return options.Find().SetSort(bson.D{{"_id", 1}}).SetLimit(int64(filter.Limit))
+}
+
+func (m *mongoRepository) CountStats(ctx context.Context) (int, error) {
+	count, err := m.readCollection("statistics", m.reads).CountDocuments(ctx, tenantQuery(ctx, bson.M{}))
+	return int(count), err
+}
+
+func (m *mongoRepository) CountMatchingStats(ctx context.Context, filter StatsFilter) (int, error) {
+	count, err := m.statistics().CountDocuments(ctx, tenantQuery(ctx, filter.bson()))
+	return int(count), err
+}
+
+// deleteBatchSize is how many stats DeleteStats deletes at a time
+const deleteBatchSize = 1000
+
+// DeleteStats writes the tombstones of a batch before deleting it, keyed by
+// the ids of the stats. Deleting again after failing halfway then skips the
+// tombstones written already.
+func (m *mongoRepository) DeleteStats(ctx context.Context, filter StatsFilter) (int, error) {
+	cursor, err := m.statistics().Find(
+		ctx,
+		tenantQuery(ctx, filter.bson()),
+		options.Find().SetProjection(bson.M{"_id": 1, "tenant": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var matched []Tombstone
+	err = cursor.All(ctx, &matched)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for start := 0; start < len(matched); start += deleteBatchSize {
+		batch := matched[start:]
+		if len(batch) > deleteBatchSize {
+			batch = batch[:deleteBatchSize]
+		}
+		last, release, err := m.reserveSyncSeqs(ctx, len(batch))
+		if err != nil {
+			return deleted, err
+		}
+		now := time.Now()
+		docs := make([]interface{}, len(batch))
+		ids := make([]primitive.ObjectID, len(batch))
+		for i, tombstone := range batch {
+			tombstone.DeletedAt = now
+			tombstone.SyncSeq = last - int64(len(batch)-1-i)
+			docs[i] = tombstone
+			ids[i] = tombstone.ID
+		}
+		_, err = m.tombstones().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		release()
+		if err != nil && !onlyDuplicateKeys(err) {
+			return deleted, err
+		}
+		result, err := m.statistics().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(result.DeletedCount)
+	}
+	return deleted, nil
+}
+
+func (m *mongoRepository) ReserveSyncSeq(ctx context.Context) (int64, error) {
+	seq, _, err := m.nextSyncSeq(ctx)
+	return seq, err
+}
+
+func (m *mongoRepository) ReleaseSyncSeq(ctx context.Context, seq int64) error {
+	_, err := m.counters().UpdateOne(ctx, bson.M{"_id": "sync_seq"}, bson.M{"$pull": bson.M{"pending": bson.M{"first": seq}}})
+	return err
+}
+
+func (m *mongoRepository) CorrectStats(ctx context.Context, stats StatsRaw, ifVersion, seq int64) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+
+	result, err := m.statistics().UpdateOne(
+		ctx,
+		bson.M{"_id": stats.ID, "tenant": tenant, "version": ifVersion},
+		bson.M{
+			"$set": bson.M{
+				"correct":         stats.Correct,
+				"chord_name":      stats.ChordName,
+				"chord_extension": stats.ChordExtension,
+				"tags":            stats.Tags,
+				"updated_at":      stats.UpdatedAt,
+				"sync_seq":        seq,
+			},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	if result.MatchedCount == 0 {
+		count, err := m.statistics().CountDocuments(ctx, bson.M{"_id": stats.ID, "tenant": tenant})
+		if err != nil {
+			return StatsRaw{}, err
+		}
+		if count == 0 {
+			return StatsRaw{}, errNotFound
+		}
+		return StatsRaw{}, errVersionConflict
+	}
+
+	stats.Tenant = tenant
+	stats.Version = ifVersion + 1
+	stats.SyncSeq = seq
+	return stats, nil
+}
+
+func (m *mongoRepository) InsertStatsCorrection(ctx context.Context, entry StatsCorrectionEntry) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+	entry.Tenant = tenant
+	entry.SchemaVersion = statsCorrectionsSchema.version()
+	_, err = m.statsCorrections().InsertOne(ctx, entry)
+	return err
+}
+
+func (m *mongoRepository) StatsCorrections(ctx context.Context, statsID primitive.ObjectID) ([]StatsCorrectionEntry, error) {
+	cursor, err := m.statsCorrections().Find(
+		ctx,
+		tenantQuery(ctx, bson.M{"stats_id": statsID}),
+		options.Find().SetSort(bson.D{{"created_at", 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	corrections := []StatsCorrectionEntry{}
+	err = statsCorrectionsSchema.decodeAll(ctx, cursor, &corrections)
+	return corrections, err
+}
+
+func (m *mongoRepository) SavePracticeSession(ctx context.Context, session PracticeSession) error {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return err
+	}
+	session.Tenant = tenant
+	session.SchemaVersion = practiceSessionsSchema.version()
+	_, err = m.practiceSessions().InsertOne(ctx, session)
+	return err
+}
+
+func (m *mongoRepository) PracticeSession(ctx context.Context, id primitive.ObjectID) (PracticeSession, error) {
+	var session PracticeSession
+	err := practiceSessionsSchema.decodeOne(m.practiceSessions().FindOne(ctx, tenantQuery(ctx, bson.M{"_id": id})), &session)
+	if err == mongo.ErrNoDocuments {
+		return PracticeSession{}, errNotFound
+	}
+	return session, err
+}
+
+func (m *mongoRepository) EndPracticeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := m.practiceSessions().UpdateOne(
+		ctx,
+		tenantQuery(ctx, bson.M{"_id": id, "ended_at": bson.M{"$exists": false}}),
+		bson.M{"$set": bson.M{"ended_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	// nothing matched, the session ended already or there is none
+	count, err := m.practiceSessions().CountDocuments(ctx, tenantQuery(ctx, bson.M{"_id": id}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) OpenPracticeSessions(ctx context.Context, since time.Time) ([]PracticeSession, error) {
+	cursor, err := m.practiceSessions().Find(ctx, tenantQuery(ctx, bson.M{
+		"started_at":  bson.M{"$gte": since},
+		"ended_at":    bson.M{"$exists": false},
+		"goal_met_at": bson.M{"$exists": false},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []PracticeSession{}
+	err = practiceSessionsSchema.decodeAll(ctx, cursor, &sessions)
+	return sessions, err
+}
+
+func (m *mongoRepository) MarkPracticeGoalMet(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := m.practiceSessions().UpdateOne(
+		ctx,
+		tenantQuery(ctx, bson.M{"_id": id, "goal_met_at": bson.M{"$exists": false}}),
+		bson.M{"$set": bson.M{"goal_met_at": at}},
+	)
+	return err
+}
+
+func (m *mongoRepository) RetagStats(ctx context.Context, filter StatsFilter, add, remove []string) (StatsRetagReport, error) {
+	cursor, err := m.statistics().Find(
+		ctx,
+		tenantQuery(ctx, filter.bson()),
+		options.Find().SetProjection(bson.M{"_id": 1, "tags": 1, "version": 1}),
+	)
+	if err != nil {
+		return StatsRetagReport{}, err
+	}
+	var matched []StatsRaw
+	err = cursor.All(ctx, &matched)
+	if err != nil {
+		return StatsRetagReport{}, err
+	}
+
+	report := StatsRetagReport{Matched: len(matched)}
+	var changed []StatsRaw
+	for _, stats := range matched {
+		tags := retagged(stats.Tags, add, remove)
+		if len(tags) > maxTags {
+			report.Full++
+			continue
+		}
+		if !sameTags(tags, stats.Tags) {
+			stats.Tags = tags
+			changed = append(changed, stats)
+		}
+	}
+
+	for start := 0; start < len(changed); start += deleteBatchSize {
+		batch := changed[start:]
+		if len(batch) > deleteBatchSize {
+			batch = batch[:deleteBatchSize]
+		}
+		last, release, err := m.reserveSyncSeqs(ctx, len(batch))
+		if err != nil {
+			return report, err
+		}
+		now := time.Now()
+		models := make([]mongo.WriteModel, len(batch))
+		for i, stats := range batch {
+			// stats written in the meantime are left alone, like the ones
+			// that no longer match
+			models[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": stats.ID, "version": stats.Version}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"tags":       stats.Tags,
+						"updated_at": now,
+						"sync_seq":   last - int64(len(batch)-1-i),
+					},
+					"$inc": bson.M{"version": 1},
+				})
+		}
+		result, err := m.statistics().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		release()
+		if result != nil {
+			report.Modified += int(result.ModifiedCount)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// matchTenant is the pipeline stage limiting an aggregation to the tenant of
+// ctx
+func matchTenant(ctx context.Context) bson.D {
+	return bson.D{{"$match", analyticsQuery(ctx, bson.M{})}}
+}
+
+// matchStats is the pipeline stage limiting an aggregation of stats to the
+// tenant and instrument of ctx
+func matchStats(ctx context.Context) bson.D {
+	return bson.D{{"$match", analyticsQuery(ctx, instrumentQuery("instrument", instrumentFromContext(ctx), bson.M{}))}}
+}
+
+func (m *mongoRepository) CountByDay(ctx context.Context, loc *time.Location) ([]StatsCountByDay, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{
+						"_id", bson.D{{
+							"$dateToString", bson.D{
+								{"format", "%Y-%m-%d"},
+								{"date", "$created_at"},
+								{"timezone", loc.String()},
+							},
+						}},
+					},
+					{
+						"count", bson.D{{"$sum", 1}},
+					},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var countByDays []StatsCountByDay
+	err = cursor.All(ctx, &countByDays)
+	return countByDays, err
+}
+
+func (m *mongoRepository) CountByDayAndExtension(ctx context.Context, loc *time.Location) ([]StatsCountByDayAndExtension, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{
+						"_id", bson.D{
+							{"day", bson.D{{
+								"$dateToString", bson.D{
+									{"format", "%Y-%m-%d"},
+									{"date", "$created_at"},
+									{"timezone", loc.String()},
+								},
+							}}},
+							{"chord_extension", "$chord_extension"},
+						},
+					},
+					{
+						"count", bson.D{{"$sum", 1}},
+					},
+				},
+			}},
+			bson.D{{
+				"$project", bson.D{
+					{"_id", 0},
+					{"day", "$_id.day"},
+					{"chord_extension", "$_id.chord_extension"},
+					{"count", 1},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []StatsCountByDayAndExtension
+	err = cursor.All(ctx, &counts)
+	return counts, err
+}
+
+func (m *mongoRepository) CountByExtension(ctx context.Context) ([]StatsCountByExtension, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$chord_extension"},
+					{"count", bson.D{{"$sum", 1}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var countByExtensions []StatsCountByExtension
+	err = cursor.All(ctx, &countByExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups, err := m.archivedByExtension(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, countByExtension := range countByExtensions {
+		countByExtensions[i].Count += rollups[countByExtension.Extension].Count
+		delete(rollups, countByExtension.Extension)
+	}
+	for extension, rollup := range rollups {
+		countByExtensions = append(countByExtensions, StatsCountByExtension{Extension: extension, Count: rollup.Count})
+	}
+	return countByExtensions, nil
+}
+
+func (m *mongoRepository) CountByRootNote(ctx context.Context, since time.Time) ([]StatsCountByRootNote, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{"$match", bson.M{"created_at": bson.M{"$gte": since}}}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$root_note"},
+					{"count", bson.D{{"$sum", 1}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var countByRootNotes []StatsCountByRootNote
+	err = cursor.All(ctx, &countByRootNotes)
+	return countByRootNotes, err
+}
+
+func (m *mongoRepository) OutcomesByTempo(ctx context.Context) ([]TempoOutcome, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{"$match", bson.M{"bpm": bson.M{"$gt": 0}}}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$bpm"},
+					{"answers", bson.D{{"$sum", 1}}},
+					{"correct", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$correct", false}}}, 0, 1}}}}}},
+					{"avg_beats_to_answer", bson.D{{"$avg", "$beats_to_answer"}}},
+					{"avg_duration_millis", bson.D{{"$avg", "$answer_duration_millis"}}},
+				},
+			}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var outcomes []TempoOutcome
+	err = cursor.All(ctx, &outcomes)
+	return outcomes, err
+}
+
+func (m *mongoRepository) OutcomesByConfidence(ctx context.Context) ([]ConfidenceOutcome, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{"$match", bson.M{"confidence": bson.M{"$gt": 0}}}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$confidence"},
+					{"answers", bson.D{{"$sum", 1}}},
+					{"correct", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$correct", false}}}, 0, 1}}}}}},
+					{"avg_duration_millis", bson.D{{"$avg", "$answer_duration_millis"}}},
+				},
+			}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var outcomes []ConfidenceOutcome
+	err = cursor.All(ctx, &outcomes)
+	return outcomes, err
+}
+
+func (m *mongoRepository) AttemptOutcomes(ctx context.Context) ([]AttemptOutcome, error) {
+	correct := bson.D{{"$ne", bson.A{"$correct", false}}}
+	// a missing attempt compares as less than 1, like a first try
+	firstTry := bson.D{{"$lte", bson.A{"$attempt", 1}}}
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$chord_extension"},
+					{"answers", bson.D{{"$sum", 1}}},
+					{"first_try_correct", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$and", bson.A{correct, firstTry}}}, 1, 0}}}}}},
+					{"correct", bson.D{{"$sum", bson.D{{"$cond", bson.A{correct, 1, 0}}}}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var outcomes []AttemptOutcome
+	err = cursor.All(ctx, &outcomes)
+	return outcomes, err
+}
+
+func (m *mongoRepository) AvgDurationByExtension(ctx context.Context) ([]StatsDurationByExtension, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchStats(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$chord_extension"},
+					{"count", bson.D{{"$sum", 1}}},
+					{"duration_sum", bson.D{{"$sum", "$answer_duration_millis"}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var hot []struct {
+		Extension   string `bson:"_id"`
+		Count       int    `bson:"count"`
+		DurationSum int64  `bson:"duration_sum"`
+	}
+	err = cursor.All(ctx, &hot)
+	if err != nil {
+		return nil, err
+	}
+
+	// the averages are taken over the hot and archived stats together
+	rollups, err := m.archivedByExtension(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range hot {
+		rollup := rollups[group.Extension]
+		rollup.Count += group.Count
+		rollup.DurationSum += group.DurationSum
+		rollups[group.Extension] = rollup
+	}
+
+	durationByExtensions := []StatsDurationByExtension{}
+	for extension, rollup := range rollups {
+		durationByExtensions = append(durationByExtensions, StatsDurationByExtension{
+			Extension:   extension,
+			AvgDuration: float64(rollup.DurationSum) / float64(rollup.Count),
+			Count:       rollup.Count,
+		})
+	}
+	return durationByExtensions, nil
+}
+
+// archivedByExtension sums up the rollups of the archived stats per chord
+// extension
+func (m *mongoRepository) archivedByExtension(ctx context.Context) (map[string]extensionRollup, error) {
+	cursor, err := m.readCollection("statistics_archive", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchTenant(ctx),
+			bson.D{{"$unwind", "$extensions"}},
+			bson.D{{"$match", instrumentQuery("extensions.instrument", instrumentFromContext(ctx), bson.M{})}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$extensions.chord_extension"},
+					{"count", bson.D{{"$sum", "$extensions.count"}}},
+					{"duration_sum", bson.D{{"$sum", "$extensions.duration_sum"}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Extension   string `bson:"_id"`
+		Count       int    `bson:"count"`
+		DurationSum int64  `bson:"duration_sum"`
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := make(map[string]extensionRollup)
+	for _, group := range groups {
+		rollups[group.Extension] = extensionRollup{
+			Extension:   group.Extension,
+			Count:       group.Count,
+			DurationSum: group.DurationSum,
+		}
+	}
+	return rollups, nil
+}
+
+func (m *mongoRepository) ArchiveStats(ctx context.Context, before time.Time) (int, error) {
+	archived := 0
+	for {
+		cursor, err := m.statistics().Find(
+			ctx,
+			tenantQuery(ctx, bson.M{"created_at": bson.M{"$lt": before}}),
+			options.Find().
+				SetSort(bson.D{{"tenant", 1}, {"created_at", 1}}).
+				SetLimit(archiveChunkSize),
+		)
+		if err != nil {
+			return archived, err
+		}
+		stats, err := decodeAllStats(ctx, cursor)
+		if err != nil {
+			return archived, err
+		}
+		if len(stats) == 0 {
+			return archived, nil
+		}
+
+		// a chunk only ever holds the stats of a single tenant
+		n := 1
+		for n < len(stats) && stats[n].Tenant == stats[0].Tenant {
+			n++
+		}
+		stats = stats[:n]
+
+		err = m.InTransaction(ctx, func(ctx context.Context) error {
+			return m.archiveChunk(ctx, stats)
+		})
+		if err != nil {
+			return archived, err
+		}
+		archived += len(stats)
+	}
+}
+
+// archiveChunk moves stats of a single tenant sorted by created_at into one
+// archive chunk. Without transactions, a chunk that was archived but not yet
+// deleted when the server stopped is archived again, colliding on its id.
+func (m *mongoRepository) archiveChunk(ctx context.Context, stats []StatsRaw) error {
+	archive, err := newStatsArchive(stats)
+	if err != nil {
+		return err
+	}
+	_, err = m.archives().InsertOne(ctx, archive)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, len(stats))
+	for i, s := range stats {
+		ids[i] = s.ID
+	}
+	_, err = m.statistics().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (m *mongoRepository) EachArchivedStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	// only the chunks overlapping the filtered range are decompressed
+	query := bson.M{}
+	if !filter.Since.IsZero() {
+		query["until"] = bson.M{"$gte": filter.Since}
+	}
+	if !filter.Until.IsZero() {
+		query["from"] = bson.M{"$lt": filter.Until}
+	}
+	cursor, err := m.readCollection("statistics_archive", m.reads).Find(
+		ctx,
+		tenantQuery(ctx, query),
+		options.Find().SetSort(bson.D{{"from", 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var archive statsArchive
+		err = cursor.Decode(&archive)
+		if err != nil {
+			return err
+		}
+		stats, err := archive.Stats()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range stats {
+			if filter.Limit > 0 && count >= filter.Limit {
+				return nil
+			}
+			if !filter.matches(s) {
+				continue
+			}
+			count++
+
+			err = fn(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// Changes goes up to the sync watermark, and reads from the primary since a
+// secondary could still lack writes below it
+func (m *mongoRepository) Changes(ctx context.Context, since int64, limit int) ([]StatsRaw, []Tombstone, error) {
+	watermark, err := m.syncWatermark(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := tenantQuery(ctx, bson.M{"sync_seq": bson.M{"$gt": since, "$lte": watermark}})
+	opts := options.Find().SetSort(bson.D{{"sync_seq", 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.statistics().Find(ctx, query, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats, err := decodeAllStats(ctx, cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cursor, err = m.tombstones().Find(ctx, query, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	tombstones := []Tombstone{}
+	err = cursor.All(ctx, &tombstones)
+	return stats, tombstones, err
+}
+
+func (m *mongoRepository) UsageByClientVersion(ctx context.Context) ([]ClientVersionUsage, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchTenant(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", bson.D{
+						{"platform", "$platform"},
+						{"app_version", "$app_version"},
+					}},
+					{"count", bson.D{{"$sum", 1}}},
+					{"avg", bson.D{{"$avg", "$answer_duration_millis"}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		ID struct {
+			Platform   string `bson:"platform"`
+			AppVersion string `bson:"app_version"`
+		} `bson:"_id"`
+		Count int     `bson:"count"`
+		Avg   float64 `bson:"avg"`
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []ClientVersionUsage{}
+	for _, group := range groups {
+		usages = append(usages, ClientVersionUsage{
+			Platform:          group.ID.Platform,
+			AppVersion:        group.ID.AppVersion,
+			StatsCount:        group.Count,
+			AvgDurationMillis: group.Avg,
+		})
+	}
+	return usages, nil
+}
+
+// TenantActivity groups the stats by tenant and day, and takes the first day
+// of tenants whose oldest stats are archived from the archive
+func (m *mongoRepository) TenantActivity(ctx context.Context, since time.Time) ([]TenantActivity, error) {
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchTenant(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", bson.D{
+						{"tenant", "$tenant"},
+						{"day", bson.D{{
+							"$dateToString", bson.D{
+								{"format", "%Y-%m-%d"},
+								{"date", "$created_at"},
+							},
+						}}},
+					}},
+				},
+			}},
+			bson.D{{"$sort", bson.D{{"_id.day", 1}}}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$_id.tenant"},
+					{"first", bson.D{{"$first", "$_id.day"}}},
+					{"days", bson.D{{"$push", "$_id.day"}}},
+				},
+			}},
+			bson.D{{
+				"$project", bson.D{
+					{"first", 1},
+					{"days", bson.D{{
+						"$filter", bson.D{
+							{"input", "$days"},
+							{"cond", bson.D{{"$gte", bson.A{"$$this", since.UTC().Format(dayLayout)}}}},
+						},
+					}}},
+				},
+			}},
+			bson.D{{"$match", bson.M{"days.0": bson.M{"$exists": true}}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := []TenantActivity{}
+	err = cursor.All(ctx, &activity)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = m.readCollection("statistics_archive", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchTenant(ctx),
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$tenant"},
+					{"from", bson.D{{"$min", "$from"}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	var archived []struct {
+		Tenant string    `bson:"_id"`
+		From   time.Time `bson:"from"`
+	}
+	err = cursor.All(ctx, &archived)
+	if err != nil {
+		return nil, err
+	}
+	archivedFrom := make(map[string]string)
+	for _, a := range archived {
+		archivedFrom[a.Tenant] = a.From.UTC().Format(dayLayout)
+	}
+	for i := range activity {
+		if from, exists := archivedFrom[activity[i].Tenant]; exists && from < activity[i].First {
+			activity[i].First = from
+		}
+	}
+	return activity, nil
+}
+
+// InsertEvents inserts unordered, events inserted already by an earlier try
+// are skipped on their duplicate _id
+func (m *mongoRepository) InsertEvents(ctx context.Context, events []Event) error {
+	documents := make([]interface{}, len(events))
+	for i := range events {
+		documents[i] = events[i]
+	}
+	_, err := m.events().InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if onlyDuplicateKeys(err) {
+		return nil
+	}
+	return err
+}
+
+func (m *mongoRepository) TenantEvents(ctx context.Context, since, until time.Time) ([]TenantEvents, error) {
+	cursor, err := m.readCollection("events", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			bson.D{{"$match", tenantQuery(ctx, bson.M{"at": bson.M{"$gte": since, "$lt": until}})}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$tenant"},
+					{"names", bson.D{{"$addToSet", "$name"}}},
+				},
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []TenantEvents{}
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func settingsQuery(ctx context.Context, userID string) bson.M {
+	return bson.M{"tenant": tenantFromContext(ctx), "user": userID}
+}
+
+func (m *mongoRepository) GetSettings(ctx context.Context, userID string) (Settings, error) {
+	var settings Settings
+	err := settingsSchema.decodeOne(m.settings().FindOne(ctx, settingsQuery(ctx, userID)), &settings)
+	if err == mongo.ErrNoDocuments {
+		return Settings{}, nil
+	}
+	return settings, err
+}
+
+func (m *mongoRepository) UpdateSettings(ctx context.Context, userID string, update Settings, ifVersion int64) (Settings, error) {
+	// the omitempty tags leave out the fields that aren't updated, and the
+	// upsert takes the tenant and user from the filter. Only new settings
+	// get the schema version, the update may leave older ones half as
+	// stored.
+	update.SchemaVersion = 0
+	update.Version = 0
+	filter := settingsQuery(ctx, userID)
+	switch ifVersion {
+	case anyVersion:
+	case 0:
+		// settings stored before they had versions are at version 0 too
+		filter["version"] = bson.M{"$exists": false}
+	default:
+		filter["version"] = ifVersion
+	}
+	// only the unconditional and the first update may insert, any other
+	// update finds nothing when the version doesn't match
+	upsert := ifVersion == anyVersion || ifVersion == 0
+	var settings Settings
+	err := settingsSchema.decodeOne(m.settings().FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{"$set": update, "$inc": bson.M{"version": 1}, "$setOnInsert": bson.M{"schema_version": settingsSchema.version()}},
+		options.FindOneAndUpdate().SetUpsert(upsert).SetReturnDocument(options.After),
+	), &settings)
+	if ifVersion != anyVersion && (err == mongo.ErrNoDocuments || mongo.IsDuplicateKeyError(err)) {
+		// the insert of an update at version 0 collides with the stored
+		// settings
+		settings, err = m.GetSettings(ctx, userID)
+		if err != nil {
+			return Settings{}, err
+		}
+		return settings, errVersionConflict
+	}
+	return settings, err
+}
+
+func (m *mongoRepository) GetNotificationPreferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := m.notificationPreferences().FindOne(ctx, settingsQuery(ctx, userID)).Decode(&preferences)
+	if err == mongo.ErrNoDocuments {
+		return NotificationPreferences{}, nil
+	}
+	return preferences, err
+}
+
+// UpdateNotificationPreferences upserts like UpdateSettings does
+func (m *mongoRepository) UpdateNotificationPreferences(ctx context.Context, userID string, update NotificationPreferences) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := m.notificationPreferences().FindOneAndUpdate(
+		ctx,
+		settingsQuery(ctx, userID),
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&preferences)
+	return preferences, err
+}
+
+func (m *mongoRepository) Integrations(ctx context.Context, userID string) ([]Integration, error) {
+	cursor, err := m.integrations().Find(ctx, settingsQuery(ctx, userID), options.Find().SetSort(bson.D{{"created_at", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	integrations := []Integration{}
+	err = cursor.All(ctx, &integrations)
+	return integrations, err
+}
+
+func (m *mongoRepository) AllIntegrations(ctx context.Context) ([]Integration, error) {
+	cursor, err := m.integrations().Find(ctx, tenantQuery(ctx, bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	integrations := []Integration{}
+	err = cursor.All(ctx, &integrations)
+	return integrations, err
+}
+
+func (m *mongoRepository) SaveIntegration(ctx context.Context, integration Integration) error {
+	_, err := m.integrations().InsertOne(ctx, integration)
+	return err
+}
+
+func (m *mongoRepository) DeleteIntegration(ctx context.Context, userID string, id primitive.ObjectID) error {
+	query := settingsQuery(ctx, userID)
+	query["_id"] = id
+	result, err := m.integrations().DeleteOne(ctx, query)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) MarkSummarySent(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := m.integrations().UpdateOne(ctx, tenantQuery(ctx, bson.M{"_id": id}), bson.M{"$set": bson.M{"summary_sent_at": at}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) MarkStreakAnnounced(ctx context.Context, id primitive.ObjectID, key string) (bool, error) {
+	result, err := m.integrations().UpdateOne(
+		ctx,
+		tenantQuery(ctx, bson.M{"_id": id, "streak_announced": bson.M{"$ne": key}}),
+		bson.M{"$set": bson.M{"streak_announced": key}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (m *mongoRepository) StreakPauses(ctx context.Context, userID string) ([]StreakPause, error) {
+	cursor, err := m.streakPauses().Find(ctx, settingsQuery(ctx, userID), options.Find().SetSort(bson.D{{"from", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	pauses := []StreakPause{}
+	err = streakPausesSchema.decodeAll(ctx, cursor, &pauses)
+	return pauses, err
+}
+
+func (m *mongoRepository) SaveStreakPause(ctx context.Context, pause StreakPause) error {
+	pause.SchemaVersion = streakPausesSchema.version()
+	_, err := m.streakPauses().InsertOne(ctx, pause)
+	return err
+}
+
+func (m *mongoRepository) DeleteStreakPause(ctx context.Context, userID string, id primitive.ObjectID) error {
+	query := settingsQuery(ctx, userID)
+	query["_id"] = id
+	result, err := m.streakPauses().DeleteOne(ctx, query)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) StreakFreezes(ctx context.Context, userID string) ([]StreakFreeze, error) {
+	cursor, err := m.streakFreezes().Find(ctx, settingsQuery(ctx, userID), options.Find().SetSort(bson.D{{"day", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	freezes := []StreakFreeze{}
+	err = streakFreezesSchema.decodeAll(ctx, cursor, &freezes)
+	return freezes, err
+}
+
+func (m *mongoRepository) SaveStreakFreeze(ctx context.Context, freeze StreakFreeze) error {
+	freeze.SchemaVersion = streakFreezesSchema.version()
+	_, err := m.streakFreezes().UpdateOne(
+		ctx,
+		bson.M{"tenant": freeze.Tenant, "user": freeze.UserID, "day": freeze.Day},
+		bson.M{"$setOnInsert": freeze},
+		options.Update().SetUpsert(true),
+	)
+	// a concurrent upsert of the same day inserted it first
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (m *mongoRepository) SaveBadge(ctx context.Context, badge Badge) error {
+	_, err := m.badges().ReplaceOne(
+		ctx,
+		bson.M{"tenant": badge.Tenant, "user": badge.UserID},
+		badge,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *mongoRepository) BadgeByHash(ctx context.Context, hash []byte) (Badge, error) {
+	var badge Badge
+	err := m.badges().FindOne(ctx, bson.M{"hash": hash}).Decode(&badge)
+	if err == mongo.ErrNoDocuments {
+		return Badge{}, errNotFound
+	}
+	return badge, err
+}
+
+func (m *mongoRepository) DeleteBadge(ctx context.Context, userID string) error {
+	result, err := m.badges().DeleteOne(ctx, settingsQuery(ctx, userID))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) SaveRelationship(ctx context.Context, relationship Relationship) (Relationship, error) {
+	var stored Relationship
+	err := m.relationships().FindOneAndUpdate(
+		ctx,
+		bson.M{"teacher": relationship.Teacher, "student": relationship.Student},
+		bson.M{"$setOnInsert": relationship},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	return stored, err
+}
+
+func (m *mongoRepository) Relationships(ctx context.Context, account string) ([]Relationship, error) {
+	cursor, err := m.relationships().Find(
+		ctx,
+		bson.M{"$or": bson.A{bson.M{"teacher": account}, bson.M{"student": account}}},
+		options.Find().SetSort(bson.D{{"invited_at", 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships := []Relationship{}
+	err = cursor.All(ctx, &relationships)
+	return relationships, err
+}
+
+func (m *mongoRepository) AcceptRelationship(ctx context.Context, id primitive.ObjectID, student string) (Relationship, error) {
+	var relationship Relationship
+	err := m.relationships().FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "student": student, "status": relationshipPending},
+		bson.M{"$set": bson.M{"status": relationshipAccepted, "accepted_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&relationship)
+	if err == mongo.ErrNoDocuments {
+		return Relationship{}, errNotFound
+	}
+	return relationship, err
+}
+
+func (m *mongoRepository) DeleteRelationship(ctx context.Context, id primitive.ObjectID, account string) (Relationship, error) {
+	var relationship Relationship
+	err := m.relationships().FindOneAndDelete(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{bson.M{"teacher": account}, bson.M{"student": account}},
+	}).Decode(&relationship)
+	if err == mongo.ErrNoDocuments {
+		return Relationship{}, errNotFound
+	}
+	return relationship, err
+}
+
+func (m *mongoRepository) HasStudent(ctx context.Context, teacher, student string) (bool, error) {
+	count, err := m.relationships().CountDocuments(ctx, bson.M{
+		"teacher": teacher,
+		"student": student,
+		"status":  relationshipAccepted,
+	})
+	return count > 0, err
+}
+
+func (m *mongoRepository) SaveAssignment(ctx context.Context, assignment Assignment) error {
+	_, err := m.assignments().InsertOne(ctx, assignment)
+	return err
+}
+
+func assignmentsQuery(account string) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"teacher": account}, bson.M{"students": account}}}
+}
+
+func (m *mongoRepository) Assignments(ctx context.Context, account string) ([]Assignment, error) {
+	cursor, err := m.assignments().Find(
+		ctx,
+		assignmentsQuery(account),
+		options.Find().SetSort(bson.D{{"due_at", 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := []Assignment{}
+	err = cursor.All(ctx, &assignments)
+	return assignments, err
+}
+
+func (m *mongoRepository) Assignment(ctx context.Context, id primitive.ObjectID, account string) (Assignment, error) {
+	query := assignmentsQuery(account)
+	query["_id"] = id
+
+	var assignment Assignment
+	err := m.assignments().FindOne(ctx, query).Decode(&assignment)
+	if err == mongo.ErrNoDocuments {
+		return Assignment{}, errNotFound
+	}
+	return assignment, err
+}
+
+func (m *mongoRepository) RemoveAssignmentStudent(ctx context.Context, teacher, student string) error {
+	_, err := m.assignments().UpdateMany(
+		ctx,
+		bson.M{"teacher": teacher, "students": student},
+		bson.M{"$pull": bson.M{"students": student}},
+	)
+	return err
+}
+
+func (m *mongoRepository) Flags(ctx context.Context) ([]Flag, error) {
+	cursor, err := m.flags().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	flags := []Flag{}
+	err = cursor.All(ctx, &flags)
+	return flags, err
+}
+
+func (m *mongoRepository) SaveFlag(ctx context.Context, flag Flag) error {
+	_, err := m.flags().ReplaceOne(ctx, bson.M{"_id": flag.Name}, flag, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) DeleteFlag(ctx context.Context, name string) error {
+	result, err := m.flags().DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) ChordAliases(ctx context.Context) ([]ChordAlias, error) {
+	cursor, err := m.chordAliases().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := []ChordAlias{}
+	err = cursor.All(ctx, &aliases)
+	return aliases, err
+}
+
+func (m *mongoRepository) SaveChordAlias(ctx context.Context, alias ChordAlias) error {
+	_, err := m.chordAliases().ReplaceOne(ctx, bson.M{"_id": alias.Alias}, alias, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) DeleteChordAlias(ctx context.Context, alias string) error {
+	result, err := m.chordAliases().DeleteOne(ctx, bson.M{"_id": alias})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) Experiments(ctx context.Context) ([]Experiment, error) {
+	cursor, err := m.experiments().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	experiments := []Experiment{}
+	err = cursor.All(ctx, &experiments)
+	return experiments, err
+}
+
+func (m *mongoRepository) SaveExperiment(ctx context.Context, experiment Experiment) error {
+	_, err := m.experiments().ReplaceOne(ctx, bson.M{"_id": experiment.Name}, experiment, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) DeleteExperiment(ctx context.Context, name string) error {
+	result, err := m.experiments().DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// ExperimentOutcomes only sees the hot stats, archiving drops the variants
+func (m *mongoRepository) ExperimentOutcomes(ctx context.Context, name string) ([]VariantOutcome, error) {
+	field := "experiments." + name
+	cursor, err := m.readCollection("statistics", m.aggregations).Aggregate(
+		ctx,
+		mongo.Pipeline{
+			matchTenant(ctx),
+			bson.D{{"$match", bson.D{{field, bson.D{{"$exists", true}}}}}},
+			bson.D{{
+				"$group", bson.D{
+					{"_id", "$" + field},
+					{"tenants", bson.D{{"$addToSet", "$tenant"}}},
+					{"answers", bson.D{{"$sum", 1}}},
+					{"correct", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$correct", false}}}, 0, 1}}}}}},
+					{"avg_duration_millis", bson.D{{"$avg", "$answer_duration_millis"}}},
+				},
+			}},
+			bson.D{{"$addFields", bson.D{{"accounts", bson.D{{"$size", "$tenants"}}}}}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := []VariantOutcome{}
+	err = cursor.All(ctx, &outcomes)
+	return outcomes, err
+}
+
+func (m *mongoRepository) Insights(ctx context.Context) (InsightReport, error) {
+	var report InsightReport
+	err := m.insights().FindOne(ctx, bson.M{"_id": tenantFromContext(ctx)}).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return InsightReport{}, errNotFound
+	}
+	return report, err
+}
+
+func (m *mongoRepository) SaveInsights(ctx context.Context, report InsightReport) error {
+	_, err := m.insights().ReplaceOne(ctx, bson.M{"_id": report.Tenant}, report, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) SheetConnections(ctx context.Context) ([]SheetConnection, error) {
+	cursor, err := m.sheetConnections().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	connections := []SheetConnection{}
+	err = cursor.All(ctx, &connections)
+	return connections, err
+}
+
+func (m *mongoRepository) SheetConnection(ctx context.Context, tenant string) (SheetConnection, error) {
+	var connection SheetConnection
+	err := m.sheetConnections().FindOne(ctx, bson.M{"_id": tenant}).Decode(&connection)
+	if err == mongo.ErrNoDocuments {
+		return SheetConnection{}, errNotFound
+	}
+	return connection, err
+}
+
+func (m *mongoRepository) SaveSheetConnection(ctx context.Context, connection SheetConnection) error {
+	_, err := m.sheetConnections().ReplaceOne(ctx, bson.M{"_id": connection.Tenant}, connection, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) DeleteSheetConnection(ctx context.Context, tenant string) error {
+	result, err := m.sheetConnections().DeleteOne(ctx, bson.M{"_id": tenant})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) UpdateSheetExport(ctx context.Context, tenant string, from, to SheetExport) (bool, error) {
+	result, err := m.sheetConnections().UpdateOne(
+		ctx,
+		bson.M{"_id": tenant, "export.cursor": from.Cursor, "export.day": from.Day},
+		bson.M{"$set": bson.M{"export": to}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// LatestSyncSeq looks at both the stats and the tombstones, each on the
+// index of the delta sync, up to the sync watermark
+func (m *mongoRepository) LatestSyncSeq(ctx context.Context) (int64, error) {
+	watermark, err := m.syncWatermark(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, collection := range []*mongo.Collection{m.statistics(), m.tombstones()} {
+		var doc struct {
+			SyncSeq int64 `bson:"sync_seq"`
+		}
+		err := collection.FindOne(ctx, tenantQuery(ctx, bson.M{"sync_seq": bson.M{"$lte": watermark}}), options.FindOne().SetSort(bson.D{{"sync_seq", -1}})).Decode(&doc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+		if doc.SyncSeq > latest {
+			latest = doc.SyncSeq
+		}
+	}
+	return latest, nil
+}
+
+func (m *mongoRepository) WarehouseExport(ctx context.Context, name string) (WarehouseExport, error) {
+	export := WarehouseExport{Name: name}
+	err := m.warehouseExports().FindOne(ctx, bson.M{"_id": name}).Decode(&export)
+	if err == mongo.ErrNoDocuments {
+		return export, nil
+	}
+	return export, err
+}
+
+// UpdateWarehouseExport upserts, so the first update creates the export. If
+// another replica created it first the upsert fails on the duplicate _id.
+func (m *mongoRepository) UpdateWarehouseExport(ctx context.Context, from, to WarehouseExport) (bool, error) {
+	result, err := m.warehouseExports().UpdateOne(
+		ctx,
+		bson.M{"_id": from.Name, "cursor": from.Cursor, "day": from.Day},
+		bson.M{"$set": bson.M{"cursor": to.Cursor, "day": to.Day}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1 || result.UpsertedCount == 1, nil
+}
+
+// LockJob upserts, so taking a lock that was never taken creates it. If the
+// lock is held by another holder the upsert fails on the duplicate _id.
+func (m *mongoRepository) LockJob(ctx context.Context, name, holder string, until time.Time) (bool, error) {
+	_, err := m.jobLocks().UpdateOne(
+		ctx,
+		bson.M{"_id": name, "$or": bson.A{bson.M{"holder": holder}, bson.M{"until": bson.M{"$lte": time.Now()}}}},
+		bson.M{"$set": bson.M{"holder": holder, "until": until}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *mongoRepository) UnlockJob(ctx context.Context, name, holder string) error {
+	_, err := m.jobLocks().DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
+
+func (m *mongoRepository) SaveJobRun(ctx context.Context, run JobRun) error {
+	_, err := m.jobRuns().ReplaceOne(ctx, bson.M{"_id": run.ID}, run, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoRepository) JobRuns(ctx context.Context, job string, limit int) ([]JobRun, error) {
+	cursor, err := m.jobRuns().Find(
+		ctx,
+		bson.M{"job": job},
+		options.Find().SetSort(bson.D{{"started_at", -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []JobRun{}
+	err = cursor.All(ctx, &runs)
+	return runs, err
+}
+
+// PrepareAccessLog creates the access log as a capped collection of
+// sizeBytes, in which Mongo deletes the oldest entries to make room. A
+// collection that exists already is kept as it is, changing its size takes
+// dropping it.
+func (m *mongoRepository) PrepareAccessLog(ctx context.Context, sizeBytes int64) error {
+	err := m.client.Database("main").CreateCollection(ctx, "access_logs", options.CreateCollection().SetCapped(true).SetSizeInBytes(sizeBytes))
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Name == "NamespaceExists" {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = m.accessLogs().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"at", -1}}},
+		{Keys: bson.D{{"request_id", 1}}},
+		{Keys: bson.D{{"tenant", 1}, {"at", -1}}},
+	})
+	return err
+}
+
+// InsertAccessLogs inserts unordered, entries inserted already by an earlier
+// try are skipped on their duplicate _id
+func (m *mongoRepository) InsertAccessLogs(ctx context.Context, entries []AccessLogEntry) error {
+	documents := make([]interface{}, len(entries))
+	for i := range entries {
+		documents[i] = entries[i]
+	}
+	_, err := m.accessLogs().InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if onlyDuplicateKeys(err) {
+		return nil
+	}
+	return err
+}
+
+func (m *mongoRepository) AccessLogs(ctx context.Context, filter AccessLogFilter) ([]AccessLogEntry, error) {
+	query := bson.M{}
+	at := bson.M{}
+	if !filter.Since.IsZero() {
+		at["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		at["$lt"] = filter.Until
+	}
+	if len(at) > 0 {
+		query["at"] = at
+	}
+	status := bson.M{}
+	if filter.MinStatus > 0 {
+		status["$gte"] = filter.MinStatus
+	}
+	if filter.MaxStatus > 0 {
+		status["$lte"] = filter.MaxStatus
+	}
+	if len(status) > 0 {
+		query["status"] = status
+	}
+	if filter.MinLatency > 0 {
+		query["latency_millis"] = bson.M{"$gte": float64(filter.MinLatency.Milliseconds())}
+	}
+	for field, value := range map[string]string{
+		"method":     filter.Method,
+		"route":      filter.Route,
+		"tenant":     filter.Tenant,
+		"user_id":    filter.UserID,
+		"request_id": filter.RequestID,
+	} {
+		if value != "" {
+			query[field] = value
+		}
+	}
+
+	cursor, err := m.accessLogs().Find(
+		ctx,
+		query,
+		options.Find().SetSort(bson.D{{"at", -1}}).SetLimit(int64(filter.Limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AccessLogEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+func (m *mongoRepository) Tokens(ctx context.Context) ([]APIToken, error) {
+	cursor, err := m.tokens().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"created_at", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []APIToken{}
+	err = cursor.All(ctx, &tokens)
+	return tokens, err
+}
+
+func (m *mongoRepository) SaveToken(ctx context.Context, token APIToken) error {
+	_, err := m.tokens().InsertOne(ctx, token)
+	return err
+}
+
+func (m *mongoRepository) DeleteToken(ctx context.Context, id primitive.ObjectID) error {
+	result, err := m.tokens().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// ProvisionUser upserts, a concurrent first sign-in of the same identity
+// fails on the unique index and is tried again to find the other's user
+func (m *mongoRepository) ProvisionUser(ctx context.Context, identity Identity, user User) (User, error) {
+	query := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}}}
+	update := bson.M{
+		"$set": bson.M{
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"last_login_at":  user.LastLoginAt,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        user.ID,
+			"identities": []Identity{identity},
+			"created_at": user.CreatedAt,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var provisioned User
+	err := m.users().FindOneAndUpdate(ctx, query, update, opts).Decode(&provisioned)
+	if mongo.IsDuplicateKeyError(err) {
+		err = m.users().FindOneAndUpdate(ctx, query, update, opts).Decode(&provisioned)
+	}
+	return provisioned, err
+}
+
+func (m *mongoRepository) User(ctx context.Context, id string) (User, error) {
+	var user User
+	err := m.users().FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return User{}, errNotFound
+	}
+	return user, err
+}
+
+func (m *mongoRepository) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	result, err := m.users().UpdateOne(ctx, bson.M{"_id": userID, "email": email}, bson.M{"$set": bson.M{"verified_email": email}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *mongoRepository) ScheduleDeletion(ctx context.Context, userID string, at *time.Time) error {
+	update := bson.M{"$set": bson.M{"deletion_scheduled_at": at}}
+	if at == nil {
+		update = bson.M{"$unset": bson.M{"deletion_scheduled_at": ""}}
+	}
+	result, err := m.users().UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	_, err = m.sessions().UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"locked": at != nil}})
+	return err
+}
+
+func (m *mongoRepository) UsersDueForDeletion(ctx context.Context, before time.Time) ([]User, error) {
+	cursor, err := m.users().Find(ctx, bson.M{"deletion_scheduled_at": bson.M{"$lte": before}})
+	if err != nil {
+		return nil, err
+	}
+	users := []User{}
+	err = cursor.All(ctx, &users)
+	return users, err
+}
+
+// PurgeUser deletes the user last, so a purge that fails halfway is done
+// again on the next run
+func (m *mongoRepository) PurgeUser(ctx context.Context, userID string) error {
+	tenant := User{ID: userID}.Tenant()
+	for _, collection := range []*mongo.Collection{m.statistics(), m.tombstones(), m.statsCorrections(), m.practiceSessions(), m.archives(), m.settings(), m.notificationPreferences(), m.integrations(), m.streakPauses(), m.streakFreezes(), m.badges(), m.events()} {
+		_, err := collection.DeleteMany(ctx, bson.M{"tenant": tenant})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := m.relationships().DeleteMany(ctx, bson.M{"$or": bson.A{bson.M{"teacher": tenant}, bson.M{"student": tenant}}})
+	if err != nil {
+		return err
+	}
+	_, err = m.assignments().DeleteMany(ctx, bson.M{"teacher": tenant})
+	if err != nil {
+		return err
+	}
+	_, err = m.assignments().UpdateMany(ctx, bson.M{"students": tenant}, bson.M{"$pull": bson.M{"students": tenant}})
+	if err != nil {
+		return err
+	}
+	_, err = m.sheetConnections().DeleteOne(ctx, bson.M{"_id": tenant})
+	if err != nil {
+		return err
+	}
+	_, err = m.insights().DeleteOne(ctx, bson.M{"_id": tenant})
+	if err != nil {
+		return err
+	}
+	_, err = m.sessions().DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	_, err = m.users().DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+func (m *mongoRepository) SaveTwoFactor(ctx context.Context, userID string, twoFactor *TwoFactor) error {
+	update := bson.M{"$set": bson.M{"two_factor": twoFactor}}
+	if twoFactor == nil {
+		update = bson.M{"$unset": bson.M{"two_factor": ""}}
+	}
+	result, err := m.users().UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// UseTwoFactorStep only updates if the step is later, so of two concurrent
+// uses of a code only one succeeds
+func (m *mongoRepository) UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error) {
+	result, err := m.users().UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "two_factor.last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"two_factor.last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *mongoRepository) UseRecoveryCode(ctx context.Context, userID string, hash []byte) (bool, error) {
+	result, err := m.users().UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "two_factor.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"two_factor.recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *mongoRepository) SaveSession(ctx context.Context, session Session) error {
+	session.SchemaVersion = sessionsSchema.version()
+	_, err := m.sessions().InsertOne(ctx, session)
+	return err
+}
+
+func (m *mongoRepository) SessionByHash(ctx context.Context, hash []byte) (Session, error) {
+	var session Session
+	err := sessionsSchema.decodeOne(m.sessions().FindOne(ctx, bson.M{"hash": hash}), &session)
+	if err == mongo.ErrNoDocuments {
+		return Session{}, errNotFound
+	}
+	return session, err
+}
+
+func (m *mongoRepository) Sessions(ctx context.Context, userID string) ([]Session, error) {
+	cursor, err := m.sessions().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{"created_at", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	err = sessionsSchema.decodeAll(ctx, cursor, &sessions)
+	return sessions, err
+}
+
+func (m *mongoRepository) TouchSession(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error {
+	result, err := m.sessions().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen_at": at, "ip": ip}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (m *mongoRepository) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	result, err := m.sessions().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
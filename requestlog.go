@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Levels of the request log, each logging the requests of the ones after it
+// too
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// logLevel is the lowest level of requests that are logged. Successful
+// requests are info, failed ones warn and those failing on the server
+// error. Debug logs every request, without sampling.
+var logLevel = logLevelInfo
+
+// logSamples are how many successful requests of a route are logged one of,
+// by the route pattern with or without the method
+var logSamples map[string]int
+
+// requestLogMu guards logLevel and logSamples, which are replaced when the
+// config is reloaded
+var requestLogMu sync.RWMutex
+
+// setRequestLogging replaces the level and sampling of the request log
+func setRequestLogging(level string, samples map[string]int) error {
+	for route, n := range samples {
+		if n < 1 {
+			return fmt.Errorf("log sample of %s has to be at least 1", route)
+		}
+	}
+
+	requestLogMu.Lock()
+	defer requestLogMu.Unlock()
+
+	logLevel, logSamples = level, samples
+	return nil
+}
+
+// LogRequests logs the requests like chi's Logger does, leaving out those
+// below the log level and all but a sample of the successful requests of
+// sampled routes, so busy routes don't drown the failures
+var LogRequests = middleware.RequestLogger(sampledLogFormatter{
+	&middleware.DefaultLogFormatter{Logger: log.New(os.Stdout, "", log.LstdFlags)},
+})
+
+type sampledLogFormatter struct {
+	middleware.LogFormatter
+}
+
+func (f sampledLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	return sampledLogEntry{LogEntry: f.LogFormatter.NewLogEntry(r), request: r}
+}
+
+type sampledLogEntry struct {
+	middleware.LogEntry
+	request *http.Request
+}
+
+func (e sampledLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	if requestLogged(e.request, status) {
+		e.LogEntry.Write(status, bytes, header, elapsed, extra)
+	}
+}
+
+// requestLogged tells if a request answered with status is logged
+func requestLogged(r *http.Request, status int) bool {
+	requestLogMu.RLock()
+	defer requestLogMu.RUnlock()
+
+	switch {
+	case status >= 500:
+		return true
+	case status >= 400:
+		return logLevel != logLevelError
+	case logLevel == logLevelDebug:
+		return true
+	case logLevel != logLevelInfo:
+		return false
+	}
+
+	route := r.URL.Path
+	if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+		route = routeContext.RoutePattern()
+	}
+	n, sampled := logSamples[r.Method+" "+route]
+	if !sampled {
+		n, sampled = logSamples[route]
+	}
+	return !sampled || rand.Intn(n) == 0
+}
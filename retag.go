@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StatsRetag adds and removes tags on the stats matching its filter, e.g.
+// tagging every answer of a session
+type StatsRetag struct {
+	ChordName      string `json:"chord_name,omitempty"`
+	RootNote       string `json:"root_note,omitempty"`
+	ChordExtension string `json:"chord_extension,omitempty"`
+	// Tag limits the retagging to the stats tagged with it
+	Tag        string     `json:"tag,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	AddTags    []string   `json:"add_tags,omitempty"`
+	RemoveTags []string   `json:"remove_tags,omitempty"`
+}
+
+// StatsRetagReport tells how many stats matched the filter of a retagging,
+// and how many of them it changed. Stats that would end up with more than
+// maxTags tags are left as they are and counted as full.
+type StatsRetagReport struct {
+	Matched  int `json:"matched"`
+	Modified int `json:"modified"`
+	Full     int `json:"full"`
+}
+
+func (t StatsRetag) filter() StatsFilter {
+	filter := StatsFilter{ChordName: t.ChordName, RootNote: t.RootNote, ChordExtension: t.ChordExtension, Tag: t.Tag}
+	if t.Since != nil {
+		filter.Since = *t.Since
+	}
+	if t.Until != nil {
+		filter.Until = *t.Until
+	}
+	return filter
+}
+
+// validate returns what is wrong with the retagging, nothing if it is valid
+func (t StatsRetag) validate() []FieldError {
+	if len(t.AddTags) == 0 && len(t.RemoveTags) == 0 {
+		return []FieldError{{Message: "at least one of add_tags and remove_tags is required"}}
+	}
+	var fieldErrors []FieldError
+	if t.Since != nil && t.Until != nil && !t.Since.Before(*t.Until) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "until", Message: "has to be after since"})
+	}
+	if len(t.AddTags) > maxTags {
+		fieldErrors = append(fieldErrors, FieldError{Field: "add_tags", Message: fmt.Sprintf("can't be more than %d", maxTags)})
+	}
+	for _, field := range []struct {
+		name string
+		tags []string
+	}{{"add_tags", t.AddTags}, {"remove_tags", t.RemoveTags}} {
+		for _, tag := range field.tags {
+			if !validTag(tag) {
+				fieldErrors = append(fieldErrors, FieldError{Field: field.name, Message: fmt.Sprintf("have to be 1 to %d characters without %s", maxTagLength, tagSeparator)})
+				break
+			}
+		}
+	}
+	for _, tag := range tidyTags(t.AddTags) {
+		if containsString(tidyTags(t.RemoveTags), tag) {
+			fieldErrors = append(fieldErrors, FieldError{Field: "remove_tags", Message: "can't have a tag of add_tags"})
+			break
+		}
+	}
+	return fieldErrors
+}
+
+// retagged returns tags with remove taken out and add appended, keeping
+// their order
+func retagged(tags, add, remove []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if !containsString(remove, tag) {
+			result = append(result, tag)
+		}
+	}
+	for _, tag := range add {
+		if !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// sameTags tells if a and b are the same tags in the same order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// retagStatsHandler adds and removes tags on the caller's stats matching a
+// filter. Archived stats aren't retagged.
+func retagStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var retag StatsRetag
+	err := decodeRequest(r, &retag)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := retag.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	report, err := repository.RetagStats(r.Context(), retag.filter(), tidyTags(retag.AddTags), tidyTags(retag.RemoveTags))
+	if report.Modified > 0 && aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, report)
+}
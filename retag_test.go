@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRetagged(t *testing.T) {
+	tests := []struct {
+		name   string
+		tags   []string
+		add    []string
+		remove []string
+		want   []string
+	}{
+		{name: "nothing to do", tags: []string{"tired", "new-keyboard"}, want: []string{"tired", "new-keyboard"}},
+		{name: "adds after the tags", tags: []string{"tired"}, add: []string{"morning", "headphones"}, want: []string{"tired", "morning", "headphones"}},
+		{name: "adds to no tags", add: []string{"morning"}, want: []string{"morning"}},
+		{name: "removes keeping the order", tags: []string{"a", "b", "c", "d"}, remove: []string{"b", "d"}, want: []string{"a", "c"}},
+		{name: "removes all", tags: []string{"a", "b"}, remove: []string{"b", "a"}, want: nil},
+		{name: "removes missing tags", tags: []string{"a"}, remove: []string{"z"}, want: []string{"a"}},
+		{name: "keeps the place of added tags it has", tags: []string{"a", "b"}, add: []string{"c", "a"}, want: []string{"a", "b", "c"}},
+		{name: "adds duplicates once", tags: []string{"a"}, add: []string{"b", "b"}, want: []string{"a", "b"}},
+		{name: "moves tags removed and added to the end", tags: []string{"a", "b", "c"}, add: []string{"a"}, remove: []string{"a"}, want: []string{"b", "c", "a"}},
+		{name: "removes and adds together", tags: []string{"a", "b", "c"}, add: []string{"d"}, remove: []string{"b"}, want: []string{"a", "c", "d"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := retagged(test.tags, test.add, test.remove)
+			if !sameTags(got, test.want) {
+				t.Errorf("retagged() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
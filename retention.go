@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetentionWeeks is how many weekly cohorts are reported unless
+	// asked otherwise
+	defaultRetentionWeeks = 12
+	// maxRetentionWeeks bounds the cohorts, and so the activity scanned
+	maxRetentionWeeks = 52
+	// dayLayout is how the days of tenant activity are written, in UTC
+	dayLayout = "2006-01-02"
+)
+
+// TenantActivity is when a tenant practiced, for signed in users the user.
+// First is the first day it ever practiced, archived stats included, Days
+// the days it practiced on since the start of what was asked for.
+type TenantActivity struct {
+	Tenant string   `bson:"_id"`
+	First  string   `bson:"first"`
+	Days   []string `bson:"days"`
+}
+
+// RetentionReport tells how many users practice and whether they keep at
+// it. A user is active on a day if they saved an answer on it, days and
+// weeks being in UTC and weeks starting on Monday.
+type RetentionReport struct {
+	// Day is the day the active users are counted up to, today
+	Day string `json:"day"`
+	// DailyActive, WeeklyActive and MonthlyActive count the users active on
+	// the day, and in the 7 and 30 days up to it
+	DailyActive   int `json:"daily_active"`
+	WeeklyActive  int `json:"weekly_active"`
+	MonthlyActive int `json:"monthly_active"`
+	// Cohorts are oldest first, the last one is of the current week
+	Cohorts []RetentionCohort `json:"cohorts"`
+}
+
+// RetentionCohort is the users who first practiced in a week, and how many
+// of them practiced in each week since
+type RetentionCohort struct {
+	// Week is the Monday the week starts on
+	Week  string `json:"week"`
+	Users int    `json:"users"`
+	// Active counts the users of the cohort active in its week and each one
+	// after, so the first count is all of them
+	Active []int `json:"active"`
+	// Retention is Active as a share of Users
+	Retention []float64 `json:"retention"`
+}
+
+// startOfWeek returns the Monday of the week of day
+func startOfWeek(day time.Time) time.Time {
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// retentionReport computes the report of weeks cohorts, up to and including
+// the current week, from the activity of every tenant
+func retentionReport(activity []TenantActivity, now time.Time, weeks int) RetentionReport {
+	today := now.UTC().Truncate(24 * time.Hour)
+	firstWeek := startOfWeek(today).AddDate(0, 0, -7*(weeks-1))
+	report := RetentionReport{Day: today.Format(dayLayout), Cohorts: []RetentionCohort{}}
+	for week := 0; week < weeks; week++ {
+		report.Cohorts = append(report.Cohorts, RetentionCohort{
+			Week:   firstWeek.AddDate(0, 0, 7*week).Format(dayLayout),
+			Active: make([]int, weeks-week),
+		})
+	}
+
+	for _, tenant := range activity {
+		var daily, weekly, monthly bool
+		activeWeeks := make(map[int]bool)
+		for _, d := range tenant.Days {
+			day, err := time.Parse(dayLayout, d)
+			if err != nil || day.After(today) {
+				continue
+			}
+			age := int(today.Sub(day).Hours() / 24)
+			daily = daily || age == 0
+			weekly = weekly || age < 7
+			monthly = monthly || age < 30
+			if !day.Before(firstWeek) {
+				activeWeeks[int(day.Sub(firstWeek).Hours()/24)/7] = true
+			}
+		}
+		report.DailyActive += boolCount(daily)
+		report.WeeklyActive += boolCount(weekly)
+		report.MonthlyActive += boolCount(monthly)
+
+		first, err := time.Parse(dayLayout, tenant.First)
+		if err != nil || first.Before(firstWeek) || first.After(today) {
+			continue
+		}
+		cohort := int(first.Sub(firstWeek).Hours()/24) / 7
+		report.Cohorts[cohort].Users++
+		for week := range activeWeeks {
+			if week >= cohort {
+				report.Cohorts[cohort].Active[week-cohort]++
+			}
+		}
+	}
+
+	for i := range report.Cohorts {
+		cohort := &report.Cohorts[i]
+		cohort.Retention = make([]float64, len(cohort.Active))
+		for week, active := range cohort.Active {
+			if cohort.Users > 0 {
+				cohort.Retention[week] = float64(active) / float64(cohort.Users)
+			}
+		}
+	}
+	return report
+}
+
+func boolCount(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// getRetentionHandler reports the active users and weekly retention cohorts,
+// with the weeks query param telling how many cohorts
+func getRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	weeks := defaultRetentionWeeks
+	if weeksParam := r.URL.Query().Get("weeks"); weeksParam != "" {
+		var err error
+		weeks, err = strconv.Atoi(weeksParam)
+		if err != nil || weeks <= 0 || weeks > maxRetentionWeeks {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "weeks", Message: "has to be a whole number from 1 to 52"})
+			return
+		}
+	}
+
+	now := time.Now()
+	today := now.UTC().Truncate(24 * time.Hour)
+	// the monthly active users need 30 days even with few cohorts
+	since := startOfWeek(today).AddDate(0, 0, -7*(weeks-1))
+	if monthAgo := today.AddDate(0, 0, -29); monthAgo.Before(since) {
+		since = monthAgo
+	}
+	activity, err := repository.TenantActivity(r.Context(), since)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, retentionReport(activity, now, weeks))
+}
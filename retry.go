@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// electionErrorCodes are the Mongo error codes of a replica set changing
+// primary, which is over in a few seconds
+var electionErrorCodes = []int{
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// transient tells if err might go away by trying again
+func transient(err error) bool {
+	if unavailable(err) {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range electionErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// abortedTransaction tells if err aborted a transaction that can be run
+// again as a whole, the writes in it never happened
+func abortedTransaction(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorLabel("TransientTransactionError")
+}
+
+// unsent tells if err means the operation never reached Mongo, which makes
+// even writes that can't be repeated safe to try again
+func unsent(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	return errors.As(err, &selectionErr)
+}
+
+// retryingRepository tries the operations of another repository again when
+// they fail with transient errors, waiting a jittered, exponentially
+// growing backoff in between. Writes that aren't safe to repeat are only
+// tried again when they never reached Mongo. Operations are guarded by
+// breaker, which fails them fast while Mongo is down.
+type retryingRepository struct {
+	next     Repository
+	attempts int
+	backoff  time.Duration
+	breaker  *circuitBreaker
+}
+
+func newRetryingRepository(next Repository, attempts int, backoff time.Duration, breaker *circuitBreaker) *retryingRepository {
+	return &retryingRepository{next: next, attempts: attempts, backoff: backoff, breaker: breaker}
+}
+
+func (r *retryingRepository) do(ctx context.Context, idempotent bool, op func() error) error {
+	err := r.breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = r.retry(ctx, idempotent, op)
+	r.breaker.Record(err)
+	return err
+}
+
+func (r *retryingRepository) retry(ctx context.Context, idempotent bool, op func() error) error {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		retryable := transient(err)
+		if !idempotent {
+			retryable = unsent(err)
+		}
+		if err == nil || attempt >= r.attempts || !retryable {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff)) + 1)):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *retryingRepository) SaveStats(ctx context.Context, stats StatsRaw, ifVersion int64) (StatsRaw, error) {
+	// the last write wins on the client ID, so writing it twice changes
+	// nothing, while conditional writes would conflict with themselves
+	idempotent := stats.ClientID != "" && ifVersion == anyVersion
+
+	var stored StatsRaw
+	err := r.do(ctx, idempotent, func() error {
+		var err error
+		stored, err = r.next.SaveStats(ctx, stats, ifVersion)
+		return err
+	})
+	return stored, err
+}
+
+func (r *retryingRepository) InsertStats(ctx context.Context, stats []StatsRaw) error {
+	return r.do(ctx, true, func() error {
+		return r.next.InsertStats(ctx, stats)
+	})
+}
+
+func (r *retryingRepository) EachStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	// once stats are handed to fn, trying again would hand them over twice
+	called := false
+	return r.do(ctx, true, func() error {
+		if called {
+			return nil
+		}
+		return r.next.EachStats(ctx, filter, func(stats StatsRaw) error {
+			called = true
+			return fn(stats)
+		})
+	})
+}
+
+func (r *retryingRepository) EachArchivedStats(ctx context.Context, filter StatsFilter, fn func(StatsRaw) error) error {
+	called := false
+	return r.do(ctx, true, func() error {
+		if called {
+			return nil
+		}
+		return r.next.EachArchivedStats(ctx, filter, func(stats StatsRaw) error {
+			called = true
+			return fn(stats)
+		})
+	})
+}
+
+// ArchiveStats is safe to repeat, chunks archived twice collide on their id
+func (r *retryingRepository) ArchiveStats(ctx context.Context, before time.Time) (int, error) {
+	var archived int
+	err := r.do(ctx, true, func() error {
+		var err error
+		archived, err = r.next.ArchiveStats(ctx, before)
+		return err
+	})
+	return archived, err
+}
+
+func (r *retryingRepository) CountStats(ctx context.Context) (int, error) {
+	var count int
+	err := r.do(ctx, true, func() error {
+		var err error
+		count, err = r.next.CountStats(ctx)
+		return err
+	})
+	return count, err
+}
+
+func (r *retryingRepository) CountMatchingStats(ctx context.Context, filter StatsFilter) (int, error) {
+	var count int
+	err := r.do(ctx, true, func() error {
+		var err error
+		count, err = r.next.CountMatchingStats(ctx, filter)
+		return err
+	})
+	return count, err
+}
+
+// DeleteStats isn't repeated, a repeat would only count the stats left to
+// delete
+func (r *retryingRepository) DeleteStats(ctx context.Context, filter StatsFilter) (int, error) {
+	var deleted int
+	err := r.do(ctx, false, func() error {
+		var err error
+		deleted, err = r.next.DeleteStats(ctx, filter)
+		return err
+	})
+	return deleted, err
+}
+
+// ReserveSyncSeq is repeated, a repeat only skips a number, which holds back
+// the delta sync for syncSeqOverlap
+func (r *retryingRepository) ReserveSyncSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := r.do(ctx, true, func() error {
+		var err error
+		seq, err = r.next.ReserveSyncSeq(ctx)
+		return err
+	})
+	return seq, err
+}
+
+// ReleaseSyncSeq is repeated, releasing again changes nothing
+func (r *retryingRepository) ReleaseSyncSeq(ctx context.Context, seq int64) error {
+	return r.do(ctx, true, func() error {
+		return r.next.ReleaseSyncSeq(ctx, seq)
+	})
+}
+
+// CorrectStats isn't repeated, a repeat would fail with errVersionConflict
+func (r *retryingRepository) CorrectStats(ctx context.Context, stats StatsRaw, ifVersion, seq int64) (StatsRaw, error) {
+	var corrected StatsRaw
+	err := r.do(ctx, false, func() error {
+		var err error
+		corrected, err = r.next.CorrectStats(ctx, stats, ifVersion, seq)
+		return err
+	})
+	return corrected, err
+}
+
+// RetagStats isn't repeated, a repeat would only count the stats left to
+// retag
+func (r *retryingRepository) RetagStats(ctx context.Context, filter StatsFilter, add, remove []string) (StatsRetagReport, error) {
+	var report StatsRetagReport
+	err := r.do(ctx, false, func() error {
+		var err error
+		report, err = r.next.RetagStats(ctx, filter, add, remove)
+		return err
+	})
+	return report, err
+}
+
+// InsertStatsCorrection isn't repeated, a repeat would fail on the id it
+// inserted
+func (r *retryingRepository) InsertStatsCorrection(ctx context.Context, entry StatsCorrectionEntry) error {
+	return r.do(ctx, false, func() error {
+		return r.next.InsertStatsCorrection(ctx, entry)
+	})
+}
+
+func (r *retryingRepository) StatsCorrections(ctx context.Context, statsID primitive.ObjectID) ([]StatsCorrectionEntry, error) {
+	var corrections []StatsCorrectionEntry
+	err := r.do(ctx, true, func() error {
+		var err error
+		corrections, err = r.next.StatsCorrections(ctx, statsID)
+		return err
+	})
+	return corrections, err
+}
+
+// SavePracticeSession isn't repeated, a repeat would fail on the id it
+// inserted
+func (r *retryingRepository) SavePracticeSession(ctx context.Context, session PracticeSession) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SavePracticeSession(ctx, session)
+	})
+}
+
+func (r *retryingRepository) PracticeSession(ctx context.Context, id primitive.ObjectID) (PracticeSession, error) {
+	var session PracticeSession
+	err := r.do(ctx, true, func() error {
+		var err error
+		session, err = r.next.PracticeSession(ctx, id)
+		return err
+	})
+	return session, err
+}
+
+func (r *retryingRepository) EndPracticeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.do(ctx, true, func() error {
+		return r.next.EndPracticeSession(ctx, id, at)
+	})
+}
+
+func (r *retryingRepository) OpenPracticeSessions(ctx context.Context, since time.Time) ([]PracticeSession, error) {
+	var sessions []PracticeSession
+	err := r.do(ctx, true, func() error {
+		var err error
+		sessions, err = r.next.OpenPracticeSessions(ctx, since)
+		return err
+	})
+	return sessions, err
+}
+
+func (r *retryingRepository) MarkPracticeGoalMet(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.do(ctx, true, func() error {
+		return r.next.MarkPracticeGoalMet(ctx, id, at)
+	})
+}
+
+func (r *retryingRepository) CountByDay(ctx context.Context, loc *time.Location) ([]StatsCountByDay, error) {
+	var countByDays []StatsCountByDay
+	err := r.do(ctx, true, func() error {
+		var err error
+		countByDays, err = r.next.CountByDay(ctx, loc)
+		return err
+	})
+	return countByDays, err
+}
+
+func (r *retryingRepository) CountByDayAndExtension(ctx context.Context, loc *time.Location) ([]StatsCountByDayAndExtension, error) {
+	var counts []StatsCountByDayAndExtension
+	err := r.do(ctx, true, func() error {
+		var err error
+		counts, err = r.next.CountByDayAndExtension(ctx, loc)
+		return err
+	})
+	return counts, err
+}
+
+func (r *retryingRepository) CountByExtension(ctx context.Context) ([]StatsCountByExtension, error) {
+	var countByExtensions []StatsCountByExtension
+	err := r.do(ctx, true, func() error {
+		var err error
+		countByExtensions, err = r.next.CountByExtension(ctx)
+		return err
+	})
+	return countByExtensions, err
+}
+
+func (r *retryingRepository) CountByRootNote(ctx context.Context, since time.Time) ([]StatsCountByRootNote, error) {
+	var countByRootNotes []StatsCountByRootNote
+	err := r.do(ctx, true, func() error {
+		var err error
+		countByRootNotes, err = r.next.CountByRootNote(ctx, since)
+		return err
+	})
+	return countByRootNotes, err
+}
+
+func (r *retryingRepository) OutcomesByTempo(ctx context.Context) ([]TempoOutcome, error) {
+	var outcomes []TempoOutcome
+	err := r.do(ctx, true, func() error {
+		var err error
+		outcomes, err = r.next.OutcomesByTempo(ctx)
+		return err
+	})
+	return outcomes, err
+}
+
+func (r *retryingRepository) OutcomesByConfidence(ctx context.Context) ([]ConfidenceOutcome, error) {
+	var outcomes []ConfidenceOutcome
+	err := r.do(ctx, true, func() error {
+		var err error
+		outcomes, err = r.next.OutcomesByConfidence(ctx)
+		return err
+	})
+	return outcomes, err
+}
+
+func (r *retryingRepository) AttemptOutcomes(ctx context.Context) ([]AttemptOutcome, error) {
+	var outcomes []AttemptOutcome
+	err := r.do(ctx, true, func() error {
+		var err error
+		outcomes, err = r.next.AttemptOutcomes(ctx)
+		return err
+	})
+	return outcomes, err
+}
+
+func (r *retryingRepository) AvgDurationByExtension(ctx context.Context) ([]StatsDurationByExtension, error) {
+	var durationByExtensions []StatsDurationByExtension
+	err := r.do(ctx, true, func() error {
+		var err error
+		durationByExtensions, err = r.next.AvgDurationByExtension(ctx)
+		return err
+	})
+	return durationByExtensions, err
+}
+
+func (r *retryingRepository) Changes(ctx context.Context, since int64, limit int) ([]StatsRaw, []Tombstone, error) {
+	var stats []StatsRaw
+	var tombstones []Tombstone
+	err := r.do(ctx, true, func() error {
+		var err error
+		stats, tombstones, err = r.next.Changes(ctx, since, limit)
+		return err
+	})
+	return stats, tombstones, err
+}
+
+func (r *retryingRepository) UsageByClientVersion(ctx context.Context) ([]ClientVersionUsage, error) {
+	var usages []ClientVersionUsage
+	err := r.do(ctx, true, func() error {
+		var err error
+		usages, err = r.next.UsageByClientVersion(ctx)
+		return err
+	})
+	return usages, err
+}
+
+func (r *retryingRepository) TenantActivity(ctx context.Context, since time.Time) ([]TenantActivity, error) {
+	var activity []TenantActivity
+	err := r.do(ctx, true, func() error {
+		var err error
+		activity, err = r.next.TenantActivity(ctx, since)
+		return err
+	})
+	return activity, err
+}
+
+// InsertEvents is repeated, a repeat skips the events inserted already
+func (r *retryingRepository) InsertEvents(ctx context.Context, events []Event) error {
+	return r.do(ctx, true, func() error {
+		return r.next.InsertEvents(ctx, events)
+	})
+}
+
+func (r *retryingRepository) TenantEvents(ctx context.Context, since, until time.Time) ([]TenantEvents, error) {
+	var events []TenantEvents
+	err := r.do(ctx, true, func() error {
+		var err error
+		events, err = r.next.TenantEvents(ctx, since, until)
+		return err
+	})
+	return events, err
+}
+
+func (r *retryingRepository) GetSettings(ctx context.Context, userID string) (Settings, error) {
+	var settings Settings
+	err := r.do(ctx, true, func() error {
+		var err error
+		settings, err = r.next.GetSettings(ctx, userID)
+		return err
+	})
+	return settings, err
+}
+
+func (r *retryingRepository) UpdateSettings(ctx context.Context, userID string, update Settings, ifVersion int64) (Settings, error) {
+	// a conditional update would conflict with itself when repeated
+	var settings Settings
+	err := r.do(ctx, ifVersion == anyVersion, func() error {
+		var err error
+		settings, err = r.next.UpdateSettings(ctx, userID, update, ifVersion)
+		return err
+	})
+	return settings, err
+}
+
+func (r *retryingRepository) GetNotificationPreferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := r.do(ctx, true, func() error {
+		var err error
+		preferences, err = r.next.GetNotificationPreferences(ctx, userID)
+		return err
+	})
+	return preferences, err
+}
+
+func (r *retryingRepository) UpdateNotificationPreferences(ctx context.Context, userID string, update NotificationPreferences) (NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := r.do(ctx, true, func() error {
+		var err error
+		preferences, err = r.next.UpdateNotificationPreferences(ctx, userID, update)
+		return err
+	})
+	return preferences, err
+}
+
+func (r *retryingRepository) Integrations(ctx context.Context, userID string) ([]Integration, error) {
+	var integrations []Integration
+	err := r.do(ctx, true, func() error {
+		var err error
+		integrations, err = r.next.Integrations(ctx, userID)
+		return err
+	})
+	return integrations, err
+}
+
+func (r *retryingRepository) AllIntegrations(ctx context.Context) ([]Integration, error) {
+	var integrations []Integration
+	err := r.do(ctx, true, func() error {
+		var err error
+		integrations, err = r.next.AllIntegrations(ctx)
+		return err
+	})
+	return integrations, err
+}
+
+// SaveIntegration isn't repeated, a repeat would fail on the duplicate ID
+func (r *retryingRepository) SaveIntegration(ctx context.Context, integration Integration) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SaveIntegration(ctx, integration)
+	})
+}
+
+// DeleteIntegration isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteIntegration(ctx context.Context, userID string, id primitive.ObjectID) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteIntegration(ctx, userID, id)
+	})
+}
+
+func (r *retryingRepository) MarkSummarySent(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.do(ctx, true, func() error {
+		return r.next.MarkSummarySent(ctx, id, at)
+	})
+}
+
+// MarkStreakAnnounced isn't repeated, a repeat would tell the milestone was
+// already posted
+func (r *retryingRepository) MarkStreakAnnounced(ctx context.Context, id primitive.ObjectID, key string) (bool, error) {
+	var marked bool
+	err := r.do(ctx, false, func() error {
+		var err error
+		marked, err = r.next.MarkStreakAnnounced(ctx, id, key)
+		return err
+	})
+	return marked, err
+}
+
+func (r *retryingRepository) StreakPauses(ctx context.Context, userID string) ([]StreakPause, error) {
+	var pauses []StreakPause
+	err := r.do(ctx, true, func() error {
+		var err error
+		pauses, err = r.next.StreakPauses(ctx, userID)
+		return err
+	})
+	return pauses, err
+}
+
+// SaveStreakPause isn't repeated, a repeat would fail on the duplicate ID
+func (r *retryingRepository) SaveStreakPause(ctx context.Context, pause StreakPause) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SaveStreakPause(ctx, pause)
+	})
+}
+
+// DeleteStreakPause isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteStreakPause(ctx context.Context, userID string, id primitive.ObjectID) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteStreakPause(ctx, userID, id)
+	})
+}
+
+func (r *retryingRepository) StreakFreezes(ctx context.Context, userID string) ([]StreakFreeze, error) {
+	var freezes []StreakFreeze
+	err := r.do(ctx, true, func() error {
+		var err error
+		freezes, err = r.next.StreakFreezes(ctx, userID)
+		return err
+	})
+	return freezes, err
+}
+
+func (r *retryingRepository) SaveStreakFreeze(ctx context.Context, freeze StreakFreeze) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveStreakFreeze(ctx, freeze)
+	})
+}
+
+func (r *retryingRepository) SaveBadge(ctx context.Context, badge Badge) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveBadge(ctx, badge)
+	})
+}
+
+func (r *retryingRepository) BadgeByHash(ctx context.Context, hash []byte) (Badge, error) {
+	var badge Badge
+	err := r.do(ctx, true, func() error {
+		var err error
+		badge, err = r.next.BadgeByHash(ctx, hash)
+		return err
+	})
+	return badge, err
+}
+
+// DeleteBadge isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteBadge(ctx context.Context, userID string) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteBadge(ctx, userID)
+	})
+}
+
+func (r *retryingRepository) Insights(ctx context.Context) (InsightReport, error) {
+	var report InsightReport
+	err := r.do(ctx, true, func() error {
+		var err error
+		report, err = r.next.Insights(ctx)
+		return err
+	})
+	return report, err
+}
+
+func (r *retryingRepository) SaveInsights(ctx context.Context, report InsightReport) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveInsights(ctx, report)
+	})
+}
+
+func (r *retryingRepository) SheetConnections(ctx context.Context) ([]SheetConnection, error) {
+	var connections []SheetConnection
+	err := r.do(ctx, true, func() error {
+		var err error
+		connections, err = r.next.SheetConnections(ctx)
+		return err
+	})
+	return connections, err
+}
+
+func (r *retryingRepository) SheetConnection(ctx context.Context, tenant string) (SheetConnection, error) {
+	var connection SheetConnection
+	err := r.do(ctx, true, func() error {
+		var err error
+		connection, err = r.next.SheetConnection(ctx, tenant)
+		return err
+	})
+	return connection, err
+}
+
+func (r *retryingRepository) SaveSheetConnection(ctx context.Context, connection SheetConnection) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveSheetConnection(ctx, connection)
+	})
+}
+
+// DeleteSheetConnection isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteSheetConnection(ctx context.Context, tenant string) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteSheetConnection(ctx, tenant)
+	})
+}
+
+// UpdateSheetExport isn't repeated, a repeat would find the export already
+// moved on and report that another replica claimed it
+func (r *retryingRepository) UpdateSheetExport(ctx context.Context, tenant string, from, to SheetExport) (bool, error) {
+	var updated bool
+	err := r.do(ctx, false, func() error {
+		var err error
+		updated, err = r.next.UpdateSheetExport(ctx, tenant, from, to)
+		return err
+	})
+	return updated, err
+}
+
+func (r *retryingRepository) LatestSyncSeq(ctx context.Context) (int64, error) {
+	var latest int64
+	err := r.do(ctx, true, func() error {
+		var err error
+		latest, err = r.next.LatestSyncSeq(ctx)
+		return err
+	})
+	return latest, err
+}
+
+func (r *retryingRepository) SaveRelationship(ctx context.Context, relationship Relationship) (Relationship, error) {
+	var stored Relationship
+	err := r.do(ctx, true, func() error {
+		var err error
+		stored, err = r.next.SaveRelationship(ctx, relationship)
+		return err
+	})
+	return stored, err
+}
+
+func (r *retryingRepository) Relationships(ctx context.Context, account string) ([]Relationship, error) {
+	var relationships []Relationship
+	err := r.do(ctx, true, func() error {
+		var err error
+		relationships, err = r.next.Relationships(ctx, account)
+		return err
+	})
+	return relationships, err
+}
+
+func (r *retryingRepository) AcceptRelationship(ctx context.Context, id primitive.ObjectID, student string) (Relationship, error) {
+	var relationship Relationship
+	err := r.do(ctx, false, func() error {
+		var err error
+		relationship, err = r.next.AcceptRelationship(ctx, id, student)
+		return err
+	})
+	return relationship, err
+}
+
+func (r *retryingRepository) DeleteRelationship(ctx context.Context, id primitive.ObjectID, account string) (Relationship, error) {
+	var relationship Relationship
+	err := r.do(ctx, false, func() error {
+		var err error
+		relationship, err = r.next.DeleteRelationship(ctx, id, account)
+		return err
+	})
+	return relationship, err
+}
+
+func (r *retryingRepository) HasStudent(ctx context.Context, teacher, student string) (bool, error) {
+	var granted bool
+	err := r.do(ctx, true, func() error {
+		var err error
+		granted, err = r.next.HasStudent(ctx, teacher, student)
+		return err
+	})
+	return granted, err
+}
+
+func (r *retryingRepository) SaveAssignment(ctx context.Context, assignment Assignment) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SaveAssignment(ctx, assignment)
+	})
+}
+
+func (r *retryingRepository) Assignments(ctx context.Context, account string) ([]Assignment, error) {
+	var assignments []Assignment
+	err := r.do(ctx, true, func() error {
+		var err error
+		assignments, err = r.next.Assignments(ctx, account)
+		return err
+	})
+	return assignments, err
+}
+
+func (r *retryingRepository) Assignment(ctx context.Context, id primitive.ObjectID, account string) (Assignment, error) {
+	var assignment Assignment
+	err := r.do(ctx, true, func() error {
+		var err error
+		assignment, err = r.next.Assignment(ctx, id, account)
+		return err
+	})
+	return assignment, err
+}
+
+func (r *retryingRepository) RemoveAssignmentStudent(ctx context.Context, teacher, student string) error {
+	return r.do(ctx, true, func() error {
+		return r.next.RemoveAssignmentStudent(ctx, teacher, student)
+	})
+}
+
+func (r *retryingRepository) Flags(ctx context.Context) ([]Flag, error) {
+	var flags []Flag
+	err := r.do(ctx, true, func() error {
+		var err error
+		flags, err = r.next.Flags(ctx)
+		return err
+	})
+	return flags, err
+}
+
+func (r *retryingRepository) SaveFlag(ctx context.Context, flag Flag) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveFlag(ctx, flag)
+	})
+}
+
+// DeleteFlag isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteFlag(ctx context.Context, name string) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteFlag(ctx, name)
+	})
+}
+
+func (r *retryingRepository) ChordAliases(ctx context.Context) ([]ChordAlias, error) {
+	var aliases []ChordAlias
+	err := r.do(ctx, true, func() error {
+		var err error
+		aliases, err = r.next.ChordAliases(ctx)
+		return err
+	})
+	return aliases, err
+}
+
+func (r *retryingRepository) SaveChordAlias(ctx context.Context, alias ChordAlias) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveChordAlias(ctx, alias)
+	})
+}
+
+// DeleteChordAlias isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteChordAlias(ctx context.Context, alias string) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteChordAlias(ctx, alias)
+	})
+}
+
+func (r *retryingRepository) Experiments(ctx context.Context) ([]Experiment, error) {
+	var experiments []Experiment
+	err := r.do(ctx, true, func() error {
+		var err error
+		experiments, err = r.next.Experiments(ctx)
+		return err
+	})
+	return experiments, err
+}
+
+func (r *retryingRepository) SaveExperiment(ctx context.Context, experiment Experiment) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveExperiment(ctx, experiment)
+	})
+}
+
+// DeleteExperiment isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteExperiment(ctx context.Context, name string) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteExperiment(ctx, name)
+	})
+}
+
+func (r *retryingRepository) ExperimentOutcomes(ctx context.Context, name string) ([]VariantOutcome, error) {
+	var outcomes []VariantOutcome
+	err := r.do(ctx, true, func() error {
+		var err error
+		outcomes, err = r.next.ExperimentOutcomes(ctx, name)
+		return err
+	})
+	return outcomes, err
+}
+
+func (r *retryingRepository) WarehouseExport(ctx context.Context, name string) (WarehouseExport, error) {
+	var export WarehouseExport
+	err := r.do(ctx, true, func() error {
+		var err error
+		export, err = r.next.WarehouseExport(ctx, name)
+		return err
+	})
+	return export, err
+}
+
+// UpdateWarehouseExport isn't repeated, a repeat would find the export
+// already moved on and report that another replica claimed it
+func (r *retryingRepository) UpdateWarehouseExport(ctx context.Context, from, to WarehouseExport) (bool, error) {
+	var updated bool
+	err := r.do(ctx, false, func() error {
+		var err error
+		updated, err = r.next.UpdateWarehouseExport(ctx, from, to)
+		return err
+	})
+	return updated, err
+}
+
+// LockJob is repeated, a repeat finds the lock held by the same holder
+func (r *retryingRepository) LockJob(ctx context.Context, name, holder string, until time.Time) (bool, error) {
+	var locked bool
+	err := r.do(ctx, true, func() error {
+		var err error
+		locked, err = r.next.LockJob(ctx, name, holder, until)
+		return err
+	})
+	return locked, err
+}
+
+func (r *retryingRepository) UnlockJob(ctx context.Context, name, holder string) error {
+	return r.do(ctx, true, func() error {
+		return r.next.UnlockJob(ctx, name, holder)
+	})
+}
+
+func (r *retryingRepository) SaveJobRun(ctx context.Context, run JobRun) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveJobRun(ctx, run)
+	})
+}
+
+func (r *retryingRepository) JobRuns(ctx context.Context, job string, limit int) ([]JobRun, error) {
+	var runs []JobRun
+	err := r.do(ctx, true, func() error {
+		var err error
+		runs, err = r.next.JobRuns(ctx, job, limit)
+		return err
+	})
+	return runs, err
+}
+
+// InsertAccessLogs is repeated, a repeat skips the entries inserted already
+func (r *retryingRepository) InsertAccessLogs(ctx context.Context, entries []AccessLogEntry) error {
+	return r.do(ctx, true, func() error {
+		return r.next.InsertAccessLogs(ctx, entries)
+	})
+}
+
+func (r *retryingRepository) AccessLogs(ctx context.Context, filter AccessLogFilter) ([]AccessLogEntry, error) {
+	var entries []AccessLogEntry
+	err := r.do(ctx, true, func() error {
+		var err error
+		entries, err = r.next.AccessLogs(ctx, filter)
+		return err
+	})
+	return entries, err
+}
+
+func (r *retryingRepository) Tokens(ctx context.Context) ([]APIToken, error) {
+	var tokens []APIToken
+	err := r.do(ctx, true, func() error {
+		var err error
+		tokens, err = r.next.Tokens(ctx)
+		return err
+	})
+	return tokens, err
+}
+
+// SaveToken isn't repeated, a repeat would fail on the duplicate ID
+func (r *retryingRepository) SaveToken(ctx context.Context, token APIToken) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SaveToken(ctx, token)
+	})
+}
+
+// DeleteToken isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteToken(ctx context.Context, id primitive.ObjectID) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteToken(ctx, id)
+	})
+}
+
+func (r *retryingRepository) ProvisionUser(ctx context.Context, identity Identity, user User) (User, error) {
+	var provisioned User
+	err := r.do(ctx, true, func() error {
+		var err error
+		provisioned, err = r.next.ProvisionUser(ctx, identity, user)
+		return err
+	})
+	return provisioned, err
+}
+
+func (r *retryingRepository) User(ctx context.Context, id string) (User, error) {
+	var user User
+	err := r.do(ctx, true, func() error {
+		var err error
+		user, err = r.next.User(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryingRepository) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	var verified bool
+	err := r.do(ctx, true, func() error {
+		var err error
+		verified, err = r.next.VerifyEmail(ctx, userID, email)
+		return err
+	})
+	return verified, err
+}
+
+func (r *retryingRepository) ScheduleDeletion(ctx context.Context, userID string, at *time.Time) error {
+	return r.do(ctx, true, func() error {
+		return r.next.ScheduleDeletion(ctx, userID, at)
+	})
+}
+
+func (r *retryingRepository) UsersDueForDeletion(ctx context.Context, before time.Time) ([]User, error) {
+	var users []User
+	err := r.do(ctx, true, func() error {
+		var err error
+		users, err = r.next.UsersDueForDeletion(ctx, before)
+		return err
+	})
+	return users, err
+}
+
+func (r *retryingRepository) PurgeUser(ctx context.Context, userID string) error {
+	return r.do(ctx, true, func() error {
+		return r.next.PurgeUser(ctx, userID)
+	})
+}
+
+func (r *retryingRepository) SaveTwoFactor(ctx context.Context, userID string, twoFactor *TwoFactor) error {
+	return r.do(ctx, true, func() error {
+		return r.next.SaveTwoFactor(ctx, userID, twoFactor)
+	})
+}
+
+// UseTwoFactorStep isn't repeated, a repeat would tell the step was used
+func (r *retryingRepository) UseTwoFactorStep(ctx context.Context, userID string, step int64) (bool, error) {
+	var used bool
+	err := r.do(ctx, false, func() error {
+		var err error
+		used, err = r.next.UseTwoFactorStep(ctx, userID, step)
+		return err
+	})
+	return used, err
+}
+
+// UseRecoveryCode isn't repeated, a repeat would tell the code was used
+func (r *retryingRepository) UseRecoveryCode(ctx context.Context, userID string, hash []byte) (bool, error) {
+	var used bool
+	err := r.do(ctx, false, func() error {
+		var err error
+		used, err = r.next.UseRecoveryCode(ctx, userID, hash)
+		return err
+	})
+	return used, err
+}
+
+// SaveSession isn't repeated, a repeat would fail on the duplicate ID
+func (r *retryingRepository) SaveSession(ctx context.Context, session Session) error {
+	return r.do(ctx, false, func() error {
+		return r.next.SaveSession(ctx, session)
+	})
+}
+
+func (r *retryingRepository) SessionByHash(ctx context.Context, hash []byte) (Session, error) {
+	var session Session
+	err := r.do(ctx, true, func() error {
+		var err error
+		session, err = r.next.SessionByHash(ctx, hash)
+		return err
+	})
+	return session, err
+}
+
+func (r *retryingRepository) Sessions(ctx context.Context, userID string) ([]Session, error) {
+	var sessions []Session
+	err := r.do(ctx, true, func() error {
+		var err error
+		sessions, err = r.next.Sessions(ctx, userID)
+		return err
+	})
+	return sessions, err
+}
+
+func (r *retryingRepository) TouchSession(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error {
+	return r.do(ctx, true, func() error {
+		return r.next.TouchSession(ctx, id, at, ip)
+	})
+}
+
+// DeleteSession isn't repeated, a repeat would fail with errNotFound
+func (r *retryingRepository) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	return r.do(ctx, false, func() error {
+		return r.next.DeleteSession(ctx, id)
+	})
+}
+
+// InTransaction isn't retried or guarded as a whole, since fn may have
+// effects beyond the repository, only the calls in fn are
+func (r *retryingRepository) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.next.InTransaction(ctx, fn)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentSchema versions the documents of a collection. Its upgraders
+// bring a stored document from the schema version at their index to the
+// next one, nil ones leave it as it is. Adding a field that older documents
+// lack a meaningful value of, or renaming one, adds an upgrader; reads then
+// see the current shape and documents are left as stored until they are
+// written. Writes changing only some fields leave the version as it is, so
+// upgraders only fill in what is missing.
+//
+// Only documents decoded by the schema are upgraded. Aggregations like
+// CountByDay and filters run in Mongo on the documents as stored, so an
+// upgrader of a field they group or filter by needs a backfill of the
+// stored documents too.
+type documentSchema []func(doc bson.M)
+
+var (
+	statsSchema = documentSchema{
+		// 0 is every document stored before documents were versioned
+		upgradeUnversionedStats,
+	}
+	// the documents of the other collections of users' data stored before
+	// documents were versioned already have the current shape
+	settingsSchema         = documentSchema{nil}
+	sessionsSchema         = documentSchema{nil}
+	practiceSessionsSchema = documentSchema{nil}
+	streakPausesSchema     = documentSchema{nil}
+	streakFreezesSchema    = documentSchema{nil}
+	statsCorrectionsSchema = documentSchema{nil}
+)
+
+// statsSchemaVersion is the schema version of the stats written now
+var statsSchemaVersion = statsSchema.version()
+
+// version is the schema version of the documents written now
+func (s documentSchema) version() int {
+	return len(s)
+}
+
+// upgradeUnversionedStats fills in what the stats stored before answers
+// were validated and versioned may lack
+func upgradeUnversionedStats(doc bson.M) {
+	if _, exists := doc["created_at"]; !exists {
+		if id, ok := doc["_id"].(primitive.ObjectID); ok {
+			doc["created_at"] = primitive.NewDateTimeFromTime(id.Timestamp())
+		}
+	}
+	if _, exists := doc["version"]; !exists {
+		doc["version"] = int64(1)
+	}
+}
+
+// decode decodes a stored document into v, upgrading it to the current
+// schema version first if it is older
+func (s documentSchema) decode(raw bson.Raw, v interface{}) error {
+	version := int64(0)
+	if value, err := raw.LookupErr("schema_version"); err == nil {
+		version, _ = value.AsInt64OK()
+	}
+	upgraders := 0
+	for i := version; i >= 0 && i < int64(len(s)); i++ {
+		if s[i] != nil {
+			upgraders++
+		}
+	}
+	if upgraders == 0 {
+		return bson.Unmarshal(raw, v)
+	}
+
+	var doc bson.M
+	err := bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return err
+	}
+	for _, upgrade := range s[version:] {
+		if upgrade != nil {
+			upgrade(doc)
+		}
+	}
+	doc["schema_version"] = s.version()
+	upgraded, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(upgraded, v)
+}
+
+// decodeOne decodes the document of result into v like result.Decode,
+// upgrading it first if it is older
+func (s documentSchema) decodeOne(result *mongo.SingleResult, v interface{}) error {
+	raw, err := result.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return s.decode(raw, v)
+}
+
+// decodeAll appends the rest of the documents of cursor to the slice
+// results points to like cursor.All, upgrading the older ones first, and
+// closes it
+func (s documentSchema) decodeAll(ctx context.Context, cursor *mongo.Cursor, results interface{}) error {
+	defer cursor.Close(ctx)
+
+	slice := reflect.ValueOf(results).Elem()
+	for cursor.Next(ctx) {
+		item := reflect.New(slice.Type().Elem())
+		err := s.decode(cursor.Current, item.Interface())
+		if err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+	}
+	return cursor.Err()
+}
+
+// decodeStats decodes a stored stats document, upgrading it to the current
+// schema version first if it is older
+func decodeStats(raw bson.Raw) (StatsRaw, error) {
+	var stats StatsRaw
+	err := statsSchema.decode(raw, &stats)
+	return stats, err
+}
+
+// decodeAllStats decodes the rest of the stats of cursor and closes it
+func decodeAllStats(ctx context.Context, cursor *mongo.Cursor) ([]StatsRaw, error) {
+	stats := []StatsRaw{}
+	err := statsSchema.decodeAll(ctx, cursor, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
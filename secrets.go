@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretEnvs are the environment variables holding secrets. Each of them can
+// instead be read from the file named by the variable with a _FILE suffix,
+// like MONGODB_URL_FILE=/run/secrets/mongodb_url for a Docker secret.
+var secretEnvs = []string{
+	"MONGODB_URL",
+	"AUTH_TOKEN",
+	"ADMIN_TOKEN",
+	"DEMO_TOKEN",
+	"TENANT_TOKENS",
+	"SIGNING_SECRETS",
+	"MAGIC_LINK_SECRET",
+	"SMTP_URL",
+	"REDIS_URL",
+	"NATS_URL",
+	"SENTRY_DSN",
+	"ALERT_WEBHOOK_URL",
+	"BACKUP_S3_ACCESS_KEY",
+	"BACKUP_S3_SECRET_KEY",
+	"GOOGLE_SERVICE_ACCOUNT",
+	"SUMMARY_LLM_API_KEY",
+}
+
+// loadSecretFiles sets the secret environment variables that are given as
+// files to the content of the files, before the options are parsed
+func loadSecretFiles() error {
+	for _, name := range secretEnvs {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			return fmt.Errorf("only one of %s and %s_FILE may be set", name, name)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		// the newline editors and echo end files with isn't part of the secret
+		err = os.Setenv(name, strings.TrimRight(string(data), "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const redacted = "[REDACTED]"
+
+// secretRedactor replaces the secrets it knows of in what is logged, so that
+// errors quoting a URL with its password or a misplaced token don't leak
+// them to wherever the logs end up
+type secretRedactor struct {
+	out      io.Writer
+	mu       sync.Mutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+var logRedactor = &secretRedactor{out: os.Stderr, secrets: map[string]bool{}}
+
+// redactSecretEnvs sends the log through the redactor, redacting the values
+// of the secret environment variables, which subcommands read as well
+func redactSecretEnvs() {
+	log.SetOutput(logRedactor)
+	for _, name := range secretEnvs {
+		redactSecrets(os.Getenv(name))
+	}
+}
+
+// redactSecrets redacts values from the log from now on. The password of a
+// value that is a URL is redacted on its own too, since errors may quote it
+// decoded or without the rest of the URL.
+func redactSecrets(values ...string) {
+	logRedactor.mu.Lock()
+	defer logRedactor.mu.Unlock()
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		logRedactor.secrets[value] = true
+		u, err := url.Parse(value)
+		if err != nil || u.User == nil {
+			continue
+		}
+		if password, ok := u.User.Password(); ok && password != "" {
+			logRedactor.secrets[password] = true
+		}
+	}
+
+	// the longest secrets go first, so that one containing another is
+	// redacted as a whole
+	secrets := make([]string, 0, len(logRedactor.secrets))
+	for secret := range logRedactor.secrets {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	replacements := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		replacements = append(replacements, secret, redacted)
+	}
+	logRedactor.replacer = strings.NewReplacer(replacements...)
+}
+
+func (s *secretRedactor) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	replacer := s.replacer
+	s.mu.Unlock()
+	if replacer == nil {
+		return s.out.Write(p)
+	}
+	_, err := io.WriteString(s.out, replacer.Replace(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
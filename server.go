@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// serverTimeouts keep slow clients from holding on to connections, like
+// those of a slowloris attack trickling in their headers a byte at a time.
+// They apply to the API and the metrics server, and to the gRPC server as
+// far as it has them.
+type serverTimeouts struct {
+	MaxHeaderSize     int           `long:"max-header-size" env:"MAX_HEADER_SIZE" default:"65536" description:"Max bytes of the request line and headers"`
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" env:"READ_HEADER_TIMEOUT" default:"10s" description:"How long a client may take to send the request headers"`
+	ReadTimeout       time.Duration `long:"read-timeout" env:"READ_TIMEOUT" default:"1m" description:"How long a client may take to send a whole request, 0 meaning no deadline"`
+	WriteTimeout      time.Duration `long:"write-timeout" env:"WRITE_TIMEOUT" default:"2m" description:"How long writing a response may take, 0 meaning no deadline"`
+	IdleTimeout       time.Duration `long:"idle-timeout" env:"IDLE_TIMEOUT" default:"2m" description:"How long an idle keep-alive connection is kept open"`
+}
+
+// validate fails on timeouts that would leave the server open to slow
+// clients. The header timeout and size can't be turned off, since the
+// zero values of http.Server mean no deadline and the default size.
+func (t serverTimeouts) validate() error {
+	if t.MaxHeaderSize <= 0 {
+		return errors.New("the max header size has to be positive")
+	}
+	if t.ReadHeaderTimeout <= 0 || t.IdleTimeout <= 0 {
+		return errors.New("the read header and idle timeouts have to be positive")
+	}
+	if t.ReadTimeout < 0 || t.WriteTimeout < 0 {
+		return errors.New("the read and write timeouts can't be negative")
+	}
+	if t.ReadTimeout > 0 && t.ReadTimeout < t.ReadHeaderTimeout {
+		return errors.New("the read timeout can't be shorter than the read header timeout")
+	}
+	return nil
+}
+
+// newServer returns an HTTP server with the timeouts and header limit
+// applied, never a zero value one
+func newServer(addr string, handler http.Handler, timeouts serverTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		MaxHeaderBytes:    timeouts.MaxHeaderSize,
+		ReadHeaderTimeout: timeouts.ReadHeaderTimeout,
+		ReadTimeout:       timeouts.ReadTimeout,
+		WriteTimeout:      timeouts.WriteTimeout,
+		IdleTimeout:       timeouts.IdleTimeout,
+	}
+}
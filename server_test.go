@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerTimeoutsValidate(t *testing.T) {
+	valid := serverTimeouts{MaxHeaderSize: 65536, ReadHeaderTimeout: 10 * time.Second, ReadTimeout: time.Minute, WriteTimeout: 2 * time.Minute, IdleTimeout: 2 * time.Minute}
+	tests := []struct {
+		name   string
+		change func(*serverTimeouts)
+		valid  bool
+	}{
+		{"defaults", func(t *serverTimeouts) {}, true},
+		{"no read and write deadlines", func(t *serverTimeouts) { t.ReadTimeout, t.WriteTimeout = 0, 0 }, true},
+		{"no header size", func(t *serverTimeouts) { t.MaxHeaderSize = 0 }, false},
+		{"no read header timeout", func(t *serverTimeouts) { t.ReadHeaderTimeout = 0 }, false},
+		{"no idle timeout", func(t *serverTimeouts) { t.IdleTimeout = 0 }, false},
+		{"negative write timeout", func(t *serverTimeouts) { t.WriteTimeout = -time.Second }, false},
+		{"read timeout before the headers", func(t *serverTimeouts) { t.ReadTimeout = time.Second }, false},
+	}
+	for _, test := range tests {
+		timeouts := valid
+		test.change(&timeouts)
+		if err := timeouts.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+// TestServerSlowHeaders has a client trickle in its headers, the server has
+// to hang up at the read header timeout
+func TestServerSlowHeaders(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(listener.Addr().String(), http.NotFoundHandler(), serverTimeouts{
+		MaxHeaderSize:     4096,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		IdleTimeout:       time.Second,
+	})
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("the server didn't hang up:", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("hung up after %s, want the read header timeout", waited)
+	}
+}
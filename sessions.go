@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const sessionContextKey contextKey = "session"
+
+// sessionTokenPrefix starts every session token, telling them apart from
+// configured and minted tokens without looking them up
+const sessionTokenPrefix = "pcs_"
+
+// sessionTTL is how long a session token is valid after sign-in
+var sessionTTL = 30 * 24 * time.Hour
+
+// sessionSeenInterval is how often the last use of a session is recorded at
+// most, so not every request writes to the database
+const sessionSeenInterval = time.Minute
+
+// twoFactorTTL is how long the user has to enter a code after signing in
+// when two-factor auth is enabled
+const twoFactorTTL = 5 * time.Minute
+
+// Session is a signed in user, identified by a token issued by the backend
+type Session struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID string             `json:"user_id" bson:"user_id"`
+	Tenant string             `json:"-" bson:"tenant"`
+	// Hash is the SHA-256 of the token. Session tokens are random enough to
+	// be stored without a salt, which lets them be looked up by hash.
+	Hash      []byte    `json:"-" bson:"hash"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	// UserAgent tells the device the session was signed in on
+	UserAgent string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	// IP and LastSeenAt are of the latest use, give or take a minute
+	IP         string    `json:"ip,omitempty" bson:"ip,omitempty"`
+	LastSeenAt time.Time `json:"last_seen_at" bson:"last_seen_at"`
+	// Current is set when listing the sessions of a user, on the caller's
+	Current bool `json:"current,omitempty" bson:"-"`
+	// Locked sessions are of accounts scheduled for deletion, they can only
+	// cancel it
+	Locked bool `json:"locked,omitempty" bson:"locked,omitempty"`
+	// TwoFactorPending sessions only become valid once a code of the user's
+	// authenticator app is entered
+	TwoFactorPending bool `json:"two_factor_pending,omitempty" bson:"two_factor_pending,omitempty"`
+	// Guest sessions are of someone trying the app without an account, see
+	// guestSignInHandler
+	Guest bool `json:"guest,omitempty" bson:"guest,omitempty"`
+	// Token is only returned when issued
+	Token         string `json:"token,omitempty" bson:"-"`
+	SchemaVersion int    `json:"-" bson:"schema_version"`
+}
+
+func sessionHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// SignIn is a new session along with the user it is for
+type SignIn struct {
+	Session Session `json:"session"`
+	User    User    `json:"user"`
+	// TwoFactorRequired tells that the session is pending until a code is
+	// posted to /auth/2fa
+	TwoFactorRequired bool `json:"two_factor_required,omitempty"`
+}
+
+// issueSession signs in user, returning the session with Token set. Pending
+// sessions are short, they are only for entering a code with.
+func issueSession(r *http.Request, user User, twoFactorPending bool) (Session, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return Session{}, err
+	}
+	token := sessionTokenPrefix + hex.EncodeToString(secret)
+
+	now := time.Now()
+	session := Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		Tenant:     user.Tenant(),
+		Hash:       sessionHash(token),
+		UserAgent:  r.UserAgent(),
+		IP:         remoteIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionTTL),
+		Locked:     user.DeletionScheduledAt != nil,
+	}
+	if twoFactorPending {
+		session.ExpiresAt = now.Add(twoFactorTTL)
+		session.TwoFactorPending = true
+	}
+	err = repository.SaveSession(r.Context(), session)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.Token = token
+	return session, nil
+}
+
+// writeSignIn answers a sign-in of user with a new session, a pending one
+// for users with two-factor auth enabled
+func writeSignIn(w http.ResponseWriter, r *http.Request, user User) {
+	session, err := issueSession(r, user, user.TwoFactorEnabled())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, newSignIn(session, user))
+}
+
+func newSignIn(session Session, user User) SignIn {
+	user.EmailVerified = user.HasVerifiedEmail()
+	return SignIn{Session: session, User: user, TwoFactorRequired: session.TwoFactorPending}
+}
+
+// isSessionToken tells if token was issued on sign-in
+func isSessionToken(token string) bool {
+	return strings.HasPrefix(token, sessionTokenPrefix)
+}
+
+// sessionForToken returns the session of a session token used from ip,
+// failing with errNotFound if the token is unknown, expired or pending
+func sessionForToken(ctx context.Context, token, ip string) (Session, error) {
+	session, err := liveSession(ctx, token)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.TwoFactorPending || session.Guest {
+		return Session{}, errNotFound
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= sessionSeenInterval || session.IP != ip {
+		session.LastSeenAt, session.IP = now, ip
+		// the request is served anyway, it is only the listing that is off
+		err = repository.TouchSession(ctx, session.ID, now, ip)
+		if err != nil && err != errNotFound {
+			log.Println("Error:", err)
+		}
+	}
+	return session, nil
+}
+
+// liveSession returns the session of a session token, pending or not,
+// failing with errNotFound if the token is unknown or expired
+func liveSession(ctx context.Context, token string) (Session, error) {
+	session, err := repository.SessionByHash(ctx, sessionHash(token))
+	if err != nil {
+		return Session{}, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return Session{}, errNotFound
+	}
+	return session, nil
+}
+
+func withSession(ctx context.Context, session Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey, session)
+}
+
+// sessionFromContext returns the session of a signed in caller, false for
+// callers with a configured or minted token
+func sessionFromContext(ctx context.Context) (Session, bool) {
+	session, signedIn := ctx.Value(sessionContextKey).(Session)
+	return session, signedIn
+}
+
+// getSessionsHandler lists the sessions the caller's user is signed in with,
+// latest used first, so ones of lost devices can be revoked
+func getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, signedIn := sessionFromContext(r.Context())
+	if !signedIn {
+		writeProblem(w, problemSignInRequired, "")
+		return
+	}
+	page, paged, fieldErrors := parsePageRequest(r)
+	if len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	sessions, err := repository.Sessions(r.Context(), current.UserID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	live := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.TwoFactorPending || !now.Before(session.ExpiresAt) {
+			continue
+		}
+		session.Current = session.ID == current.ID
+		live = append(live, session)
+	}
+	sort.SliceStable(live, func(i, j int) bool {
+		return live[i].LastSeenAt.After(live[j].LastSeenAt)
+	})
+
+	if !paged {
+		writeResponse(w, r, live)
+		return
+	}
+	start, end, next, err := page.offsetBounds(len(live))
+	if err != nil {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "cursor", Message: "isn't a cursor of this list"})
+		return
+	}
+	writePage(w, r, page, Page{Data: live[start:end], NextCursor: next, Total: len(live)})
+}
+
+// revokeSessionHandler signs a session of the caller's user out, the
+// caller's own one too
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current, signedIn := sessionFromContext(r.Context())
+	if !signedIn {
+		writeProblem(w, problemSignInRequired, "")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	sessions, err := repository.Sessions(r.Context(), current.UserID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	owned := false
+	for _, session := range sessions {
+		owned = owned || session.ID == id
+	}
+	if owned {
+		err = repository.DeleteSession(r.Context(), id)
+	}
+	if !owned || err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
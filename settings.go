@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	// the server image has no zoneinfo, so the timezones are compiled in
+	_ "time/tzdata"
+)
+
+// Settings are a user's preferences, roaming across their devices. Every
+// field is optional, and a PUT only changes the fields it sends.
+type Settings struct {
+	// Version counts the updates, 0 until the settings are first stored
+	Version           int64          `json:"version,omitempty" bson:"version,omitempty"`
+	Theme             *string        `json:"theme,omitempty" bson:"theme,omitempty"`
+	PreferredNotation *string        `json:"preferred_notation,omitempty" bson:"preferred_notation,omitempty"`
+	DrillDefaults     *DrillDefaults `json:"drill_defaults,omitempty" bson:"drill_defaults,omitempty"`
+	// Timezone is an IANA timezone name, e.g. "Europe/Stockholm"
+	Timezone   *string    `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty" bson:"modified_at,omitempty"`
+	// SchemaVersion is the shape the settings were stored in, it is only
+	// set when they are first stored
+	SchemaVersion int `json:"-" bson:"schema_version,omitempty"`
+}
+
+// DrillDefaults are the drill options preselected on a new drill, replaced
+// as a whole when updated
+type DrillDefaults struct {
+	RootNotes       []string `json:"root_notes" bson:"root_notes"`
+	ChordExtensions []string `json:"chord_extensions" bson:"chord_extensions"`
+	ChordsPerDrill  int      `json:"chords_per_drill" bson:"chords_per_drill"`
+}
+
+var validThemes = map[string]bool{"light": true, "dark": true, "system": true}
+
+// validate returns what is wrong with the settings, nothing if they are valid
+func (s Settings) validate() []FieldError {
+	var fieldErrors []FieldError
+	if s.Theme != nil && !validThemes[*s.Theme] {
+		fieldErrors = append(fieldErrors, FieldError{Field: "theme", Message: "has to be light, dark or system"})
+	}
+	if s.PreferredNotation != nil && (*s.PreferredNotation == "" || len(*s.PreferredNotation) > 32) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "preferred_notation", Message: "has to be 1 to 32 characters"})
+	}
+	if s.DrillDefaults != nil && s.DrillDefaults.ChordsPerDrill < 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "drill_defaults.chords_per_drill", Message: "can't be negative"})
+	}
+	if s.Timezone != nil {
+		if _, err := time.LoadLocation(*s.Timezone); err != nil || *s.Timezone == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: "timezone", Message: "isn't an IANA timezone"})
+		}
+	}
+	return fieldErrors
+}
+
+func getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := repository.GetSettings(r.Context(), userFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(settings.Version))
+	writeResponse(w, r, settings)
+}
+
+func updateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var update Settings
+	err := decodeRequest(r, &update)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if fieldErrors := update.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+	ifVersion := int64(anyVersion)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		ifVersion, err = parseETag(ifMatch)
+		if err != nil {
+			writeProblem(w, problemInvalidRequest, "If-Match isn't an ETag")
+			return
+		}
+	}
+
+	now := time.Now()
+	update.ModifiedAt = &now
+	update.Version = 0
+	settings, err := repository.UpdateSettings(r.Context(), userFromContext(r.Context()), update, ifVersion)
+	if err == errVersionConflict {
+		w.Header().Set("ETag", formatETag(settings.Version))
+		writeConflict(w, problemSettingsChanged, settings)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// the timezone changes how the aggregations count days
+	if update.Timezone != nil && aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+
+	w.Header().Set("ETag", formatETag(settings.Version))
+	writeResponse(w, r, settings)
+}
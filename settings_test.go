@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUpdateSettingsIfMatch updates the settings one after another, each
+// with the If-Match of the test
+func TestUpdateSettingsIfMatch(t *testing.T) {
+	defer func(saved Repository) { repository = saved }(repository)
+	repository = newMemoryRepository(nil)
+	ctx := withTenant(withUser(context.Background(), "u"), defaultTenant)
+
+	tests := []struct {
+		name      string
+		ifMatch   string
+		theme     string
+		status    int
+		etag      string
+		wantTheme string
+	}{
+		{"first update", `"0"`, "dark", http.StatusOK, `"1"`, "dark"},
+		{"stored already", `"0"`, "light", http.StatusConflict, `"1"`, "dark"},
+		{"current version", `"1"`, "light", http.StatusOK, `"2"`, "light"},
+		{"stale version", `"1"`, "dark", http.StatusConflict, `"2"`, "light"},
+		{"weak etag", `W/"2"`, "system", http.StatusOK, `"3"`, "system"},
+		{"without If-Match", "", "dark", http.StatusOK, `"4"`, "dark"},
+		{"invalid If-Match", "*", "light", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/me/settings", strings.NewReader(`{"theme":"`+test.theme+`"}`)).WithContext(ctx)
+			if test.ifMatch != "" {
+				r.Header.Set("If-Match", test.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			updateSettingsHandler(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if etag := w.Header().Get("ETag"); etag != test.etag {
+				t.Errorf("ETag %s, want %s", etag, test.etag)
+			}
+			if test.wantTheme == "" {
+				return
+			}
+			var settings Settings
+			if test.status == http.StatusConflict {
+				var problem struct {
+					Current Settings `json:"current"`
+				}
+				err := json.Unmarshal(w.Body.Bytes(), &problem)
+				if err != nil {
+					t.Fatal(err)
+				}
+				settings = problem.Current
+			} else if err := json.Unmarshal(w.Body.Bytes(), &settings); err != nil {
+				t.Fatal(err)
+			}
+			if settings.Theme == nil || *settings.Theme != test.wantTheme {
+				t.Errorf("theme %v, want %s", settings.Theme, test.wantTheme)
+			}
+		})
+	}
+}
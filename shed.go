@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Route classes limited in how many of their requests are served at once
+const (
+	classWrites       = "writes"
+	classAggregations = "aggregations"
+)
+
+// LimitInFlight serves at most limit requests of class at a time and answers
+// the rest with 503 right away, rather than queueing them, so a stampede of
+// one class of requests can't take the database from the others. A limit of 0
+// turns it off. The routes given the same middleware share the limit.
+func LimitInFlight(class string, limit int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				requestsShedTotal.WithLabelValues(class).Inc()
+				w.Header().Set("Retry-After", "1")
+				writeProblem(w, problemOverloaded, "")
+			}
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jessevdk/go-flags"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// How answers are exported to a sheet
+const (
+	// sheetModeStats appends a row per answer
+	sheetModeStats = "stats"
+	// sheetModeDaily appends a row per day that is over
+	sheetModeDaily = "daily"
+)
+
+// sheetsBatchSize is how many rows are appended at most in one call
+const sheetsBatchSize = 500
+
+// sheetsScope lets the service account edit the sheets shared with it
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// sheets appends to the connected Google Sheets, nil if the export isn't
+// configured
+var sheets *sheetsClient
+
+var (
+	statsSheetHeader = []interface{}{"created_at", "chord_name", "root_note", "chord_extension", "answer_duration_millis", "correct", "id", "version"}
+	dailySheetHeader = []interface{}{"day", "answers", "correct", "avg_duration_millis"}
+)
+
+type sheetsOptions struct {
+	GoogleServiceAccount string        `long:"google-service-account" env:"GOOGLE_SERVICE_ACCOUNT" description:"JSON key of the Google service account answers are exported to Sheets and BigQuery with, or the path to it, enables the Sheets export"`
+	SheetsExportInterval time.Duration `long:"sheets-export-interval" env:"SHEETS_EXPORT_INTERVAL" default:"5m" description:"How often new answers are exported to the connected sheets"`
+}
+
+// SheetConnection exports the answers of a tenant to a Google Sheet shared
+// with the service account
+type SheetConnection struct {
+	Tenant        string `json:"tenant" bson:"_id"`
+	SpreadsheetID string `json:"spreadsheet_id" bson:"spreadsheet_id"`
+	// Sheet is the name of the tab rows are appended to
+	Sheet string `json:"sheet" bson:"sheet"`
+	Mode  string `json:"mode" bson:"mode"`
+	// Timezone decides the days of the daily rollups, UTC if empty
+	Timezone    string      `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ConnectedAt time.Time   `json:"connected_at" bson:"connected_at"`
+	Export      SheetExport `json:"export" bson:"export"`
+}
+
+// SheetExport is how far the answers of a tenant are exported
+type SheetExport struct {
+	// Cursor is the sync sequence number of the last answer exported
+	Cursor int64 `json:"-" bson:"cursor"`
+	// Day is the last day rolled up, as YYYY-MM-DD
+	Day string `json:"day,omitempty" bson:"day"`
+	// At and Error are of the latest export, Error empty if it worked
+	At    *time.Time `json:"at,omitempty" bson:"at,omitempty"`
+	Error string     `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// validate returns what is wrong with the connection, nothing if it is
+// valid
+func (c SheetConnection) validate() []FieldError {
+	var fieldErrors []FieldError
+	if c.SpreadsheetID == "" || len(c.SpreadsheetID) > 128 || strings.ContainsAny(c.SpreadsheetID, "/?#") {
+		fieldErrors = append(fieldErrors, FieldError{Field: "spreadsheet_id", Message: "has to be the ID in the URL of the spreadsheet"})
+	}
+	if c.Sheet == "" || len(c.Sheet) > 100 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "sheet", Message: "has to be the name of a tab, of up to 100 characters"})
+	}
+	if c.Mode != sheetModeStats && c.Mode != sheetModeDaily {
+		fieldErrors = append(fieldErrors, FieldError{Field: "mode", Message: "has to be stats or daily"})
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil || c.Timezone == "Local" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "timezone", Message: "has to be an IANA timezone like Europe/Stockholm"})
+	}
+	return fieldErrors
+}
+
+func (c SheetConnection) location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// sheetsClient calls the Sheets API as a service account, which has to be
+// given edit access to the spreadsheets
+type sheetsClient struct {
+	*googleServiceAccount
+}
+
+func newSheetsClient(key string) (*sheetsClient, error) {
+	account, err := newGoogleServiceAccount(key, sheetsScope)
+	if err != nil {
+		return nil, err
+	}
+	return &sheetsClient{account}, nil
+}
+
+// Append adds rows after the last row of the sheet with the values as is,
+// so they aren't parsed as formulas
+func (c *sheetsClient) Append(ctx context.Context, spreadsheetID, sheet string, rows [][]interface{}) error {
+	// quoting the name makes it a valid range whatever characters it has
+	sheetRange := "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+	endpoint := "https://sheets.googleapis.com/v4/spreadsheets/" + url.PathEscape(spreadsheetID) +
+		"/values/" + url.PathEscape(sheetRange) + ":append?valueInputOption=RAW&insertDataOption=INSERT_ROWS"
+	return c.post(ctx, endpoint, map[string]interface{}{"values": rows}, nil)
+}
+
+func statsSheetRow(stats StatsRaw) []interface{} {
+	return []interface{}{
+		stats.CreatedAt.UTC().Format(time.RFC3339),
+		stats.ChordName,
+		stats.RootNote,
+		stats.ChordExtension,
+		stats.AnswerDurationMilliSeconds,
+		stats.Correct == nil || *stats.Correct,
+		stats.ID.Hex(),
+		stats.Version,
+	}
+}
+
+// dailyRollup sums up the answers per day
+type dailyRollup struct {
+	answers, correct, durations int
+}
+
+func (d *dailyRollup) add(stats StatsRaw) {
+	d.answers++
+	if stats.Correct == nil || *stats.Correct {
+		d.correct++
+	}
+	d.durations += stats.AnswerDurationMilliSeconds
+}
+
+// avg is the average answer duration, 0 without answers
+func (d dailyRollup) avg() int {
+	if d.answers == 0 {
+		return 0
+	}
+	return d.durations / d.answers
+}
+
+func (d dailyRollup) row(day string) []interface{} {
+	return []interface{}{day, d.answers, d.correct, d.avg()}
+}
+
+// sheetsExportJob exports the new answers of every connected tenant every
+// interval. A tenant failing to export doesn't fail the run, its error is
+// kept with its connection.
+func sheetsExportJob(interval time.Duration) *job {
+	return &job{
+		name:     "sheets_export",
+		schedule: "@every " + interval.String(),
+		timeout:  15 * time.Minute,
+		run: func(ctx context.Context) error {
+			connections, err := repository.SheetConnections(ctx)
+			if err != nil {
+				return err
+			}
+			for _, connection := range connections {
+				err = exportToSheet(ctx, connection, time.Now())
+				if err != nil {
+					log.Printf("Failed to export tenant %s to Sheets! Error: %s\n", connection.Tenant, err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// exportToSheet appends the answers of the connection's tenant that are
+// new since the last export, or the rollups of the days since, up to a
+// batch at a time. The rows are claimed by moving the export on before they
+// are appended, so replicas exporting side by side don't append them twice.
+// If appending fails the export is moved back, and the rows are tried again
+// on the next run.
+func exportToSheet(ctx context.Context, connection SheetConnection, now time.Time) error {
+	ctx = withTenant(ctx, connection.Tenant)
+	next := connection.Export
+	var rows [][]interface{}
+	switch connection.Mode {
+	case sheetModeStats:
+		stats, _, err := repository.Changes(ctx, connection.Export.Cursor, sheetsBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			rows = append(rows, statsSheetRow(s))
+			next.Cursor = s.SyncSeq
+		}
+	case sheetModeDaily:
+		loc := connection.location()
+		last, err := time.ParseInLocation("2006-01-02", connection.Export.Day, loc)
+		if err != nil {
+			return err
+		}
+		today := now.In(loc).Format("2006-01-02")
+		for day := last.AddDate(0, 0, 1); day.Format("2006-01-02") < today && len(rows) < 31; day = day.AddDate(0, 0, 1) {
+			var rollup dailyRollup
+			err = repository.EachStats(ctx, StatsFilter{Since: day, Until: day.AddDate(0, 0, 1)}, func(stats StatsRaw) error {
+				rollup.add(stats)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			rows = append(rows, rollup.row(day.Format("2006-01-02")))
+			next.Day = day.Format("2006-01-02")
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	next.At, next.Error = &now, ""
+	claimed, err := repository.UpdateSheetExport(ctx, connection.Tenant, connection.Export, next)
+	if err != nil || !claimed {
+		return err
+	}
+	err = sheets.Append(ctx, connection.SpreadsheetID, connection.Sheet, rows)
+	if err != nil {
+		failed := connection.Export
+		failed.At, failed.Error = &now, err.Error()
+		_, rollbackErr := repository.UpdateSheetExport(ctx, connection.Tenant, next, failed)
+		if rollbackErr != nil {
+			log.Println("Error:", rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func getSheetConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	connections, err := repository.SheetConnections(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, connections)
+}
+
+// connectSheetHandler connects the tenant in the path to a sheet, replacing
+// its previous connection. The header row is appended right away, which
+// tells if the sheet is shared with the service account. From then on new
+// answers are exported, older ones can be exported with sheets-backfill.
+func connectSheetHandler(w http.ResponseWriter, r *http.Request) {
+	if sheets == nil {
+		writeProblem(w, problemNotFound, "The Sheets export isn't configured")
+		return
+	}
+	var connection SheetConnection
+	err := decodeRequest(r, &connection)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	connection.Tenant = chi.URLParam(r, "tenant")
+	if fieldErrors := connection.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	now := time.Now()
+	connection.ConnectedAt = now
+	connection.Export = SheetExport{}
+	header := statsSheetHeader
+	if connection.Mode == sheetModeStats {
+		connection.Export.Cursor, err = repository.LatestSyncSeq(withTenant(r.Context(), connection.Tenant))
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	} else {
+		header = dailySheetHeader
+		connection.Export.Day = now.In(connection.location()).AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	err = sheets.Append(r.Context(), connection.SpreadsheetID, connection.Sheet, [][]interface{}{header})
+	if err != nil {
+		writeProblem(w, problemIntegrationFailed, fmt.Sprintf("Share the spreadsheet with %s as an editor: %s", sheets.email, err))
+		return
+	}
+
+	err = repository.SaveSheetConnection(r.Context(), connection)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, connection)
+}
+
+// disconnectSheetHandler stops exporting the tenant in the path, the rows
+// already appended are kept
+func disconnectSheetHandler(w http.ResponseWriter, r *http.Request) {
+	err := repository.DeleteSheetConnection(r.Context(), chi.URLParam(r, "tenant"))
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSheetsBackfill exports the answers of a tenant from before it was
+// connected to its sheet, the ones after are exported by the server
+func runSheetsBackfill(args []string) {
+	var options struct {
+		MongoUrl string `short:"u" env:"MONGODB_URL" description:"URL to mongo" required:"true"`
+		Tenant   string `long:"tenant" description:"Tenant whose answers are exported" required:"true"`
+		sheetsOptions
+	}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Usage = "sheets-backfill [OPTIONS]"
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		log.Fatalln("Error parsing input:", err)
+	}
+	if options.GoogleServiceAccount == "" {
+		log.Fatalln("Error parsing input: the Google service account is required")
+	}
+	sheets, err = newSheetsClient(options.GoogleServiceAccount)
+	if err != nil {
+		log.Fatalln("Error parsing input: Google service account:", err)
+	}
+
+	ctx := context.Background()
+	client := connectToMongo(options.MongoUrl, mongoSettings{
+		MaxPoolSize:            20,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+	})
+	defer client.Disconnect(ctx)
+	repository = newMongoRepository(client, readpref.Primary(), readpref.Primary())
+
+	connection, err := repository.SheetConnection(withTenant(ctx, allTenants), options.Tenant)
+	if err != nil {
+		log.Fatalln("Failed to load the connection of", options.Tenant+"! Error:", err)
+	}
+	count, err := backfillSheet(withTenant(ctx, options.Tenant), connection)
+	if err != nil {
+		log.Fatalf("Failed to backfill after %d rows! Error: %s\n", count, err)
+	}
+	log.Printf("Appended %d rows to %s\n", count, connection.Sheet)
+}
+
+// backfillSheet appends the rows of connection up to where the server took
+// over, and returns how many it appended
+func backfillSheet(ctx context.Context, connection SheetConnection) (int, error) {
+	var rows [][]interface{}
+	loc := connection.location()
+	var answers []StatsRaw
+	days := map[string]*dailyRollup{}
+	var order []string
+	err := repository.EachStats(ctx, StatsFilter{}, func(stats StatsRaw) error {
+		if connection.Mode == sheetModeStats {
+			if stats.SyncSeq <= connection.Export.Cursor {
+				answers = append(answers, stats)
+			}
+			return nil
+		}
+		day := stats.CreatedAt.In(loc).Format("2006-01-02")
+		if day > connection.Export.Day {
+			return nil
+		}
+		if days[day] == nil {
+			days[day] = &dailyRollup{}
+			order = append(order, day)
+		}
+		days[day].add(stats)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(answers, func(i, j int) bool { return answers[i].CreatedAt.Before(answers[j].CreatedAt) })
+	for _, stats := range answers {
+		rows = append(rows, statsSheetRow(stats))
+	}
+	sort.Strings(order)
+	for _, day := range order {
+		rows = append(rows, days[day].row(day))
+	}
+
+	appended := 0
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > sheetsBatchSize {
+			batch = batch[:sheetsBatchSize]
+		}
+		err = sheets.Append(ctx, connection.SpreadsheetID, connection.Sheet, batch)
+		if err != nil {
+			return appended, err
+		}
+		appended += len(batch)
+		rows = rows[len(batch):]
+	}
+	return appended, nil
+}
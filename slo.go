@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// anyRoute is the budget key of the routes without a budget of their own
+	anyRoute = "*"
+	// maxLatencySamples bounds the latencies kept per route, the oldest
+	// making room for new ones on busy routes
+	maxLatencySamples = 2000
+	// minLatencySamples is how many requests a route needs in the window
+	// before its p95 is held against its budget, so a single slow request
+	// to a quiet route doesn't alert
+	minLatencySamples = 20
+	// latencyCheckInterval is how often the p95s are checked
+	latencyCheckInterval = 30 * time.Second
+)
+
+// latencies is the SLO monitor, nil when no latency budgets are configured
+var latencies *latencyMonitor
+
+// alertIntegration is the Slack or Discord channel operational alerts are
+// posted to, nil when not configured
+var alertIntegration *Integration
+
+// newAlertIntegration takes the kind of the integration from the host of
+// webhookURL
+func newAlertIntegration(webhookURL string) (*Integration, error) {
+	for _, kind := range []string{integrationSlack, integrationDiscord} {
+		integration := Integration{Kind: kind, WebhookURL: webhookURL}
+		if len(integration.validate()) == 0 {
+			return &integration, nil
+		}
+	}
+	return nil, errors.New("has to be an incoming webhook URL of Slack or Discord")
+}
+
+// parseLatencyBudgets parses the durations of the budgets by route
+func parseLatencyBudgets(budgets map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration)
+	for route, budget := range budgets {
+		duration, err := time.ParseDuration(budget)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("latency budget of %s has to be a duration like 200ms", route)
+		}
+		parsed[route] = duration
+	}
+	return parsed, nil
+}
+
+// RouteLatency is the rolling p95 latency of a route held against its
+// budget
+type RouteLatency struct {
+	Route        string  `json:"route"`
+	BudgetMillis float64 `json:"budget_millis"`
+	P95Millis    float64 `json:"p95_millis"`
+	// Samples is how many requests the p95 is taken over
+	Samples  int  `json:"samples"`
+	Breached bool `json:"breached"`
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyMonitor tracks the latencies of the routes with a budget over a
+// rolling window, and alerts when the p95 of a route goes over its budget
+// and again when it is back within. Every replica monitors the requests it
+// serves.
+type latencyMonitor struct {
+	budgets map[string]time.Duration
+	window  time.Duration
+
+	mu       sync.Mutex
+	samples  map[string][]latencySample
+	breached map[string]bool
+}
+
+func newLatencyMonitor(budgets map[string]time.Duration, window time.Duration) *latencyMonitor {
+	return &latencyMonitor{
+		budgets:  budgets,
+		window:   window,
+		samples:  make(map[string][]latencySample),
+		breached: make(map[string]bool),
+	}
+}
+
+// budget returns the budget of route, false if it has none, l.mu has to be
+// held
+func (l *latencyMonitor) budget(route string) (time.Duration, bool) {
+	budget, exists := l.budgets[route]
+	if !exists {
+		budget, exists = l.budgets[anyRoute]
+	}
+	return budget, exists
+}
+
+// TrackLatency records the latency of every request to a route with a
+// budget. Requests matching no route aren't, their paths are made up by
+// the client.
+func TrackLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		routeContext := chi.RouteContext(r.Context())
+		if routeContext == nil || routeContext.RoutePattern() == "" {
+			return
+		}
+		latencies.record(routeContext.RoutePattern(), start, time.Since(start))
+	})
+}
+
+func (l *latencyMonitor) record(route string, at time.Time, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.budget(route); !exists {
+		return
+	}
+
+	samples := append(l.samples[route], latencySample{at: at, latency: latency})
+	if len(samples) > maxLatencySamples {
+		samples = samples[len(samples)-maxLatencySamples:]
+	}
+	l.samples[route] = samples
+}
+
+// setBudgets replaces the budgets, forgetting the routes left without one
+func (l *latencyMonitor) setBudgets(budgets map[string]time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.budgets = budgets
+	for route := range l.samples {
+		if _, exists := l.budget(route); !exists {
+			delete(l.samples, route)
+			delete(l.breached, route)
+		}
+	}
+}
+
+// Routes returns the latencies of the routes requested within the window,
+// sorted by route, dropping the samples older than the window
+func (l *latencyMonitor) Routes(now time.Time) []RouteLatency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	routes := []RouteLatency{}
+	for route, samples := range l.samples {
+		kept := samples[:0]
+		for _, sample := range samples {
+			if now.Sub(sample.at) < l.window {
+				kept = append(kept, sample)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.samples, route)
+			continue
+		}
+		l.samples[route] = kept
+
+		budget, _ := l.budget(route)
+		sorted := make([]time.Duration, len(kept))
+		for i, sample := range kept {
+			sorted[i] = sample.latency
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		p95 := sorted[(len(sorted)*95+99)/100-1]
+		routes = append(routes, RouteLatency{
+			Route:        route,
+			BudgetMillis: float64(budget.Microseconds()) / 1000,
+			P95Millis:    float64(p95.Microseconds()) / 1000,
+			Samples:      len(kept),
+			Breached:     l.breached[route],
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+	return routes
+}
+
+// Run checks the p95s every latencyCheckInterval until the server stops
+func (l *latencyMonitor) Run() {
+	for {
+		time.Sleep(latencyCheckInterval)
+		l.check(time.Now())
+	}
+}
+
+// check alerts on the routes that went over their budget or are back within
+// it since the last check. Routes with too few requests keep their state.
+func (l *latencyMonitor) check(now time.Time) {
+	for _, route := range l.Routes(now) {
+		routeLatencyP95.WithLabelValues(route.Route).Set(route.P95Millis / 1000)
+		if route.Samples < minLatencySamples {
+			continue
+		}
+		breached := route.P95Millis > route.BudgetMillis
+		if breached == route.Breached {
+			continue
+		}
+
+		l.mu.Lock()
+		l.breached[route.Route] = breached
+		l.mu.Unlock()
+		route.Breached = breached
+		if breached {
+			latencyBudgetBreachesTotal.WithLabelValues(route.Route).Inc()
+			log.Printf("Latency budget of %s breached! p95: %.0fms, budget: %.0fms\n", route.Route, route.P95Millis, route.BudgetMillis)
+		} else {
+			log.Printf("Latency of %s is back within budget, p95: %.0fms\n", route.Route, route.P95Millis)
+		}
+		l.alert(route)
+	}
+}
+
+// alert posts the change of a route to the alert integration, if there is
+// one
+func (l *latencyMonitor) alert(route RouteLatency) {
+	if alertIntegration == nil {
+		return
+	}
+	card := Card{
+		Title: "🐢 Latency budget breached: " + route.Route,
+		Text:  fmt.Sprintf("The p95 latency over the last %s is above the budget.", l.window),
+		Color: 0xdc2626,
+	}
+	if !route.Breached {
+		card.Title = "✅ Latency back within budget: " + route.Route
+		card.Text = fmt.Sprintf("The p95 latency over the last %s is within the budget again.", l.window)
+		card.Color = 0x16a34a
+	}
+	card.Fields = []CardField{
+		{Name: "p95", Value: fmt.Sprintf("%.0fms", route.P95Millis)},
+		{Name: "Budget", Value: fmt.Sprintf("%.0fms", route.BudgetMillis)},
+		{Name: "Requests", Value: fmt.Sprint(route.Samples)},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	postCards(ctx, []Integration{*alertIntegration}, card)
+}
+
+// getLatenciesHandler lists the rolling p95 latencies of the routes with a
+// budget on the replica serving the request
+func getLatenciesHandler(w http.ResponseWriter, r *http.Request) {
+	if latencies == nil {
+		writeProblem(w, problemNotFound, "No latency budgets are configured")
+		return
+	}
+
+	writeResponse(w, r, latencies.Routes(time.Now()))
+}
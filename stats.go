@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The functions in this file hold the logic shared by the HTTP handlers and
+// the gRPC service, on top of the repository.
+
+// addStats stores an answer and returns it as stored. Answers carrying a
+// client ID are upserted on it with last-write-wins, so a replayed offline
+// queue neither duplicates answers nor overwrites newer versions of them.
+// Devices editing the same answer pass the version they last saw as
+// ifVersion, and get errVersionConflict and the stored answer back if
+// someone else wrote it in between.
+func addStats(ctx context.Context, stats StatsRaw, ifVersion int64) (StatsRaw, error) {
+	// ids and versions are only ever assigned by the server
+	stats.ID = primitive.NilObjectID
+	stats.Version = 0
+	stats.Experiments = tagExperiments(ctx)
+	stats = withBeats(stats)
+	stats = withTidyNotes(stats)
+	// answers are stored as sent if the aliases can't be read, they are
+	// aggregated with the extension they spell all the same
+	if aliases, err := chordAliases(ctx); err == nil {
+		stats = aliases.stats(stats)
+	}
+	if stats.ClientID != "" {
+		stats.ClientID = strings.ToLower(stats.ClientID)
+		if stats.UpdatedAt.IsZero() {
+			stats.UpdatedAt = stats.CreatedAt
+		}
+		if stats.UpdatedAt.IsZero() {
+			stats.UpdatedAt = time.Now()
+		}
+	}
+
+	var stored StatsRaw
+	err := checkQuota(ctx)
+	// plain inserts are left to the worker when there is a stream. If NATS
+	// fails they are saved right away instead, which saves them twice in the
+	// rare case that they reached the stream but its ack didn't.
+	if err == nil && statsStream != nil && stats.ClientID == "" && ifVersion == anyVersion {
+		stored, err = statsStream.Publish(ctx, stats)
+		if err == nil {
+			return stored, errQueued
+		}
+		log.Println("Failed to publish stats, saving them right away! Error:", err)
+		err = nil
+	}
+	// only plain inserts can be batched, upserts depend on what is stored
+	if err == nil && statsBatcher != nil && stats.ClientID == "" && ifVersion == anyVersion {
+		stored, err = statsBatcher.Push(ctx, stats)
+		if err != nil {
+			return StatsRaw{}, err
+		}
+		return stored, errQueued
+	}
+	if err == nil {
+		stored, err = repository.SaveStats(ctx, stats, ifVersion)
+	}
+	// conditional writes can't wait, their outcome depends on what is stored
+	if unavailable(err) && statsQueue != nil && ifVersion == anyVersion {
+		err = statsQueue.Push(ctx, stats)
+		if err != nil {
+			return StatsRaw{}, err
+		}
+		return stats, errQueued
+	}
+	if err != nil {
+		return stored, err
+	}
+
+	recordStatsSaved(ctx, stored)
+	if aggregateCache != nil {
+		aggregateCache.Invalidate()
+	}
+	return stored, nil
+}
+
+// validateChord returns what is wrong with the chord of an answer, nothing if
+// it is valid
+func validateChord(stats StatsRaw) []FieldError {
+	var fieldErrors []FieldError
+	if stats.ChordName == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_name", Message: "is missing"})
+	}
+	if stats.RootNote == "" || rootNotePattern.FindString(stats.RootNote) != stats.RootNote {
+		fieldErrors = append(fieldErrors, FieldError{Field: "root_note", Message: "has to be a note like C, F# or Bb"})
+	} else if stats.ChordName != "" && !strings.HasPrefix(stats.ChordName, stats.RootNote) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "chord_name", Message: "has to start with root_note"})
+	}
+	if stats.Instrument != "" && !validInstrument(stats.Instrument) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "instrument", Message: "has to be one of " + strings.Join(instruments, ", ")})
+	}
+	return fieldErrors
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func validUUID(id string) bool {
+	return uuidPattern.MatchString(id)
+}
+
+var errInvalidTimezone = errors.New("invalid timezone")
+
+// userLocation returns the timezone that decides what "today" is for a user:
+// the one named, if any, otherwise the one in their settings and UTC if they
+// haven't set one
+func userLocation(ctx context.Context, userID string, name string) (*time.Location, error) {
+	if name == "" {
+		settings, err := repository.GetSettings(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if settings.Timezone == nil {
+			return time.UTC, nil
+		}
+		name = *settings.Timezone
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, errInvalidTimezone
+	}
+	return loc, nil
+}
+
+// countByDayLastMonth returns the number of answers per day in loc for the
+// last 31 days and today, including days without any answers
+func countByDayLastMonth(ctx context.Context, loc *time.Location) ([]StatsCountByDay, error) {
+	countByDaysFromMongo, err := repository.CountByDay(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	countsMap := make(map[string]StatsCountByDay)
+	for _, count := range countByDaysFromMongo {
+		countsMap[count.Day] = count
+	}
+
+	startTimeDaysAgo := 31
+	responseCountByDays := []StatsCountByDay{}
+	for i := startTimeDaysAgo; i >= 0; i-- {
+		today := time.Now().In(loc)
+		targetDay := today.AddDate(0, 0, -i)
+		targetDayStr := targetDay.Format("2006-01-02")
+		count, exists := countsMap[targetDayStr]
+		if exists {
+			responseCountByDays = append(responseCountByDays, count)
+		} else {
+			responseCountByDays = append(
+				responseCountByDays,
+				StatsCountByDay{
+					Day:   targetDayStr,
+					Count: 0,
+				},
+			)
+		}
+	}
+	return responseCountByDays, nil
+}
+
+// Families of chords, by how many notes are stacked on the triad
+const (
+	chordFamilyTriad    = "triad"
+	chordFamilySeventh  = "seventh"
+	chordFamilyExtended = "extended"
+)
+
+// chordFamily returns the family of chords with extension: those with a 9th,
+// 11th, 13th or added note are extended, other 7th chords sevenths and the
+// rest, like maj, m, dim, aug and sus4, triads
+func chordFamily(extension string) string {
+	extension = strings.ToLower(extension)
+	for _, extended := range []string{"9", "11", "13", "6", "add"} {
+		if strings.Contains(extension, extended) {
+			return chordFamilyExtended
+		}
+	}
+	if strings.Contains(extension, "7") {
+		return chordFamilySeventh
+	}
+	return chordFamilyTriad
+}
+
+// countByDayAndFamilyLastMonth returns the number of answers of each family of
+// chords per day in loc for the last 31 days and today, including days
+// without any answers
+func countByDayAndFamilyLastMonth(ctx context.Context, loc *time.Location) ([]StatsCountByDayAndFamily, error) {
+	counts, err := repository.CountByDayAndExtension(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	countsMap := make(map[string]*StatsCountByDayAndFamily)
+	for _, count := range counts {
+		dayCount, exists := countsMap[count.Day]
+		if !exists {
+			dayCount = &StatsCountByDayAndFamily{Day: count.Day}
+			countsMap[count.Day] = dayCount
+		}
+		switch chordFamily(aliases.extension(count.Extension)) {
+		case chordFamilyTriad:
+			dayCount.Triads += count.Count
+		case chordFamilySeventh:
+			dayCount.Sevenths += count.Count
+		case chordFamilyExtended:
+			dayCount.Extended += count.Count
+		}
+	}
+
+	today := time.Now().In(loc)
+	responseCounts := []StatsCountByDayAndFamily{}
+	for i := 31; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format("2006-01-02")
+		if count, exists := countsMap[day]; exists {
+			responseCounts = append(responseCounts, *count)
+		} else {
+			responseCounts = append(responseCounts, StatsCountByDayAndFamily{Day: day})
+		}
+	}
+	return responseCounts, nil
+}
+
+// countByExtension counts the answers per chord extension, the ones stored
+// with an alias counting as the extension it spells
+func countByExtension(ctx context.Context) ([]StatsCountByExtension, error) {
+	countByExtensions, err := repository.CountByExtension(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return aliases.mergeCounts(countByExtensions), nil
+}
+
+// avgDurationByExtension returns the average answer duration in seconds, the
+// answers stored with an alias counting as the extension it spells
+func avgDurationByExtension(ctx context.Context) ([]StatsDurationByExtension, error) {
+	durationByExtensions, err := repository.AvgDurationByExtension(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	durationByExtensions = aliases.mergeDurations(durationByExtensions)
+
+	durationByExtensionsTransformed := []StatsDurationByExtension{}
+	for _, duration := range durationByExtensions {
+		newDuration := StatsDurationByExtension{
+			AvgDuration: duration.AvgDuration / 1000,
+			Extension:   duration.Extension,
+		}
+		durationByExtensionsTransformed = append(durationByExtensionsTransformed, newDuration)
+	}
+	return durationByExtensionsTransformed, nil
+}
@@ -0,0 +1,1186 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: statspb/stats.proto
+
+package statspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Stats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ChordName            string                 `protobuf:"bytes,1,opt,name=chord_name,json=chordName,proto3" json:"chord_name,omitempty"`
+	RootNote             string                 `protobuf:"bytes,2,opt,name=root_note,json=rootNote,proto3" json:"root_note,omitempty"`
+	ChordExtension       string                 `protobuf:"bytes,3,opt,name=chord_extension,json=chordExtension,proto3" json:"chord_extension,omitempty"`
+	AnswerDurationMillis int64                  `protobuf:"varint,4,opt,name=answer_duration_millis,json=answerDurationMillis,proto3" json:"answer_duration_millis,omitempty"`
+	CreatedAt            *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// assigned by the server
+	Id string `protobuf:"bytes,6,opt,name=id,proto3" json:"id,omitempty"`
+	// optional UUID generated by the client, makes resubmitting safe
+	ClientId string `protobuf:"bytes,7,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// client time of the write, the latest write with the same client_id wins
+	UpdatedAt *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// bumped by the server on every accepted write
+	Version int64 `protobuf:"varint,9,opt,name=version,proto3" json:"version,omitempty"`
+	// left out by clients that only record right answers, which count as
+	// right
+	Correct *bool `protobuf:"varint,10,opt,name=correct,proto3,oneof" json:"correct,omitempty"`
+	// what the chord was played on, piano if empty
+	Instrument string `protobuf:"bytes,11,opt,name=instrument,proto3" json:"instrument,omitempty"`
+	// tempo of the metronome the answer was given against, 0 without one
+	Bpm int32 `protobuf:"varint,12,opt,name=bpm,proto3" json:"bpm,omitempty"`
+	// beats of the metronome the answer took, worked out from
+	// answer_duration_millis if 0
+	BeatsToAnswer float64 `protobuf:"fixed64,13,opt,name=beats_to_answer,json=beatsToAnswer,proto3" json:"beats_to_answer,omitempty"`
+	// how sure the user was of the answer from 1 to 5, 0 if not rated
+	Confidence int32 `protobuf:"varint,14,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	// the try at the prompt the answer was given on, 1 for the first
+	Attempt int32 `protobuf:"varint,15,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	// the user's own words on the circumstances of the answer
+	Tags []string `protobuf:"bytes,16,rep,name=tags,proto3" json:"tags,omitempty"`
+	Note string   `protobuf:"bytes,17,opt,name=note,proto3" json:"note,omitempty"`
+	// the client that recorded the answer
+	Platform   string `protobuf:"bytes,18,opt,name=platform,proto3" json:"platform,omitempty"`
+	AppVersion string `protobuf:"bytes,19,opt,name=app_version,json=appVersion,proto3" json:"app_version,omitempty"`
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Stats) GetChordName() string {
+	if x != nil {
+		return x.ChordName
+	}
+	return ""
+}
+
+func (x *Stats) GetRootNote() string {
+	if x != nil {
+		return x.RootNote
+	}
+	return ""
+}
+
+func (x *Stats) GetChordExtension() string {
+	if x != nil {
+		return x.ChordExtension
+	}
+	return ""
+}
+
+func (x *Stats) GetAnswerDurationMillis() int64 {
+	if x != nil {
+		return x.AnswerDurationMillis
+	}
+	return 0
+}
+
+func (x *Stats) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Stats) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Stats) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *Stats) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Stats) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Stats) GetCorrect() bool {
+	if x != nil && x.Correct != nil {
+		return *x.Correct
+	}
+	return false
+}
+
+func (x *Stats) GetInstrument() string {
+	if x != nil {
+		return x.Instrument
+	}
+	return ""
+}
+
+func (x *Stats) GetBpm() int32 {
+	if x != nil {
+		return x.Bpm
+	}
+	return 0
+}
+
+func (x *Stats) GetBeatsToAnswer() float64 {
+	if x != nil {
+		return x.BeatsToAnswer
+	}
+	return 0
+}
+
+func (x *Stats) GetConfidence() int32 {
+	if x != nil {
+		return x.Confidence
+	}
+	return 0
+}
+
+func (x *Stats) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *Stats) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Stats) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+func (x *Stats) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+func (x *Stats) GetAppVersion() string {
+	if x != nil {
+		return x.AppVersion
+	}
+	return ""
+}
+
+type AddStatsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Stats *Stats `protobuf:"bytes,1,opt,name=stats,proto3" json:"stats,omitempty"`
+	// only write the stats if the stored version equals this, 0 meaning not
+	// stored yet, otherwise ABORTED is returned
+	IfVersion *int64 `protobuf:"varint,2,opt,name=if_version,json=ifVersion,proto3,oneof" json:"if_version,omitempty"`
+}
+
+func (x *AddStatsRequest) Reset() {
+	*x = AddStatsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddStatsRequest) ProtoMessage() {}
+
+func (x *AddStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddStatsRequest.ProtoReflect.Descriptor instead.
+func (*AddStatsRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AddStatsRequest) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+func (x *AddStatsRequest) GetIfVersion() int64 {
+	if x != nil && x.IfVersion != nil {
+		return *x.IfVersion
+	}
+	return 0
+}
+
+type AddStatsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// the stats as stored
+	Stats *Stats `protobuf:"bytes,1,opt,name=stats,proto3" json:"stats,omitempty"`
+}
+
+func (x *AddStatsResponse) Reset() {
+	*x = AddStatsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddStatsResponse) ProtoMessage() {}
+
+func (x *AddStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddStatsResponse.ProtoReflect.Descriptor instead.
+func (*AddStatsResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AddStatsResponse) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type ListStatsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListStatsRequest) Reset() {
+	*x = ListStatsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListStatsRequest) ProtoMessage() {}
+
+func (x *ListStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListStatsRequest.ProtoReflect.Descriptor instead.
+func (*ListStatsRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{3}
+}
+
+type CountByDayRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// IANA timezone the days are counted in, defaults to the user's timezone
+	Timezone string `protobuf:"bytes,1,opt,name=timezone,proto3" json:"timezone,omitempty"`
+}
+
+func (x *CountByDayRequest) Reset() {
+	*x = CountByDayRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CountByDayRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountByDayRequest) ProtoMessage() {}
+
+func (x *CountByDayRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountByDayRequest.ProtoReflect.Descriptor instead.
+func (*CountByDayRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CountByDayRequest) GetTimezone() string {
+	if x != nil {
+		return x.Timezone
+	}
+	return ""
+}
+
+type DayCount struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Day   string `protobuf:"bytes,1,opt,name=day,proto3" json:"day,omitempty"`
+	Count int64  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *DayCount) Reset() {
+	*x = DayCount{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DayCount) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DayCount) ProtoMessage() {}
+
+func (x *DayCount) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DayCount.ProtoReflect.Descriptor instead.
+func (*DayCount) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DayCount) GetDay() string {
+	if x != nil {
+		return x.Day
+	}
+	return ""
+}
+
+func (x *DayCount) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type CountByDayResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Counts []*DayCount `protobuf:"bytes,1,rep,name=counts,proto3" json:"counts,omitempty"`
+}
+
+func (x *CountByDayResponse) Reset() {
+	*x = CountByDayResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CountByDayResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountByDayResponse) ProtoMessage() {}
+
+func (x *CountByDayResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountByDayResponse.ProtoReflect.Descriptor instead.
+func (*CountByDayResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CountByDayResponse) GetCounts() []*DayCount {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+type CountByExtensionRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *CountByExtensionRequest) Reset() {
+	*x = CountByExtensionRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CountByExtensionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountByExtensionRequest) ProtoMessage() {}
+
+func (x *CountByExtensionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountByExtensionRequest.ProtoReflect.Descriptor instead.
+func (*CountByExtensionRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{7}
+}
+
+type ExtensionCount struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ChordExtension string `protobuf:"bytes,1,opt,name=chord_extension,json=chordExtension,proto3" json:"chord_extension,omitempty"`
+	Count          int64  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *ExtensionCount) Reset() {
+	*x = ExtensionCount{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExtensionCount) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtensionCount) ProtoMessage() {}
+
+func (x *ExtensionCount) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtensionCount.ProtoReflect.Descriptor instead.
+func (*ExtensionCount) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExtensionCount) GetChordExtension() string {
+	if x != nil {
+		return x.ChordExtension
+	}
+	return ""
+}
+
+func (x *ExtensionCount) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type CountByExtensionResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Counts []*ExtensionCount `protobuf:"bytes,1,rep,name=counts,proto3" json:"counts,omitempty"`
+}
+
+func (x *CountByExtensionResponse) Reset() {
+	*x = CountByExtensionResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CountByExtensionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountByExtensionResponse) ProtoMessage() {}
+
+func (x *CountByExtensionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountByExtensionResponse.ProtoReflect.Descriptor instead.
+func (*CountByExtensionResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CountByExtensionResponse) GetCounts() []*ExtensionCount {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+type DurationByExtensionRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *DurationByExtensionRequest) Reset() {
+	*x = DurationByExtensionRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DurationByExtensionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DurationByExtensionRequest) ProtoMessage() {}
+
+func (x *DurationByExtensionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DurationByExtensionRequest.ProtoReflect.Descriptor instead.
+func (*DurationByExtensionRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{10}
+}
+
+type ExtensionDuration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ChordExtension string `protobuf:"bytes,1,opt,name=chord_extension,json=chordExtension,proto3" json:"chord_extension,omitempty"`
+	// average answer duration in seconds
+	AvgDuration float64 `protobuf:"fixed64,2,opt,name=avg_duration,json=avgDuration,proto3" json:"avg_duration,omitempty"`
+}
+
+func (x *ExtensionDuration) Reset() {
+	*x = ExtensionDuration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExtensionDuration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtensionDuration) ProtoMessage() {}
+
+func (x *ExtensionDuration) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtensionDuration.ProtoReflect.Descriptor instead.
+func (*ExtensionDuration) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ExtensionDuration) GetChordExtension() string {
+	if x != nil {
+		return x.ChordExtension
+	}
+	return ""
+}
+
+func (x *ExtensionDuration) GetAvgDuration() float64 {
+	if x != nil {
+		return x.AvgDuration
+	}
+	return 0
+}
+
+type DurationByExtensionResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Durations []*ExtensionDuration `protobuf:"bytes,1,rep,name=durations,proto3" json:"durations,omitempty"`
+}
+
+func (x *DurationByExtensionResponse) Reset() {
+	*x = DurationByExtensionResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_statspb_stats_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DurationByExtensionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DurationByExtensionResponse) ProtoMessage() {}
+
+func (x *DurationByExtensionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DurationByExtensionResponse.ProtoReflect.Descriptor instead.
+func (*DurationByExtensionResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *DurationByExtensionResponse) GetDurations() []*ExtensionDuration {
+	if x != nil {
+		return x.Durations
+	}
+	return nil
+}
+
+var File_statspb_stats_proto protoreflect.FileDescriptor
+
+var file_statspb_stats_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x73, 0x74, 0x61, 0x74, 0x73, 0x70, 0x62, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x1b, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72,
+	0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e,
+	0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x22, 0x83, 0x05, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x1d, 0x0a,
+	0x0a, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09,
+	0x72, 0x6f, 0x6f, 0x74, 0x5f, 0x6e, 0x6f, 0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x72, 0x6f, 0x6f, 0x74, 0x4e, 0x6f, 0x74, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x68, 0x6f,
+	0x72, 0x64, 0x5f, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0e, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x34, 0x0a, 0x16, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x5f, 0x64, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6d, 0x69, 0x6c, 0x6c, 0x69, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x14, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x4d, 0x69, 0x6c, 0x6c, 0x69, 0x73, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x02, 0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64,
+	0x12, 0x39, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x76,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x76, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x07, 0x63, 0x6f, 0x72, 0x72, 0x65, 0x63, 0x74,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x48, 0x00, 0x52, 0x07, 0x63, 0x6f, 0x72, 0x72, 0x65, 0x63,
+	0x74, 0x88, 0x01, 0x01, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x6e, 0x73, 0x74, 0x72, 0x75, 0x6d, 0x65,
+	0x6e, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x69, 0x6e, 0x73, 0x74, 0x72, 0x75,
+	0x6d, 0x65, 0x6e, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x62, 0x70, 0x6d, 0x18, 0x0c, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x03, 0x62, 0x70, 0x6d, 0x12, 0x26, 0x0a, 0x0f, 0x62, 0x65, 0x61, 0x74, 0x73, 0x5f,
+	0x74, 0x6f, 0x5f, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x0d, 0x62, 0x65, 0x61, 0x74, 0x73, 0x54, 0x6f, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12, 0x1e,
+	0x0a, 0x0a, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x0a, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x07, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73,
+	0x18, 0x10, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x6f, 0x74, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x6f, 0x74, 0x65,
+	0x12, 0x1a, 0x0a, 0x08, 0x70, 0x6c, 0x61, 0x74, 0x66, 0x6f, 0x72, 0x6d, 0x18, 0x12, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x70, 0x6c, 0x61, 0x74, 0x66, 0x6f, 0x72, 0x6d, 0x12, 0x1f, 0x0a, 0x0b,
+	0x61, 0x70, 0x70, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x61, 0x70, 0x70, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x42, 0x0a, 0x0a,
+	0x08, 0x5f, 0x63, 0x6f, 0x72, 0x72, 0x65, 0x63, 0x74, 0x22, 0x7e, 0x0a, 0x0f, 0x41, 0x64, 0x64,
+	0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x38, 0x0a, 0x05,
+	0x73, 0x74, 0x61, 0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x70, 0x69,
+	0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67,
+	0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52,
+	0x05, 0x73, 0x74, 0x61, 0x74, 0x73, 0x12, 0x22, 0x0a, 0x0a, 0x69, 0x66, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x48, 0x00, 0x52, 0x09, 0x69, 0x66,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x69,
+	0x66, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x4c, 0x0a, 0x10, 0x41, 0x64, 0x64,
+	0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x38, 0x0a,
+	0x05, 0x73, 0x74, 0x61, 0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x70,
+	0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e,
+	0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73,
+	0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x73, 0x22, 0x12, 0x0a, 0x10, 0x4c, 0x69, 0x73, 0x74, 0x53,
+	0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x2f, 0x0a, 0x11, 0x43,
+	0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x44, 0x61, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x1a, 0x0a, 0x08, 0x74, 0x69, 0x6d, 0x65, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x74, 0x69, 0x6d, 0x65, 0x7a, 0x6f, 0x6e, 0x65, 0x22, 0x32, 0x0a, 0x08,
+	0x44, 0x61, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x61, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x64, 0x61, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74,
+	0x22, 0x53, 0x0a, 0x12, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x44, 0x61, 0x79, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3d, 0x0a, 0x06, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x25, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68,
+	0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74,
+	0x73, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x61, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x06, 0x63,
+	0x6f, 0x75, 0x6e, 0x74, 0x73, 0x22, 0x19, 0x0a, 0x17, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79,
+	0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x22, 0x4f, 0x0a, 0x0e, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x75,
+	0x6e, 0x74, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x5f, 0x65, 0x78, 0x74, 0x65,
+	0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x63, 0x68, 0x6f,
+	0x72, 0x64, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x63,
+	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e,
+	0x74, 0x22, 0x5f, 0x0a, 0x18, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65,
+	0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x43, 0x0a,
+	0x06, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2b, 0x2e,
+	0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69,
+	0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x78, 0x74, 0x65,
+	0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x06, 0x63, 0x6f, 0x75, 0x6e,
+	0x74, 0x73, 0x22, 0x1c, 0x0a, 0x1a, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x79,
+	0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x22, 0x5f, 0x0a, 0x11, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x44, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x5f, 0x65,
+	0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e,
+	0x63, 0x68, 0x6f, 0x72, 0x64, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x21,
+	0x0a, 0x0c, 0x61, 0x76, 0x67, 0x5f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x61, 0x76, 0x67, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x22, 0x6b, 0x0a, 0x1b, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x79, 0x45,
+	0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x4c, 0x0a, 0x09, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x2e, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64,
+	0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76,
+	0x31, 0x2e, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x44, 0x75, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x52, 0x09, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x32, 0xd4,
+	0x04, 0x0a, 0x0c, 0x53, 0x74, 0x61, 0x74, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12,
+	0x67, 0x0a, 0x08, 0x41, 0x64, 0x64, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x2c, 0x2e, 0x70, 0x69,
+	0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67,
+	0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x64, 0x64, 0x53, 0x74, 0x61,
+	0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2d, 0x2e, 0x70, 0x69, 0x61, 0x6e,
+	0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73,
+	0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x64, 0x64, 0x53, 0x74, 0x61, 0x74, 0x73,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x60, 0x0a, 0x09, 0x4c, 0x69, 0x73, 0x74,
+	0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x2d, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f,
+	0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73,
+	0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72,
+	0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e,
+	0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x30, 0x01, 0x12, 0x6d, 0x0a, 0x0a, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x42, 0x79, 0x44, 0x61, 0x79, 0x12, 0x2e, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f,
+	0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74,
+	0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x44, 0x61,
+	0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2f, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f,
+	0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74,
+	0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x44, 0x61,
+	0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x7f, 0x0a, 0x10, 0x43, 0x6f, 0x75,
+	0x6e, 0x74, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x34, 0x2e,
+	0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69,
+	0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x75, 0x6e,
+	0x74, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x35, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64,
+	0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76,
+	0x31, 0x2e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69,
+	0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x88, 0x01, 0x0a, 0x13, 0x44,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x37, 0x2e, 0x70, 0x69, 0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74,
+	0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31,
+	0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e,
+	0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x38, 0x2e, 0x70, 0x69,
+	0x61, 0x6e, 0x6f, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67,
+	0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x42, 0x79, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x3a, 0x5a, 0x38, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e,
+	0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x61, 0x74, 0x68, 0x65, 0x6e, 0x72, 0x69, 0x2f, 0x70, 0x69, 0x61,
+	0x6e, 0x6f, 0x2d, 0x63, 0x68, 0x6f, 0x72, 0x64, 0x2d, 0x74, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e,
+	0x67, 0x2d, 0x62, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x73, 0x70,
+	0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_statspb_stats_proto_rawDescOnce sync.Once
+	file_statspb_stats_proto_rawDescData = file_statspb_stats_proto_rawDesc
+)
+
+func file_statspb_stats_proto_rawDescGZIP() []byte {
+	file_statspb_stats_proto_rawDescOnce.Do(func() {
+		file_statspb_stats_proto_rawDescData = protoimpl.X.CompressGZIP(file_statspb_stats_proto_rawDescData)
+	})
+	return file_statspb_stats_proto_rawDescData
+}
+
+var file_statspb_stats_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_statspb_stats_proto_goTypes = []interface{}{
+	(*Stats)(nil),                       // 0: pianochordtraining.stats.v1.Stats
+	(*AddStatsRequest)(nil),             // 1: pianochordtraining.stats.v1.AddStatsRequest
+	(*AddStatsResponse)(nil),            // 2: pianochordtraining.stats.v1.AddStatsResponse
+	(*ListStatsRequest)(nil),            // 3: pianochordtraining.stats.v1.ListStatsRequest
+	(*CountByDayRequest)(nil),           // 4: pianochordtraining.stats.v1.CountByDayRequest
+	(*DayCount)(nil),                    // 5: pianochordtraining.stats.v1.DayCount
+	(*CountByDayResponse)(nil),          // 6: pianochordtraining.stats.v1.CountByDayResponse
+	(*CountByExtensionRequest)(nil),     // 7: pianochordtraining.stats.v1.CountByExtensionRequest
+	(*ExtensionCount)(nil),              // 8: pianochordtraining.stats.v1.ExtensionCount
+	(*CountByExtensionResponse)(nil),    // 9: pianochordtraining.stats.v1.CountByExtensionResponse
+	(*DurationByExtensionRequest)(nil),  // 10: pianochordtraining.stats.v1.DurationByExtensionRequest
+	(*ExtensionDuration)(nil),           // 11: pianochordtraining.stats.v1.ExtensionDuration
+	(*DurationByExtensionResponse)(nil), // 12: pianochordtraining.stats.v1.DurationByExtensionResponse
+	(*timestamppb.Timestamp)(nil),       // 13: google.protobuf.Timestamp
+}
+var file_statspb_stats_proto_depIdxs = []int32{
+	13, // 0: pianochordtraining.stats.v1.Stats.created_at:type_name -> google.protobuf.Timestamp
+	13, // 1: pianochordtraining.stats.v1.Stats.updated_at:type_name -> google.protobuf.Timestamp
+	0,  // 2: pianochordtraining.stats.v1.AddStatsRequest.stats:type_name -> pianochordtraining.stats.v1.Stats
+	0,  // 3: pianochordtraining.stats.v1.AddStatsResponse.stats:type_name -> pianochordtraining.stats.v1.Stats
+	5,  // 4: pianochordtraining.stats.v1.CountByDayResponse.counts:type_name -> pianochordtraining.stats.v1.DayCount
+	8,  // 5: pianochordtraining.stats.v1.CountByExtensionResponse.counts:type_name -> pianochordtraining.stats.v1.ExtensionCount
+	11, // 6: pianochordtraining.stats.v1.DurationByExtensionResponse.durations:type_name -> pianochordtraining.stats.v1.ExtensionDuration
+	1,  // 7: pianochordtraining.stats.v1.StatsService.AddStats:input_type -> pianochordtraining.stats.v1.AddStatsRequest
+	3,  // 8: pianochordtraining.stats.v1.StatsService.ListStats:input_type -> pianochordtraining.stats.v1.ListStatsRequest
+	4,  // 9: pianochordtraining.stats.v1.StatsService.CountByDay:input_type -> pianochordtraining.stats.v1.CountByDayRequest
+	7,  // 10: pianochordtraining.stats.v1.StatsService.CountByExtension:input_type -> pianochordtraining.stats.v1.CountByExtensionRequest
+	10, // 11: pianochordtraining.stats.v1.StatsService.DurationByExtension:input_type -> pianochordtraining.stats.v1.DurationByExtensionRequest
+	2,  // 12: pianochordtraining.stats.v1.StatsService.AddStats:output_type -> pianochordtraining.stats.v1.AddStatsResponse
+	0,  // 13: pianochordtraining.stats.v1.StatsService.ListStats:output_type -> pianochordtraining.stats.v1.Stats
+	6,  // 14: pianochordtraining.stats.v1.StatsService.CountByDay:output_type -> pianochordtraining.stats.v1.CountByDayResponse
+	9,  // 15: pianochordtraining.stats.v1.StatsService.CountByExtension:output_type -> pianochordtraining.stats.v1.CountByExtensionResponse
+	12, // 16: pianochordtraining.stats.v1.StatsService.DurationByExtension:output_type -> pianochordtraining.stats.v1.DurationByExtensionResponse
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_statspb_stats_proto_init() }
+func file_statspb_stats_proto_init() {
+	if File_statspb_stats_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_statspb_stats_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Stats); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddStatsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddStatsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListStatsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CountByDayRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DayCount); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CountByDayResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CountByExtensionRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExtensionCount); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CountByExtensionResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DurationByExtensionRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExtensionDuration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_statspb_stats_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DurationByExtensionResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_statspb_stats_proto_msgTypes[0].OneofWrappers = []interface{}{}
+	file_statspb_stats_proto_msgTypes[1].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_statspb_stats_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_statspb_stats_proto_goTypes,
+		DependencyIndexes: file_statspb_stats_proto_depIdxs,
+		MessageInfos:      file_statspb_stats_proto_msgTypes,
+	}.Build()
+	File_statspb_stats_proto = out.File
+	file_statspb_stats_proto_rawDesc = nil
+	file_statspb_stats_proto_goTypes = nil
+	file_statspb_stats_proto_depIdxs = nil
+}
@@ -0,0 +1,279 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: statspb/stats.proto
+
+package statspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// StatsServiceClient is the client API for StatsService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StatsServiceClient interface {
+	AddStats(ctx context.Context, in *AddStatsRequest, opts ...grpc.CallOption) (*AddStatsResponse, error)
+	// ListStats streams every stored answer
+	ListStats(ctx context.Context, in *ListStatsRequest, opts ...grpc.CallOption) (StatsService_ListStatsClient, error)
+	CountByDay(ctx context.Context, in *CountByDayRequest, opts ...grpc.CallOption) (*CountByDayResponse, error)
+	CountByExtension(ctx context.Context, in *CountByExtensionRequest, opts ...grpc.CallOption) (*CountByExtensionResponse, error)
+	DurationByExtension(ctx context.Context, in *DurationByExtensionRequest, opts ...grpc.CallOption) (*DurationByExtensionResponse, error)
+}
+
+type statsServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStatsServiceClient(cc grpc.ClientConnInterface) StatsServiceClient {
+	return &statsServiceClient{cc}
+}
+
+func (c *statsServiceClient) AddStats(ctx context.Context, in *AddStatsRequest, opts ...grpc.CallOption) (*AddStatsResponse, error) {
+	out := new(AddStatsResponse)
+	err := c.cc.Invoke(ctx, "/pianochordtraining.stats.v1.StatsService/AddStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsServiceClient) ListStats(ctx context.Context, in *ListStatsRequest, opts ...grpc.CallOption) (StatsService_ListStatsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StatsService_ServiceDesc.Streams[0], "/pianochordtraining.stats.v1.StatsService/ListStats", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &statsServiceListStatsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StatsService_ListStatsClient interface {
+	Recv() (*Stats, error)
+	grpc.ClientStream
+}
+
+type statsServiceListStatsClient struct {
+	grpc.ClientStream
+}
+
+func (x *statsServiceListStatsClient) Recv() (*Stats, error) {
+	m := new(Stats)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *statsServiceClient) CountByDay(ctx context.Context, in *CountByDayRequest, opts ...grpc.CallOption) (*CountByDayResponse, error) {
+	out := new(CountByDayResponse)
+	err := c.cc.Invoke(ctx, "/pianochordtraining.stats.v1.StatsService/CountByDay", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsServiceClient) CountByExtension(ctx context.Context, in *CountByExtensionRequest, opts ...grpc.CallOption) (*CountByExtensionResponse, error) {
+	out := new(CountByExtensionResponse)
+	err := c.cc.Invoke(ctx, "/pianochordtraining.stats.v1.StatsService/CountByExtension", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *statsServiceClient) DurationByExtension(ctx context.Context, in *DurationByExtensionRequest, opts ...grpc.CallOption) (*DurationByExtensionResponse, error) {
+	out := new(DurationByExtensionResponse)
+	err := c.cc.Invoke(ctx, "/pianochordtraining.stats.v1.StatsService/DurationByExtension", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatsServiceServer is the server API for StatsService service.
+// All implementations must embed UnimplementedStatsServiceServer
+// for forward compatibility
+type StatsServiceServer interface {
+	AddStats(context.Context, *AddStatsRequest) (*AddStatsResponse, error)
+	// ListStats streams every stored answer
+	ListStats(*ListStatsRequest, StatsService_ListStatsServer) error
+	CountByDay(context.Context, *CountByDayRequest) (*CountByDayResponse, error)
+	CountByExtension(context.Context, *CountByExtensionRequest) (*CountByExtensionResponse, error)
+	DurationByExtension(context.Context, *DurationByExtensionRequest) (*DurationByExtensionResponse, error)
+	mustEmbedUnimplementedStatsServiceServer()
+}
+
+// UnimplementedStatsServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedStatsServiceServer struct {
+}
+
+func (UnimplementedStatsServiceServer) AddStats(context.Context, *AddStatsRequest) (*AddStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddStats not implemented")
+}
+func (UnimplementedStatsServiceServer) ListStats(*ListStatsRequest, StatsService_ListStatsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListStats not implemented")
+}
+func (UnimplementedStatsServiceServer) CountByDay(context.Context, *CountByDayRequest) (*CountByDayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountByDay not implemented")
+}
+func (UnimplementedStatsServiceServer) CountByExtension(context.Context, *CountByExtensionRequest) (*CountByExtensionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountByExtension not implemented")
+}
+func (UnimplementedStatsServiceServer) DurationByExtension(context.Context, *DurationByExtensionRequest) (*DurationByExtensionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DurationByExtension not implemented")
+}
+func (UnimplementedStatsServiceServer) mustEmbedUnimplementedStatsServiceServer() {}
+
+// UnsafeStatsServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StatsServiceServer will
+// result in compilation errors.
+type UnsafeStatsServiceServer interface {
+	mustEmbedUnimplementedStatsServiceServer()
+}
+
+func RegisterStatsServiceServer(s grpc.ServiceRegistrar, srv StatsServiceServer) {
+	s.RegisterService(&StatsService_ServiceDesc, srv)
+}
+
+func _StatsService_AddStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsServiceServer).AddStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pianochordtraining.stats.v1.StatsService/AddStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsServiceServer).AddStats(ctx, req.(*AddStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsService_ListStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StatsServiceServer).ListStats(m, &statsServiceListStatsServer{stream})
+}
+
+type StatsService_ListStatsServer interface {
+	Send(*Stats) error
+	grpc.ServerStream
+}
+
+type statsServiceListStatsServer struct {
+	grpc.ServerStream
+}
+
+func (x *statsServiceListStatsServer) Send(m *Stats) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StatsService_CountByDay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountByDayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsServiceServer).CountByDay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pianochordtraining.stats.v1.StatsService/CountByDay",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsServiceServer).CountByDay(ctx, req.(*CountByDayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsService_CountByExtension_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountByExtensionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsServiceServer).CountByExtension(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pianochordtraining.stats.v1.StatsService/CountByExtension",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsServiceServer).CountByExtension(ctx, req.(*CountByExtensionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StatsService_DurationByExtension_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DurationByExtensionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatsServiceServer).DurationByExtension(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pianochordtraining.stats.v1.StatsService/DurationByExtension",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatsServiceServer).DurationByExtension(ctx, req.(*DurationByExtensionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StatsService_ServiceDesc is the grpc.ServiceDesc for StatsService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StatsService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pianochordtraining.stats.v1.StatsService",
+	HandlerType: (*StatsServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddStats",
+			Handler:    _StatsService_AddStats_Handler,
+		},
+		{
+			MethodName: "CountByDay",
+			Handler:    _StatsService_CountByDay_Handler,
+		},
+		{
+			MethodName: "CountByExtension",
+			Handler:    _StatsService_CountByExtension_Handler,
+		},
+		{
+			MethodName: "DurationByExtension",
+			Handler:    _StatsService_DurationByExtension_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListStats",
+			Handler:       _StatsService_ListStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "statspb/stats.proto",
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPausedDaysPerYear is how many days a user may pause their streak for
+// in a calendar year, so a pause is a planned break and not a way of
+// keeping a streak without practicing
+const maxPausedDaysPerYear = 14
+
+// StreakPause is a planned break of a user. Its days neither end nor add to
+// the user's streak.
+type StreakPause struct {
+	ID primitive.ObjectID `json:"id" bson:"_id"`
+	// From and Until are the first and last day of the pause, in the user's
+	// timezone
+	From          string    `json:"from" bson:"from"`
+	Until         string    `json:"until" bson:"until"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	Tenant        string    `json:"-" bson:"tenant"`
+	UserID        string    `json:"-" bson:"user"`
+	SchemaVersion int       `json:"-" bson:"schema_version"`
+}
+
+// days returns the days of the pause, first to last
+func (p StreakPause) days() []string {
+	from, err := time.Parse(dayLayout, p.From)
+	if err != nil {
+		return nil
+	}
+	until, err := time.Parse(dayLayout, p.Until)
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for day := from; !day.After(until); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(dayLayout))
+	}
+	return days
+}
+
+// validate returns what is wrong with a new pause, nothing if it is valid.
+// Pauses are planned, so they can't start before today, and together with
+// the existing pauses they can't have more than maxPausedDaysPerYear days
+// in a year.
+func (p StreakPause) validate(existing []StreakPause, today string) []FieldError {
+	from, err := time.Parse(dayLayout, p.From)
+	if err != nil {
+		return []FieldError{{Field: "from", Message: "has to be a day like 2006-01-02"}}
+	}
+	until, err := time.Parse(dayLayout, p.Until)
+	if err != nil {
+		return []FieldError{{Field: "until", Message: "has to be a day like 2006-01-02"}}
+	}
+	if p.From < today {
+		return []FieldError{{Field: "from", Message: "can't be before today"}}
+	}
+	if until.Before(from) {
+		return []FieldError{{Field: "until", Message: "can't be before from"}}
+	}
+	if until.Sub(from) >= maxPausedDaysPerYear*24*time.Hour {
+		return []FieldError{{Field: "until", Message: fmt.Sprintf("can't be more than %d days after from", maxPausedDaysPerYear-1)}}
+	}
+
+	paused := make(map[string]int)
+	for _, pause := range existing {
+		if pause.From <= p.Until && p.From <= pause.Until {
+			return []FieldError{{Field: "from", Message: fmt.Sprintf("overlaps the pause from %s to %s", pause.From, pause.Until)}}
+		}
+		for _, day := range pause.days() {
+			paused[day[:4]]++
+		}
+	}
+	for _, day := range p.days() {
+		paused[day[:4]]++
+		if paused[day[:4]] > maxPausedDaysPerYear {
+			return []FieldError{{Field: "until", Message: fmt.Sprintf("would pause more than %d days in %s", maxPausedDaysPerYear, day[:4])}}
+		}
+	}
+	return nil
+}
+
+// pausedDays returns the days the user in ctx paused their streak on
+func pausedDays(ctx context.Context) (map[string]bool, error) {
+	pauses, err := repository.StreakPauses(ctx, userFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	paused := make(map[string]bool)
+	for _, pause := range pauses {
+		for _, day := range pause.days() {
+			paused[day] = true
+		}
+	}
+	return paused, nil
+}
+
+// keptBetween tells if every day after previous and before day is kept by
+// a pause or a freeze, so a streak carries on from previous to day
+func keptBetween(previous, day time.Time, kept map[string]bool) bool {
+	for between := previous.AddDate(0, 0, 1); between.Before(day); between = between.AddDate(0, 0, 1) {
+		if !kept[between.Format(dayLayout)] {
+			return false
+		}
+	}
+	return true
+}
+
+// pauseStreakHandler plans a break of the caller, see StreakPause
+func pauseStreakHandler(w http.ResponseWriter, r *http.Request) {
+	var pause StreakPause
+	err := decodeRequest(r, &pause)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	userID := userFromContext(r.Context())
+	loc, err := userLocation(r.Context(), userID, "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	existing, err := repository.StreakPauses(r.Context(), userID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if fieldErrors := pause.validate(existing, time.Now().In(loc).Format(dayLayout)); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	pause.ID = primitive.NewObjectID()
+	pause.CreatedAt = time.Now()
+	pause.Tenant, pause.UserID = tenantFromContext(r.Context()), userID
+	err = repository.SaveStreakPause(r.Context(), pause)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponseStatus(w, r, http.StatusCreated, pause)
+}
+
+// getStreakPausesHandler lists the caller's pauses, earliest first
+func getStreakPausesHandler(w http.ResponseWriter, r *http.Request) {
+	pauses, err := repository.StreakPauses(r.Context(), userFromContext(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, pauses)
+}
+
+// deleteStreakPauseHandler cancels a pause of the caller that hasn't
+// started yet. Started pauses stay, their days already kept the streak.
+func deleteStreakPauseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	userID := userFromContext(r.Context())
+	loc, err := userLocation(r.Context(), userID, "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	pauses, err := repository.StreakPauses(r.Context(), userID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for _, pause := range pauses {
+		if pause.ID == id && pause.From <= time.Now().In(loc).Format(dayLayout) {
+			writeProblem(w, problemInvalidRequest, "The pause has started already")
+			return
+		}
+	}
+
+	err = repository.DeleteStreakPause(r.Context(), userID, id)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreakPauseValidate(t *testing.T) {
+	const today = "2026-03-10"
+	tests := []struct {
+		name     string
+		pause    StreakPause
+		existing []StreakPause
+		// field is the field of the error wanted, message a part of its
+		// message, and neither is set for a valid pause
+		field, message string
+	}{
+		{name: "single day today", pause: StreakPause{From: today, Until: today}},
+		{name: "two weeks", pause: StreakPause{From: "2026-03-11", Until: "2026-03-24"}},
+		{name: "not a day", pause: StreakPause{From: "10.3.2026", Until: today}, field: "from", message: "has to be a day"},
+		{name: "before today", pause: StreakPause{From: "2026-03-09", Until: today}, field: "from", message: "can't be before today"},
+		{name: "until before from", pause: StreakPause{From: "2026-03-12", Until: "2026-03-11"}, field: "until", message: "can't be before from"},
+		{name: "longer than two weeks", pause: StreakPause{From: "2026-03-11", Until: "2026-03-25"}, field: "until", message: "more than 13 days after from"},
+		{
+			name:     "overlapping the start of another",
+			pause:    StreakPause{From: "2026-03-12", Until: "2026-03-15"},
+			existing: []StreakPause{{From: "2026-03-15", Until: "2026-03-16"}},
+			field:    "from",
+			message:  "overlaps the pause from 2026-03-15 to 2026-03-16",
+		},
+		{
+			name:     "inside another",
+			pause:    StreakPause{From: "2026-03-13", Until: "2026-03-13"},
+			existing: []StreakPause{{From: "2026-03-12", Until: "2026-03-14"}},
+			field:    "from",
+			message:  "overlaps",
+		},
+		{
+			name:     "right after another",
+			pause:    StreakPause{From: "2026-03-15", Until: "2026-03-16"},
+			existing: []StreakPause{{From: "2026-03-12", Until: "2026-03-14"}},
+		},
+		{
+			name:     "up to the yearly limit",
+			pause:    StreakPause{From: "2026-04-01", Until: "2026-04-04"},
+			existing: []StreakPause{{From: "2026-01-05", Until: "2026-01-14"}},
+		},
+		{
+			name:     "over the yearly limit",
+			pause:    StreakPause{From: "2026-04-01", Until: "2026-04-05"},
+			existing: []StreakPause{{From: "2026-01-05", Until: "2026-01-14"}},
+			field:    "until",
+			message:  "would pause more than 14 days in 2026",
+		},
+		{
+			name:     "over the limit of the next year only",
+			pause:    StreakPause{From: "2026-12-25", Until: "2027-01-03"},
+			existing: []StreakPause{{From: "2027-02-01", Until: "2027-02-12"}},
+			field:    "until",
+			message:  "in 2027",
+		},
+		{
+			name:     "limit counted per year",
+			pause:    StreakPause{From: "2026-03-11", Until: "2026-03-20"},
+			existing: []StreakPause{{From: "2025-12-20", Until: "2025-12-31"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fieldErrors := test.pause.validate(test.existing, today)
+			if test.field == "" {
+				if len(fieldErrors) > 0 {
+					t.Errorf("validate() = %v, want no errors", fieldErrors)
+				}
+				return
+			}
+			if len(fieldErrors) != 1 {
+				t.Fatalf("validate() = %v, want one error of %s", fieldErrors, test.field)
+			}
+			if fieldErrors[0].Field != test.field || !strings.Contains(fieldErrors[0].Message, test.message) {
+				t.Errorf("validate() = %s %q, want %s with %q", fieldErrors[0].Field, fieldErrors[0].Message, test.field, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// statsStream publishes plain inserts to NATS for the worker to save, nil
+// when disabled
+var statsStream *jetStream
+
+const (
+	// streamAckWait is how long the worker has to save a message before
+	// JetStream delivers it again
+	streamAckWait = 30 * time.Second
+	// streamFetchWait is how long a pull of the worker waits for messages
+	streamFetchWait = 5 * time.Second
+	// streamDurable is the consumer the workers share, so each message is
+	// saved by only one of them
+	streamDurable = "worker"
+)
+
+// streamOptions configure the stream stats are published to, shared by the
+// server and the worker command
+type streamOptions struct {
+	NatsUrl    string `long:"nats-url" env:"NATS_URL" description:"URL of a NATS server with JetStream as nats://[user:password@]host:4222, POST /stats then publishes plain inserts for the worker command to save, disabled if empty"`
+	NatsStream string `long:"nats-stream" env:"NATS_STREAM" default:"STATS" description:"JetStream stream the stats are published to, created if missing"`
+}
+
+// streamedStats is a message of the stream
+type streamedStats struct {
+	Tenant string `json:"tenant"`
+	// User is missing from messages published by older versions
+	User  string   `json:"user,omitempty"`
+	Stats StatsRaw `json:"stats"`
+}
+
+// jetStream publishes to and pulls from a JetStream stream with a single
+// subject, reconnecting to NATS when the connection broke
+type jetStream struct {
+	url, name, stream, subject string
+
+	mu   sync.Mutex
+	conn *natsConn
+	// consumerCreated tells if the consumer of the workers is known to exist
+	consumerCreated bool
+}
+
+// jetStreamError is the error of a JetStream API response
+type jetStreamError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+func (e *jetStreamError) Error() string {
+	return fmt.Sprintf("JetStream answered %d: %s", e.Code, e.Description)
+}
+
+// jetStreamStreamNotFound is the err_code of a stream that doesn't exist
+const jetStreamStreamNotFound = 10059
+
+// newJetStream connects to the stream named stream, creating it if missing.
+// The stream is a work queue, so messages are deleted once acked.
+func newJetStream(ctx context.Context, natsURL, name, stream string) (*jetStream, error) {
+	s := &jetStream{url: natsURL, name: name, stream: stream, subject: "pct." + strings.ToLower(stream)}
+	var info struct {
+		Error *jetStreamError `json:"error"`
+	}
+	err := s.api(ctx, "STREAM.INFO."+stream, nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Error == nil {
+		return s, nil
+	}
+	if info.Error.ErrCode != jetStreamStreamNotFound {
+		return nil, info.Error
+	}
+
+	var created struct {
+		Error *jetStreamError `json:"error"`
+	}
+	err = s.api(ctx, "STREAM.CREATE."+stream, map[string]interface{}{
+		"name":      stream,
+		"subjects":  []string{s.subject},
+		"retention": "workqueue",
+		"storage":   "file",
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+	if created.Error != nil {
+		return nil, created.Error
+	}
+	log.Printf("Created JetStream stream %s\n", stream)
+	return s, nil
+}
+
+// connection returns the connection to NATS, dialing again if it broke
+func (s *jetStream) connection(ctx context.Context) (*natsConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil && s.conn.Err() == nil {
+		return s.conn, nil
+	}
+	conn, err := dialNATS(ctx, s.url, s.name)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// api calls the JetStream API, decoding the response into v
+func (s *jetStream) api(ctx context.Context, subject string, request, v interface{}) error {
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if request != nil {
+		data, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	replies, err := conn.Request(ctx, "$JS.API."+subject, data, 1)
+	if err != nil {
+		return err
+	}
+	if len(replies) == 0 {
+		return errors.New("JetStream isn't enabled on the NATS server")
+	}
+	return json.Unmarshal(replies[0].Data, v)
+}
+
+// Publish publishes stats on behalf of the tenant and user of ctx and returns once
+// JetStream stored them. The stats get their id here, so it can be returned
+// before they are saved, and saving them twice inserts them once.
+func (s *jetStream) Publish(ctx context.Context, stats StatsRaw) (StatsRaw, error) {
+	tenant, err := writeTenant(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	stats.ID = primitive.NewObjectID()
+	stats.Version = 1
+	data, err := json.Marshal(streamedStats{Tenant: tenant, User: userFromContext(ctx), Stats: stats})
+	if err != nil {
+		return StatsRaw{}, err
+	}
+
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	replies, err := conn.Request(ctx, s.subject, data, 1)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	if len(replies) == 0 {
+		return StatsRaw{}, fmt.Errorf("no JetStream stream listens on %s", s.subject)
+	}
+	var ack struct {
+		Error *jetStreamError `json:"error"`
+	}
+	err = json.Unmarshal(replies[0].Data, &ack)
+	if err != nil {
+		return StatsRaw{}, err
+	}
+	if ack.Error != nil {
+		return StatsRaw{}, ack.Error
+	}
+	return stats, nil
+}
+
+// Fetch pulls up to max messages for the durable consumer of the workers,
+// creating it if missing, and waits up to streamFetchWait for the first
+func (s *jetStream) Fetch(ctx context.Context, max int) ([]natsMsg, error) {
+	if !s.consumerCreated {
+		var consumer struct {
+			Error *jetStreamError `json:"error"`
+		}
+		err := s.api(ctx, "CONSUMER.DURABLE.CREATE."+s.stream+"."+streamDurable, map[string]interface{}{
+			"stream_name": s.stream,
+			"config": map[string]interface{}{
+				"durable_name":   streamDurable,
+				"ack_policy":     "explicit",
+				"ack_wait":       streamAckWait.Nanoseconds(),
+				"deliver_policy": "all",
+			},
+		}, &consumer)
+		if err != nil {
+			return nil, err
+		}
+		if consumer.Error != nil {
+			return nil, consumer.Error
+		}
+		s.consumerCreated = true
+	}
+
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	request, _ := json.Marshal(map[string]interface{}{"batch": max, "expires": streamFetchWait.Nanoseconds()})
+	ctx, cancel := context.WithTimeout(ctx, streamFetchWait+time.Second)
+	defer cancel()
+	msgs, err := conn.Request(ctx, "$JS.API.CONSUMER.MSG.NEXT."+s.stream+"."+streamDurable, request, max)
+	if err == context.DeadlineExceeded {
+		return nil, nil
+	}
+	return msgs, err
+}
+
+// Ack tells JetStream msg is done with, ack being +ACK once it is saved or
+// +TERM if it never can be
+func (s *jetStream) Ack(ctx context.Context, msg natsMsg, ack string) error {
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Publish(msg.Reply, "", []byte(ack))
+}
+
+// runWorker is the worker command, saving the stats published to the
+// stream. Any number of workers can run side by side.
+func runWorker(args []string) {
+	var options struct {
+		MongoUrl  string `short:"u" env:"MONGODB_URL" description:"URL to mongo" required:"true"`
+		RedisUrl  string `long:"redis-url" env:"REDIS_URL" description:"URL to the redis of the API, to invalidate its cache and wake its polls as stats are saved"`
+		BatchSize int    `long:"batch-size" env:"WORKER_BATCH_SIZE" default:"500" description:"How many stats are pulled and inserted at most at a time"`
+		streamOptions
+	}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Usage = "worker [OPTIONS]"
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		log.Fatalln("Error parsing input:", err)
+	}
+	if options.NatsUrl == "" || options.BatchSize < 1 {
+		log.Fatalln("Error parsing input: the NATS URL and a batch size are required")
+	}
+
+	ctx := context.Background()
+	client := connectToMongo(options.MongoUrl, mongoSettings{
+		MaxPoolSize:            20,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 5 * time.Second,
+	})
+	defer client.Disconnect(ctx)
+	repository = newMongoRepository(client, readpref.Primary(), readpref.Primary())
+	if options.RedisUrl != "" {
+		redisClient = connectToRedis(options.RedisUrl)
+		defer redisClient.Close()
+		// the worker only invalidates, it never caches
+		aggregateCache = newRedisCache(redisClient, 0)
+	} else {
+		log.Println("Without a Redis URL the API isn't told about saved stats, its cache is stale until it expires")
+	}
+
+	stream, err := newJetStream(ctx, options.NatsUrl, "piano-chord-training-worker", options.NatsStream)
+	if err != nil {
+		log.Fatalln("Failed to connect to NATS! Error:", err)
+	}
+	log.Printf("Saving the stats of stream %s\n", options.NatsStream)
+	for {
+		msgs, err := stream.Fetch(ctx, options.BatchSize)
+		if err != nil {
+			log.Println("Failed to pull stats! Error:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		saveStreamedStats(ctx, stream, msgs)
+	}
+}
+
+// saveStreamedStats inserts the stats of msgs per tenant and user and acks
+// them. Stats that fail to insert aren't acked, so they are delivered again.
+func saveStreamedStats(ctx context.Context, stream *jetStream, msgs []natsMsg) {
+	byCaller := make(map[statsCaller][]StatsRaw)
+	acks := make(map[statsCaller][]natsMsg)
+	for _, msg := range msgs {
+		var streamed streamedStats
+		err := json.Unmarshal(msg.Data, &streamed)
+		if err != nil || streamed.Tenant == "" || streamed.Stats.ID.IsZero() {
+			statsDroppedTotal.Inc()
+			log.Printf("Dropped malformed stats %q\n", msg.Data)
+			stream.Ack(ctx, msg, "+TERM")
+			continue
+		}
+		caller := statsCaller{tenant: streamed.Tenant, user: streamed.User}
+		byCaller[caller] = append(byCaller[caller], streamed.Stats)
+		acks[caller] = append(acks[caller], msg)
+	}
+
+	for caller, stats := range byCaller {
+		callerCtx := withTenant(withUser(ctx, caller.user), caller.tenant)
+		err := repository.InsertStats(callerCtx, stats)
+		if err != nil {
+			log.Printf("Failed to save %d stats of tenant %s, they are delivered again! Error: %s\n", len(stats), caller.tenant, err)
+			continue
+		}
+		recordStatsSaved(callerCtx, stats...)
+		if aggregateCache != nil {
+			aggregateCache.Invalidate()
+		}
+		for _, msg := range acks[caller] {
+			err = stream.Ack(ctx, msg, "+ACK")
+			if err != nil {
+				log.Println("Failed to ack saved stats, they are saved again! Error:", err)
+			}
+		}
+	}
+}
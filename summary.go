@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// summaryOptions configure who writes the weekly summaries
+type summaryOptions struct {
+	SummaryLLMURL    string `long:"summary-llm-url" env:"SUMMARY_LLM_URL" description:"OpenAI compatible chat completions endpoint weekly summaries are written by, like https://api.openai.com/v1/chat/completions, summaries are written from templates if empty"`
+	SummaryLLMAPIKey string `long:"summary-llm-api-key" env:"SUMMARY_LLM_API_KEY" description:"API key sent as bearer token to the summary LLM"`
+	SummaryLLMModel  string `long:"summary-llm-model" env:"SUMMARY_LLM_MODEL" description:"Model the summary LLM writes with, required with a summary LLM URL"`
+}
+
+// summaryNarrator writes the weekly summaries, from templates unless an LLM
+// is configured
+var summaryNarrator narrator = templateNarrator{}
+
+// narrator turns the numbers of a week into sentences for people
+type narrator interface {
+	Narrate(ctx context.Context, week WeekSummary) ([]string, error)
+}
+
+// WeekSummary are the numbers of the caller's last 7 days, today included,
+// compared to the 7 days before
+type WeekSummary struct {
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	Answers       int    `json:"answers"`
+	DaysPracticed int    `json:"days_practiced"`
+	// Accuracy is the part of the answers that were right, from 0 to 1
+	Accuracy           float64 `json:"accuracy"`
+	AvgDurationSeconds float64 `json:"avg_duration_seconds"`
+	// MostPracticed is the chord extension answered most often
+	MostPracticed string           `json:"most_practiced,omitempty"`
+	BestDay       *StatsCountByDay `json:"best_day,omitempty"`
+	Streak        int              `json:"streak"`
+	PreviousWeek  PreviousWeek     `json:"previous_week"`
+	Insights      []Insight        `json:"insights"`
+}
+
+// PreviousWeek are the numbers of the 7 days before a week
+type PreviousWeek struct {
+	Answers            int     `json:"answers"`
+	AvgDurationSeconds float64 `json:"avg_duration_seconds"`
+}
+
+// Narrative is a week told in sentences
+type Narrative struct {
+	Week      WeekSummary `json:"week"`
+	Sentences []string    `json:"sentences"`
+}
+
+// weekSummary sums up the last 7 days in loc of the user in ctx, on the
+// instrument of ctx
+func weekSummary(ctx context.Context, loc *time.Location) (WeekSummary, error) {
+	counts, err := countByDayLastMonth(ctx, loc)
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	kept, err := keptDays(ctx)
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	week, previous := counts[len(counts)-7:], counts[len(counts)-14:len(counts)-7]
+	summary := WeekSummary{Start: week[0].Day, End: week[len(week)-1].Day, Streak: currentStreak(counts, kept), Insights: []Insight{}}
+	for _, day := range week {
+		if day.Count > 0 {
+			summary.DaysPracticed++
+		}
+		if day.Count > 0 && (summary.BestDay == nil || day.Count > summary.BestDay.Count) {
+			best := day
+			summary.BestDay = &best
+		}
+	}
+
+	weekStart, err := time.ParseInLocation("2006-01-02", summary.Start, loc)
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	previousStart, err := time.ParseInLocation("2006-01-02", previous[0].Day, loc)
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	correct, durationMilli, previousDurationMilli := 0, 0, 0
+	byExtension := make(map[string]int)
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	err = repository.EachStats(ctx, StatsFilter{Since: previousStart, Instrument: instrumentFromContext(ctx)}, func(stats StatsRaw) error {
+		if stats.CreatedAt.Before(weekStart) {
+			summary.PreviousWeek.Answers++
+			previousDurationMilli += stats.AnswerDurationMilliSeconds
+			return nil
+		}
+		summary.Answers++
+		if stats.Correct == nil || *stats.Correct {
+			correct++
+		}
+		durationMilli += stats.AnswerDurationMilliSeconds
+		byExtension[aliases.extension(stats.ChordExtension)]++
+		return nil
+	})
+	if err != nil {
+		return WeekSummary{}, err
+	}
+	if summary.Answers > 0 {
+		summary.Accuracy = float64(correct) / float64(summary.Answers)
+		summary.AvgDurationSeconds = float64(durationMilli) / float64(summary.Answers) / 1000
+	}
+	if summary.PreviousWeek.Answers > 0 {
+		summary.PreviousWeek.AvgDurationSeconds = float64(previousDurationMilli) / float64(summary.PreviousWeek.Answers) / 1000
+	}
+	for extension, count := range byExtension {
+		if count > byExtension[summary.MostPracticed] || count == byExtension[summary.MostPracticed] && extension < summary.MostPracticed {
+			summary.MostPracticed = extension
+		}
+	}
+
+	report, err := repository.Insights(ctx)
+	if err != nil && err != errNotFound {
+		return WeekSummary{}, err
+	}
+	if report.Insights != nil {
+		summary.Insights = report.Insights
+	}
+	return summary, nil
+}
+
+// templateNarrator writes summaries from fixed sentences
+type templateNarrator struct{}
+
+func (templateNarrator) Narrate(ctx context.Context, week WeekSummary) ([]string, error) {
+	if week.Answers == 0 {
+		if week.PreviousWeek.Answers > 0 {
+			return []string{fmt.Sprintf("You didn't practice this week, after %d chords the week before. A few minutes today gets you going again.", week.PreviousWeek.Answers)}, nil
+		}
+		return []string{"You didn't practice this week. A few minutes today gets you going!"}, nil
+	}
+
+	sentences := []string{fmt.Sprintf("You answered %d chords on %d of the last 7 days%s.", week.Answers, week.DaysPracticed, comparedToPreviousWeek(week))}
+	if week.BestDay != nil && week.DaysPracticed > 1 {
+		day, err := time.Parse("2006-01-02", week.BestDay.Day)
+		if err == nil {
+			sentences = append(sentences, fmt.Sprintf("%s was your busiest day with %d chords.", day.Weekday(), week.BestDay.Count))
+		}
+	}
+	speed := fmt.Sprintf("You got %.0f%% of them right, taking %.1f seconds per chord", week.Accuracy*100, week.AvgDurationSeconds)
+	if change := week.AvgDurationSeconds - week.PreviousWeek.AvgDurationSeconds; week.PreviousWeek.Answers > 0 && change <= -0.05 {
+		speed += fmt.Sprintf(", %.1f seconds faster than the week before", -change)
+	} else if week.PreviousWeek.Answers > 0 && change >= 0.05 {
+		speed += fmt.Sprintf(", %.1f seconds slower than the week before", change)
+	}
+	sentences = append(sentences, speed+".")
+	if week.MostPracticed != "" {
+		sentences = append(sentences, fmt.Sprintf("You practiced %s chords the most.", extensionName(week.MostPracticed)))
+	}
+	for _, insight := range week.Insights {
+		sentences = append(sentences, insight.Message+".")
+	}
+	if week.Streak > 1 {
+		sentences = append(sentences, fmt.Sprintf("You're on a %d-day streak, keep it going!", week.Streak))
+	}
+	return sentences, nil
+}
+
+// comparedToPreviousWeek tells how the answers of week compare to the week
+// before, empty if there were none before
+func comparedToPreviousWeek(week WeekSummary) string {
+	previous := week.PreviousWeek.Answers
+	switch {
+	case previous == 0:
+		return ""
+	case week.Answers > previous:
+		return fmt.Sprintf(", %.0f%% more than the week before", float64(week.Answers-previous)/float64(previous)*100)
+	case week.Answers < previous:
+		return fmt.Sprintf(", %.0f%% fewer than the week before", float64(previous-week.Answers)/float64(previous)*100)
+	}
+	return ", as many as the week before"
+}
+
+// llmPrompt tells the LLM how to write a summary
+const llmPrompt = `You write the weekly summary of a piano chord training app for its user. You get the numbers of the user's week as JSON, accuracy being from 0 to 1. Write 2 to 5 short, friendly and encouraging sentences in the second person about them, each on a line of its own, without headings, lists or numbers that aren't in the JSON.`
+
+// llmNarrator has an LLM behind an OpenAI compatible chat completions API
+// write the summaries
+type llmNarrator struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func newLLMNarrator(options summaryOptions) (*llmNarrator, error) {
+	if options.SummaryLLMModel == "" {
+		return nil, errors.New("the summary LLM needs a model")
+	}
+	return &llmNarrator{
+		url:    options.SummaryLLMURL,
+		apiKey: options.SummaryLLMAPIKey,
+		model:  options.SummaryLLMModel,
+		client: &http.Client{Timeout: 20 * time.Second},
+	}, nil
+}
+
+func (n *llmNarrator) Narrate(ctx context.Context, week WeekSummary) ([]string, error) {
+	numbers, err := json.Marshal(week)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"model": n.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": llmPrompt},
+			{"role": "user", "content": string(numbers)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+n.apiKey)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("summary LLM answered %s", resp.Status)
+	}
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&completion)
+	if err != nil {
+		return nil, err
+	}
+
+	var sentences []string
+	if len(completion.Choices) > 0 {
+		for _, line := range strings.Split(completion.Choices[0].Message.Content, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				sentences = append(sentences, line)
+			}
+		}
+	}
+	if len(sentences) == 0 {
+		return nil, errors.New("summary LLM wrote nothing")
+	}
+	return sentences, nil
+}
+
+// getInsightsSummaryHandler tells the caller's week in sentences, counting
+// days in the timezone query param if given, otherwise the user's timezone.
+// Summaries the LLM fails to write are written from templates instead.
+func getInsightsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	loc, err := userLocation(
+		r.Context(),
+		userFromContext(r.Context()),
+		r.URL.Query().Get("timezone"),
+	)
+	if err == errInvalidTimezone {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "timezone", Message: "isn't an IANA timezone"})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	week, err := weekSummary(r.Context(), loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	sentences, err := summaryNarrator.Narrate(r.Context(), week)
+	if err != nil {
+		log.Println("Failed to narrate the week, falling back to templates! Error:", err)
+		sentences, _ = templateNarrator{}.Narrate(r.Context(), week)
+	}
+
+	writeResponse(w, r, Narrative{Week: week, Sentences: sentences})
+}
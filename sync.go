@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+	// syncSeqOverlap is how long a reserved sync sequence number holds back
+	// the delta sync at most, reservations older than that are taken as
+	// abandoned by a crashed or failed write
+	syncSeqOverlap = time.Minute
+	// syncSeqReleaseTimeout bounds releasing a sync sequence number once its
+	// write is done
+	syncSeqReleaseTimeout = 5 * time.Second
+)
+
+// Tombstone records a deleted stat, so that offline clients learn about the
+// deletion on their next sync
+type Tombstone struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	DeletedAt time.Time          `json:"deleted_at" bson:"deleted_at"`
+	SyncSeq   int64              `json:"-" bson:"sync_seq"`
+	Tenant    string             `json:"-" bson:"tenant"`
+}
+
+// SyncChanges is a page of the delta sync. NextCursor is passed as since to
+// get the following page, and stays the same when there was nothing new.
+type SyncChanges struct {
+	Stats      []StatsRaw  `json:"stats"`
+	Deleted    []Tombstone `json:"deleted"`
+	NextCursor string      `json:"next_cursor"`
+	HasMore    bool        `json:"has_more"`
+}
+
+// releaseSyncSeq releases a sync sequence number of repo once its write is
+// done, even if the request of the write isn't around anymore. Failures are
+// only logged, the number stops holding back the sync after syncSeqOverlap
+// anyway.
+func releaseSyncSeq(repo Repository, seq int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), syncSeqReleaseTimeout)
+	defer cancel()
+	err := repo.ReleaseSyncSeq(ctx, seq)
+	if err != nil {
+		log.Println("Failed to release a sync sequence number! Error:", err)
+	}
+}
+
+// syncChanges merges the changed stats and tombstones after since into a
+// single page of at most limit changes, in sequence order
+func syncChanges(ctx context.Context, since int64, limit int) (SyncChanges, error) {
+	// one extra of each tells whether there is another page
+	stats, tombstones, err := repository.Changes(ctx, since, limit+1)
+	if err != nil {
+		return SyncChanges{}, err
+	}
+
+	changes := SyncChanges{
+		Stats:   []StatsRaw{},
+		Deleted: []Tombstone{},
+	}
+	cursor := since
+	for len(changes.Stats)+len(changes.Deleted) < limit {
+		if len(stats) > 0 && (len(tombstones) == 0 || stats[0].SyncSeq < tombstones[0].SyncSeq) {
+			cursor = stats[0].SyncSeq
+			changes.Stats = append(changes.Stats, stats[0])
+			stats = stats[1:]
+		} else if len(tombstones) > 0 {
+			cursor = tombstones[0].SyncSeq
+			changes.Deleted = append(changes.Deleted, tombstones[0])
+			tombstones = tombstones[1:]
+		} else {
+			break
+		}
+	}
+	changes.NextCursor = strconv.FormatInt(cursor, 10)
+	changes.HasMore = len(stats)+len(tombstones) > 0
+	return changes, nil
+}
+
+// getSyncChangesHandler lets offline clients sync incrementally. Without a
+// since cursor every stored stat is returned, page by page.
+func getSyncChangesHandler(w http.ResponseWriter, r *http.Request) {
+	since, valid := parseSyncCursor(r)
+	if !valid {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "since", Message: "has to be a cursor returned by an earlier sync"})
+		return
+	}
+
+	limit := defaultSyncLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			writeProblem(w, problemInvalidRequest, "", FieldError{Field: "limit", Message: "has to be a positive whole number"})
+			return
+		}
+		if limit > maxSyncLimit {
+			limit = maxSyncLimit
+		}
+	}
+
+	changes, err := syncChanges(r.Context(), since, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, changes)
+}
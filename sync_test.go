@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSyncChangesInterleaved has a correction take its sequence number
+// before an answer saved after it, but commit after the answer. A client
+// syncing in between must still get the correction.
+func TestSyncChangesInterleaved(t *testing.T) {
+	defer func(saved Repository) { repository = saved }(repository)
+	repository = newMemoryRepository(nil)
+	ctx := withTenant(withUser(context.Background(), "u"), defaultTenant)
+
+	first, err := repository.SaveStats(ctx, StatsRaw{ChordName: "C", RootNote: "C", CreatedAt: time.Now()}, anyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := syncChanges(ctx, 0, defaultSyncLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Stats) != 1 {
+		t.Fatalf("first sync = %d stats, want 1", len(changes.Stats))
+	}
+	cursor := first.SyncSeq
+
+	// the slower writer takes its number first
+	seq, err := repository.ReserveSyncSeq(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repository.SaveStats(ctx, StatsRaw{ChordName: "D", RootNote: "D", CreatedAt: time.Now()}, anyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SyncSeq <= seq {
+		t.Fatalf("faster writer got %d, want more than %d", second.SyncSeq, seq)
+	}
+
+	changes, err = syncChanges(ctx, cursor, defaultSyncLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Stats) != 0 || changes.NextCursor != "1" {
+		t.Fatalf("sync with a write in flight = %d stats up to %s, want none up to 1", len(changes.Stats), changes.NextCursor)
+	}
+	latest, err := repository.LatestSyncSeq(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != cursor {
+		t.Errorf("LatestSyncSeq() with a write in flight = %d, want %d", latest, cursor)
+	}
+
+	first.ChordName = "Cm"
+	_, err = repository.CorrectStats(ctx, first, first.Version, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repository.ReleaseSyncSeq(ctx, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = syncChanges(ctx, cursor, defaultSyncLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Stats) != 2 || changes.Stats[0].ChordName != "Cm" || changes.Stats[1].ID != second.ID {
+		t.Fatalf("sync after both writes = %+v, want the correction and then the answer", changes.Stats)
+	}
+}
+
+func TestSyncWatermark(t *testing.T) {
+	tests := []struct {
+		name    string
+		seq     int64
+		pending map[int64]time.Duration
+		want    int64
+	}{
+		{name: "nothing in flight", seq: 5, want: 5},
+		{name: "latest in flight", seq: 5, pending: map[int64]time.Duration{5: 0}, want: 4},
+		{name: "earliest in flight", seq: 5, pending: map[int64]time.Duration{2: 0, 4: 0}, want: 1},
+		{name: "abandoned", seq: 5, pending: map[int64]time.Duration{2: syncSeqOverlap, 4: 0}, want: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMemoryRepository(nil)
+			m.syncSeq = test.seq
+			for seq, age := range test.pending {
+				m.syncPending[seq] = time.Now().Add(-age)
+			}
+			if got := m.syncWatermark(); got != test.want {
+				t.Errorf("syncWatermark() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	minBPM = 20
+	maxBPM = 400
+)
+
+// TempoOutcome sums up the answers given against a metronome at a tempo
+type TempoOutcome struct {
+	BPM     int `json:"bpm" bson:"_id"`
+	Answers int `json:"answers" bson:"answers"`
+	Correct int `json:"correct" bson:"correct"`
+	// Accuracy is 0 until there are answers
+	Accuracy          float64 `json:"accuracy" bson:"-"`
+	AvgBeatsToAnswer  float64 `json:"avg_beats_to_answer" bson:"avg_beats_to_answer"`
+	AvgDurationMillis float64 `json:"avg_duration_millis" bson:"avg_duration_millis"`
+}
+
+// validateTempo returns what is wrong with the tempo of an answer, nothing
+// if it is valid or the answer wasn't given against a metronome
+func validateTempo(stats StatsRaw) []FieldError {
+	var fieldErrors []FieldError
+	if stats.BPM != 0 && (stats.BPM < minBPM || stats.BPM > maxBPM) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "bpm", Message: fmt.Sprintf("has to be from %d to %d", minBPM, maxBPM)})
+	}
+	if stats.BeatsToAnswer < 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "beats_to_answer", Message: "can't be negative"})
+	} else if stats.BeatsToAnswer > 0 && stats.BPM == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "beats_to_answer", Message: "needs bpm"})
+	}
+	return fieldErrors
+}
+
+// withBeats fills in the beats an answer given against a metronome took from
+// its duration, if the client didn't count them
+func withBeats(stats StatsRaw) StatsRaw {
+	if stats.BPM > 0 && stats.BeatsToAnswer == 0 {
+		stats.BeatsToAnswer = float64(stats.AnswerDurationMilliSeconds) * float64(stats.BPM) / 60000
+	}
+	return stats
+}
+
+// getOutcomesByTempoHandler sums up the answers given against a metronome by
+// tempo, slowest first
+func getOutcomesByTempoHandler(w http.ResponseWriter, r *http.Request) {
+	outcomes, err := repository.OutcomesByTempo(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	for i, outcome := range outcomes {
+		if outcome.Answers > 0 {
+			outcomes[i].Accuracy = float64(outcome.Correct) / float64(outcome.Answers)
+		}
+	}
+	writeResponse(w, r, outcomes)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const tenantContextKey contextKey = "tenant"
+
+// defaultTenant owns the data written with the main auth token, including
+// everything stored before tenants existed
+const defaultTenant = "default"
+
+// allTenants lets admin requests read across tenants. Any other request
+// only ever sees the data of its own tenant.
+const allTenants = "*"
+
+// tenantQuotas caps the number of stats a tenant can store, overriding
+// defaultQuota
+var tenantQuotas map[string]int
+
+// defaultQuota caps the number of stats of tenants without a quota of their
+// own, 0 meaning unlimited. Signed-in users are tenants of their own, so it
+// caps every one of them separately, while the users of a tenant token share
+// its quota.
+var defaultQuota int
+
+// quotasMu guards the quotas, which are replaced when the config is reloaded
+var quotasMu sync.RWMutex
+
+var errNoTenant = errors.New("no tenant to write for")
+
+var errQuotaExceeded = errors.New("stats quota exceeded")
+
+// quotaCountTTL is how long a counted number of stats is trusted to let
+// writes through. The stats saved on this replica meanwhile are added to it,
+// so only the other replicas can go past a quota, by what they save in that
+// time.
+const quotaCountTTL = 10 * time.Second
+
+// maxQuotaCounts is how many tenants' counts are kept before the outdated
+// ones are dropped
+const maxQuotaCounts = 10000
+
+// quotaCounts are the stats counts of the tenants with quotas, so that not
+// every write has to count them in the database
+var quotaCounts = struct {
+	sync.Mutex
+	counts map[string]quotaCount
+}{counts: make(map[string]quotaCount)}
+
+type quotaCount struct {
+	count     int
+	countedAt time.Time
+}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// tenantFromContext returns the tenant of the authorized caller, empty for
+// an unauthorized one
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey).(string)
+	return tenant
+}
+
+// writeTenant returns the tenant that a write in ctx belongs to
+func writeTenant(ctx context.Context) (string, error) {
+	tenant := tenantFromContext(ctx)
+	if tenant == "" || tenant == allTenants {
+		return "", errNoTenant
+	}
+	return tenant, nil
+}
+
+// tenantQuery limits query to the tenant of ctx. A missing tenant matches
+// nothing, so forgetting to authorize never leaks data.
+func tenantQuery(ctx context.Context, query bson.M) bson.M {
+	tenant := tenantFromContext(ctx)
+	if tenant == allTenants {
+		return query
+	}
+
+	scoped := bson.M{"tenant": tenant}
+	for key, value := range query {
+		scoped[key] = value
+	}
+	return scoped
+}
+
+// tenantMatches is the in-memory counterpart of tenantQuery
+func tenantMatches(ctx context.Context, tenant string) bool {
+	ctxTenant := tenantFromContext(ctx)
+	return ctxTenant == allTenants || (ctxTenant != "" && ctxTenant == tenant)
+}
+
+// Usage is how much a tenant stores, and how much it may
+type Usage struct {
+	StatsCount int `json:"stats_count"`
+	// StatsQuota and StatsRemaining are left out when there is no quota
+	StatsQuota     *int `json:"stats_quota,omitempty"`
+	StatsRemaining *int `json:"stats_remaining,omitempty"`
+}
+
+// quotaFor returns the quota of tenant, 0 meaning unlimited
+func quotaFor(tenant string) int {
+	if isGuestTenant(tenant) {
+		return guestQuota
+	}
+	quotasMu.RLock()
+	defer quotasMu.RUnlock()
+
+	quota, exists := tenantQuotas[tenant]
+	if !exists {
+		return defaultQuota
+	}
+	return quota
+}
+
+// setQuotas replaces the quotas of the tenants and the default one
+func setQuotas(quotas map[string]int, quota int) {
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+
+	tenantQuotas, defaultQuota = quotas, quota
+}
+
+// checkQuota fails with errQuotaExceeded when the tenant of ctx has stored
+// as many stats as its quota allows
+func checkQuota(ctx context.Context) error {
+	return checkQuotaRoom(ctx, 1)
+}
+
+// checkQuotaRoom fails with errQuotaExceeded when the quota of the tenant of
+// ctx has no room left for n more stats. Deleted stats leave the cached count
+// too high, so writes are only refused after counting again.
+func checkQuotaRoom(ctx context.Context, n int) error {
+	tenant := tenantFromContext(ctx)
+	quota := quotaFor(tenant)
+	if quota <= 0 {
+		return nil
+	}
+
+	count, cached := cachedQuotaCount(tenant)
+	if !cached || count+n > quota {
+		var err error
+		count, err = repository.CountStats(ctx)
+		if err != nil {
+			return err
+		}
+		cacheQuotaCount(tenant, count)
+	}
+	if count+n > quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// cachedQuotaCount returns the cached stats count of tenant, if it is fresh
+func cachedQuotaCount(tenant string) (int, bool) {
+	quotaCounts.Lock()
+	defer quotaCounts.Unlock()
+
+	counted, exists := quotaCounts.counts[tenant]
+	if !exists || time.Since(counted.countedAt) > quotaCountTTL {
+		return 0, false
+	}
+	return counted.count, true
+}
+
+func cacheQuotaCount(tenant string, count int) {
+	quotaCounts.Lock()
+	defer quotaCounts.Unlock()
+
+	if len(quotaCounts.counts) >= maxQuotaCounts {
+		for tenant, counted := range quotaCounts.counts {
+			if time.Since(counted.countedAt) > quotaCountTTL {
+				delete(quotaCounts.counts, tenant)
+			}
+		}
+	}
+	quotaCounts.counts[tenant] = quotaCount{count: count, countedAt: time.Now()}
+}
+
+// addToQuotaCount adds n saved stats to the cached count of tenant, if it has
+// one. Saving stats that replace others overcounts, which only makes the
+// next write close to the quota count again.
+func addToQuotaCount(tenant string, n int) {
+	quotaCounts.Lock()
+	defer quotaCounts.Unlock()
+
+	if counted, exists := quotaCounts.counts[tenant]; exists {
+		counted.count += n
+		quotaCounts.counts[tenant] = counted
+	}
+}
+
+func usage(ctx context.Context) (Usage, error) {
+	count, err := repository.CountStats(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{StatsCount: count}
+	if quota := quotaFor(tenantFromContext(ctx)); quota > 0 {
+		remaining := quota - count
+		if remaining < 0 {
+			// lowering a quota doesn't delete anything
+			remaining = 0
+		}
+		usage.StatsQuota = &quota
+		usage.StatsRemaining = &remaining
+	}
+	return usage, nil
+}
+
+func getUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := usage(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, usage)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// countingRepository counts how often the stats are counted
+type countingRepository struct {
+	Repository
+	counted int
+}
+
+func (r *countingRepository) CountStats(ctx context.Context) (int, error) {
+	r.counted++
+	return r.Repository.CountStats(ctx)
+}
+
+// TestCheckQuotaCounts checks the quota of a tenant one write after another,
+// the stats are only counted again when the cached count is outdated or
+// would refuse the write
+func TestCheckQuotaCounts(t *testing.T) {
+	defer func(saved Repository) { repository = saved }(repository)
+	defer setQuotas(nil, 0)
+	defer func() { quotaCounts.counts = make(map[string]quotaCount) }()
+	counting := &countingRepository{Repository: newMemoryRepository([]StatsRaw{
+		{ChordName: "C", RootNote: "C"},
+		{ChordName: "D", RootNote: "D"},
+	})}
+	repository = counting
+	setQuotas(map[string]int{defaultTenant: 4}, 0)
+	ctx := withTenant(context.Background(), defaultTenant)
+
+	tests := []struct {
+		name string
+		// saved are new stats, replaced are saved over stored ones
+		saved    int
+		replaced int
+		err      error
+		counted  int
+	}{
+		{"first write", 0, 0, nil, 1},
+		{"cached count", 0, 0, nil, 1},
+		{"overcounted", 0, 2, nil, 2},
+		{"room left", 1, 0, nil, 2},
+		{"quota used up", 1, 0, errQuotaExceeded, 3},
+	}
+	for _, test := range tests {
+		for i := 0; i < test.saved; i++ {
+			_, err := repository.SaveStats(ctx, StatsRaw{ChordName: "E", RootNote: "E"}, anyVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		addToQuotaCount(defaultTenant, test.saved+test.replaced)
+
+		err := checkQuota(ctx)
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if counting.counted != test.counted {
+			t.Errorf("%s: counted %d times, want %d", test.name, counting.counted, test.counted)
+		}
+	}
+}
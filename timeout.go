@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestTimeout is how long a request to a route without a timeout of its
+// own may take, 0 meaning no limit
+var requestTimeout time.Duration
+
+// routeTimeouts are how long requests may take by route pattern with or
+// without the method, or by method alone
+var routeTimeouts map[string]time.Duration
+
+// timeoutsMu guards the timeouts, which are replaced when the config is
+// reloaded
+var timeoutsMu sync.RWMutex
+
+// parseRouteTimeouts parses the durations of the timeouts by route
+func parseRouteTimeouts(timeouts map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(timeouts))
+	for route, timeout := range timeouts {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("timeout of %s isn't a duration", route)
+		}
+		parsed[strings.TrimSpace(route)] = d
+	}
+	return parsed, nil
+}
+
+// setTimeouts replaces the timeouts
+func setTimeouts(timeout time.Duration, timeouts map[string]time.Duration) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+
+	requestTimeout, routeTimeouts = timeout, timeouts
+}
+
+// timeoutFor returns how long a request with method to route may take. A
+// timeout of the route and method wins over one of the route, which wins over
+// one of the method.
+func timeoutFor(method, route string) time.Duration {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+
+	for _, key := range []string{method + " " + route, route, method} {
+		if timeout, exists := routeTimeouts[key]; exists {
+			return timeout
+		}
+	}
+	return requestTimeout
+}
+
+// LimitDuration cancels the context of requests that take longer than the
+// timeout of their route, found in routes before they are served. Handlers
+// failing because of it answer with a timeout problem through
+// writeInternalError; those that ignore the context and answer nothing get
+// it once they return, unless they took over the connection.
+func LimitDuration(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.URL.Path
+			routeContext := chi.NewRouteContext()
+			if routes.Match(routeContext, r.Method, r.URL.Path) {
+				route = routeContext.RoutePattern()
+			}
+			timeout := timeoutFor(r.Method, route)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+			tracker := &hijackTracker{ResponseWriter: w}
+			ww := middleware.NewWrapResponseWriter(tracker, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			if ww.Status() == 0 && !tracker.hijacked && timedOut(r) {
+				writeTimeoutProblem(w, r)
+			}
+		})
+	}
+}
+
+// hijackTracker remembers if the connection of a response was taken over,
+// like WebSockets do, after which there is no response left to write
+type hijackTracker struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (t *hijackTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	t.hijacked = err == nil
+	return conn, rw, err
+}
+
+func (t *hijackTracker) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// timedOut tells if the deadline of r passed
+func timedOut(r *http.Request) bool {
+	return r.Context().Err() == context.DeadlineExceeded
+}
+
+// writeTimeoutProblem answers that r took too long, with the ID to look the
+// request up by in the logs
+func writeTimeoutProblem(w http.ResponseWriter, r *http.Request) {
+	requestTimeoutsTotal.Inc()
+	detail := "The request took too long to serve"
+	if requestID := middleware.GetReqID(r.Context()); requestID != "" {
+		detail += ", its request ID is " + requestID
+	}
+	writeProblem(w, problemTimeout, detail)
+}
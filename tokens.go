@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mintedTokenPrefix starts every minted token, so leaked ones are easy to
+// spot, e.g. by secret scanners
+const mintedTokenPrefix = "pct_"
+
+// tokensTTL is how long the minted tokens are cached, replicas see tokens
+// minted or revoked through another one that much later
+const tokensTTL = 10 * time.Second
+
+// APIToken is an auth token minted through the admin API. Tokens can be
+// valid side by side, so the one in the app can be rotated by minting a new
+// one, shipping it and revoking the old one once no app uses it anymore.
+type APIToken struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	Label  string             `json:"label" bson:"label"`
+	Tenant string             `json:"tenant" bson:"tenant"`
+	Salt   []byte             `json:"-" bson:"salt"`
+	Hash   []byte             `json:"-" bson:"hash"`
+	// ExpiresAt is when the token stops being valid, never if nil
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	// Token is only returned when minted, only the hash is stored
+	Token string `json:"token,omitempty" bson:"-"`
+}
+
+// validate returns what is wrong with the token, nothing if it is valid
+func (t APIToken) validate() []FieldError {
+	var fieldErrors []FieldError
+	if t.Label == "" || len(t.Label) > 64 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "label", Message: "has to be 1 to 64 characters"})
+	}
+	if t.Tenant == allTenants || len(t.Tenant) > 64 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "tenant", Message: "has to be a tenant of up to 64 characters"})
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "expires_at", Message: "has to be in the future"})
+	}
+	return fieldErrors
+}
+
+// mintedCredentials caches the minted tokens as credentials. When they can't
+// be refreshed the ones loaded last are used, so auth keeps working while
+// the database is unavailable.
+var mintedCredentials = struct {
+	sync.Mutex
+	credentials []credential
+	expiresAt   time.Time
+}{}
+
+func cachedMintedCredentials(ctx context.Context) []credential {
+	mintedCredentials.Lock()
+	defer mintedCredentials.Unlock()
+
+	if repository == nil || time.Now().Before(mintedCredentials.expiresAt) {
+		return mintedCredentials.credentials
+	}
+	tokens, err := repository.Tokens(ctx)
+	if err != nil {
+		log.Println("Error:", err)
+		return mintedCredentials.credentials
+	}
+	minted := make([]credential, 0, len(tokens))
+	for _, token := range tokens {
+		minted = append(minted, credential{tenant: token.Tenant, salt: token.Salt, hash: token.Hash, expiresAt: token.ExpiresAt})
+	}
+	mintedCredentials.credentials = minted
+	mintedCredentials.expiresAt = time.Now().Add(tokensTTL)
+	return minted
+}
+
+func invalidateMintedCredentials() {
+	mintedCredentials.Lock()
+	defer mintedCredentials.Unlock()
+
+	mintedCredentials.expiresAt = time.Time{}
+}
+
+// mintToken creates a token, returned with Token set
+func mintToken(ctx context.Context, token APIToken) (APIToken, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return APIToken{}, err
+	}
+	c, err := newCredential(token.Tenant, mintedTokenPrefix+hex.EncodeToString(secret))
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	token.ID = primitive.NewObjectID()
+	token.Salt = c.salt
+	token.Hash = c.hash
+	token.CreatedAt = time.Now()
+	err = repository.SaveToken(ctx, token)
+	if err != nil {
+		return APIToken{}, err
+	}
+	invalidateMintedCredentials()
+
+	token.Token = mintedTokenPrefix + hex.EncodeToString(secret)
+	return token, nil
+}
+
+func getTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := repository.Tokens(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, tokens)
+}
+
+// mintTokenHandler answers with the new token, which can't be read again
+// later
+func mintTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var token APIToken
+	err := decodeRequest(r, &token)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if token.Tenant == "" {
+		token.Tenant = defaultTenant
+	}
+	if fieldErrors := token.validate(); len(fieldErrors) > 0 {
+		writeProblem(w, problemInvalidRequest, "", fieldErrors...)
+		return
+	}
+
+	token, err = mintToken(r.Context(), token)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	log.Printf("Minted token %s for tenant %s\n", token.Label, token.Tenant)
+	writeResponseStatus(w, r, http.StatusCreated, token)
+}
+
+// revokeTokenHandler deletes a minted token, tokens from the configuration
+// can't be revoked
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+
+	err = repository.DeleteToken(r.Context(), id)
+	if err == errNotFound {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	invalidateMintedCredentials()
+
+	log.Printf("Revoked token %s\n", id.Hex())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps
+	totpIssuer = "Piano Chord Training"
+	// totpPeriod and totpDigits are the defaults of RFC 6238, the ones every
+	// authenticator app supports
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many steps a code may be off, for clocks that are a
+	// bit off and codes entered just as they change
+	totpSkew           = 1
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// totpEncoding is how secrets are shown to authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Enrollment is what the user needs to set up an authenticator app, only
+// returned when enrolling
+type Enrollment struct {
+	Secret string `json:"secret"`
+	// ProvisioningURI is shown as a QR code for authenticator apps to scan
+	ProvisioningURI string `json:"provisioning_uri"`
+	// RecoveryCodes can each be used once instead of a code, when the
+	// authenticator app is lost
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// totpCode returns the code of secret at step, as of RFC 4226
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the step a code of secret is for, false if it is for
+// none near now
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func recoveryCodeHash(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hash[:]
+}
+
+// newRecoveryCode returns a code like abcde-fghij
+func newRecoveryCode() (string, error) {
+	random := make([]byte, recoveryCodeLength)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	code := make([]byte, 0, recoveryCodeLength+1)
+	for i, b := range random {
+		if i == recoveryCodeLength/2 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[int(b)%len(alphabet)])
+	}
+	return string(code), nil
+}
+
+// checkSecondFactor tells if code is a current TOTP code of the user, or one
+// of the user's recovery codes, using it up either way
+func checkSecondFactor(ctx context.Context, userID string, twoFactor *TwoFactor, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, matched := matchTOTP(twoFactor.Secret, code, time.Now()); matched {
+		return repository.UseTwoFactorStep(ctx, userID, step)
+	}
+	return repository.UseRecoveryCode(ctx, userID, recoveryCodeHash(code))
+}
+
+// signedInUser returns the user of a signed in caller. Callers with a
+// configured or minted token are answered with 403.
+func signedInUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	session, signedIn := sessionFromContext(r.Context())
+	if !signedIn {
+		writeProblem(w, problemSignInRequired, "")
+		return User{}, false
+	}
+	user, err := repository.User(r.Context(), session.UserID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return User{}, false
+	}
+	// as in sign-in responses
+	user.EmailVerified = user.HasVerifiedEmail()
+	return user, true
+}
+
+// enrollTwoFactorHandler starts setting up an authenticator app, which
+// takes effect once confirmed with a first code. Enrolling again before
+// confirming starts over with a new secret.
+func enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled() {
+		writeProblem(w, problemTwoFactorEnabled, "Disable it first to enroll another authenticator app")
+		return
+	}
+
+	twoFactor := &TwoFactor{Secret: make([]byte, 20), EnrolledAt: time.Now()}
+	_, err := rand.Read(twoFactor.Secret)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	enrollment := Enrollment{Secret: totpEncoding.EncodeToString(twoFactor.Secret)}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, code)
+		twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes, recoveryCodeHash(code))
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.ID
+	}
+	enrollment.ProvisioningURI = (&url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + account,
+		// some authenticator apps show a + of the issuer as is
+		RawQuery: strings.ReplaceAll(url.Values{
+			"secret":    {enrollment.Secret},
+			"issuer":    {totpIssuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(totpPeriod)},
+		}.Encode(), "+", "%20"),
+	}).String()
+
+	err = repository.SaveTwoFactor(r.Context(), user.ID, twoFactor)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponseStatus(w, r, http.StatusCreated, enrollment)
+}
+
+type twoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// confirmTwoFactorHandler enables two-factor auth with a first code of the
+// enrolled authenticator app
+func confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	var body twoFactorCode
+	err := decodeRequest(r, &body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if user.TwoFactor == nil {
+		writeProblem(w, problemNotFound, "There is no authenticator app enrolled")
+		return
+	}
+	if user.TwoFactor.Enabled {
+		writeProblem(w, problemTwoFactorEnabled, "")
+		return
+	}
+
+	step, matched := matchTOTP(user.TwoFactor.Secret, strings.TrimSpace(body.Code), time.Now())
+	if !matched {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "code", Message: "isn't a current code of the authenticator app"})
+		return
+	}
+	twoFactor := *user.TwoFactor
+	twoFactor.Enabled, twoFactor.LastStep = true, step
+	err = repository.SaveTwoFactor(r.Context(), user.ID, &twoFactor)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, twoFactor)
+}
+
+// disableTwoFactorHandler turns two-factor auth off, with a code or a
+// recovery code so a stolen session can't turn it off
+func disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	var body twoFactorCode
+	err := decodeRequest(r, &body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		writeProblem(w, problemNotFound, "Two-factor auth isn't enabled")
+		return
+	}
+
+	valid, err := checkSecondFactor(r.Context(), user.ID, user.TwoFactor, body.Code)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !valid {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "code", Message: "isn't a current or recovery code"})
+		return
+	}
+	err = repository.SaveTwoFactor(r.Context(), user.ID, nil)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyTwoFactorHandler completes a sign-in of a user with two-factor auth,
+// trading the pending session and a code for a full session
+func verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	failureKeys := authFailureKeys(remoteIP(r), "")
+	if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+		writeBanned(w, bannedFor)
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	err := decodeRequest(r, &body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	pending, err := liveSession(r.Context(), body.Token)
+	if err == errNotFound || (err == nil && !pending.TwoFactorPending) {
+		delayFailure(r.Context(), authFailures.fail(failureKeys))
+		writeProblem(w, problemUnauthorized, "The sign-in is unknown or expired, sign in again")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	user, err := repository.User(r.Context(), pending.UserID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	valid := !user.TwoFactorEnabled()
+	if !valid {
+		valid, err = checkSecondFactor(r.Context(), user.ID, user.TwoFactor, body.Code)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+	if !valid {
+		delayFailure(r.Context(), authFailures.fail(failureKeys))
+		writeProblem(w, problemUnauthorized, "The code is wrong or was used already")
+		return
+	}
+
+	err = repository.DeleteSession(r.Context(), pending.ID)
+	if err == errNotFound {
+		// the same sign-in was completed concurrently
+		writeProblem(w, problemUnauthorized, "The sign-in is unknown or expired, sign in again")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	session, err := issueSession(r, user, false)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, newSignIn(session, user))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "piano-chord-training-backend"
+
+var tracer = otel.Tracer(serviceName)
+
+// tracingEnabled tells if an OTLP endpoint is configured
+func tracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing exports spans over OTLP/HTTP, configured by the standard
+// OTEL_* env vars, such as OTEL_EXPORTER_OTLP_ENDPOINT and
+// OTEL_EXPORTER_OTLP_HEADERS. The returned function flushes the spans not
+// exported yet.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// NameSpanByRoute names the span of a request after the route it matched,
+// such as "GET /assignments/{id}", which is only known once chi has routed it
+func NameSpanByRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			pattern := routeContext.RoutePattern()
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(semconv.HTTPRouteKey.String(pattern))
+		}
+	})
+}
+
+// newMongoTracer returns a command monitor recording a span per Mongo
+// command, as a child of the span of the operation's context. Only the
+// command and collection are recorded, commands can hold user data.
+func newMongoTracer() *event.CommandMonitor {
+	var mu sync.Mutex
+	spans := make(map[int64]trace.Span)
+
+	end := func(requestID int64, err string) {
+		mu.Lock()
+		span, exists := spans[requestID]
+		delete(spans, requestID)
+		mu.Unlock()
+		if !exists {
+			return
+		}
+		if err != "" {
+			span.SetStatus(codes.Error, err)
+		}
+		span.End()
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			attributes := []attribute.KeyValue{
+				semconv.DBSystemMongoDB,
+				semconv.DBNameKey.String(evt.DatabaseName),
+				semconv.DBOperationKey.String(evt.CommandName),
+			}
+			// the collection is the value of the command's first element
+			elements, err := evt.Command.Elements()
+			if err == nil && len(elements) > 0 {
+				if collection, ok := elements[0].Value().StringValueOK(); ok {
+					attributes = append(attributes, semconv.DBMongoDBCollectionKey.String(collection))
+				}
+			}
+			_, span := tracer.Start(
+				ctx,
+				"mongo."+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attributes...),
+			)
+
+			mu.Lock()
+			spans[evt.RequestID] = span
+			mu.Unlock()
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			end(evt.RequestID, "")
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			end(evt.RequestID, evt.Failure)
+		},
+	}
+}
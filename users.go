@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+)
+
+// User is an account signed in through an identity provider, rather than
+// sharing a configured token. Every user is a tenant of its own, named by the
+// user's ID.
+type User struct {
+	ID    string `json:"id" bson:"_id"`
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
+	// EmailVerified tells if the identity provider vouches for the email.
+	// Responses also set it for an email verified with a link, see
+	// HasVerifiedEmail.
+	EmailVerified bool `json:"email_verified" bson:"email_verified"`
+	// VerifiedEmail is the address last verified with a link, verifications
+	// of earlier addresses don't apply once the email changes
+	VerifiedEmail string     `json:"-" bson:"verified_email,omitempty"`
+	Identities    []Identity `json:"identities" bson:"identities"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	LastLoginAt   time.Time  `json:"last_login_at" bson:"last_login_at"`
+	// DeletionScheduledAt is when the account is deleted, set while the user
+	// can still cancel it
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+	// TwoFactor is set once the user starts enrolling an authenticator app
+	TwoFactor *TwoFactor `json:"two_factor,omitempty" bson:"two_factor,omitempty"`
+}
+
+// TwoFactor is the TOTP authenticator app of a user, which has to confirm
+// every sign-in once enabled
+type TwoFactor struct {
+	// Secret is shared with the authenticator app, so it can't be hashed
+	Secret []byte `json:"-" bson:"secret"`
+	// Enabled is set once a first code confirms the enrollment
+	Enabled bool `json:"enabled" bson:"enabled"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes [][]byte `json:"-" bson:"recovery_codes"`
+	// LastStep is the time step of the last code used, codes of it and
+	// earlier steps are refused so a code can't be replayed
+	LastStep   int64     `json:"-" bson:"last_step"`
+	EnrolledAt time.Time `json:"enrolled_at" bson:"enrolled_at"`
+}
+
+// Identity is a user as known to an identity provider. Users aren't matched
+// across providers by email, since not every provider verifies it.
+type Identity struct {
+	Provider string `json:"provider" bson:"provider"`
+	Subject  string `json:"subject" bson:"subject"`
+}
+
+// Tenant returns the tenant holding the user's data
+func (u User) Tenant() string {
+	return u.ID
+}
+
+// HasVerifiedEmail tells if the user's email is known to be theirs. Emails
+// are only sent to other addresses to verify them, so notifications can't
+// be used to spam strangers.
+func (u User) HasVerifiedEmail() bool {
+	return u.Email != "" && (u.EmailVerified || u.VerifiedEmail == u.Email)
+}
+
+// TwoFactorEnabled tells if signing in needs a code of the user's
+// authenticator app
+func (u User) TwoFactorEnabled() bool {
+	return u.TwoFactor != nil && u.TwoFactor.Enabled
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// verifyEmailTTL is how long a link to verify an email can be used
+const verifyEmailTTL = 24 * time.Hour
+
+// verifyEmailURL is the page of the app verification links open, with the
+// token appended as the token query param, empty when verification is
+// disabled
+var verifyEmailURL string
+
+// sendVerificationEmail emails user a link to verify the address with. It
+// does nothing if a link was sent to the address within the last minute.
+func sendVerificationEmail(ctx context.Context, user User) error {
+	if !claimEmailSlot(user.Email) {
+		return nil
+	}
+	token := signEmailLink("verify-email", []string{user.ID, user.Email}, time.Now().Add(verifyEmailTTL))
+	return emailSender.Send(ctx, user.Email, "Verify your email for Piano Chord Training", fmt.Sprintf(
+		"Open this link within %d hours to verify your email:\n\n%s\n\nIf you didn't sign up, you can ignore this email.\n",
+		int(verifyEmailTTL.Hours()), linkTo(verifyEmailURL, token),
+	))
+}
+
+// welcomeUser emails a verification link to a user who just signed up with
+// an address the identity provider doesn't vouch for. Failing to is only
+// logged, since the link can be sent again.
+func welcomeUser(ctx context.Context, user User, created bool) {
+	if !created || verifyEmailURL == "" || user.Email == "" || user.HasVerifiedEmail() {
+		return
+	}
+	err := sendVerificationEmail(ctx, user)
+	if err != nil {
+		log.Println("Error:", err)
+	}
+}
+
+// resendVerificationHandler emails the signed in user another link to
+// verify the email with
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if verifyEmailURL == "" {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	user, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Email == "" {
+		writeProblem(w, problemNotFound, "The user has no email")
+		return
+	}
+	if user.HasVerifiedEmail() {
+		writeProblem(w, problemEmailVerified, "")
+		return
+	}
+
+	err := sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyEmailHandler marks the email a verification link was sent to as
+// verified. Anyone with the link may, so a signed in caller isn't needed.
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if verifyEmailURL == "" {
+		writeProblem(w, problemNotFound, "")
+		return
+	}
+	failureKeys := authFailureKeys(remoteIP(r), "")
+	if bannedFor := authFailures.bannedFor(failureKeys); bannedFor > 0 {
+		writeBanned(w, bannedFor)
+		return
+	}
+
+	fields, err := verifyEmailLink("verify-email", r.URL.Query().Get("token"), 2)
+	if err != nil {
+		delayFailure(r.Context(), authFailures.fail(failureKeys))
+		writeProblem(w, problemUnauthorized, "The verification link is invalid or expired")
+		return
+	}
+
+	verified, err := repository.VerifyEmail(r.Context(), fields[0], fields[1])
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !verified {
+		writeProblem(w, problemUnauthorized, "The email of the user was changed since the link was sent")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
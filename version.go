@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// gitSHA and buildTime are set at build time, e.g. with
+//
+//	go build -ldflags "-X main.gitSHA=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+	gitSHA    = "unknown"
+	buildTime = "unknown"
+)
+
+// enabledFeatures names the optional parts of the server that are turned on,
+// set once at startup
+var enabledFeatures []string
+
+// BuildInfo tells which build is running, and how it is configured
+type BuildInfo struct {
+	GitSHA    string   `json:"git_sha"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features"`
+}
+
+func buildInfo() BuildInfo {
+	features := enabledFeatures
+	if features == nil {
+		features = []string{}
+	}
+	return BuildInfo{
+		GitSHA:    gitSHA,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Features:  features,
+	}
+}
+
+// getVersionHandler needs no auth token, so deploys can be checked with curl
+func getVersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, buildInfo())
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warehouseBatchSize is how many changes are exported at most in one write
+const warehouseBatchSize = 1000
+
+// warehouseRollupDelay is how long after a day is over, in UTC, it is
+// rolled up, leaving offline clients time to sync the answers of the day
+const warehouseRollupDelay = 24 * time.Hour
+
+// bigQueryScope lets the service account insert into BigQuery tables and
+// create them
+const bigQueryScope = "https://www.googleapis.com/auth/bigquery"
+
+// The exports keeping track of how far the warehouse is filled
+const (
+	// warehouseChanges exports every write of stats and every deletion
+	warehouseChanges = "changes"
+	// warehouseRollups exports the daily rollups
+	warehouseRollups = "rollups"
+)
+
+type warehouseOptions struct {
+	WarehouseSink           string        `long:"warehouse-sink" env:"WAREHOUSE_SINK" description:"Where stats and daily rollups are exported for analysis, bigquery or s3, disabled if empty"`
+	BigQueryDataset         string        `long:"bigquery-dataset" env:"BIGQUERY_DATASET" description:"BigQuery dataset the tables are created in as [project.]dataset, the project defaulting to the one of the Google service account"`
+	WarehouseS3Prefix       string        `long:"warehouse-s3-prefix" env:"WAREHOUSE_S3_PREFIX" default:"warehouse/" description:"Prefix of the keys of the exported files in the backup bucket"`
+	WarehouseExportInterval time.Duration `long:"warehouse-export-interval" env:"WAREHOUSE_EXPORT_INTERVAL" default:"15m" description:"How often new stats are exported to the warehouse"`
+}
+
+// sink returns the sink the options configure, with BigQuery authenticating
+// as the Google service account and S3 using the backup bucket
+func (o warehouseOptions) sink(googleServiceAccount string, backups backupOptions) (warehouseSink, error) {
+	switch o.WarehouseSink {
+	case "bigquery":
+		if googleServiceAccount == "" || o.BigQueryDataset == "" {
+			return nil, errors.New("BigQuery needs a Google service account and a dataset")
+		}
+		account, err := newGoogleServiceAccount(googleServiceAccount, bigQueryScope)
+		if err != nil {
+			return nil, err
+		}
+		project, dataset := account.projectID, o.BigQueryDataset
+		if i := strings.Index(dataset, "."); i >= 0 {
+			project, dataset = dataset[:i], dataset[i+1:]
+		}
+		if project == "" {
+			return nil, errors.New("the BigQuery dataset has to name its project")
+		}
+		return &bigQuerySink{account: account, project: project, dataset: dataset, tables: make(map[string]bool)}, nil
+	case "s3":
+		storage, err := backups.s3Client()
+		if err != nil {
+			return nil, err
+		}
+		return s3Sink{storage: storage, prefix: o.WarehouseS3Prefix}, nil
+	}
+	return nil, fmt.Errorf("unknown warehouse sink %q, has to be bigquery or s3", o.WarehouseSink)
+}
+
+// WarehouseExport is how far an export to the warehouse got
+type WarehouseExport struct {
+	Name string `bson:"_id"`
+	// Cursor is the sync sequence number of the last change exported
+	Cursor int64 `bson:"cursor"`
+	// Day is the last day rolled up, as YYYY-MM-DD
+	Day string `bson:"day"`
+}
+
+// warehouseRow is a row of a warehouse table. ID is the same every time the
+// row is exported, which lets sinks drop rows exported twice.
+type warehouseRow struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// warehouseSink writes rows to a data warehouse. Batch names the rows, the
+// same rows are written with the same batch.
+type warehouseSink interface {
+	Write(ctx context.Context, table, batch string, rows []warehouseRow) error
+}
+
+// warehouseColumn is a column of a warehouse table, typed as in BigQuery
+type warehouseColumn struct {
+	Name string
+	Type string
+}
+
+// warehouseTable describes a table the rows are written to, for creating it
+type warehouseTable struct {
+	Columns []warehouseColumn
+	// PartitionBy is the column the table is partitioned on by day
+	PartitionBy string
+}
+
+// warehouseTables are the tables of the warehouse. Stats are appended on
+// every write, so a stat is in the stats table once per version.
+var warehouseTables = map[string]warehouseTable{
+	"stats": {
+		Columns: []warehouseColumn{
+			{"id", "STRING"},
+			{"tenant", "STRING"},
+			{"chord_name", "STRING"},
+			{"root_note", "STRING"},
+			{"chord_extension", "STRING"},
+			{"answer_duration_millis", "INTEGER"},
+			{"correct", "BOOLEAN"},
+			{"created_at", "TIMESTAMP"},
+			{"platform", "STRING"},
+			{"app_version", "STRING"},
+			{"experiments", "STRING"},
+			{"version", "INTEGER"},
+			{"sync_seq", "INTEGER"},
+		},
+		PartitionBy: "created_at",
+	},
+	"deletions": {
+		Columns: []warehouseColumn{
+			{"id", "STRING"},
+			{"tenant", "STRING"},
+			{"deleted_at", "TIMESTAMP"},
+			{"sync_seq", "INTEGER"},
+		},
+		PartitionBy: "deleted_at",
+	},
+	"daily_rollups": {
+		Columns: []warehouseColumn{
+			{"tenant", "STRING"},
+			{"day", "DATE"},
+			{"chord_extension", "STRING"},
+			{"answers", "INTEGER"},
+			{"correct", "INTEGER"},
+			{"avg_duration_millis", "INTEGER"},
+		},
+		PartitionBy: "day",
+	},
+}
+
+func warehouseStatsRow(stats StatsRaw) warehouseRow {
+	experiments := ""
+	if len(stats.Experiments) > 0 {
+		encoded, _ := json.Marshal(stats.Experiments)
+		experiments = string(encoded)
+	}
+	return warehouseRow{
+		ID: fmt.Sprintf("%s-%d", stats.ID.Hex(), stats.Version),
+		Values: map[string]interface{}{
+			"id":                     stats.ID.Hex(),
+			"tenant":                 stats.Tenant,
+			"chord_name":             stats.ChordName,
+			"root_note":              stats.RootNote,
+			"chord_extension":        stats.ChordExtension,
+			"answer_duration_millis": stats.AnswerDurationMilliSeconds,
+			"correct":                stats.Correct == nil || *stats.Correct,
+			"created_at":             stats.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"platform":               stats.Platform,
+			"app_version":            stats.AppVersion,
+			"experiments":            experiments,
+			"version":                stats.Version,
+			"sync_seq":               stats.SyncSeq,
+		},
+	}
+}
+
+func warehouseDeletionRow(tombstone Tombstone) warehouseRow {
+	return warehouseRow{
+		ID: fmt.Sprintf("%s-deleted-%d", tombstone.ID.Hex(), tombstone.SyncSeq),
+		Values: map[string]interface{}{
+			"id":         tombstone.ID.Hex(),
+			"tenant":     tombstone.Tenant,
+			"deleted_at": tombstone.DeletedAt.UTC().Format(time.RFC3339Nano),
+			"sync_seq":   tombstone.SyncSeq,
+		},
+	}
+}
+
+// warehouseExportJob exports to sink every interval, until the warehouse
+// has caught up. Accounts purged later stay in the warehouse.
+func warehouseExportJob(sink warehouseSink, interval time.Duration) *job {
+	return &job{
+		name:     "warehouse_export",
+		schedule: "@every " + interval.String(),
+		timeout:  time.Hour,
+		run: func(ctx context.Context) error {
+			for _, export := range []func(context.Context, warehouseSink, time.Time) (bool, error){exportWarehouseChanges, exportWarehouseRollups} {
+				more, err := true, error(nil)
+				for more && err == nil {
+					more, err = export(ctx, sink, time.Now())
+				}
+				if err != nil {
+					warehouseExportFailuresTotal.Inc()
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// claimWarehouseExport moves the export from from to to before the rows are
+// written, so replicas exporting side by side don't write them twice. If
+// write fails the export is moved back, and the rows are tried again on the
+// next run. It returns false if another replica claimed the rows first.
+func claimWarehouseExport(ctx context.Context, from, to WarehouseExport, write func() error) (bool, error) {
+	claimed, err := repository.UpdateWarehouseExport(ctx, from, to)
+	if err != nil || !claimed {
+		return false, err
+	}
+	err = write()
+	if err != nil {
+		_, rollbackErr := repository.UpdateWarehouseExport(ctx, to, from)
+		if rollbackErr != nil {
+			log.Println("Error:", rollbackErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// exportWarehouseChanges exports the next batch of written stats and
+// deletions of every tenant, and tells if there may be more
+func exportWarehouseChanges(ctx context.Context, sink warehouseSink, now time.Time) (bool, error) {
+	from, err := repository.WarehouseExport(ctx, warehouseChanges)
+	if err != nil {
+		return false, err
+	}
+	changes, err := syncChanges(ctx, from.Cursor, warehouseBatchSize)
+	if err != nil {
+		return false, err
+	}
+	if len(changes.Stats)+len(changes.Deleted) == 0 {
+		return false, nil
+	}
+
+	to := from
+	to.Cursor, err = strconv.ParseInt(changes.NextCursor, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	batch := fmt.Sprintf("%012d-%012d", from.Cursor+1, to.Cursor)
+	claimed, err := claimWarehouseExport(ctx, from, to, func() error {
+		if len(changes.Stats) > 0 {
+			rows := make([]warehouseRow, len(changes.Stats))
+			for i, stats := range changes.Stats {
+				rows[i] = warehouseStatsRow(stats)
+			}
+			err := sink.Write(ctx, "stats", batch, rows)
+			if err != nil {
+				return err
+			}
+			warehouseRowsExportedTotal.WithLabelValues("stats").Add(float64(len(rows)))
+		}
+		if len(changes.Deleted) > 0 {
+			rows := make([]warehouseRow, len(changes.Deleted))
+			for i, tombstone := range changes.Deleted {
+				rows[i] = warehouseDeletionRow(tombstone)
+			}
+			err := sink.Write(ctx, "deletions", batch, rows)
+			if err != nil {
+				return err
+			}
+			warehouseRowsExportedTotal.WithLabelValues("deletions").Add(float64(len(rows)))
+		}
+		return nil
+	})
+	return claimed && changes.HasMore, err
+}
+
+// exportWarehouseRollups rolls up the next day of every tenant, per chord
+// extension, and tells if there may be more days due. The first day rolled
+// up is the last one due when the export starts, the days before can be
+// rolled up from the stats table.
+func exportWarehouseRollups(ctx context.Context, sink warehouseSink, now time.Time) (bool, error) {
+	from, err := repository.WarehouseExport(ctx, warehouseRollups)
+	if err != nil {
+		return false, err
+	}
+	due := now.UTC().Add(-warehouseRollupDelay).Truncate(24 * time.Hour)
+	day := due.AddDate(0, 0, -1)
+	if from.Day != "" {
+		last, err := time.Parse("2006-01-02", from.Day)
+		if err != nil {
+			return false, err
+		}
+		day = last.AddDate(0, 0, 1)
+	}
+	if !day.Before(due) {
+		return false, nil
+	}
+
+	rollups := map[[2]string]*dailyRollup{}
+	err = repository.EachStats(ctx, StatsFilter{Since: day, Until: day.AddDate(0, 0, 1)}, func(stats StatsRaw) error {
+		key := [2]string{stats.Tenant, stats.ChordExtension}
+		if rollups[key] == nil {
+			rollups[key] = &dailyRollup{}
+		}
+		rollups[key].add(stats)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	to := from
+	to.Day = day.Format("2006-01-02")
+	claimed, err := claimWarehouseExport(ctx, from, to, func() error {
+		if len(rollups) == 0 {
+			return nil
+		}
+		rows := make([]warehouseRow, 0, len(rollups))
+		for key, rollup := range rollups {
+			rows = append(rows, warehouseRow{
+				ID: key[0] + "/" + to.Day + "/" + key[1],
+				Values: map[string]interface{}{
+					"tenant":              key[0],
+					"day":                 to.Day,
+					"chord_extension":     key[1],
+					"answers":             rollup.answers,
+					"correct":             rollup.correct,
+					"avg_duration_millis": rollup.avg(),
+				},
+			})
+		}
+		err := sink.Write(ctx, "daily_rollups", to.Day, rows)
+		if err != nil {
+			return err
+		}
+		warehouseRowsExportedTotal.WithLabelValues("daily_rollups").Add(float64(len(rows)))
+		return nil
+	})
+	return claimed, err
+}
+
+// bigQuerySink streams rows into BigQuery tables, creating the tables the
+// first time they are missing
+type bigQuerySink struct {
+	account          *googleServiceAccount
+	project, dataset string
+
+	mu sync.Mutex
+	// tables are the ones known to exist
+	tables map[string]bool
+}
+
+func (s *bigQuerySink) endpoint(path string) string {
+	return "https://bigquery.googleapis.com/bigquery/v2/projects/" + url.PathEscape(s.project) +
+		"/datasets/" + url.PathEscape(s.dataset) + "/tables" + path
+}
+
+// Write inserts rows with their IDs as insert IDs, which BigQuery drops
+// duplicates by for a minute or so. Repeats after that are told apart in
+// queries by id and version.
+func (s *bigQuerySink) Write(ctx context.Context, table, batch string, rows []warehouseRow) error {
+	err := s.insert(ctx, table, rows)
+	var notFound googleError
+	if errors.As(err, &notFound) && notFound.StatusCode == http.StatusNotFound && !s.exists(table) {
+		err = s.createTable(ctx, table)
+		if err != nil {
+			return err
+		}
+		err = s.insert(ctx, table, rows)
+	}
+	return err
+}
+
+func (s *bigQuerySink) insert(ctx context.Context, table string, rows []warehouseRow) error {
+	insertRows := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		insertRows[i] = map[string]interface{}{"insertId": row.ID, "json": row.Values}
+	}
+	var response struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	err := s.account.post(ctx, s.endpoint("/"+url.PathEscape(table)+"/insertAll"), map[string]interface{}{"rows": insertRows}, &response)
+	if err != nil {
+		return err
+	}
+	// without skipInvalidRows no row is inserted if any is invalid
+	if len(response.InsertErrors) > 0 && len(response.InsertErrors[0].Errors) > 0 {
+		first := response.InsertErrors[0]
+		return fmt.Errorf("BigQuery refused row %d of %s: %s", first.Index, table, first.Errors[0].Message)
+	}
+	s.mu.Lock()
+	s.tables[table] = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *bigQuerySink) exists(table string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tables[table]
+}
+
+func (s *bigQuerySink) createTable(ctx context.Context, table string) error {
+	definition, ok := warehouseTables[table]
+	if !ok {
+		return fmt.Errorf("unknown warehouse table %s", table)
+	}
+	fields := make([]map[string]string, len(definition.Columns))
+	for i, column := range definition.Columns {
+		fields[i] = map[string]string{"name": column.Name, "type": column.Type}
+	}
+	err := s.account.post(ctx, s.endpoint(""), map[string]interface{}{
+		"tableReference":   map[string]string{"projectId": s.project, "datasetId": s.dataset, "tableId": table},
+		"schema":           map[string]interface{}{"fields": fields},
+		"timePartitioning": map[string]string{"type": "DAY", "field": definition.PartitionBy},
+	}, nil)
+	var conflict googleError
+	if errors.As(err, &conflict) && conflict.StatusCode == http.StatusConflict {
+		// another replica created it first
+		return nil
+	}
+	if err == nil {
+		log.Printf("Created BigQuery table %s.%s.%s\n", s.project, s.dataset, table)
+	}
+	return err
+}
+
+// s3Sink writes a batch as a file of JSON lines, under a prefix per table,
+// which warehouses like Snowflake, Redshift and Athena can load. A batch
+// written again replaces its file.
+type s3Sink struct {
+	storage *s3Client
+	prefix  string
+}
+
+func (s s3Sink) Write(ctx context.Context, table, batch string, rows []warehouseRow) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, row := range rows {
+		err := encoder.Encode(row.Values)
+		if err != nil {
+			return err
+		}
+	}
+	return s.storage.Put(ctx, s.prefix+table+"/"+batch+".ndjson", &body, int64(body.Len()))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// warmupLength is how many chords a warm-up has
+	warmupLength = 8
+	// warmupChallenges is how many weak chords a warm-up has at most
+	warmupChallenges = 3
+	// warmupHistory is how far back the answers go that tell mastered chords
+	// from weak ones
+	warmupHistory = 30 * 24 * time.Hour
+	// minMasteryAnswers is how many answers of a chord tell whether it is
+	// mastered or weak
+	minMasteryAnswers = 5
+	// masteryAccuracy is the accuracy from which a chord answered as fast as
+	// the others is mastered
+	masteryAccuracy = 0.9
+)
+
+// The kinds of chords of a warm-up
+const (
+	warmupConfidence = "confidence"
+	warmupChallenge  = "challenge"
+)
+
+// starterChords are the warm-up of users who haven't practiced yet
+var starterChords = []WarmupChord{
+	{ChordName: "C", RootNote: "C", ChordExtension: "maj", Kind: warmupConfidence},
+	{ChordName: "F", RootNote: "F", ChordExtension: "maj", Kind: warmupConfidence},
+	{ChordName: "G", RootNote: "G", ChordExtension: "maj", Kind: warmupConfidence},
+	{ChordName: "Am", RootNote: "A", ChordExtension: "m", Kind: warmupConfidence},
+}
+
+// Warmup is a short sequence of chords to open a practice session with. It
+// is the same all day, and changes from day to day.
+type Warmup struct {
+	Day    string        `json:"day"`
+	Chords []WarmupChord `json:"chords"`
+}
+
+// WarmupChord is a chord of a warm-up, either a mastered one for confidence
+// or a weak one as a challenge
+type WarmupChord struct {
+	ChordName      string `json:"chord_name"`
+	RootNote       string `json:"root_note"`
+	ChordExtension string `json:"chord_extension"`
+	Kind           string `json:"kind"`
+}
+
+// chordOutcome sums up the answers of a chord
+type chordOutcome struct {
+	chord   WarmupChord
+	answers int
+	// correct counts the answers right on the first try, a chord only found
+	// after a few isn't mastered
+	correct       int
+	durationMilli int
+	// rated counts the answers rated with a confidence, confidence adds up
+	// their ratings
+	rated      int
+	confidence int
+}
+
+func (o chordOutcome) accuracy() float64 {
+	return float64(o.correct) / float64(o.answers)
+}
+
+func (o chordOutcome) avgDurationMilli() float64 {
+	return float64(o.durationMilli) / float64(o.answers)
+}
+
+// confident tells if the user felt sure of the chord, or didn't rate it
+func (o chordOutcome) confident() bool {
+	return o.rated == 0 || float64(o.confidence)/float64(o.rated) >= masteryConfidence
+}
+
+// warmup returns the warm-up of the user in ctx for today in loc, made of the
+// answers before today so it stays the same all day
+func warmup(ctx context.Context, loc *time.Location) (Warmup, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	outcomes := make(map[string]*chordOutcome)
+	answers, durationMilli := 0, 0
+	aliases, err := chordAliases(ctx)
+	if err != nil {
+		return Warmup{}, err
+	}
+	err = repository.EachStats(ctx, StatsFilter{Since: today.Add(-warmupHistory), Until: today}, func(stats StatsRaw) error {
+		stats = aliases.stats(stats)
+		outcome, exists := outcomes[stats.ChordName]
+		if !exists {
+			outcome = &chordOutcome{chord: WarmupChord{ChordName: stats.ChordName, RootNote: stats.RootNote, ChordExtension: stats.ChordExtension}}
+			outcomes[stats.ChordName] = outcome
+		}
+		outcome.answers++
+		if firstTryCorrect(stats) {
+			outcome.correct++
+		}
+		outcome.durationMilli += stats.AnswerDurationMilliSeconds
+		if stats.Confidence > 0 {
+			outcome.rated++
+			outcome.confidence += stats.Confidence
+		}
+		answers++
+		durationMilli += stats.AnswerDurationMilliSeconds
+		return nil
+	})
+	if err != nil {
+		return Warmup{}, err
+	}
+
+	day := today.Format("2006-01-02")
+	if len(outcomes) == 0 {
+		return Warmup{Day: day, Chords: starterChords}, nil
+	}
+
+	// the chords are sorted before shuffling, so the same answers and day
+	// always make the same warm-up
+	var mastered, weak, rest []*chordOutcome
+	avgDurationMilli := float64(durationMilli) / float64(answers)
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.answers < minMasteryAnswers:
+			rest = append(rest, outcome)
+		case outcome.accuracy() >= masteryAccuracy && outcome.avgDurationMilli() <= avgDurationMilli && outcome.confident():
+			mastered = append(mastered, outcome)
+		default:
+			weak = append(weak, outcome)
+		}
+	}
+	sort.Slice(weak, func(i, j int) bool {
+		if weak[i].accuracy() != weak[j].accuracy() {
+			return weak[i].accuracy() < weak[j].accuracy()
+		}
+		if weak[i].avgDurationMilli() != weak[j].avgDurationMilli() {
+			return weak[i].avgDurationMilli() > weak[j].avgDurationMilli()
+		}
+		return weak[i].chord.ChordName < weak[j].chord.ChordName
+	})
+	byName := func(outcomes []*chordOutcome) {
+		sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].chord.ChordName < outcomes[j].chord.ChordName })
+	}
+	byName(mastered)
+	byName(rest)
+
+	random := rand.New(rand.NewSource(warmupSeed(ctx, day)))
+	chords := []WarmupChord{}
+	for _, outcome := range weak {
+		if len(chords) == warmupChallenges {
+			break
+		}
+		chord := outcome.chord
+		chord.Kind = warmupChallenge
+		chords = append(chords, chord)
+	}
+	// mastered chords give confidence, the others fill in for users who
+	// master too few yet
+	random.Shuffle(len(mastered), func(i, j int) { mastered[i], mastered[j] = mastered[j], mastered[i] })
+	random.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	fillers := append(append(mastered, rest...), weak[len(chords):]...)
+	for _, outcome := range fillers {
+		if len(chords) == warmupLength {
+			break
+		}
+		chord := outcome.chord
+		chord.Kind = warmupConfidence
+		chords = append(chords, chord)
+	}
+	random.Shuffle(len(chords), func(i, j int) { chords[i], chords[j] = chords[j], chords[i] })
+	return Warmup{Day: day, Chords: chords}, nil
+}
+
+// warmupSeed is the seed of the warm-up of the user in ctx on day
+func warmupSeed(ctx context.Context, day string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(tenantFromContext(ctx) + "/" + userFromContext(ctx) + "/" + day))
+	return int64(hash.Sum64())
+}
+
+// getWarmupHandler serves the warm-up of the day, the day being in the
+// timezone query param if given, otherwise the user's timezone
+func getWarmupHandler(w http.ResponseWriter, r *http.Request) {
+	loc, err := userLocation(
+		r.Context(),
+		userFromContext(r.Context()),
+		r.URL.Query().Get("timezone"),
+	)
+	if err == errInvalidTimezone {
+		writeProblem(w, problemInvalidRequest, "", FieldError{Field: "timezone", Message: "isn't an IANA timezone"})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	routine, err := warmup(r.Context(), loc)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, routine)
+}